@@ -0,0 +1,260 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+// DefaultAccountName is the name of the account every user starts with.
+const DefaultAccountName = "default"
+
+var (
+	// ErrNoAccount is returned when a user has no account of a given name.
+	ErrNoAccount = errors.New("No account with that name")
+	// ErrAccountExists is returned when creating or renaming an account to
+	// the name of another account.
+	ErrAccountExists = errors.New("An account with that name already exists")
+	// ErrBadAccountName is returned for empty account names and names that
+	// contain whitespace.
+	ErrBadAccountName = errors.New("Account names must be non-empty and have no spaces")
+	// ErrAccountArchived is returned when selecting or spending from an
+	// archived account.
+	ErrAccountArchived = errors.New("Account is archived")
+	// ErrAccountSelected is returned when archiving the selected account.
+	ErrAccountSelected = errors.New("Cannot archive the selected account")
+)
+
+// Account is a named HDWallet of a user. All of a user's accounts are
+// derived from the same seed, each under its own account index, so they are
+// all backed up by the same mnemonic phrase.
+type Account struct {
+	Name string
+	// Archived accounts are hidden, and can't be selected or spent from.
+	// Their balances are still tracked.
+	Archived bool
+	Wallet   *blockchain.HDWallet
+}
+
+// Current returns the selected account, which pays and receives by default.
+func (u *User) Current() *Account {
+	if account, err := u.Account(u.Selected); err == nil {
+		return account
+	}
+	return u.Accounts[0]
+}
+
+// Wallet returns the wallet of the selected account.
+func (u *User) Wallet() *blockchain.HDWallet {
+	return u.Current().Wallet
+}
+
+// Account returns the account with the given name.
+func (u *User) Account(name string) (*Account, error) {
+	for _, account := range u.Accounts {
+		if account.Name == name {
+			return account, nil
+		}
+	}
+	return nil, ErrNoAccount
+}
+
+// NewAccount adds an account with the given name, whose keys are derived
+// under the next unused account index. The user's seed must not be
+// encrypted.
+func (u *User) NewAccount(name string) (*Account, error) {
+	if err := u.checkNewName(name); err != nil {
+		return nil, err
+	}
+	index := uint32(0)
+	for _, account := range u.Accounts {
+		if account.Wallet.Account >= index {
+			index = account.Wallet.Account + 1
+		}
+	}
+	wallet, err := u.Accounts[0].Wallet.NewAccount(index)
+	if err != nil {
+		return nil, err
+	}
+	account := &Account{Name: name, Wallet: wallet}
+	u.Accounts = append(u.Accounts, account)
+	return account, nil
+}
+
+// Select makes the account with the given name the one that pays and
+// receives by default.
+func (u *User) Select(name string) error {
+	account, err := u.Account(name)
+	if err != nil {
+		return err
+	} else if account.Archived {
+		return ErrAccountArchived
+	}
+	u.Selected = name
+	return nil
+}
+
+// Rename changes the name of an account.
+func (u *User) Rename(name, newName string) error {
+	account, err := u.Account(name)
+	if err != nil {
+		return err
+	}
+	if err := u.checkNewName(newName); err != nil {
+		return err
+	}
+	account.Name = newName
+	if u.Selected == name {
+		u.Selected = newName
+	}
+	return nil
+}
+
+// Archive hides an account and stops it from being spent from, or brings an
+// archived account back if archived is false. The selected account can't be
+// archived.
+func (u *User) Archive(name string, archived bool) error {
+	account, err := u.Account(name)
+	if err != nil {
+		return err
+	} else if archived && u.Current() == account {
+		return ErrAccountSelected
+	}
+	account.Archived = archived
+	return nil
+}
+
+// checkNewName returns an error if an account can't be given the name.
+func (u *User) checkNewName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return ErrBadAccountName
+	} else if _, err := u.Account(name); err == nil {
+		return ErrAccountExists
+	}
+	return nil
+}
+
+// Update updates the wallets of all of the user's accounts and the user's
+// watches based on the transactions in the given block.
+func (u *User) Update(block *blockchain.Block, bc *blockchain.BlockChain) error {
+	for _, account := range u.Accounts {
+		if err := account.Wallet.Update(block, bc); err != nil {
+			return err
+		}
+	}
+	for _, watch := range u.Watches {
+		if err := watch.Update(block, bc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revert undoes the effect of the given block on the wallets of all of the
+// user's accounts and the user's watches.
+func (u *User) Revert(block *blockchain.Block, bc *blockchain.BlockChain) error {
+	for _, account := range u.Accounts {
+		if err := account.Wallet.Revert(block, bc); err != nil {
+			return err
+		}
+	}
+	for _, watch := range u.Watches {
+		if err := watch.Revert(block, bc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refresh sets the wallets of all of the user's accounts and the user's
+// watches from the given blockchain.
+func (u *User) Refresh(bc *blockchain.BlockChain) error {
+	for _, account := range u.Accounts {
+		if err := account.Wallet.Refresh(bc); err != nil {
+			return err
+		}
+	}
+	for _, watch := range u.Watches {
+		watch.Refresh(bc)
+	}
+	return nil
+}
+
+// PendingTxns returns the pending transactions of all of the user's
+// accounts.
+func (u *User) PendingTxns() []*blockchain.Transaction {
+	pending := make([]*blockchain.Transaction, 0)
+	for _, account := range u.Accounts {
+		pending = append(pending, account.Wallet.PendingTxns()...)
+	}
+	return pending
+}
+
+// owner returns the account with a wallet of the given address, and that
+// wallet, or nil if none of the user's accounts holds it.
+func (u *User) owner(addr string) (*Account, *blockchain.Wallet) {
+	for _, account := range u.Accounts {
+		if w := account.Wallet.Find(addr); w != nil {
+			return account, w
+		}
+	}
+	return nil, nil
+}
+
+// keys returns the wallets of every key of every account.
+func (u *User) keys() []*blockchain.Wallet {
+	keys := make([]*blockchain.Wallet, 0)
+	for _, account := range u.Accounts {
+		keys = append(keys, account.Wallet.Wallets()...)
+	}
+	return keys
+}
+
+// Restore replaces the user's accounts with those derived from the given
+// mnemonic phrase and passphrase, finding the addresses each account has used
+// in the blockchain. Accounts are found in order of index until one that has
+// never been used; the first is always kept. Imported keys are kept in the
+// first account. Accounts that existed before keep their names.
+func (u *User) Restore(mnemonic, passphrase string, bc *blockchain.BlockChain) error {
+	first, err := blockchain.RestoreHDWallet(mnemonic, passphrase)
+	if err != nil {
+		return err
+	}
+
+	old := make(map[uint32]*Account, len(u.Accounts))
+	for _, account := range u.Accounts {
+		old[account.Wallet.Account] = account
+	}
+	accounts := make([]*Account, 0)
+	for index, wallet := uint32(0), first; ; index++ {
+		if index > 0 {
+			if wallet, err = first.NewAccount(index); err != nil {
+				return err
+			}
+		}
+		if err := wallet.Recover(bc, blockchain.DefaultGapLimit); err != nil {
+			return err
+		}
+		if index > 0 && !wallet.Used(bc) {
+			break
+		}
+		account := &Account{Name: fmt.Sprintf("account-%d", index), Wallet: wallet}
+		if index == 0 {
+			account.Name = DefaultAccountName
+		}
+		if previous, ok := old[index]; ok {
+			account.Name, account.Archived = previous.Name, previous.Archived
+		}
+		accounts = append(accounts, account)
+	}
+	first.Imported = u.Accounts[0].Wallet.Imported
+	if err := first.Refresh(bc); err != nil {
+		return err
+	}
+
+	u.Accounts = accounts
+	u.Selected = accounts[0].Name
+	return nil
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+func TestNewAccount(t *testing.T) {
+	user := NewUser()
+	assert.Equal(t, DefaultAccountName, user.Current().Name)
+
+	hot, err := user.NewAccount("hot")
+	assert.Nil(t, err)
+	cold, err := user.NewAccount("cold")
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), hot.Wallet.Account)
+	assert.Equal(t, uint32(2), cold.Wallet.Account)
+	assert.NotEqual(t, hot.Wallet.Public(), user.Public())
+	assert.NotEqual(t, hot.Wallet.Public(), cold.Wallet.Public())
+
+	_, err = user.NewAccount("hot")
+	assert.Equal(t, ErrAccountExists, err)
+	_, err = user.NewAccount("fee paying")
+	assert.Equal(t, ErrBadAccountName, err)
+	_, err = user.NewAccount("")
+	assert.Equal(t, ErrBadAccountName, err)
+	_, err = user.Account("fees")
+	assert.Equal(t, ErrNoAccount, err)
+}
+
+func TestSelectRenameAndArchiveAccounts(t *testing.T) {
+	user := NewUser()
+	hot, _ := user.NewAccount("hot")
+	user.NewAccount("cold")
+
+	assert.Nil(t, user.Select("hot"))
+	assert.Equal(t, hot, user.Current())
+	assert.Equal(t, hot.Wallet.Public(), user.Public())
+	assert.Equal(t, ErrNoAccount, user.Select("fees"))
+
+	assert.Nil(t, user.Rename("hot", "spending"))
+	assert.Equal(t, "spending", user.Selected)
+	assert.Equal(t, ErrAccountExists, user.Rename("cold", "spending"))
+
+	assert.Equal(t, ErrAccountSelected, user.Archive("spending", true))
+	assert.Nil(t, user.Archive("cold", true))
+	assert.Equal(t, ErrAccountArchived, user.Select("cold"))
+	assert.Nil(t, user.Archive("cold", false))
+	assert.Nil(t, user.Select("cold"))
+}
+
+func TestPayFrom(t *testing.T) {
+	a := newTestApp()
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	a.Chain = bc
+	hot, _ := a.CurrentUser.NewAccount("hot")
+	hot.Wallet.Import(wallets["alice"])
+	assert.Nil(t, a.CurrentUser.Refresh(bc))
+
+	// The default account has nothing to spend.
+	assert.NotNil(t, a.Pay("badf00d", 2))
+	assert.Nil(t, a.PayFrom("hot", "badf00d", 2, 0))
+	txn := a.Pool.Peek()
+	assert.Equal(t, wallets["alice"].Public(), txn.Sender)
+	assert.Equal(t, hot.Wallet.Change[0].Public().Repr(), txn.Outputs[1].Recipient)
+	assert.Equal(t, []*blockchain.Transaction{txn}, hot.Wallet.PendingTxns())
+
+	// Archived accounts can't be spent from.
+	assert.Nil(t, a.CurrentUser.Archive("hot", true))
+	assert.Equal(t, ErrAccountArchived, a.PayFrom("hot", "badf00d", 1, 0))
+	assert.Equal(t, ErrNoAccount, a.PayFrom("fees", "badf00d", 1, 0))
+}
+
+func TestRestoreAccounts(t *testing.T) {
+	user := NewUser()
+	hot, _ := user.NewAccount("hot")
+	user.NewAccount("unused")
+	mnemonic, _ := user.Wallet().Mnemonic()
+
+	// Only the hot account has received coins.
+	a := newTestApp()
+	a.CurrentUser = user
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	a.Chain = bc
+	user.Wallet().Import(wallets["alice"])
+	assert.Nil(t, user.Refresh(bc))
+	assert.Nil(t, a.Pay(hot.Wallet.Public().Repr(), 3))
+	b := a.Pool.NextBlock(bc, blockchain.NewWallet().Public(), 1<<18)
+	assert.Nil(t, bc.AppendBlock(b))
+
+	restored := NewUser()
+	restored.Accounts[0].Wallet.Import(wallets["alice"])
+	assert.Nil(t, restored.Restore(mnemonic, "", bc))
+	assert.Len(t, restored.Accounts, 2)
+	assert.Equal(t, DefaultAccountName, restored.Accounts[0].Name)
+	assert.Equal(t, "account-1", restored.Accounts[1].Name)
+	assert.Equal(t, hot.Wallet.Public(), restored.Accounts[1].Wallet.Receive[0].Public())
+	assert.Equal(t, uint64(3), restored.Accounts[1].Wallet.Balance())
+	assert.Len(t, restored.Accounts[0].Wallet.Imported, 1)
+}
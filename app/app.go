@@ -10,6 +10,7 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/google/uuid"
@@ -32,13 +33,37 @@ const (
 	// MaxBlockSize is the maximum size of a block in bytes
 	MaxBlockSize = 5000000
 	// MinBlockSize is the minimum size of a block in bytes
-	MinBlockSize         = 1000
+	MinBlockSize = 1000
+	// UserFileName is the name of the file the user is saved to
+	UserFileName         = "user.json"
 	blockQueueSize       = 100
 	transactionQueueSize = 100
-	userFileName         = "user.json"
 	blockchainFileName   = "blockchain.json"
+	blockStoreFileName   = "blocks.dat"
+	poolFileName         = "pool.json"
+	poolSaveInterval     = 10 * time.Minute
 )
 
+// ReorgHandler is a function that is called whenever the main chain is switched
+// from one branch of the block tree to another.
+type ReorgHandler func(*blockchain.Reorg)
+
+// queuedTransaction is a transaction waiting to be handled along with the
+// listen address of the peer it came from and, if it is a replacement, the hash
+// of the pending transaction it replaces.
+type queuedTransaction struct {
+	txn      *blockchain.Transaction
+	from     string
+	replaces *blockchain.Hash
+}
+
+// ChainInfo describes the blocks a node can serve to its peers. Blocks below
+// PrunedHeight have had their transactions discarded.
+type ChainInfo struct {
+	Height       uint32
+	PrunedHeight uint32
+}
+
 // App contains information about a running instance of a Cumulus node
 type App struct {
 	CurrentUser      *User
@@ -47,8 +72,9 @@ type App struct {
 	Miner            *miner.Miner
 	Pool             *pool.Pool
 	blockQueue       chan *blockchain.Block
-	transactionQueue chan *blockchain.Transaction
+	transactionQueue chan *queuedTransaction
 	quitChan         chan bool
+	reorgHandlers    []ReorgHandler
 }
 
 // New returns a new user with the given parameters
@@ -60,7 +86,7 @@ func New(user *User, pStore *peer.PeerStore, chain *blockchain.BlockChain, pool
 		Miner:            miner.New(),
 		Pool:             pool,
 		blockQueue:       make(chan *blockchain.Block, blockQueueSize),
-		transactionQueue: make(chan *blockchain.Transaction, transactionQueueSize),
+		transactionQueue: make(chan *queuedTransaction, transactionQueueSize),
 		quitChan:         make(chan bool),
 	}
 }
@@ -77,40 +103,82 @@ func Run(cfg conf.Config) {
 	config := &cfg
 	addr := fmt.Sprintf("%s:%d", config.Interface, config.Port)
 
-	// Set starting difficulty (TODO: remove this when we have adjustable difficulty)
+	// Set the difficulty of the genesis block, later blocks are retargeted
+	// from it.
 	consensus.CurrentDifficulty = big.NewInt(2 << 21)
 
-	// Load user info from a file (or create a new user if there isn't one on disk)
-	user, err := LoadUser(userFileName)
-	if err != nil {
+	// Add any configured checkpoints to the hard-coded ones.
+	for _, s := range config.Checkpoints {
+		cp, err := consensus.ParseCheckpoint(s)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid checkpoint ", s)
+		}
+		consensus.Checkpoints = append(consensus.Checkpoints, cp)
+	}
+	consensus.FastValidation = config.FastValidation
+	if config.MaxFutureDrift > 0 {
+		consensus.MaxFutureDrift = uint32(config.MaxFutureDrift / time.Second)
+	}
+
+	// Load user info from a file (or create a new user if there isn't one on
+	// disk). A file that fails to load is left alone rather than replaced,
+	// since it may hold the only copy of the user's keys.
+	user, err := LoadUser(UserFileName)
+	if err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Fatal("Failed to load user info from ", UserFileName)
+	} else if err != nil {
 		user = NewUser()
-		if err := user.Save(userFileName); err != nil {
-			log.WithError(err).Fatal("Failed to save new user info to ", userFileName)
+		if err := user.Save(UserFileName); err != nil {
+			log.WithError(err).Fatal("Failed to save new user info to ", UserFileName)
 		} else {
-			log.Info("Saved new user info to file ", userFileName)
+			log.Info("Saved new user info to file ", UserFileName)
 		}
 	} else {
-		log.Info("Loaded user info from ", userFileName)
+		log.Info("Loaded user info from ", UserFileName)
+	}
+	if len(config.Account) > 0 {
+		if err := user.Select(config.Account); err != nil {
+			log.WithError(err).Fatal("Failed to select account ", config.Account)
+		}
 	}
 
-	// Load blockchain from a file (or create a new one if there isn't one on disk)
-	chain, err := blockchain.Load(blockchainFileName)
+	// Load blockchain from the block store (or create a new one if there isn't
+	// one on disk)
+	chain, err := openBlockChain(user)
 	if err != nil {
-		genesisBlock := blockchain.Genesis(user.Public(), consensus.CurrentTarget(),
-			blockchain.StartingBlockReward, []byte{})
-		chain = blockchain.New()
-		chain.AppendBlock(genesisBlock)
-		if err := user.Wallet.Refresh(chain); err != nil {
-			log.WithError(err).Fatal("Failed to set user wallet information " +
-				"based on the newly created blockchain")
-		}
-		log.Info("Created new blockchain with genesis block")
-	} else {
-		log.Info("Loaded blockchain from ", blockchainFileName)
+		log.WithError(err).Fatal("Failed to open blockchain")
+	}
+	if config.PruneDepth > 0 {
+		log.Infof("Pruning all but the last %d blocks", config.PruneDepth)
+		chain.SetPruneDepth(config.PruneDepth)
+	}
+
+	// The user file is only written on exit, so the wallet may not reflect
+	// blocks that were persisted after it was last saved.
+	if err := user.Refresh(chain); err != nil {
+		log.WithError(err).Fatal("Failed to set user wallet information " +
+			"based on the blockchain")
 	}
 
 	// Create new app instance
 	a := New(user, peer.NewPeerStore(addr), chain, pool.New())
+	if len(config.SelectionPolicy) > 0 {
+		policy, err := pool.PolicyByName(config.SelectionPolicy, config.MaxTxnsPerSender)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid transaction selection policy")
+		}
+		a.Pool.SetPolicy(policy)
+	}
+	a.Pool.SetLimits(pool.Limits{
+		MaxCount:     config.PoolMaxTxns,
+		MaxBytes:     config.PoolMaxBytes,
+		MaxAge:       config.PoolExpiry,
+		MaxPerSender: config.PoolMaxPerSender,
+		MaxPerPeer:   config.PoolMaxPerPeer,
+	})
+
+	// Reload the transactions that were pending when the node last stopped.
+	restored := a.restorePool(poolFileName)
 
 	// We'll need to wait on at least 2 goroutines (Listen and
 	// MaintainConnections) to start before returning
@@ -118,7 +186,7 @@ func Run(cfg conf.Config) {
 	wg.Add(3)
 
 	// Start a goroutine that waits for program termination. Before the program
-	// exits it will flush logs and save the blockchain.
+	// exits it will flush logs and close the block store.
 	go a.awaitExit(wg)
 
 	// Below we'll connect to peers. After which, requests could begin to
@@ -154,6 +222,14 @@ func Run(cfg conf.Config) {
 		a.ConnectAndDiscover(cfg.Target)
 	}
 
+	// Let the network know about the pending transactions we restored.
+	for _, txn := range restored {
+		a.PeerStore.Broadcast(msg.Push{
+			ResourceType: msg.ResourceTransaction,
+			Resource:     txn,
+		})
+	}
+
 	if config.Mine {
 		log.Info("Starting miner")
 		go a.RunMiner()
@@ -208,6 +284,8 @@ func (a *App) RequestHandler(req *msg.Request) msg.Response {
 		"Bad request")
 	upToDateErr := msg.NewProtocolError(msg.UpToDate,
 		"The requested block has not yet been mined")
+	prunedErr := msg.NewProtocolError(msg.Pruned,
+		"The requested block has been pruned")
 
 	switch req.ResourceType {
 	case msg.ResourcePeerInfo:
@@ -215,24 +293,38 @@ func (a *App) RequestHandler(req *msg.Request) msg.Response {
 	case msg.ResourceBlock:
 		log.Debug("Received block request")
 
-		// Block is requested by block hash.
-		hashBytes, err := json.Marshal(req.Params["lastBlockHash"])
-		if err != nil {
-			log.Debug("Returning response with status code: BadRequest")
-			res.Error = badRequestErr
-			break
-		}
-
 		a.Chain.RLock()
 		defer a.Chain.RUnlock()
 
-		var hash blockchain.Hash
-		err = json.Unmarshal(hashBytes, &hash)
+		if _, ok := req.Params["hash"]; ok {
+			// Block is requested by its own hash.
+			hash, err := decodeHashParam(req.Params, "hash")
+			if err != nil {
+				log.Debug("Returning response with status code: BadRequest")
+				res.Error = badRequestErr
+				break
+			}
+			block, err := a.Chain.GetBlockByHash(hash)
+			if err != nil {
+				log.Debug("Returning response with status code: ResourceNotFound")
+				res.Error = notFoundErr
+			} else if block.BlockNumber < a.Chain.PrunedHeight() {
+				log.Debug("Returning response with status code: Pruned")
+				res.Error = prunedErr
+			} else {
+				log.Debug("Returning response with block")
+				res.Resource = block
+			}
+			break
+		}
+
+		// Block is requested by the hash of the block before it.
+		hash, err := decodeHashParam(req.Params, "lastBlockHash")
 		if err != nil {
 			log.Debug("Returning response with status code: BadRequest")
 			res.Error = badRequestErr
 			break
-		} else if len(a.Chain.Blocks) > 0 && hash == blockchain.HashSum(a.Chain.LastBlock()) {
+		} else if len(a.Chain.Blocks) > 0 && hash == a.Chain.Head {
 			log.Debug("Returning response with status code: UpToDate")
 			res.Error = upToDateErr
 			break
@@ -242,10 +334,45 @@ func (a *App) RequestHandler(req *msg.Request) msg.Response {
 		if err != nil {
 			log.Debug("Returning response with status code: ResourceNotFound")
 			res.Error = notFoundErr
+		} else if block.BlockNumber < a.Chain.PrunedHeight() {
+			log.Debug("Returning response with status code: Pruned")
+			res.Error = prunedErr
 		} else {
 			log.Debug("Returning response with block")
 			res.Resource = block
 		}
+	case msg.ResourceTransaction:
+		log.Debug("Received transaction request")
+
+		// Transaction is requested by hash.
+		hash, err := decodeHashParam(req.Params, "hash")
+		if err != nil {
+			log.Debug("Returning response with status code: BadRequest")
+			res.Error = badRequestErr
+			break
+		}
+
+		a.Chain.RLock()
+		defer a.Chain.RUnlock()
+
+		txn, _, err := a.Chain.GetTransactionByHash(hash)
+		if err != nil {
+			log.Debug("Returning response with status code: ResourceNotFound")
+			res.Error = notFoundErr
+		} else {
+			log.Debug("Returning response with transaction")
+			res.Resource = txn
+		}
+	case msg.ResourceChainInfo:
+		log.Debug("Received chain info request")
+
+		a.Chain.RLock()
+		defer a.Chain.RUnlock()
+
+		res.Resource = ChainInfo{
+			Height:       uint32(len(a.Chain.Blocks)),
+			PrunedHeight: a.Chain.PrunedHeight(),
+		}
 	default:
 		res.Error = typeErr
 	}
@@ -253,6 +380,18 @@ func (a *App) RequestHandler(req *msg.Request) msg.Response {
 	return res
 }
 
+// decodeHashParam decodes the hash in the request parameter with the given
+// name.
+func decodeHashParam(params map[string]interface{}, name string) (blockchain.Hash, error) {
+	var hash blockchain.Hash
+	hashBytes, err := json.Marshal(params[name])
+	if err != nil {
+		return hash, err
+	}
+	err = json.Unmarshal(hashBytes, &hash)
+	return hash, err
+}
+
 // PushHandler is called every time a peer sends us a Push message except on
 // peers whos PushHandlers have been overridden.
 func (a *App) PushHandler(push *msg.Push) {
@@ -285,30 +424,103 @@ func (a *App) PushHandler(push *msg.Push) {
 			return
 		}
 		log.Debug("Adding transaction to work queue")
-		a.transactionQueue <- &txn
+		a.transactionQueue <- &queuedTransaction{txn: &txn, from: push.From}
+
+	case msg.ResourceReplacement:
+		replacementBytes, err := json.Marshal(push.Resource)
+		if err != nil {
+			log.WithError(err).Debug("Received invalid replacement")
+			return
+		}
+		var r pool.Replacement
+		dec := json.NewDecoder(bytes.NewReader(replacementBytes))
+		dec.UseNumber()
+		if err := dec.Decode(&r); err != nil || r.New == nil {
+			log.WithError(err).Debug("Received invalid replacement")
+			return
+		}
+		log.Debug("Adding replacement to work queue")
+		a.transactionQueue <- &queuedTransaction{
+			txn:      r.New,
+			from:     push.From,
+			replaces: &r.Old,
+		}
 	default:
 		// Invalid resource type. Ignore
 	}
 }
 
+// openBlockChain opens the blockchain persisted in the block store. If the
+// store is empty, the blockchain is imported from the JSON file written by
+// older versions of cumulus if there is one, otherwise a new blockchain is
+// created with a genesis block mined by the given user.
+func openBlockChain(user *User) (*blockchain.BlockChain, error) {
+	chain, err := blockchain.Open(blockStoreFileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain.Blocks) > 0 {
+		log.Info("Loaded blockchain from ", blockStoreFileName)
+		return chain, nil
+	}
+
+	if _, err := os.Stat(blockchainFileName); err == nil {
+		legacyChain, err := blockchain.Load(blockchainFileName)
+		if err != nil {
+			chain.Close()
+			return nil, err
+		}
+		for _, b := range legacyChain.Blocks {
+			if err := chain.AppendBlock(b); err != nil {
+				chain.Close()
+				return nil, err
+			}
+		}
+		log.Infof("Imported blockchain from %s into %s", blockchainFileName,
+			blockStoreFileName)
+		return chain, nil
+	}
+
+	genesisBlock := blockchain.Genesis(user.Public(), consensus.CurrentTarget(),
+		blockchain.StartingBlockReward, []byte{})
+	if err := chain.AppendBlock(genesisBlock); err != nil {
+		chain.Close()
+		return nil, err
+	}
+	log.Info("Created new blockchain with genesis block")
+	return chain, nil
+}
+
 // createBlockchain returns a new instance of a blockchain with only a genesis
 // block.
 func createBlockchain(user *User) *blockchain.BlockChain {
 	bc := blockchain.New()
-	genesisBlock := blockchain.Genesis(user.Wallet.Public(),
+	genesisBlock := blockchain.Genesis(user.Public(),
 		consensus.CurrentTarget(), blockchain.StartingBlockReward, []byte{})
 
 	bc.AppendBlock(genesisBlock)
 	return bc
 }
 
-// HandleWork continually collects new work from existing work channels.
+// HandleWork continually collects new work from existing work channels. The
+// transaction pool is saved periodically in between.
 func (a *App) HandleWork() {
 	log.Debug("Worker waiting for work")
+	saveTicker := time.NewTicker(poolSaveInterval)
+	defer saveTicker.Stop()
 	for {
 		select {
+		case <-saveTicker.C:
+			a.savePool(poolFileName)
 		case work := <-a.transactionQueue:
-			a.HandleTransaction(work)
+			if work.replaces != nil {
+				a.handleReplacement(&pool.Replacement{
+					Old: *work.replaces,
+					New: work.txn,
+				}, work.from)
+			} else {
+				a.handleTransactionFrom(work.txn, work.from)
+			}
 		case work := <-a.blockQueue:
 			a.HandleBlock(work)
 		case <-a.quitChan:
@@ -317,8 +529,47 @@ func (a *App) HandleWork() {
 	}
 }
 
+// restorePool reloads the transactions saved to the file with the given name
+// into the pool, along with the current user's pending transactions, keeping
+// those that are still valid with respect to the chain. Returns the
+// transactions that were restored.
+func (a *App) restorePool(fileName string) []*blockchain.Transaction {
+	a.Chain.RLock()
+	defer a.Chain.RUnlock()
+
+	restored, err := a.Pool.Load(fileName, a.Chain)
+	if err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Error("Failed to load transaction pool from ", fileName)
+	}
+	for _, txn := range a.CurrentUser.PendingTxns() {
+		if a.Pool.Get(blockchain.HashSum(txn)) != nil {
+			continue
+		}
+		code, err := a.Pool.PushFrom(txn, a.Chain, "")
+		if code == consensus.ValidTransaction && err == nil {
+			restored = append(restored, txn)
+		}
+	}
+	log.Infof("Restored %d pending transactions", len(restored))
+	return restored
+}
+
+// savePool writes the transaction pool to the file with the given name.
+func (a *App) savePool(fileName string) {
+	if err := a.Pool.Save(fileName); err != nil {
+		log.WithError(err).Error("Error saving transaction pool")
+	}
+}
+
 // HandleTransaction handles new transactions.
 func (a *App) HandleTransaction(txn *blockchain.Transaction) {
+	a.handleTransactionFrom(txn, "")
+}
+
+// handleTransactionFrom handles a new transaction received from the peer with
+// the given listen address, which is counted against that peer's quota in the
+// pool.
+func (a *App) handleTransactionFrom(txn *blockchain.Transaction, from string) {
 	a.Chain.RLock()
 	defer a.Chain.RUnlock()
 
@@ -334,14 +585,40 @@ func (a *App) HandleTransaction(txn *blockchain.Transaction) {
 	}
 
 	// We don't have this transaction in our pool, so we can try add it.
-	code := a.Pool.Push(txn, a.Chain)
-	if code == consensus.ValidTransaction {
-		log.Debug("Added transaction to pool from address: " + txn.Sender.Repr())
+	code, err := a.Pool.PushFrom(txn, a.Chain, from)
+	if err != nil {
+		log.WithError(err).Debug("Transaction rejected from peer: " + from)
+	} else if code == consensus.ValidTransaction {
+		log.Debug("Added transaction to pool from address: " + txn.From())
 	} else {
-		log.Debug("Bad transaction rejected from sender: " + txn.Sender.Repr())
+		log.Debug("Bad transaction rejected from sender: " + txn.From())
 	}
 }
 
+// handleReplacement replaces a pending transaction in the pool with a new one
+// from the same sender, received from the peer with the given listen address.
+// The replacement is propagated to the network if it was accepted, so that the
+// original is evicted everywhere. Peers that have already made the replacement
+// reject it, which stops it from bouncing between nodes.
+func (a *App) handleReplacement(r *pool.Replacement, from string) {
+	a.Chain.RLock()
+	defer a.Chain.RUnlock()
+
+	code, err := a.Pool.Replace(r, a.Chain, from)
+	if err != nil {
+		log.WithError(err).Debug("Replacement rejected from peer: " + from)
+		return
+	} else if code != consensus.ValidTransaction {
+		log.Debug("Bad replacement rejected from sender: " + r.New.From())
+		return
+	}
+	log.Debug("Replaced pending transaction from address: " + r.New.From())
+	a.PeerStore.Broadcast(msg.Push{
+		ResourceType: msg.ResourceReplacement,
+		Resource:     r,
+	})
+}
+
 // HandleBlock handles new blocks.
 func (a *App) HandleBlock(blk *blockchain.Block) {
 	wasMining := a.Miner.PauseIfRunning()
@@ -349,8 +626,22 @@ func (a *App) HandleBlock(blk *blockchain.Block) {
 	a.Chain.Lock()
 	defer a.Chain.Unlock()
 
-	if blk.BlockNumber < uint32(len(a.Chain.Blocks)) {
+	if a.Chain.GetKnownBlock(blockchain.HashSum(blk)) != nil {
 		// We already have this block
+		if wasMining {
+			a.ResumeMiner(false)
+		}
+		return
+	}
+
+	if a.Chain.GetKnownBlock(blk.LastBlock) != nil &&
+		(blk.LastBlock != a.Chain.Head || consensus.DeferBlock(a.Chain, blk)) {
+		// The block builds on a side branch of our block tree, or can't be
+		// connected until we know the last checkpoint.
+		chainChanged := a.handleSideBlock(blk)
+		if wasMining {
+			a.ResumeMiner(chainChanged)
+		}
 		return
 	}
 
@@ -361,7 +652,7 @@ func (a *App) HandleBlock(blk *blockchain.Block) {
 		chainChanged, err := a.SyncBlockChain()
 		if chainChanged {
 			// We must update our wallet to reflect the new state of the blockchain
-			if err := a.CurrentUser.Wallet.Refresh(a.Chain); err != nil {
+			if err := a.CurrentUser.Refresh(a.Chain); err != nil {
 				log.WithError(err).Fatal("Failed to update wallet")
 			}
 		}
@@ -386,8 +677,10 @@ func (a *App) HandleBlock(blk *blockchain.Block) {
 	// Append to the chain before requesting the next block so that the block
 	// numbers make sense. Then update the user's wallet in case transactions
 	// from the block affect it.
-	a.Chain.AppendBlock(blk)
-	if err := a.CurrentUser.Wallet.Update(blk, a.Chain); err != nil {
+	if err := a.Chain.AppendBlock(blk); err != nil {
+		log.WithError(err).Fatal("Failed to write block to the block store")
+	}
+	if err := a.CurrentUser.Update(blk, a.Chain); err != nil {
 		log.WithError(err).Fatal("Attempt to add block with invalid " +
 			"transaction(s) to the blockchain")
 	}
@@ -399,6 +692,114 @@ func (a *App) HandleBlock(blk *blockchain.Block) {
 	return
 }
 
+// handleSideBlock adds a block that builds on a block other than the tip of
+// our main chain to the block tree. If the branch ending at the block has more
+// cumulative work than the main chain, the main chain is switched to that
+// branch, unless the branch has to wait for the last checkpoint. Returns true
+// if the main chain changed.
+func (a *App) handleSideBlock(blk *blockchain.Block) bool {
+	if valid, code := consensus.VerifyBlockHeader(a.Chain, blk); !valid {
+		log.WithFields(log.Fields{"validationCode": code}).Debug(
+			"Rejected invalid side branch block")
+		return false
+	}
+
+	heavier, err := a.Chain.AddSideBlock(blk)
+	if err != nil {
+		log.WithError(err).Debug("Failed to add block to side branch")
+		return false
+	}
+	if !heavier {
+		log.Infof("Added block number %d to side branch", blk.BlockNumber)
+		return false
+	}
+	if consensus.DeferBlock(a.Chain, blk) {
+		log.Infof("Holding block number %d until the last checkpoint", blk.BlockNumber)
+		return false
+	}
+	return a.reorganize(blockchain.HashSum(blk))
+}
+
+// OnReorg registers a handler to be called after every chain reorganization.
+// Handlers are called with the blockchain locked.
+func (a *App) OnReorg(handler ReorgHandler) {
+	a.reorgHandlers = append(a.reorgHandlers, handler)
+}
+
+// reorganize switches the main chain to the branch of the block tree ending
+// at the block with the given hash, verifying each block on the new branch as
+// it is connected. The effect of the disconnected blocks on our wallet is
+// reversed, and their transactions are returned to the transaction pool if
+// they are still valid. Returns true if the main chain changed.
+func (a *App) reorganize(tip blockchain.Hash) bool {
+	fork, err := a.Chain.Fork(tip)
+	if err != nil {
+		log.WithError(err).Error("Failed to switch to heavier branch")
+		return false
+	}
+
+	// Undo the effect of the blocks we are about to disconnect on our wallet
+	// while their inputs can still be found in the chain, keeping a copy of
+	// the wallet's state in case the new branch turns out to be invalid.
+	user := a.CurrentUser
+	wallets := user.keys()
+	balances := make([]uint64, len(wallets))
+	pending := make([][]*blockchain.Transaction, len(wallets))
+	for i, w := range wallets {
+		balances[i] = w.Balance
+		pending[i] = append([]*blockchain.Transaction{}, w.PendingTxns...)
+	}
+	watches := make([]blockchain.Watch, len(user.Watches))
+	for i, w := range user.Watches {
+		watches[i] = *w
+	}
+	for _, b := range fork.Disconnected {
+		if err := user.Revert(b, a.Chain); err != nil {
+			log.WithError(err).Fatal("Failed to update wallet")
+		}
+	}
+
+	verify := func(bc *blockchain.BlockChain, b *blockchain.Block) bool {
+		valid, code := consensus.VerifyBlock(bc, b)
+		if !valid {
+			log.WithFields(log.Fields{"validationCode": code}).Debug(
+				"Invalid block on new branch")
+		}
+		return valid
+	}
+
+	reorg, err := a.Chain.Reorganize(tip, verify)
+	if err != nil {
+		log.WithError(err).Error("Failed to switch to heavier branch")
+		for i, w := range wallets {
+			w.Balance = balances[i]
+			w.PendingTxns = pending[i]
+		}
+		for i, w := range user.Watches {
+			*w = watches[i]
+		}
+		return false
+	}
+
+	for _, b := range reorg.Connected {
+		if err := user.Update(b, a.Chain); err != nil {
+			log.WithError(err).Fatal("Failed to update wallet")
+		}
+	}
+	returned := a.Pool.Reorganize(reorg, a.Chain)
+
+	log.WithFields(log.Fields{
+		"depth":    reorg.Depth(),
+		"oldTip":   fmt.Sprintf("%x", reorg.OldTip),
+		"newTip":   fmt.Sprintf("%x", reorg.NewTip),
+		"returned": returned,
+	}).Info("Switched to heavier branch")
+	for _, handler := range a.reorgHandlers {
+		handler(reorg)
+	}
+	return true
+}
+
 // RunMiner continuously pulls transactions form the transaction pool, uses them to
 // create blocks, and mines those blocks. When a block is mined it is added
 // to the blockchain and broadcasted into the network. RunMiner returns when
@@ -409,13 +810,11 @@ func (a *App) RunMiner() {
 		a.Chain.RLock()
 
 		// Make a new block form the transactions in the transaction pool
-		blockToMine := a.Pool.NextBlock(a.Chain, a.CurrentUser.Wallet.Public(),
+		blockToMine := a.Pool.NextBlock(a.Chain, a.CurrentUser.Public(),
 			a.CurrentUser.BlockSize)
 
 		a.Chain.RUnlock()
 
-		// TODO: update this when we have adjustable difficulty
-		blockToMine.Target = consensus.CurrentTarget()
 		miningResult := a.Miner.Mine(blockToMine)
 
 		if miningResult.Complete {
@@ -475,10 +874,14 @@ func (a *App) SyncBlockChain() (bool, error) {
 		newBlockChan <- block
 	}
 
-	// Continually request the block after the latest block in our chain until
-	// we are totally up to date
+	// Continually request the block after the latest block we know of until
+	// we are totally up to date. If the peer doesn't know about the block we
+	// asked about, our chain has diverged from theirs; walk back down our chain
+	// until we find a block we have in common and download their branch from
+	// there.
+	cursor := a.Chain.LastBlock()
 	for {
-		err := a.makeBlockRequest(a.Chain.LastBlock(), blockResponseHandler)
+		err := a.makeBlockRequest(cursor, blockResponseHandler)
 		if err != nil {
 			if a.PeerStore.Size() == 0 {
 				// No peers to make the request to
@@ -488,7 +891,8 @@ func (a *App) SyncBlockChain() (bool, error) {
 		}
 
 		// Wait for response
-		change, done := a.handleBlockResponse(newBlockChan, errChan)
+		next, change, done := a.handleBlockResponse(cursor, newBlockChan, errChan)
+		cursor = next
 		if change {
 			chainChanged = true
 		}
@@ -528,18 +932,40 @@ func (a *App) makeBlockRequest(currentHead *blockchain.Block,
 }
 
 // handleBlockResponse receives a block or nil from the newBlockChan and attempts
-// to validate it and add it to the blockchain, or it handles a protocol error
-// from the errChan. Returns whether the blockchain was modified and whether we
-// received an UpToDate response.
-func (a *App) handleBlockResponse(newBlockChan chan *blockchain.Block,
-	errChan chan *msg.ProtocolError) (changed bool, upToDate bool) {
+// to validate it and add it to the block tree, or it handles a protocol error
+// from the errChan. cursor is the block whose successor was requested. Returns
+// the block whose successor should be requested next, whether the main chain
+// was modified and whether we received an UpToDate response.
+func (a *App) handleBlockResponse(cursor *blockchain.Block,
+	newBlockChan chan *blockchain.Block, errChan chan *msg.ProtocolError) (
+	next *blockchain.Block, changed bool, upToDate bool) {
 	select {
 	case newBlock := <-newBlockChan:
 		if newBlock == nil {
 			// We received a response with no error but an invalid resource
 			// Try again
 			log.Debug("Received block response with invalid resource")
-			return false, false
+			return cursor, false, false
+		}
+
+		if known := a.Chain.GetKnownBlock(blockchain.HashSum(newBlock)); known != nil {
+			// We already have this block, move on to the next one.
+			return known, false, false
+		}
+
+		if newBlock.LastBlock != a.Chain.Head || consensus.DeferBlock(a.Chain, newBlock) {
+			// The block extends a branch other than our main chain, or has to
+			// wait in the block tree until we know the last checkpoint.
+			if a.Chain.GetKnownBlock(newBlock.LastBlock) == nil {
+				log.Debug("SyncBlockchain received block with unknown parent")
+				return cursor, false, cursor == nil
+			}
+			changed := a.handleSideBlock(newBlock)
+			if a.Chain.GetKnownBlock(blockchain.HashSum(newBlock)) == nil {
+				// The block was rejected. Try again
+				return cursor, changed, false
+			}
+			return newBlock, changed, false
 		}
 
 		valid, validationCode := consensus.VerifyBlock(a.Chain, newBlock)
@@ -547,28 +973,40 @@ func (a *App) handleBlockResponse(newBlockChan chan *blockchain.Block,
 			// There is something wrong with this block. Try again
 			fields := log.Fields{"validationCode": validationCode}
 			log.WithFields(fields).Debug("SyncBlockchain received invalid block")
-			return false, false
+			return cursor, false, false
 		}
 
-		// Valid block. Append it to the chain
+		// Valid block. Remove its transactions and the ones that conflict
+		// with them from the pool, and append it to the chain
 		log.Debugf("Adding block %d to blockchain", newBlock.BlockNumber)
 		log.Debug("Blockchain length: ", len(a.Chain.Blocks))
-		a.Chain.AppendBlock(newBlock)
-		return true, false
+		a.Pool.Purge(newBlock, a.Chain)
+		if err := a.Chain.AppendBlock(newBlock); err != nil {
+			log.WithError(err).Fatal("Failed to write block to the block store")
+		}
+		return newBlock, true, false
 
 	case err := <-errChan:
 		if err.Code == msg.ResourceNotFound {
-			// Our chain might be out of sync, roll it back by one block
-			// and request the next block
+			// The peer doesn't know about the block we asked about, so our
+			// chain has diverged from theirs. Step back one block and ask
+			// again.
 			log.Debug("Received response with status code: ResourceNotFound")
-			a.Chain.RollBack()
-			return true, false
+			if cursor == nil || cursor.BlockNumber == 0 {
+				// We have no blocks in common with the peer.
+				return nil, false, cursor == nil
+			}
+			return a.Chain.GetKnownBlock(cursor.LastBlock), false, false
 		} else if err.Code == msg.UpToDate {
 			log.Debug("Received response with status code: UpToDate")
-			return false, true
+			return cursor, false, true
+		} else if err.Code == msg.Pruned {
+			// The peer can't serve the block, try again with another peer.
+			log.Debug("Received response with status code: Pruned")
+			return cursor, false, false
 		}
 		log.Debug("Received response with unexpected status code: ", err.Code)
-		return false, false
+		return cursor, false, false
 	}
 }
 
@@ -585,12 +1023,14 @@ func (a *App) awaitExit(wg *sync.WaitGroup) {
 // onExit saves app state to disk before exiting.
 func (a *App) onExit() {
 	log.Info("Saving app state and flushing logs...")
-	if err := a.Chain.Save(blockchainFileName); err != nil {
-		log.WithError(err).Error("Error saving blockchain")
+	a.Chain.Lock()
+	if err := a.Chain.Close(); err != nil {
+		log.WithError(err).Error("Error closing block store")
 	}
-	if err := a.CurrentUser.Save(userFileName); err != nil {
+	if err := a.CurrentUser.Save(UserFileName); err != nil {
 		log.WithError(err).Error("Error saving user info")
 	}
+	a.savePool(poolFileName)
 	logFile.Sync()
 	logFile.Close()
 	os.Exit(0)
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"math"
 	"os"
 	"testing"
 	"time"
@@ -57,7 +58,7 @@ func TestPushHandlerNewTestTransaction(t *testing.T) {
 	select {
 	case tr, ok := <-a.transactionQueue:
 		assert.True(t, ok)
-		assert.Equal(t, tr, txn)
+		assert.Equal(t, tr.txn, txn)
 	}
 }
 
@@ -73,6 +74,52 @@ func TestRequestHandlerNewBlockOK(t *testing.T) {
 	assert.Equal(t, block, a.Chain.Blocks[1])
 }
 
+func TestRequestHandlerBlockByHash(t *testing.T) {
+	a := newTestApp()
+
+	req := newTestBlockRequest(nil)
+	req.Params = map[string]interface{}{"hash": blockchain.HashSum(a.Chain.Blocks[1])}
+	resp := a.RequestHandler(req)
+	block, ok := resp.Resource.(*blockchain.Block)
+
+	assert.True(t, ok, "resource should contain block")
+	assert.Equal(t, a.Chain.Blocks[1], block)
+
+	req.Params["hash"] = blockchain.NewTestHash()
+	resp = a.RequestHandler(req)
+	assert.Equal(t, msg.ResourceNotFound, int(resp.Error.Code), resp.Error.Message)
+}
+
+func TestRequestHandlerPrunedBlock(t *testing.T) {
+	a := newTestApp()
+	hash := blockchain.HashSum(a.Chain.Blocks[0])
+	a.Chain.SetPruneDepth(2)
+
+	req := newTestBlockRequest(nil)
+	req.Params = map[string]interface{}{"hash": hash}
+	resp := a.RequestHandler(req)
+	assert.Equal(t, msg.Pruned, int(resp.Error.Code), resp.Error.Message)
+
+	req.ResourceType = msg.ResourceChainInfo
+	resp = a.RequestHandler(req)
+	assert.Equal(t, ChainInfo{Height: 3, PrunedHeight: 1}, resp.Resource)
+}
+
+func TestRequestHandlerTransactionByHash(t *testing.T) {
+	a := newTestApp()
+	txn := a.Chain.Blocks[2].Transactions[1]
+
+	req := newTestBlockRequest(nil)
+	req.ResourceType = msg.ResourceTransaction
+	req.Params = map[string]interface{}{"hash": blockchain.HashSum(txn)}
+	resp := a.RequestHandler(req)
+	assert.Equal(t, txn, resp.Resource)
+
+	req.Params["hash"] = blockchain.NewTestHash()
+	resp = a.RequestHandler(req)
+	assert.Equal(t, msg.ResourceNotFound, int(resp.Error.Code), resp.Error.Message)
+}
+
 func TestRequestHandlerNewBlockBadParams(t *testing.T) {
 	a := newTestApp()
 
@@ -185,7 +232,7 @@ func TestHandleTransaction(t *testing.T) {
 	a := newTestApp()
 	go a.HandleWork()
 	time.Sleep(50 * time.Millisecond)
-	a.transactionQueue <- blockchain.NewTestTransaction()
+	a.transactionQueue <- &queuedTransaction{txn: blockchain.NewTestTransaction()}
 	assert.Equal(t, len(a.transactionQueue), 0)
 }
 
@@ -247,26 +294,148 @@ func TestHandleBlockResponse(t *testing.T) {
 	newBlockChan <- lastBlock
 
 	// Handle the block.
-	changed, upToDate := a.handleBlockResponse(newBlockChan, errChan)
+	cursor := a.Chain.LastBlock()
+	next, changed, upToDate := a.handleBlockResponse(cursor, newBlockChan, errChan)
+	assert.Equal(t, lastBlock, next)
 	assert.True(t, changed)
 	assert.False(t, upToDate)
 
 	// Add an on-chain block to the handler (this shouldn't change the chain).
 	newBlockChan <- a.Chain.Blocks[1]
-	changed, upToDate = a.handleBlockResponse(newBlockChan, errChan)
+	next, changed, upToDate = a.handleBlockResponse(next, newBlockChan, errChan)
+	assert.Equal(t, a.Chain.Blocks[1], next)
 	assert.False(t, changed)
 	assert.False(t, upToDate)
 
 	// More stuff happens.
 	errChan <- msg.NewProtocolError(msg.UpToDate, "")
-	changed, upToDate = a.handleBlockResponse(newBlockChan, errChan)
+	next, changed, upToDate = a.handleBlockResponse(next, newBlockChan, errChan)
 	assert.False(t, changed)
 	assert.True(t, upToDate)
+
+	// The peer doesn't know our tip, so we should step back one block without
+	// changing the chain.
 	errChan <- msg.NewProtocolError(msg.ResourceNotFound, "")
-	changed, upToDate = a.handleBlockResponse(newBlockChan, errChan)
+	next, changed, upToDate = a.handleBlockResponse(
+		a.Chain.LastBlock(), newBlockChan, errChan)
+	assert.Equal(t, a.Chain.Blocks[1], next)
+	assert.False(t, changed)
+	assert.False(t, upToDate)
+	assert.Equal(t, len(a.Chain.Blocks), 3)
+}
+
+func TestHandleBlockResponseUpdatesPool(t *testing.T) {
+	a := newTestApp()
+	newBlockChan := make(chan *blockchain.Block, 1)
+	errChan := make(chan *msg.ProtocolError, 1)
+	bc, b := blockchain.NewValidTestChainAndBlock()
+	a.Chain = bc
+	for _, txn := range b.Transactions[1:] {
+		assert.Equal(t, consensus.ValidTransaction, a.Pool.Push(txn, a.Chain))
+	}
+
+	// The transactions in a block added while syncing leave the pool, so they
+	// aren't mined again.
+	newBlockChan <- b
+	next, changed, _ := a.handleBlockResponse(a.Chain.LastBlock(), newBlockChan, errChan)
+	assert.Equal(t, b, next)
 	assert.True(t, changed)
+	assert.Equal(t, 0, a.Pool.Size())
+}
+
+func TestHandleBlockResponseSideBranch(t *testing.T) {
+	a := newTestApp()
+	newBlockChan := make(chan *blockchain.Block, 1)
+	errChan := make(chan *msg.ProtocolError, 1)
+
+	// A block with an unknown parent is ignored.
+	orphan := blockchain.NewTestBlock()
+	newBlockChan <- orphan
+	cursor := a.Chain.LastBlock()
+	next, changed, upToDate := a.handleBlockResponse(cursor, newBlockChan, errChan)
+	assert.Equal(t, cursor, next)
+	assert.False(t, changed)
 	assert.False(t, upToDate)
-	assert.Equal(t, len(a.Chain.Blocks), 2)
+	assert.Nil(t, a.Chain.GetKnownBlock(blockchain.HashSum(orphan)))
+}
+
+func TestHandleBlockResponseFastValidation(t *testing.T) {
+	a := newTestApp()
+	newBlockChan := make(chan *blockchain.Block, 1)
+	errChan := make(chan *msg.ProtocolError, 1)
+
+	// Roll back two blocks and make the tip a checkpoint.
+	tip := a.Chain.RollBack()
+	parent := a.Chain.RollBack()
+	oldCheckpoints, oldFast := consensus.Checkpoints, consensus.FastValidation
+	defer func() {
+		consensus.Checkpoints, consensus.FastValidation = oldCheckpoints, oldFast
+	}()
+	consensus.Checkpoints = []consensus.Checkpoint{
+		{Height: tip.BlockNumber, Hash: blockchain.HashSum(tip)},
+	}
+	consensus.FastValidation = true
+
+	// The block below the checkpoint waits in the block tree.
+	newBlockChan <- parent
+	next, changed, _ := a.handleBlockResponse(a.Chain.LastBlock(), newBlockChan, errChan)
+	assert.Equal(t, parent, next)
+	assert.False(t, changed)
+	assert.Equal(t, 1, len(a.Chain.Blocks))
+	assert.NotNil(t, a.Chain.GetKnownBlock(blockchain.HashSum(parent)))
+
+	// Both are connected once the checkpoint arrives.
+	newBlockChan <- tip
+	next, changed, _ = a.handleBlockResponse(next, newBlockChan, errChan)
+	assert.Equal(t, tip, next)
+	assert.True(t, changed)
+	assert.Equal(t, 3, len(a.Chain.Blocks))
+	assert.Equal(t, blockchain.HashSum(tip), a.Chain.Head)
+}
+
+// newTestSideBlock creates a block containing only a CloudBase transaction
+// that builds on the given parent.
+func newTestSideBlock(parent *blockchain.Block) *blockchain.Block {
+	cb, _ := blockchain.NewValidCloudBaseTestTransaction()
+	b := &blockchain.Block{
+		BlockHeader: blockchain.BlockHeader{
+			BlockNumber: parent.BlockNumber + 1,
+			LastBlock:   blockchain.HashSum(parent),
+			Target:      consensus.CurrentTarget(),
+			Time:        parent.Time + 1,
+		},
+		Transactions: []*blockchain.Transaction{cb},
+	}
+	b.UpdateMerkleRoot()
+	return b
+}
+
+func TestHandleBlockReorg(t *testing.T) {
+	a := newTestApp()
+	var reorgs []*blockchain.Reorg
+	a.OnReorg(func(r *blockchain.Reorg) {
+		reorgs = append(reorgs, r)
+	})
+	oldTip := a.Chain.LastBlock()
+
+	// A side branch with as much work as the main chain doesn't replace it.
+	side1 := newTestSideBlock(a.Chain.Blocks[1])
+	a.HandleBlock(side1)
+	assert.Equal(t, oldTip, a.Chain.LastBlock())
+	assert.Empty(t, reorgs)
+
+	// Once the side branch has more work it becomes the main chain.
+	side2 := newTestSideBlock(side1)
+	a.HandleBlock(side2)
+	assert.Equal(t, side2, a.Chain.LastBlock())
+	assert.Equal(t, 4, len(a.Chain.Blocks))
+	assert.Equal(t, 1, len(reorgs))
+	assert.Equal(t, 1, reorgs[0].Depth())
+	assert.Equal(t, blockchain.HashSum(oldTip), reorgs[0].OldTip)
+	assert.Equal(t, blockchain.HashSum(side2), reorgs[0].NewTip)
+
+	// The transaction in the disconnected block is back in the pool.
+	assert.NotNil(t, a.Pool.Get(blockchain.HashSum(oldTip.Transactions[1])))
 }
 
 func TestHandleWork(t *testing.T) {
@@ -300,13 +469,188 @@ func TestPay(t *testing.T) {
 	// Fail with low balance.
 	assert.NotNil(t, err)
 
-	a.CurrentUser.Wallet.Balance = amt
+	a.CurrentUser.Wallet().Receive[0].Balance = amt
 	err = a.Pay("badf00d", amt)
 
 	// Fail with bad inputs.
 	assert.NotNil(t, err)
 }
 
+func TestPayFromUnspentOutputs(t *testing.T) {
+	a := newTestApp()
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	a.Chain = bc
+	a.CurrentUser.Wallet().Import(wallets["alice"])
+	assert.Nil(t, a.CurrentUser.Wallet().Refresh(bc))
+
+	// Alice has 3 coins from a single transaction in block 1.
+	inputs, total, err := a.collectInputsForTxn(wallets["alice"].Public().Repr(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), total)
+	assert.Equal(t, []blockchain.TxHashPointer{{
+		BlockNumber: 1,
+		Hash:        blockchain.HashSum(bc.Blocks[1].Transactions[1]),
+		Index:       1,
+	}}, inputs)
+
+	assert.Nil(t, a.Pay("badf00d", 2))
+	assert.Equal(t, 1, a.Pool.Size())
+}
+
+func TestPayWithFee(t *testing.T) {
+	a := newTestApp()
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	a.Chain = bc
+	a.CurrentUser.Wallet().Import(wallets["alice"])
+	assert.Nil(t, a.CurrentUser.Wallet().Refresh(bc))
+
+	// Alice's 3 coins are split between the payment and the fee.
+	assert.Nil(t, a.PayWithFee("badf00d", 2, 1))
+	txn := a.Pool.Peek()
+	assert.Equal(t, []blockchain.TxOutput{{Amount: 2, Recipient: "badf00d"}}, txn.Outputs)
+	fee, err := txn.GetFee(bc)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), fee)
+
+	assert.NotNil(t, a.PayWithFee("badf00d", 2, 2))
+}
+
+func TestPaySplitsAcrossAddresses(t *testing.T) {
+	a := newTestApp()
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	a.Chain = bc
+	a.CurrentUser.Wallet().Import(wallets["alice"])
+	a.CurrentUser.Wallet().Import(wallets["bob"])
+	assert.Nil(t, a.CurrentUser.Wallet().Refresh(bc))
+	assert.NotNil(t, a.PayWithFee("badf00d", 4, 1))
+
+	// Neither alice's 3 coins nor bob's 1 cover the payment and fee alone.
+	assert.Nil(t, a.PayWithFee("badf00d", 3, 1))
+	assert.Equal(t, 2, a.Pool.Size())
+	first, second := a.Pool.Peek(), a.Pool.GetN(1)
+	assert.Equal(t, wallets["alice"].Public(), first.Sender)
+	assert.Equal(t, []blockchain.TxOutput{{Amount: 2, Recipient: "badf00d"}}, first.Outputs)
+	assert.Equal(t, wallets["bob"].Public(), second.Sender)
+	assert.Equal(t, []blockchain.TxOutput{{Amount: 1, Recipient: "badf00d"}}, second.Outputs)
+	assert.Len(t, a.CurrentUser.Wallet().PendingTxns(), 2)
+}
+
+func TestPaySplitSentTogether(t *testing.T) {
+	a := newTestApp()
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	a.Chain = bc
+	a.CurrentUser.Wallet().Import(wallets["alice"])
+	a.CurrentUser.Wallet().Import(wallets["bob"])
+	assert.Nil(t, a.CurrentUser.Wallet().Refresh(bc))
+
+	// Bob's part of the payment is over his quota, so alice's isn't sent
+	// either.
+	a.Pool.SetLimits(pool.Limits{MaxPerSender: 1})
+	other := blockchain.NewTestTransaction()
+	other.Sender = wallets["bob"].Public()
+	a.Pool.PushUnsafe(other)
+	assert.Equal(t, pool.ErrSenderQuota, a.PayWithFee("badf00d", 3, 1))
+	assert.Equal(t, 1, a.Pool.Size())
+	assert.Empty(t, a.CurrentUser.Wallet().PendingTxns())
+
+	assert.Equal(t, blockchain.ErrAmountOverflow,
+		a.PayWithFee("badf00d", math.MaxUint64, 1))
+}
+
+func TestPayFromPendingChange(t *testing.T) {
+	a := newTestApp()
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	a.Chain = bc
+	a.CurrentUser.Wallet().Import(wallets["alice"])
+	assert.Nil(t, a.CurrentUser.Wallet().Refresh(bc))
+
+	// Alice's only confirmed output is spent by her first payment, so her
+	// second payment spends its change.
+	assert.Nil(t, a.Pay("badf00d", 2))
+	first := a.Pool.Peek()
+	assert.Nil(t, a.Pay("badf00d", 1))
+	assert.Equal(t, 2, a.Pool.Size())
+	second := a.Pool.GetN(1)
+	assert.Equal(t, []blockchain.TxHashPointer{
+		blockchain.UnconfirmedInput(blockchain.HashSum(first), 1),
+	}, second.Inputs)
+
+	// The change went to a fresh change address, which sent the second
+	// payment.
+	change := a.CurrentUser.Wallet().Change
+	assert.Len(t, change, 1)
+	assert.Equal(t, change[0].Public().Repr(), first.Outputs[1].Recipient)
+	assert.Equal(t, change[0].Public(), second.Sender)
+	assert.Equal(t, uint64(0), a.CurrentUser.Wallet().GetEffectiveBalance())
+
+	// Both payments can go in the next block.
+	b := a.Pool.NextBlock(bc, blockchain.NewWallet().Public(), 1<<18)
+	assert.Equal(t, []*blockchain.Transaction{first, second}, b.Transactions[1:])
+
+	// Nothing is left to spend.
+	assert.NotNil(t, a.Pay("badf00d", 1))
+}
+
+func TestReplacePayment(t *testing.T) {
+	a := newTestApp()
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	a.Chain = bc
+	a.CurrentUser.Wallet().Import(wallets["alice"])
+	assert.Nil(t, a.CurrentUser.Wallet().Refresh(bc))
+
+	assert.Nil(t, a.Pay("badf00d", 2))
+	old := a.Pool.Peek()
+	assert.NotNil(t, a.ReplacePayment(blockchain.NewTestHash(), "f00d", 1, 0))
+	assert.NotNil(t, a.ReplacePayment(blockchain.HashSum(old), "f00d", 3, 1))
+
+	assert.Nil(t, a.ReplacePayment(blockchain.HashSum(old), "f00d", 1, 1))
+	assert.Equal(t, 1, a.Pool.Size())
+	txn := a.Pool.Peek()
+	assert.Equal(t, old.Inputs, txn.Inputs)
+	assert.Equal(t, "f00d", txn.Outputs[0].Recipient)
+	assert.Equal(t, []*blockchain.Transaction{txn}, a.CurrentUser.Wallet().PendingTxns())
+}
+
+func TestPushHandlerReplacement(t *testing.T) {
+	a := newTestApp()
+	txn := blockchain.NewTestTransaction()
+	old := blockchain.NewTestHash()
+	push := msg.Push{
+		ResourceType: msg.ResourceReplacement,
+		Resource:     pool.Replacement{Old: old, New: txn},
+		From:         "127.0.0.1:8001",
+	}
+	a.PushHandler(&push)
+	tr := <-a.transactionQueue
+	assert.Equal(t, txn, tr.txn)
+	assert.Equal(t, old, *tr.replaces)
+	assert.Equal(t, "127.0.0.1:8001", tr.from)
+}
+
+func TestRestorePool(t *testing.T) {
+	fileName := "poolTestFile.json"
+	a := newTestApp()
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+	a.Chain = bc
+	a.Pool.Push(parent, bc)
+	a.savePool(fileName)
+	defer os.Remove(fileName)
+
+	// The saved pool is restored along with the user's pending transactions.
+	a.Pool = pool.New()
+	a.CurrentUser.Wallet().Receive[0].PendingTxns = []*blockchain.Transaction{child}
+	restored := a.restorePool(fileName)
+	assert.Equal(t, []*blockchain.Transaction{parent, child}, restored)
+	assert.Equal(t, 2, a.Pool.Size())
+
+	// Transactions that are no longer valid are not restored.
+	a.Pool = pool.New()
+	a.CurrentUser.Wallet().Receive[0].PendingTxns = []*blockchain.Transaction{
+		blockchain.NewTestTransaction(),
+	}
+	assert.Equal(t, []*blockchain.Transaction{parent}, a.restorePool(fileName))
+}
+
 func TestRun(t *testing.T) {
 	cfg := conf.Config{
 		Interface: "127.0.0.1",
@@ -317,5 +661,7 @@ func TestRun(t *testing.T) {
 		Console:   false,
 	}
 	Run(cfg)
-	assert.Nil(t, os.Remove(userFileName))
+	assert.Nil(t, os.Remove(UserFileName))
+	assert.Nil(t, os.Remove(blockStoreFileName))
+	os.Remove(blockStoreFileName + ".utxo")
 }
@@ -1,8 +1,10 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/abiosoft/ishell"
 	"github.com/ubclaunchpad/cumulus/blockchain"
@@ -15,6 +17,10 @@ var (
 	shell *ishell.Shell
 )
 
+// defaultUnlockMinutes is how long cryptowallet unlock keeps keys decrypted
+// if no duration is given.
+const defaultUnlockMinutes = 5
+
 // RunConsole starts the Cumulus console. This should be run only once as a
 // goroutine, and logging should be redirected away from stdout before it is run.
 // It takes a pointer to a PeerStore so we can use the PeerStore to interact
@@ -30,6 +36,13 @@ func RunConsole(a *App) *ishell.Shell {
 			send(ctx, a)
 		},
 	})
+	shell.AddCmd(&ishell.Cmd{
+		Name: "replace",
+		Help: "replace a pending transaction before it is mined",
+		Func: func(ctx *ishell.Context) {
+			replace(ctx, a)
+		},
+	})
 	shell.AddCmd(&ishell.Cmd{
 		Name: "wallet",
 		Help: "view the status of a wallet",
@@ -37,6 +50,34 @@ func RunConsole(a *App) *ishell.Shell {
 			checkWallet(ctx, a)
 		},
 	})
+	shell.AddCmd(&ishell.Cmd{
+		Name: "account",
+		Help: "list, create, select, rename or archive accounts",
+		Func: func(ctx *ishell.Context) {
+			accounts(ctx, a)
+		},
+	})
+	shell.AddCmd(&ishell.Cmd{
+		Name: "watch",
+		Help: "follow addresses you don't hold the keys of",
+		Func: func(ctx *ishell.Context) {
+			watch(ctx, a)
+		},
+	})
+	shell.AddCmd(&ishell.Cmd{
+		Name: "receive",
+		Help: "show a fresh address to receive coins at",
+		Func: func(ctx *ishell.Context) {
+			receive(ctx, a)
+		},
+	})
+	shell.AddCmd(&ishell.Cmd{
+		Name: "seed",
+		Help: "back up or restore the wallet with a mnemonic phrase",
+		Func: func(ctx *ishell.Context) {
+			seed(ctx, a)
+		},
+	})
 	shell.AddCmd(&ishell.Cmd{
 		Name: "address",
 		Help: "show the address this host is listening on",
@@ -96,7 +137,7 @@ func encryptUser(ctx *ishell.Context, app *App, password string) error {
 		return err
 	}
 
-	if err := app.CurrentUser.Save(userFileName); err != nil {
+	if err := app.CurrentUser.Save(UserFileName); err != nil {
 		app.CurrentUser.DecryptPrivateKey(password)
 		ctx.Print(err)
 		return err
@@ -111,7 +152,7 @@ func decryptUser(ctx *ishell.Context, app *App, password string) error {
 		return err
 	}
 
-	if err := app.CurrentUser.Save(userFileName); err != nil {
+	if err := app.CurrentUser.Save(UserFileName); err != nil {
 		app.CurrentUser.EncryptPrivateKey(password)
 		ctx.Print(err)
 		return err
@@ -126,6 +167,10 @@ func cryptoWallet(ctx *ishell.Context, app *App) {
 		ctx.Println("\nCOMMANDS:")
 		ctx.Println("\t enable \t Enable cryptowallet")
 		ctx.Println("\t disable \t Disable cryptowallet")
+		ctx.Println("\t unlock \t Keep keys decrypted for a number of minutes " +
+			"(default 5)")
+		ctx.Println("\t lock   \t Wipe decrypted keys before they time out")
+		ctx.Println("\t status \t Show whether cryptowallet is enabled")
 		return
 	}
 
@@ -188,12 +233,50 @@ func cryptoWallet(ctx *ishell.Context, app *App) {
 		} else {
 			ctx.Print("Successfully disabled cryptowallet")
 		}
+	case "unlock":
+		if !app.CurrentUser.CryptoWallet {
+			ctx.Println("CryptoWallet is disabled")
+			return
+		}
+		minutes := defaultUnlockMinutes
+		if len(ctx.Args) > 1 {
+			var err error
+			if minutes, err = strconv.Atoi(ctx.Args[1]); err != nil || minutes <= 0 {
+				ctx.Println("Minutes must be a positive number")
+				return
+			}
+		}
+		ctx.Print("Enter password: ")
+		password := ctx.ReadPassword()
+		d := time.Duration(minutes) * time.Minute
+		err := app.CurrentUser.Unlock(password, d)
+
+		// Invalid password, try again
+		if InvalidPassword(err) {
+			ctx.Print("Inavalid password, try again: ")
+			password = ctx.ReadPassword()
+			err = app.CurrentUser.Unlock(password, d)
+		}
+		if err != nil {
+			ctx.Println("Unable to decrypt private key:", err)
+			return
+		}
+		// Keys encrypted before keystores have just been moved to one.
+		if err := app.CurrentUser.Save(UserFileName); err != nil {
+			ctx.Println(err)
+		}
+		ctx.Printf("Unlocked for %d minutes\n", minutes)
+	case "lock":
+		app.CurrentUser.Lock()
+		ctx.Println("Locked")
 	case "status":
 		var s string
-		if app.CurrentUser.CryptoWallet {
-			s = "enabled"
-		} else {
+		if !app.CurrentUser.CryptoWallet {
 			s = "disabled"
+		} else if app.CurrentUser.Unlocked() {
+			s = "enabled (unlocked)"
+		} else {
+			s = "enabled"
 		}
 		ctx.Printf("cryptowallet status: %s", s)
 	default:
@@ -201,59 +284,67 @@ func cryptoWallet(ctx *ishell.Context, app *App) {
 		ctx.Println("\nCOMMANDS:")
 		ctx.Println("\t enable \t Enable cryptowallet")
 		ctx.Println("\t disable \t Disable cryptowallet")
+		ctx.Println("\t unlock \t Keep keys decrypted for a number of minutes " +
+			"(default 5)")
+		ctx.Println("\t lock   \t Wipe decrypted keys before they time out")
+		ctx.Println("\t status \t Show whether cryptowallet is enabled")
 	}
 }
 
 func send(ctx *ishell.Context, app *App) {
-	if len(ctx.Args) < 2 {
-		ctx.Println("Usage: send [amount] [public address]")
+	args, account := parseFromAccount(ctx.Args, app.CurrentUser.Current().Name)
+	if len(args) < 2 {
+		ctx.Println("Usage: send [amount] [public address] [fee] [--from account]")
 		return
 	}
 
-	amount, err := strconv.ParseFloat(ctx.Args[0], 64)
+	amount, fee, err := parseAmountAndFee(args[0], args[2:])
 	if err != nil {
 		ctx.Println(err)
 		return
-	} else if amount <= 0 {
-		ctx.Println("Amount must be a positive decimal value")
-		return
 	}
-	amount *= float64(blockchain.CoinValue)
-	addr := ctx.Args[1]
+	addr := args[1]
 
-	cryptoWallet := false
-	password := ""
-	if app.CurrentUser.CryptoWallet {
-		ctx.Print("Enter cryptowallet password: ")
-		password = ctx.ReadPassword()
-		err = app.CurrentUser.DecryptPrivateKey(password)
+	// Try to make a payment.
+	err = withPrivateKey(ctx, app, func() error {
+		ctx.Println("Sending amount", coinValue(amount), "to", addr,
+			"with fee", coinValue(fee), "from account", account)
+		return app.PayFrom(account, addr, amount, fee)
+	})
+	if err != nil {
+		emoji.Println(":disappointed: ", err)
+	} else {
+		emoji.Println(":mailbox_with_mail: Its in the mail!")
+	}
+}
 
-		// Invalid password, try again
-		if InvalidPassword(err) {
-			ctx.Print("Inavalid password, try again: ")
-			password = ctx.ReadPassword()
-			err = app.CurrentUser.DecryptPrivateKey(password)
-		}
-		if err != nil {
-			ctx.Println("Cannot proceed with transaction, unable to decrypt private key")
-			return
-		}
-		cryptoWallet = true
+func replace(ctx *ishell.Context, app *App) {
+	if len(ctx.Args) < 3 {
+		ctx.Println("Usage: replace [pending transaction] [amount] [public address] [fee]")
+		return
 	}
 
-	// Try to make a payment.
-	ctx.Println("Sending amount", coinValue(uint64(amount)), "to", addr)
-	err = app.Pay(addr, uint64(amount))
+	pending := app.CurrentUser.Wallet().PendingTxns()
+	i, err := strconv.Atoi(ctx.Args[0])
+	if err != nil || i < 0 || i >= len(pending) {
+		ctx.Println("Pending transaction must be a number listed by the wallet command")
+		return
+	}
+	old := blockchain.HashSum(pending[i])
 
-	// Re-enable crypto wallet
-	if cryptoWallet {
-		err := app.CurrentUser.EncryptPrivateKey(password)
-		if err != nil {
-			ctx.Println("Error re-encrypting private key locally")
-			panic(err)
-		}
+	amount, fee, err := parseAmountAndFee(ctx.Args[1], ctx.Args[3:])
+	if err != nil {
+		ctx.Println(err)
+		return
 	}
+	addr := ctx.Args[2]
 
+	// Try to replace the payment.
+	err = withPrivateKey(ctx, app, func() error {
+		ctx.Println("Replacing pending transaction", i, "with amount",
+			coinValue(amount), "to", addr, "with fee", coinValue(fee))
+		return app.ReplacePayment(old, addr, amount, fee)
+	})
 	if err != nil {
 		emoji.Println(":disappointed: ", err)
 	} else {
@@ -261,25 +352,126 @@ func send(ctx *ishell.Context, app *App) {
 	}
 }
 
+// parseFromAccount removes a trailing "--from [account]" from console
+// arguments, returning the remaining arguments and the account, or the given
+// default account if there was none.
+func parseFromAccount(args []string, account string) ([]string, string) {
+	if n := len(args); n >= 2 && args[n-2] == "--from" {
+		return args[:n-2], args[n-1]
+	}
+	return args, account
+}
+
+// parseAmountAndFee parses a coin amount and an optional fee from console
+// arguments.
+func parseAmountAndFee(amountArg string, feeArgs []string) (uint64, uint64, error) {
+	amount, err := strconv.ParseFloat(amountArg, 64)
+	if err != nil {
+		return 0, 0, err
+	} else if amount <= 0 {
+		return 0, 0, errors.New("Amount must be a positive decimal value")
+	}
+	amount *= float64(blockchain.CoinValue)
+
+	fee := float64(0)
+	if len(feeArgs) > 0 {
+		fee, err = strconv.ParseFloat(feeArgs[0], 64)
+		if err != nil {
+			return 0, 0, err
+		} else if fee < 0 {
+			return 0, 0, errors.New("Fee must be a non-negative decimal value")
+		}
+		fee *= float64(blockchain.CoinValue)
+	}
+	return uint64(amount), uint64(fee), nil
+}
+
+// withPrivateKey runs f with the current user's keys decrypted, asking for
+// the cryptowallet password if it is enabled and not unlocked, and wipes them
+// again after. Changes to the user's seeds or keys need withSecrets instead.
+func withPrivateKey(ctx *ishell.Context, app *App, f func() error) error {
+	if app.CurrentUser.Unlocked() {
+		return f()
+	}
+
+	ctx.Print("Enter cryptowallet password: ")
+	password := ctx.ReadPassword()
+	err := app.CurrentUser.Unlock(password, 0)
+
+	// Invalid password, try again
+	if InvalidPassword(err) {
+		ctx.Print("Inavalid password, try again: ")
+		password = ctx.ReadPassword()
+		err = app.CurrentUser.Unlock(password, 0)
+	}
+	if err != nil {
+		return errors.New("Cannot proceed with transaction, unable to decrypt private key")
+	}
+	defer app.CurrentUser.Lock()
+	return f()
+}
+
+// withSecrets runs f, which may change the current user's seeds or keys, with
+// the cryptowallet disabled, asking for its password if it is enabled, and
+// enables it again after with the same password.
+func withSecrets(ctx *ishell.Context, app *App, f func() error) error {
+	if !app.CurrentUser.CryptoWallet {
+		return f()
+	}
+
+	ctx.Print("Enter cryptowallet password: ")
+	password := ctx.ReadPassword()
+	err := app.CurrentUser.DecryptPrivateKey(password)
+
+	// Invalid password, try again
+	if InvalidPassword(err) {
+		ctx.Print("Inavalid password, try again: ")
+		password = ctx.ReadPassword()
+		err = app.CurrentUser.DecryptPrivateKey(password)
+	}
+	if err != nil {
+		return errors.New("Cannot proceed, unable to decrypt private key")
+	}
+
+	err = f()
+
+	// Re-enable crypto wallet
+	if err := app.CurrentUser.EncryptPrivateKey(password); err != nil {
+		ctx.Println("Error re-encrypting private key locally")
+		panic(err)
+	}
+	return err
+}
+
 func checkWallet(ctx *ishell.Context, app *App) {
 	app.Chain.RLock()
 	defer app.Chain.RUnlock()
 
-	wallet := app.CurrentUser.Wallet
+	account := app.CurrentUser.Current()
+	if len(ctx.Args) > 0 {
+		var err error
+		if account, err = app.CurrentUser.Account(ctx.Args[0]); err != nil {
+			ctx.Println(err)
+			return
+		}
+	}
+	wallet := account.Wallet
 
 	// Show actual and effective balance
-	ctx.Println("Balance:", coinValue(wallet.Balance))
+	ctx.Println("Account:", account.Name)
+	ctx.Println("Balance:", coinValue(wallet.Balance()))
 	ctx.Println("Effective Balance:", coinValue(wallet.GetEffectiveBalance()))
+	ctx.Println("Addresses:", len(wallet.Wallets()))
 
 	// Show list of pending transactions
-	if len(wallet.PendingTxns) > 0 {
+	if pending := wallet.PendingTxns(); len(pending) > 0 {
 		ctx.Println("Pending Transactions:")
-		for i, txn := range wallet.PendingTxns {
+		for i, txn := range pending {
 			ctx.Println("\nTransaction ", strconv.Itoa(i))
 
 			var recipient string
 			for _, output := range txn.Outputs {
-				if output.Recipient != txn.Sender.Repr() {
+				if wallet.Find(output.Recipient) == nil {
 					recipient = output.Recipient
 					break
 				}
@@ -366,19 +558,258 @@ func toggleMiner(ctx *ishell.Context, app *App) {
 	}
 }
 
-func createWallet(ctx *ishell.Context, app *App) {
-	// Create a new wallet and set as CurrentUser's wallet.
-	wallet := blockchain.NewWallet()
-	app.CurrentUser.Wallet = wallet
-	emoji.Println(":credit_card: New wallet created!")
+func receive(ctx *ishell.Context, app *App) {
+	// Derive a fresh receive address, which needs the user's seed.
+	var wallet *blockchain.Wallet
+	err := withPrivateKey(ctx, app, func() error {
+		var err error
+		wallet, err = app.CurrentUser.Wallet().NextReceive()
+		return err
+	})
+	if err != nil {
+		emoji.Println(":disappointed: ", err)
+		return
+	}
+	if err := app.CurrentUser.Save(UserFileName); err != nil {
+		ctx.Println(err)
+	}
 
 	// Give a printout of the address(es).
 	emoji.Print(":mailbox:")
 	ctx.Println(" Address: " + wallet.Public().Repr())
+	ctx.Println("Public Key: " + wallet.Public().PublicKey())
 	emoji.Println(":fist: Emoji Address: " + wallet.Public().Emoji())
 	ctx.Println("")
 }
 
+func seed(ctx *ishell.Context, app *App) {
+	usage := func(ctx *ishell.Context) {
+		ctx.Println("\nUsage: seed [command]")
+		ctx.Println("\nCOMMANDS:")
+		ctx.Println("\t show    \t Show the mnemonic phrase that backs up the wallet")
+		ctx.Println("\t restore \t Replace the wallet with one restored from a " +
+			"mnemonic phrase")
+	}
+	if len(ctx.Args) != 1 {
+		usage(ctx)
+		return
+	}
+
+	switch ctx.Args[0] {
+	case "show":
+		err := withPrivateKey(ctx, app, func() error {
+			mnemonic, err := app.CurrentUser.Wallet().Mnemonic()
+			if err != nil {
+				return err
+			}
+			ctx.Println("Write these words down and keep them somewhere safe.")
+			ctx.Println("Anyone who has them can spend your coins.")
+			ctx.Println("\n\t" + mnemonic + "\n")
+			return nil
+		})
+		if err != nil {
+			ctx.Println("Unable to show mnemonic phrase:", err)
+		}
+	case "restore":
+		ctx.Print("Enter mnemonic phrase: ")
+		mnemonic := ctx.ReadLine()
+		ctx.Print("Enter passphrase (if any): ")
+		passphrase := ctx.ReadPassword()
+
+		// Find the accounts and addresses the seed has used on the chain.
+		err := withSecrets(ctx, app, func() error {
+			app.Chain.RLock()
+			defer app.Chain.RUnlock()
+			return app.CurrentUser.Restore(mnemonic, passphrase, app.Chain)
+		})
+		if err != nil {
+			ctx.Println("Unable to restore wallet:", err)
+			return
+		}
+		if err := app.CurrentUser.Save(UserFileName); err != nil {
+			ctx.Println(err)
+		}
+		for _, account := range app.CurrentUser.Accounts {
+			ctx.Println("Restored account", account.Name, "with",
+				len(account.Wallet.Wallets()), "addresses and balance",
+				coinValue(account.Wallet.Balance()))
+		}
+	default:
+		usage(ctx)
+	}
+}
+
+func accounts(ctx *ishell.Context, app *App) {
+	usage := func(ctx *ishell.Context) {
+		ctx.Println("\nUsage: account [command] [name] [new name]")
+		ctx.Println("\nCOMMANDS:")
+		ctx.Println("\t list      \t List accounts, including archived ones with \"all\"")
+		ctx.Println("\t new       \t Create an account")
+		ctx.Println("\t select    \t Pay and receive with an account by default")
+		ctx.Println("\t rename    \t Rename an account")
+		ctx.Println("\t archive   \t Hide an account and stop spending from it")
+		ctx.Println("\t unarchive \t Bring back an archived account")
+	}
+	if len(ctx.Args) == 0 {
+		listAccounts(ctx, app, false)
+		return
+	}
+
+	user := app.CurrentUser
+	var err error
+	switch args := ctx.Args[1:]; {
+	case ctx.Args[0] == "list":
+		listAccounts(ctx, app, len(args) > 0 && args[0] == "all")
+		return
+	case ctx.Args[0] == "new" && len(args) == 1:
+		// Deriving the account's keys needs the user's seed, which the
+		// keystore must hold for the new account too.
+		err = withSecrets(ctx, app, func() error {
+			account, err := user.NewAccount(args[0])
+			if err != nil {
+				return err
+			}
+
+			// The account may have been used before the seed was restored.
+			app.Chain.RLock()
+			defer app.Chain.RUnlock()
+			return account.Wallet.Recover(app.Chain, blockchain.DefaultGapLimit)
+		})
+	case ctx.Args[0] == "select" && len(args) == 1:
+		err = user.Select(args[0])
+	case ctx.Args[0] == "rename" && len(args) == 2:
+		err = user.Rename(args[0], args[1])
+	case ctx.Args[0] == "archive" && len(args) == 1:
+		err = user.Archive(args[0], true)
+	case ctx.Args[0] == "unarchive" && len(args) == 1:
+		err = user.Archive(args[0], false)
+	default:
+		usage(ctx)
+		return
+	}
+	if err != nil {
+		ctx.Println(err)
+		return
+	}
+	if err := user.Save(UserFileName); err != nil {
+		ctx.Println(err)
+	}
+	listAccounts(ctx, app, false)
+}
+
+func watch(ctx *ishell.Context, app *App) {
+	usage := func(ctx *ishell.Context) {
+		ctx.Println("\nUsage: watch [command] [address or label] [label]")
+		ctx.Println("\nCOMMANDS:")
+		ctx.Println("\t list    \t List watched addresses and their balances")
+		ctx.Println("\t add     \t Watch an address or public key, with an optional label")
+		ctx.Println("\t remove  \t Stop watching an address")
+		ctx.Println("\t history \t List the transactions to and from a watched address")
+	}
+	if len(ctx.Args) == 0 {
+		listWatches(ctx, app)
+		return
+	}
+
+	user := app.CurrentUser
+	switch args := ctx.Args[1:]; {
+	case ctx.Args[0] == "list":
+		listWatches(ctx, app)
+		return
+	case ctx.Args[0] == "add" && (len(args) == 1 || len(args) == 2):
+		label := ""
+		if len(args) == 2 {
+			label = args[1]
+		}
+		app.Chain.RLock()
+		_, err := user.Watch(args[0], label, app.Chain)
+		app.Chain.RUnlock()
+		if err != nil {
+			ctx.Println(err)
+			return
+		}
+	case ctx.Args[0] == "remove" && len(args) == 1:
+		if err := user.Unwatch(args[0]); err != nil {
+			ctx.Println(err)
+			return
+		}
+	case ctx.Args[0] == "history" && len(args) == 1:
+		watchHistory(ctx, app, args[0])
+		return
+	default:
+		usage(ctx)
+		return
+	}
+	if err := user.Save(UserFileName); err != nil {
+		ctx.Println(err)
+	}
+	listWatches(ctx, app)
+}
+
+// listWatches prints the label, address and balance of each of the current
+// user's watched addresses.
+func listWatches(ctx *ishell.Context, app *App) {
+	app.Chain.RLock()
+	defer app.Chain.RUnlock()
+
+	if len(app.CurrentUser.Watches) == 0 {
+		ctx.Println("No watched addresses")
+	}
+	for _, w := range app.CurrentUser.Watches {
+		ctx.Println(w.Label)
+		ctx.Println("\tAddress:", w.Repr)
+		ctx.Println("\tBalance:", coinValue(w.Balance))
+		ctx.Println("\tTransactions:", len(w.History))
+	}
+}
+
+// watchHistory prints the transactions to and from the watched address with
+// the given address or label.
+func watchHistory(ctx *ishell.Context, app *App, addrOrLabel string) {
+	app.Chain.RLock()
+	defer app.Chain.RUnlock()
+
+	w, err := app.CurrentUser.FindWatch(addrOrLabel)
+	if err != nil {
+		ctx.Println(err)
+		return
+	}
+	ctx.Println("Address:", w.Repr)
+	ctx.Println("Balance:", coinValue(w.Balance))
+	for _, t := range w.History {
+		amount, party := "Received:", "From:"
+		if t.Outgoing {
+			amount, party = "Sent:", "To:"
+		}
+		ctx.Printf("\nBlock %d, transaction %x\n", t.BlockNumber, t.Hash)
+		ctx.Println("\t"+amount, coinValue(t.Amount))
+		ctx.Println("\t"+party, t.Counterparty)
+	}
+}
+
+// listAccounts prints the name, balance and address of each of the current
+// user's accounts, marking the selected one.
+func listAccounts(ctx *ishell.Context, app *App, archived bool) {
+	app.Chain.RLock()
+	defer app.Chain.RUnlock()
+
+	for _, account := range app.CurrentUser.Accounts {
+		if account.Archived && !archived {
+			continue
+		}
+		marker := " "
+		if account == app.CurrentUser.Current() {
+			marker = "*"
+		}
+		ctx.Println(marker, account.Name)
+		ctx.Println("\tBalance:", coinValue(account.Wallet.Balance()))
+		ctx.Println("\tAddress:", account.Wallet.Public().Repr())
+		if account.Archived {
+			ctx.Println("\tArchived")
+		}
+	}
+}
+
 func editUser(ctx *ishell.Context, app *App) {
 	if len(ctx.Args) == 0 {
 		ctx.Println("Current User:")
@@ -389,7 +820,7 @@ func editUser(ctx *ishell.Context, app *App) {
 	} else if len(ctx.Args) == 2 {
 		if ctx.Args[0] == "name" {
 			app.CurrentUser.Name = ctx.Args[1]
-			if err := app.CurrentUser.Save(userFileName); err != nil {
+			if err := app.CurrentUser.Save(UserFileName); err != nil {
 				ctx.Print(err)
 			}
 			return
@@ -403,7 +834,7 @@ func editUser(ctx *ishell.Context, app *App) {
 				return
 			}
 			app.CurrentUser.BlockSize = (uint32)(size)
-			if err := app.CurrentUser.Save(userFileName); err != nil {
+			if err := app.CurrentUser.Save(UserFileName); err != nil {
 				ctx.Print(err)
 			}
 			return
@@ -11,6 +11,7 @@ func TestRunConsoleHasCommands(t *testing.T) {
 	a := newTestApp()
 	s := RunConsole(a)
 	expected := []string{
+		"account",
 		"address",
 		"clear",
 		"connect",
@@ -19,9 +20,13 @@ func TestRunConsoleHasCommands(t *testing.T) {
 		"help",
 		"miner",
 		"peers",
+		"receive",
+		"replace",
+		"seed",
 		"send",
 		"user",
 		"wallet",
+		"watch",
 	}
 	c := s.Cmds()
 	found := []string{}
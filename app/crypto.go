@@ -7,6 +7,7 @@ import (
 	"crypto/sha512"
 	"strings"
 
+	"github.com/ubclaunchpad/cumulus/keystore"
 	"golang.org/x/crypto/pbkdf2"
 )
 
@@ -28,7 +29,8 @@ const (
 	maxPasswordLen = 128
 )
 
-// Encrypt encrypts cipherText with a given password
+// Encrypt encrypts cipherText with a given password. It was used to encrypt
+// private keys before keystores, and is kept to migrate them.
 func Encrypt(plainText []byte, password string) ([]byte, error) {
 
 	passwordBytes := []byte(password)
@@ -103,11 +105,13 @@ func VerifyPasswordComplexity(password string) bool {
 	return (len(password) >= minPasswordLen) && (len(password) <= maxPasswordLen)
 }
 
-// InvalidPassword is returned from Decrypt if an invalid password is used to
-// decrypt the ciphertext
+// InvalidPassword returns true if the error is returned from Decrypt or a
+// keystore because an invalid password was used to decrypt the ciphertext
 func InvalidPassword(err error) bool {
 	if err == nil {
 		return false
+	} else if err == keystore.ErrBadPassword {
+		return true
 	}
 	return strings.Compare(err.Error(), "cipher: message authentication failed") == 0
 }
@@ -0,0 +1,285 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+	"github.com/ubclaunchpad/cumulus/keystore"
+)
+
+// legacyKeyCipherLen is the length of a private key encrypted by Encrypt: the
+// key, the GCM tag, the salt and the nonce.
+const legacyKeyCipherLen = blockchain.CoordLen + 16 + saltSize + nonceSize
+
+var (
+	// keystoreParams are the scrypt parameters of new keystores.
+	keystoreParams = keystore.StandardParams
+
+	// ErrKeystoreMismatch is returned when a user's keystore doesn't hold the
+	// keys of all of its wallets.
+	ErrKeystoreMismatch = errors.New("Keystore does not hold the user's keys")
+)
+
+// secrets are the parts of a user that are kept in its keystore rather than
+// its user file: the seed of each account, by the address of the account's
+// first receive key, and the keys that weren't derived from a seed, by
+// address. Derived keys are derived again from their seed when unlocking.
+type secrets struct {
+	Seeds map[string]seedSecret
+	Keys  map[string][]byte
+}
+
+// seedSecret is the seed of an account and the entropy of its mnemonic.
+type seedSecret struct {
+	Entropy, Seed []byte
+}
+
+// KeystoreFileName returns the name of the file that holds the keystore of
+// the user saved to the file with the given name.
+func KeystoreFileName(userFileName string) string {
+	return strings.TrimSuffix(userFileName, filepath.Ext(userFileName)) +
+		".keystore.json"
+}
+
+// Unlock decrypts the user's keys and seeds with the cryptowallet password
+// and keeps them in memory for the given duration, after which they are
+// wiped again. A duration of zero keeps them until Lock is called. Users
+// whose keys were encrypted before keystores are migrated to a keystore,
+// which is written the next time the user is saved.
+func (u *User) Unlock(password string, d time.Duration) error {
+	u.keysLock.Lock()
+	defer u.keysLock.Unlock()
+	if !u.CryptoWallet {
+		return nil
+	}
+	if err := u.unlockKeys(password); err != nil {
+		return err
+	}
+	u.stopRelock()
+	if d > 0 {
+		u.relock = time.AfterFunc(d, u.Lock)
+	}
+	return nil
+}
+
+// Lock wipes the user's decrypted keys and seeds from memory if the
+// cryptowallet is enabled.
+func (u *User) Lock() {
+	u.keysLock.Lock()
+	defer u.keysLock.Unlock()
+	if u.CryptoWallet && u.keystore != nil {
+		u.wipeKeys()
+	}
+	u.stopRelock()
+}
+
+// Unlocked returns true if the user's keys can be used, either because the
+// cryptowallet is disabled or because it has been unlocked.
+func (u *User) Unlocked() bool {
+	u.keysLock.Lock()
+	defer u.keysLock.Unlock()
+	return !u.CryptoWallet || u.unlocked
+}
+
+// stopRelock cancels the pending relock, if any.
+func (u *User) stopRelock() {
+	if u.relock != nil {
+		u.relock.Stop()
+		u.relock = nil
+	}
+}
+
+// unlockKeys decrypts the user's keys and seeds with the password, from the
+// keystore or else from the legacy encryption of the user's wallets.
+func (u *User) unlockKeys(password string) error {
+	if u.keystore == nil {
+		return u.migrateKeys(password)
+	}
+	plainText, err := u.keystore.Open(password)
+	if err != nil {
+		return err
+	}
+	var s secrets
+	if err := json.Unmarshal(plainText, &s); err != nil {
+		return err
+	}
+	if err := u.setSecrets(&s); err != nil {
+		u.wipeKeys()
+		return err
+	}
+	u.unlocked = true
+	return nil
+}
+
+// seal replaces the user's keystore with one holding the user's current
+// secrets, encrypted with the password.
+func (u *User) seal(password string) error {
+	plainText, err := json.Marshal(u.secrets())
+	if err != nil {
+		return err
+	}
+	ks, err := keystore.Seal(plainText, password, keystoreParams)
+	if err != nil {
+		return err
+	}
+	u.keystore = ks
+	return nil
+}
+
+// secrets returns the seeds and imported keys of the user's accounts.
+func (u *User) secrets() *secrets {
+	s := &secrets{
+		Seeds: make(map[string]seedSecret),
+		Keys:  make(map[string][]byte),
+	}
+	for _, account := range u.Accounts {
+		hd := account.Wallet
+		if len(hd.Seed) > 0 && len(hd.Receive) > 0 {
+			s.Seeds[hd.Receive[0].Public().Repr()] = seedSecret{hd.Entropy, hd.Seed}
+		}
+		for _, w := range hd.Imported {
+			s.Keys[w.Public().Repr()] = w.PrivateKeyBytes()
+		}
+	}
+	return s
+}
+
+// setSecrets gives the user's accounts their seeds and their wallets their
+// keys. Returns an error if a key is missing or doesn't match its wallet.
+func (u *User) setSecrets(s *secrets) error {
+	for _, account := range u.Accounts {
+		hd := account.Wallet
+		if len(hd.Receive) > 0 {
+			seed, ok := s.Seeds[hd.Receive[0].Public().Repr()]
+			if !ok {
+				return ErrKeystoreMismatch
+			}
+			hd.Entropy, hd.Seed = seed.Entropy, seed.Seed
+		}
+		for chain, wallets := range map[uint32][]*blockchain.Wallet{
+			blockchain.ReceiveChain: hd.Receive,
+			blockchain.ChangeChain:  hd.Change,
+		} {
+			for i, w := range wallets {
+				derived, err := hd.Derive(chain, uint32(i))
+				if err != nil {
+					return err
+				}
+				if err := w.Unlock(derived.PrivateKeyBytes()); err != nil {
+					return ErrKeystoreMismatch
+				}
+			}
+		}
+		for _, w := range hd.Imported {
+			if err := w.Unlock(s.Keys[w.Public().Repr()]); err != nil {
+				return ErrKeystoreMismatch
+			}
+		}
+	}
+	return nil
+}
+
+// wipeKeys wipes the keys and seeds of the user's accounts from memory.
+func (u *User) wipeKeys() {
+	for _, w := range u.keys() {
+		w.Lock(nil)
+	}
+	for _, account := range u.Accounts {
+		for _, secret := range [][]byte{account.Wallet.Entropy, account.Wallet.Seed} {
+			for i := range secret {
+				secret[i] = 0
+			}
+		}
+		account.Wallet.Entropy, account.Wallet.Seed = nil, nil
+	}
+	u.unlocked = false
+}
+
+// migrateKeys decrypts keys and seeds that were encrypted by Encrypt and
+// stored in the user's wallets, before keystores, and seals them in a new
+// keystore with the same password. Nothing is replaced if decryption fails.
+func (u *User) migrateKeys(password string) error {
+	wallets := u.keys()
+	keys := make([][]byte, len(wallets))
+	for i, w := range wallets {
+		if len(w.EncryptedKey) == 0 {
+			return blockchain.ErrNoPrivateKey
+		}
+		key, err := decryptLegacyKey(w.EncryptedKey, password)
+		if err != nil {
+			return err
+		}
+		if err := w.CheckKey(key); err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+	entropies := make([][]byte, len(u.Accounts))
+	seeds := make([][]byte, len(u.Accounts))
+	for i, account := range u.Accounts {
+		entropies[i], seeds[i] = account.Wallet.Entropy, account.Wallet.Seed
+		if len(seeds[i]) == 0 {
+			continue
+		}
+		var err error
+		if entropies[i], err = Decrypt(entropies[i], password); err != nil {
+			return err
+		}
+		if seeds[i], err = Decrypt(seeds[i], password); err != nil {
+			return err
+		}
+	}
+
+	for i, w := range wallets {
+		w.Unlock(keys[i])
+	}
+	for i, account := range u.Accounts {
+		account.Wallet.Entropy = entropies[i]
+		account.Wallet.Seed = seeds[i]
+	}
+	u.unlocked = true
+	if err := u.seal(password); err != nil {
+		return err
+	}
+	log.Info("Moved encrypted keys to a keystore, they will be saved there")
+	return nil
+}
+
+// decryptLegacyKey decrypts a private key that was encrypted by Encrypt.
+// These were stored as integers, dropping any leading zeros of the
+// ciphertext, which are put back until it decrypts.
+func decryptLegacyKey(cipherText []byte, password string) ([]byte, error) {
+	for {
+		key, err := Decrypt(cipherText, password)
+		if err == nil || !InvalidPassword(err) || len(cipherText) >= legacyKeyCipherLen {
+			return key, err
+		}
+		cipherText = append([]byte{0}, cipherText...)
+	}
+}
+
+// withoutSecrets returns a copy of the user without any keys or seeds, to be
+// saved alongside its keystore.
+func (u *User) withoutSecrets() *User {
+	accounts := make([]*Account, len(u.Accounts))
+	for i, account := range u.Accounts {
+		accounts[i] = &Account{
+			Name:     account.Name,
+			Archived: account.Archived,
+			Wallet:   account.Wallet.WatchOnly(),
+		}
+	}
+	return &User{
+		Accounts:     accounts,
+		Selected:     u.Selected,
+		Name:         u.Name,
+		BlockSize:    u.BlockSize,
+		CryptoWallet: u.CryptoWallet,
+		Watches:      u.Watches,
+	}
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+	"github.com/ubclaunchpad/cumulus/keystore"
+)
+
+func init() {
+	keystoreParams = keystore.LightParams
+}
+
+func TestKeystoreFileName(t *testing.T) {
+	assert.Equal(t, "user.keystore.json", KeystoreFileName(UserFileName))
+	assert.Equal(t, "dir/user.keystore.json", KeystoreFileName("dir/user"))
+}
+
+func TestEncryptedUserKeystore(t *testing.T) {
+	fileName := "userTestFile.json"
+	defer os.Remove(fileName)
+	defer os.Remove(KeystoreFileName(fileName))
+
+	user := NewUser()
+	imported := blockchain.NewWallet()
+	user.Wallet().Import(imported)
+	_, err := user.Wallet().NextChange()
+	assert.Nil(t, err)
+	keys := make(map[string][]byte)
+	for _, w := range user.keys() {
+		keys[w.Public().Repr()] = w.PrivateKeyBytes()
+	}
+	seed := append([]byte{}, user.Wallet().Seed...)
+
+	// The keys and seed are wiped from memory and kept out of the user file.
+	assert.Nil(t, user.EncryptPrivateKey("password"))
+	assert.False(t, user.Unlocked())
+	assert.Nil(t, user.Wallet().Seed)
+	for _, w := range user.keys() {
+		assert.True(t, w.Locked())
+	}
+	assert.Nil(t, user.Save(fileName))
+	userBytes, err := ioutil.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(userBytes), `"Key"`))
+	assert.False(t, strings.Contains(string(userBytes), `"Seed":"`))
+	info, err := os.Stat(KeystoreFileName(fileName))
+	assert.Nil(t, err)
+	assert.Equal(t, keystore.FileMode, info.Mode().Perm())
+
+	loaded, err := LoadUser(fileName)
+	assert.Nil(t, err)
+	assert.True(t, loaded.CryptoWallet)
+	assert.False(t, loaded.Unlocked())
+	assert.True(t, InvalidPassword(loaded.Unlock("wrong password", 0)))
+	assert.False(t, loaded.Unlocked())
+
+	// Unlocking derives the keys again, and restores imported ones.
+	assert.Nil(t, loaded.Unlock("password", 0))
+	assert.True(t, loaded.Unlocked())
+	assert.Equal(t, seed, loaded.Wallet().Seed)
+	assert.Len(t, loaded.keys(), len(keys))
+	for _, w := range loaded.keys() {
+		assert.Equal(t, keys[w.Public().Repr()], w.PrivateKeyBytes())
+	}
+
+	// Saving while unlocked still leaves the keys out.
+	assert.Nil(t, loaded.Save(fileName))
+	userBytes, err = ioutil.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(userBytes), `"Key"`))
+
+	loaded.Lock()
+	assert.False(t, loaded.Unlocked())
+	assert.True(t, loaded.Wallet().Receive[0].Locked())
+	assert.Nil(t, loaded.Wallet().Seed)
+}
+
+func TestUnlockExpires(t *testing.T) {
+	user := NewUser()
+	key := user.Wallet().Receive[0].PrivateKeyBytes()
+	assert.Nil(t, user.EncryptPrivateKey("password"))
+
+	assert.Nil(t, user.Unlock("password", 50*time.Millisecond))
+	assert.True(t, user.Unlocked())
+	time.Sleep(200 * time.Millisecond)
+	assert.False(t, user.Unlocked())
+	assert.True(t, user.Wallet().Receive[0].Locked())
+
+	// Disabling the cryptowallet cancels the relock.
+	assert.Nil(t, user.Unlock("password", 50*time.Millisecond))
+	assert.Nil(t, user.DecryptPrivateKey("password"))
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, user.Unlocked())
+	assert.Equal(t, key, user.Wallet().Receive[0].PrivateKeyBytes())
+}
+
+func TestMigrateLegacyEncryptedUser(t *testing.T) {
+	fileName := "userTestFile.json"
+	defer os.Remove(fileName)
+	defer os.Remove(KeystoreFileName(fileName))
+
+	// Keys used to be encrypted with Encrypt and kept in the user's wallets.
+	user := NewUser()
+	key := user.Wallet().Receive[0].PrivateKeyBytes()
+	seed := user.Wallet().Seed
+	for _, w := range user.keys() {
+		cipherText, err := Encrypt(w.PrivateKeyBytes(), "password")
+		assert.Nil(t, err)
+		w.Lock(cipherText)
+	}
+	var err error
+	user.Wallet().Entropy, err = Encrypt(user.Wallet().Entropy, "password")
+	assert.Nil(t, err)
+	user.Wallet().Seed, err = Encrypt(user.Wallet().Seed, "password")
+	assert.Nil(t, err)
+	user.CryptoWallet = true
+	assert.Nil(t, user.Save(fileName))
+	_, err = os.Stat(KeystoreFileName(fileName))
+	assert.True(t, os.IsNotExist(err))
+
+	// Unlocking moves them to a keystore.
+	loaded, err := LoadUser(fileName)
+	assert.Nil(t, err)
+	assert.True(t, InvalidPassword(loaded.Unlock("wrong password", 0)))
+	assert.Nil(t, loaded.Unlock("password", 0))
+	assert.Equal(t, key, loaded.Wallet().Receive[0].PrivateKeyBytes())
+	assert.Equal(t, seed, loaded.Wallet().Seed)
+	assert.Nil(t, loaded.Save(fileName))
+	loaded.Lock()
+
+	loaded, err = LoadUser(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, loaded.Wallet().Receive[0].EncryptedKey)
+	assert.Nil(t, loaded.Unlock("password", 0))
+	assert.Equal(t, key, loaded.Wallet().Receive[0].PrivateKeyBytes())
+}
+
+func TestDecryptLegacyKey(t *testing.T) {
+	// Keys were stored as integers, losing any leading zeros of their
+	// encryption.
+	key := blockchain.NewWallet().PrivateKeyBytes()
+	var cipherText []byte
+	for i := 0; i < 5000 && (len(cipherText) == 0 || cipherText[0] != 0); i++ {
+		var err error
+		cipherText, err = Encrypt(key, "password")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, byte(0), cipherText[0])
+
+	decrypted, err := decryptLegacyKey(cipherText[1:], "password")
+	assert.Nil(t, err)
+	assert.Equal(t, key, decrypted)
+	_, err = decryptLegacyKey(cipherText[1:], "wrong password")
+	assert.True(t, InvalidPassword(err))
+}
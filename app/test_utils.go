@@ -26,7 +26,7 @@ func newTestApp() *App {
 		Miner:            miner.New(),
 		Pool:             pool.New(),
 		blockQueue:       make(chan *blockchain.Block, blockQueueSize),
-		transactionQueue: make(chan *blockchain.Transaction, transactionQueueSize),
+		transactionQueue: make(chan *queuedTransaction, transactionQueueSize),
 		quitChan:         make(chan bool),
 	}
 }
@@ -4,29 +4,47 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"math/big"
+	"io"
 	"os"
+	"sort"
+	"sync"
+	"time"
 
 	crand "crypto/rand"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/ubclaunchpad/cumulus/blockchain"
 	"github.com/ubclaunchpad/cumulus/consensus"
+	"github.com/ubclaunchpad/cumulus/keystore"
 	"github.com/ubclaunchpad/cumulus/msg"
+	"github.com/ubclaunchpad/cumulus/pool"
 )
 
 // User holds basic user information.
 type User struct {
-	Wallet       *blockchain.Wallet
+	Accounts     []*Account
+	Selected     string
 	Name         string
 	BlockSize    uint32
 	CryptoWallet bool
+	// Watches follow addresses whose keys the user doesn't hold.
+	Watches []*blockchain.Watch
+
+	// keystore holds the user's keys and seeds while the cryptowallet is
+	// enabled. It is saved to its own file.
+	keystore *keystore.Keystore
+	// keysLock guards whether the user's keys are unlocked.
+	keysLock sync.Mutex
+	unlocked bool
+	relock   *time.Timer
 }
 
 // NewUser creates a new user
 func NewUser() *User {
+	wallet, _ := blockchain.NewHDWallet(crand.Reader, "")
 	return &User{
-		Wallet:       blockchain.NewWallet(),
+		Accounts:     []*Account{{Name: DefaultAccountName, Wallet: wallet}},
+		Selected:     DefaultAccountName,
 		BlockSize:    blockchain.DefaultBlockSize,
 		Name:         "Default User",
 		CryptoWallet: false,
@@ -35,75 +53,118 @@ func NewUser() *User {
 
 // Public returns the public key of the given user
 func (u *User) Public() blockchain.Address {
-	return u.Wallet.Public()
+	return u.Wallet().Public()
 }
 
-// EncryptPrivateKey encrypts the user's private key
+// EncryptPrivateKey enables the cryptowallet: the user's keys and seeds are
+// moved to a keystore encrypted with the password, and wiped from memory.
 func (u *User) EncryptPrivateKey(password string) error {
+	u.keysLock.Lock()
+	defer u.keysLock.Unlock()
 	if !u.CryptoWallet {
-		pk := u.Wallet.PrivateKey.D.Bytes()
-		pk, err := Encrypt(pk, password)
-		if err != nil {
+		if err := u.seal(password); err != nil {
 			return err
 		}
-
-		u.Wallet.PrivateKey.D = new(big.Int).SetBytes(pk)
+		u.wipeKeys()
 		u.CryptoWallet = true
 	}
 	return nil
 }
 
-// DecryptPrivateKey decrypts the user's private key
+// DecryptPrivateKey disables the cryptowallet: the user's keys and seeds are
+// decrypted with the password and kept with the user again.
 func (u *User) DecryptPrivateKey(password string) error {
+	u.keysLock.Lock()
+	defer u.keysLock.Unlock()
 	if u.CryptoWallet {
-		pk := u.Wallet.PrivateKey.D.Bytes()
-		pk, err := Decrypt(pk, password)
-		if err != nil {
+		if err := u.unlockKeys(password); err != nil {
 			return err
 		}
-
-		u.Wallet.PrivateKey.D = new(big.Int).SetBytes(pk)
+		u.stopRelock()
+		u.keystore = nil
 		u.CryptoWallet = false
 	}
 	return nil
 }
 
+// userFileMode is the permissions of user files, which hold private keys and
+// so must only be readable by their owner.
+const userFileMode os.FileMode = 0600
+
 // Save writes the user to a file of the given name in the current working
-// directory in JSON format. It returns an error if one occurred, or a pointer
-// to the file that was written to on success.
+// directory in JSON format. The user is written to a temporary file which then
+// replaces the file, so that the file is never left partially written. If the
+// cryptowallet is enabled, the user's keys and seeds are left out and its
+// keystore is written to the file named by KeystoreFileName instead. It
+// returns an error if one occurred.
 func (u *User) Save(fileName string) error {
-	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
-		os.Remove(fileName)
+	u.keysLock.Lock()
+	saved := u
+	if u.keystore != nil {
+		saved = u.withoutSecrets()
+		if err := u.keystore.Save(KeystoreFileName(fileName)); err != nil {
+			u.keysLock.Unlock()
+			return err
+		}
 	}
-
-	userFile, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, 0644)
+	userBytes, err := json.Marshal(saved)
+	u.keysLock.Unlock()
 	if err != nil {
 		return err
 	}
-	defer userFile.Close()
 
-	userBytes, err := json.Marshal(u)
+	tmpName := fileName + ".tmp"
+	os.Remove(tmpName)
+	userFile, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		userFileMode)
 	if err != nil {
 		return err
 	}
-
-	if _, err = userFile.Write(userBytes); err != nil {
+	_, err = userFile.Write(userBytes)
+	if err == nil {
+		err = userFile.Sync()
+	}
+	if closeErr := userFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, fileName); err != nil {
 		return err
 	}
+
+	// A keystore left over from before the cryptowallet was disabled is no
+	// longer needed.
+	if !u.CryptoWallet {
+		os.Remove(KeystoreFileName(fileName))
+	}
 	return nil
 }
 
 // LoadUser attempts to read user info from the file with the given name in the
 // current working directory in JSON format. On success this returns
 // a pointer to a new user constructed from the information in the file.
-// If an error occurrs it is returned.
+// If an error occurrs it is returned, and os.IsNotExist reports whether it is
+// because there is no such file. A file that others can read is made private.
 func LoadUser(fileName string) (*User, error) {
-	userFile, err := os.OpenFile(fileName, os.O_RDONLY|os.O_CREATE, 0644)
+	userFile, err := os.Open(fileName)
 	if err != nil {
 		return nil, err
 	}
 	defer userFile.Close()
 
+	if info, err := userFile.Stat(); err != nil {
+		return nil, err
+	} else if info.Mode().Perm()&^userFileMode != 0 {
+		log.Warnf("%s is readable by other users, restricting it to %#o",
+			fileName, userFileMode)
+		if err := os.Chmod(fileName, userFileMode); err != nil {
+			return nil, err
+		}
+	}
+
 	dec := json.NewDecoder(userFile)
 	dec.UseNumber()
 
@@ -112,25 +173,245 @@ func LoadUser(fileName string) (*User, error) {
 		return nil, err
 	}
 
+	// Users saved before they had accounts have a single wallet, which
+	// becomes their default account.
+	if len(u.Accounts) == 0 {
+		if _, err := userFile.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		wallet, err := loadLegacyWallet(userFile)
+		if err != nil {
+			return nil, err
+		}
+		u.Accounts = []*Account{{Name: DefaultAccountName, Wallet: wallet}}
+		u.Selected = DefaultAccountName
+	}
+
+	if u.CryptoWallet {
+		// Users whose keys were encrypted before keystores have none, and
+		// are migrated when unlocked.
+		ks, err := keystore.Load(KeystoreFileName(fileName))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		u.keystore = ks
+	} else {
+		for _, w := range u.keys() {
+			if w.Locked() {
+				return nil, blockchain.ErrNoPrivateKey
+			}
+		}
+	}
+
+	// A seed can only be added while the user's keys are not encrypted.
+	// Accounts with a seed always have a receive key.
+	if account := u.Accounts[0]; len(account.Wallet.Receive) == 0 {
+		if u.CryptoWallet {
+			log.Warn("Wallet has no seed, disable cryptowallet and restart " +
+				"to create one")
+			return &u, nil
+		}
+		wallet, err := blockchain.NewHDWallet(crand.Reader, "")
+		if err != nil {
+			return nil, err
+		}
+		wallet.Imported = account.Wallet.Imported
+		account.Wallet = wallet
+		log.Info("Created a seed for the wallet, back it up with the seed command")
+	}
+
 	return &u, nil
 }
 
+// loadLegacyWallet reads the wallet of a user saved before they had accounts.
+// Before wallets were derived from a seed, it was a single key, which is kept
+// as an imported key.
+func loadLegacyWallet(r io.Reader) (*blockchain.HDWallet, error) {
+	var legacy struct{ Wallet json.RawMessage }
+	if err := json.NewDecoder(r).Decode(&legacy); err != nil {
+		return nil, err
+	}
+	wallet := &blockchain.HDWallet{}
+	if len(legacy.Wallet) == 0 || string(legacy.Wallet) == "null" {
+		return wallet, nil
+	}
+	if err := json.Unmarshal(legacy.Wallet, wallet); err != nil {
+		return nil, err
+	}
+	if len(wallet.Wallets()) > 0 {
+		return wallet, nil
+	}
+
+	key := &blockchain.Wallet{}
+	if err := json.Unmarshal(legacy.Wallet, key); err != nil {
+		return nil, err
+	}
+	wallet.Import(key)
+	return wallet, nil
+}
+
 // Pay pays an amount of coin to an address `to`.
 func (a *App) Pay(to string, amount uint64) error {
-	wallet := a.CurrentUser.Wallet
-	pool := a.Pool
+	return a.PayWithFee(to, amount, 0)
+}
+
+// PayWithFee pays an amount of coin to an address `to` from the current
+// user's selected account, leaving the given fee for the miner who includes
+// the transaction in a block.
+func (a *App) PayWithFee(to string, amount, fee uint64) error {
+	return a.PayFrom(a.CurrentUser.Current().Name, to, amount, fee)
+}
+
+// PayFrom pays an amount of coin to an address `to` from the current user's
+// account with the given name, leaving the given fee for the miner. A
+// transaction spends from a single address, so if no address of the account
+// holds enough on its own, the payment is split into one transaction per
+// address, the first of which leaves the fee. Either all of the transactions
+// are added to the pool and broadcast, or none are.
+func (a *App) PayFrom(account string, to string, amount, fee uint64) error {
+	// The user's keys must not be locked again while the payment is signed.
+	a.CurrentUser.keysLock.Lock()
+	defer a.CurrentUser.keysLock.Unlock()
+
+	from, err := a.CurrentUser.Account(account)
+	if err != nil {
+		return err
+	} else if from.Archived {
+		return ErrAccountArchived
+	}
+	wallet := from.Wallet
+
+	// Collect inputs to the wallet's addresses whose total is >= the given
+	// amount plus the fee
+	total, ok := blockchain.AddAmount(amount, fee)
+	if !ok {
+		return blockchain.ErrAmountOverflow
+	}
+	spends, err := a.collectSpends(wallet, total)
+	if err != nil {
+		return err
+	}
+
+	// The transactions must be signed.
+	txns := make([]*blockchain.Transaction, 0, len(spends))
+	for _, s := range spends {
+		txnFee := fee
+		if txnFee > s.total {
+			txnFee = s.total
+		}
+		pay := s.total - txnFee
+		if pay > amount {
+			pay = amount
+		}
+		txn, err := a.newPayment(wallet, s.wallet, s.inputs, s.total, to, pay, txnFee)
+		if err != nil {
+			return err
+		}
+		txns = append(txns, txn)
+		amount -= pay
+		fee -= txnFee
+	}
+
+	// The transactions must all be added to the pool before any is sent.
+	code, err := a.Pool.PushAll(txns, a.Chain, "")
+	if code != consensus.ValidTransaction {
+		return fmt.Errorf("Transaction validation failed with code %d", code)
+	} else if err != nil {
+		return err
+	}
+
+	for _, txn := range txns {
+		// The transaction must be added to the wallet's pending transcations
+		if err := wallet.SetPending(txn); err != nil {
+			return err
+		}
 
-	// Collect input transactions who's total output to the sender is >= the
-	// given amount
-	inputTxns, totalInput, err := a.collectInputsForTxn(wallet.Public().Repr(), amount)
+		// The transaction must be broadcasted to the network.
+		a.PeerStore.Broadcast(msg.Push{
+			ResourceType: msg.ResourceTransaction,
+			Resource:     txn,
+		})
+	}
+	return nil
+}
+
+// ReplacePayment replaces the current user's pending transaction with the
+// given hash with one that pays an amount of coin to an address `to` from the
+// same inputs, leaving the given fee for the miner. The original transaction
+// is evicted from the pools of the rest of the network.
+func (a *App) ReplacePayment(old blockchain.Hash, to string, amount, fee uint64) error {
+	// The user's keys must not be locked again while the payment is signed.
+	a.CurrentUser.keysLock.Lock()
+	defer a.CurrentUser.keysLock.Unlock()
+
+	oldTxn := a.Pool.Get(old)
+	if oldTxn == nil {
+		return errors.New("Transaction is not pending")
+	}
+	account, wallet := a.CurrentUser.owner(oldTxn.From())
+	if wallet == nil {
+		return blockchain.ErrNotOurs
+	}
+
+	// The replacement spends exactly the inputs of the original.
+	a.Chain.RLock()
+	oldFee, err := a.Pool.Fee(oldTxn, a.Chain)
+	a.Chain.RUnlock()
 	if err != nil {
 		return err
 	}
+	totalOutput, err := oldTxn.GetTotalOutput()
+	if err != nil {
+		return err
+	}
+	totalInput := totalOutput + oldFee
+	if totalInput < amount+fee {
+		return errors.New("Insufficient funds")
+	}
+	txn, err := a.newPayment(account.Wallet, wallet, oldTxn.Inputs, totalInput,
+		to, amount, fee)
+	if err != nil {
+		return err
+	}
+
+	// The replacement must take the original's place in the pool.
+	r := &pool.Replacement{Old: old, New: txn}
+	code, err := a.Pool.Replace(r, a.Chain, "")
+	if code != consensus.ValidTransaction {
+		return fmt.Errorf("Transaction validation failed with code %d", code)
+	} else if err != nil {
+		return err
+	}
+
+	// The replacement must take the original's place in the wallet's pending
+	// transactions.
+	if pending, i := wallet.IsPending(oldTxn); pending {
+		wallet.DropPending(i)
+	}
+	if err := account.Wallet.SetPending(txn); err != nil {
+		return err
+	}
 
+	// The replacement must be broadcasted to the network.
+	a.PeerStore.Broadcast(msg.Push{
+		ResourceType: msg.ResourceReplacement,
+		Resource:     r,
+	})
+	return nil
+}
+
+// newPayment returns a transaction signed by the given wallet of an account
+// that spends the given inputs, worth totalInput in all, to pay an amount of
+// coin to an address `to` and leave the given fee for the miner. Any change is
+// sent to a fresh change address of the account, or back to the wallet if the
+// account has no seed to derive one from.
+func (a *App) newPayment(account *blockchain.HDWallet, wallet *blockchain.Wallet,
+	inputs []blockchain.TxHashPointer, totalInput uint64, to string,
+	amount, fee uint64) (*blockchain.Transaction, error) {
 	// A legitimate transaction must be built.
 	tbody := blockchain.TxBody{
 		Sender: wallet.Public(),
-		Inputs: inputTxns,
+		Inputs: inputs,
 		Outputs: []blockchain.TxOutput{
 			blockchain.TxOutput{
 				Recipient: to,
@@ -139,101 +420,135 @@ func (a *App) Pay(to string, amount uint64) error {
 		},
 	}
 
-	// Any change left over gets sent back to the sender
-	if totalInput > amount {
+	// Any change left over after the fee gets sent to a new change address
+	if totalInput > amount+fee {
+		change := wallet.Public()
+		if w, err := account.NextChange(); err == nil {
+			change = w.Public()
+		} else if err != blockchain.ErrNoSeed {
+			return nil, err
+		}
 		tbody.Outputs = append(tbody.Outputs, blockchain.TxOutput{
-			Amount:    totalInput - amount,
-			Recipient: wallet.Public().Repr(),
+			Amount:    totalInput - amount - fee,
+			Recipient: change.Repr(),
 		})
 	}
 
-	// The transaction must be signed.
-	if txn, err := tbody.Sign(*a.CurrentUser.Wallet, crand.Reader); err == nil {
-		// The transaction must be added to the pool.
-		code := pool.Push(txn, a.Chain)
-		if code != consensus.ValidTransaction {
-			return fmt.Errorf("Transaction validation failed with code %d", code)
+	return tbody.Sign(*wallet, crand.Reader)
+}
+
+// spend is a set of inputs to a single address of an account.
+type spend struct {
+	wallet *blockchain.Wallet
+	inputs []blockchain.TxHashPointer
+	total  uint64
+}
+
+// collectSpends returns the inputs for a payment of the given amount from the
+// addresses of an account. If an address can cover the amount on its own,
+// only it is spent from. Otherwise addresses are spent from in order of the
+// amount they can spend, largest first. Returns an error if all of the
+// addresses together can't cover the amount.
+func (a *App) collectSpends(account *blockchain.HDWallet, amount uint64) ([]spend, error) {
+	wallets := account.Wallets()
+	for _, w := range wallets {
+		inputs, total, err := a.collectInputsForTxn(w.Public().Repr(), amount)
+		if err == nil {
+			return []spend{{wallet: w, inputs: inputs, total: total}}, nil
 		}
+	}
 
-		// The transaction must be added to the wallet's pending transcations
-		if err := wallet.SetPending(txn); err != nil {
-			return err
+	a.Chain.RLock()
+	defer a.Chain.RUnlock()
+
+	spends := make([]spend, 0, len(wallets))
+	for _, w := range wallets {
+		ptrs, unspent := a.spendableOutputs(w.Public().Repr())
+		s := spend{wallet: w, inputs: ptrs}
+		for _, ptr := range ptrs {
+			s.total += unspent[ptr]
 		}
+		if s.total > 0 {
+			spends = append(spends, s)
+		}
+	}
+	sort.SliceStable(spends, func(i, j int) bool {
+		return spends[i].total > spends[j].total
+	})
 
-		// The transaction must be broadcasted to the network.
-		a.PeerStore.Broadcast(msg.Push{
-			ResourceType: msg.ResourceTransaction,
-			Resource:     txn,
-		})
-	} else {
-		return err
+	total := uint64(0)
+	for i, s := range spends {
+		total += s.total
+		if total >= amount {
+			return spends[:i+1], nil
+		}
 	}
-	return nil
+	return nil, errors.New("Insufficient funds")
 }
 
 // collectInputsForTxn returns a list of input transactions for a new transaction
 // from the given sender of the given amount, and the total value of all the
 // inputs returned. Returns an error if there are not enough transactions to the
-// given sender in the blockchain to make a new transaction of the given amount.
+// given sender in the blockchain and the pool to make a new transaction of the
+// given amount.
 func (a *App) collectInputsForTxn(sender string, amount uint64) ([]blockchain.TxHashPointer,
 	uint64, error) {
 
 	a.Chain.RLock()
 	defer a.Chain.RUnlock()
 
+	// Add unspent outputs to our list of inputs until the total is greater
+	// than or equal to the amount for the transaction we want to send.
+	ptrs, unspent := a.spendableOutputs(sender)
 	total := uint64(0)
 	inputs := make([]blockchain.TxHashPointer, 0)
-	spentTxns := make(map[blockchain.Hash]*blockchain.Transaction, 0)
-
-	// Iterate through the blockchain in reverse, searching for transactions to
-	// sender.
-	for i := len(a.Chain.Blocks) - 1; i >= 0; i-- {
-		block := a.Chain.Blocks[i]
-		txns := block.GetTransactionsTo(sender)
-
-		// Add all the transactions to sender to our list of potential input
-		// transactions until the total is greater that or equal to the amount
-		// for the transaction we want to send.
-		for i, txn := range txns {
-			if i != 0 {
-				// This transaction is not a CloudBase transaction. Add its
-				// inputs to a map of spent transactions so we don't spend them.
-				for _, hashPtr := range txn.Inputs {
-					spentTxn := a.Chain.GetInputTransaction(&hashPtr)
-					if spentTxn == nil {
-						log.Fatal("Transaction in blockchain references noexistent input")
-					}
-					spentTxns[blockchain.HashSum(spentTxn)] = spentTxn
-				}
-			}
-
-			if spentTxns[blockchain.HashSum(txn)] != nil {
-				// This transaction has already been used as an input to another
-				// transaction
-				continue
-			}
+	for _, txnPtr := range ptrs {
+		outputToSender := unspent[txnPtr]
+		if outputToSender >= amount {
+			// This output alone has an amount large enough to be our only
+			// input, so return it
+			inputs = []blockchain.TxHashPointer{txnPtr}
+			return inputs, outputToSender, nil
+		}
 
-			// This transaction has not been spent so we can use it
-			outputToSender := txn.GetTotalOutputFor(sender)
-			txnPtr := blockchain.TxHashPointer{
-				BlockNumber: block.BlockNumber,
-				Hash:        blockchain.HashSum(block),
-				Index:       uint32(i),
-			}
+		inputs = append(inputs, txnPtr)
+		total += outputToSender
+		if total >= amount {
+			return inputs, total, nil
+		}
+	}
+	return nil, 0, errors.New("Insufficient funds")
+}
 
-			if outputToSender >= amount {
-				// This transaction alone has an amount large enough to be our
-				// only input, so return it
-				inputs = []blockchain.TxHashPointer{txnPtr}
-				return inputs, outputToSender, nil
-			}
+// spendableOutputs returns the outputs to the given sender that no pending
+// transaction spends yet, ordered from newest to oldest with outputs of
+// pending transactions first, along with their amounts. The blockchain must
+// be locked.
+func (a *App) spendableOutputs(sender string) ([]blockchain.TxHashPointer,
+	map[blockchain.TxHashPointer]uint64) {
+	// Outputs to the sender from pending transactions can be spent before
+	// they are mined.
+	unspent := a.Chain.UnspentOutputsFor(sender)
+	for ptr, amount := range a.Pool.UnspentOutputsFor(sender) {
+		unspent[ptr] = amount
+	}
 
-			inputs = append(inputs, txnPtr)
-			total += outputToSender
-			if total >= amount {
-				return inputs, total, nil
-			}
+	ptrs := make([]blockchain.TxHashPointer, 0, len(unspent))
+	for ptr := range unspent {
+		if a.Pool.SpentBy(ptr, sender) != nil {
+			// A pending transaction already spends this output.
+			continue
 		}
+		ptrs = append(ptrs, ptr)
 	}
-	return nil, 0, errors.New("Insufficient funds")
+	sort.Slice(ptrs, func(i, j int) bool {
+		if ptrs[i].BlockNumber != ptrs[j].BlockNumber {
+			return ptrs[i].BlockNumber > ptrs[j].BlockNumber
+		}
+		if ptrs[i].Index != ptrs[j].Index {
+			return ptrs[i].Index > ptrs[j].Index
+		}
+		return ptrs[i].Output > ptrs[j].Output
+	})
+	return ptrs, unspent
 }
@@ -1,10 +1,17 @@
 package app
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
 )
 
 func TestGetCurrentUser(t *testing.T) {
@@ -37,6 +44,8 @@ func TestSave(t *testing.T) {
 
 	assert.Nil(t, user1.DecryptPrivateKey("password"))
 	assert.Nil(t, user1.Save("userTestFile.json"))
+	_, err = os.Stat(KeystoreFileName("userTestFile.json"))
+	assert.True(t, os.IsNotExist(err))
 
 	user1, err = LoadUser("userTestFile.json")
 	assert.Nil(t, err)
@@ -44,3 +53,112 @@ func TestSave(t *testing.T) {
 	assert.Equal(t, user1, user2)
 	assert.Nil(t, os.Remove("userTestFile.json"))
 }
+
+func TestSaveIsPrivateAndAtomic(t *testing.T) {
+	fileName := "userTestFile.json"
+	defer os.Remove(fileName)
+	assert.Nil(t, ioutil.WriteFile(fileName, []byte("{}"), 0644))
+
+	// The file is replaced, so it takes the permissions of the new one.
+	user := NewUser()
+	assert.Nil(t, user.Save(fileName))
+	info, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	_, err = os.Stat(fileName + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	// A user that fails to save leaves the file alone.
+	assert.Nil(t, os.Mkdir(fileName+".tmp", 0700))
+	defer os.RemoveAll(fileName + ".tmp")
+	assert.Nil(t, ioutil.WriteFile(fileName+".tmp/file", nil, 0600))
+	assert.NotNil(t, NewUser().Save(fileName))
+	loaded, err := LoadUser(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, user.Public(), loaded.Public())
+}
+
+func TestLoadUserRestrictsPermissions(t *testing.T) {
+	fileName := "userTestFile.json"
+	defer os.Remove(fileName)
+	assert.Nil(t, NewUser().Save(fileName))
+	assert.Nil(t, os.Chmod(fileName, 0644))
+
+	_, err := LoadUser(fileName)
+	assert.Nil(t, err)
+	info, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestLoadMalformedUser(t *testing.T) {
+	fileName := "userTestFile.json"
+	defer os.Remove(fileName)
+
+	_, err := LoadUser(fileName)
+	assert.True(t, os.IsNotExist(err))
+
+	// A key that doesn't match its address fails to load rather than loading
+	// as some other key.
+	user := NewUser()
+	userBytes, err := json.Marshal(user)
+	assert.Nil(t, err)
+	key := hex.EncodeToString(user.Wallet().Receive[0].PrivateKeyBytes())
+	other := hex.EncodeToString(blockchain.NewWallet().PrivateKeyBytes())
+	userBytes = bytes.Replace(userBytes, []byte(key), []byte(other), 1)
+	assert.Nil(t, ioutil.WriteFile(fileName, userBytes, 0600))
+	_, err = LoadUser(fileName)
+	assert.Equal(t, blockchain.ErrBadPrivateKey, err)
+
+	assert.Nil(t, ioutil.WriteFile(fileName, []byte(`{"Accounts":[`), 0600))
+	_, err = LoadUser(fileName)
+	assert.NotNil(t, err)
+	assert.False(t, os.IsNotExist(err))
+}
+
+func TestLoadLegacyUser(t *testing.T) {
+	fileName := "userTestFile.json"
+	defer os.Remove(fileName)
+
+	// Users used to have a single wallet, saved as the encoding of its
+	// struct.
+	wallet := blockchain.NewWallet()
+	legacy := struct {
+		Wallet    struct{ *ecdsa.PrivateKey }
+		Name      string
+		BlockSize uint32
+	}{struct{ *ecdsa.PrivateKey }{wallet.PrivateKey}, "Legacy User",
+		blockchain.DefaultBlockSize}
+	legacyBytes, err := json.Marshal(legacy)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(fileName, legacyBytes, 0600))
+
+	// The wallet is kept alongside a new seed.
+	user, err := LoadUser(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, "Legacy User", user.Name)
+	assert.Len(t, user.Wallet().Imported, 1)
+	assert.Equal(t, wallet, user.Wallet().Imported[0])
+	assert.Len(t, user.Wallet().Receive, 1)
+	_, err = user.Wallet().Mnemonic()
+	assert.Nil(t, err)
+}
+
+func TestLoadUserWithoutAccounts(t *testing.T) {
+	fileName := "userTestFile.json"
+	defer os.Remove(fileName)
+
+	// Users used to have a single HD wallet.
+	wallet, _ := blockchain.NewHDWallet(crand.Reader, "")
+	legacyBytes, err := json.Marshal(struct{ Wallet *blockchain.HDWallet }{wallet})
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(fileName, legacyBytes, 0600))
+
+	// It becomes the default account.
+	user, err := LoadUser(fileName)
+	assert.Nil(t, err)
+	assert.Len(t, user.Accounts, 1)
+	assert.Equal(t, DefaultAccountName, user.Current().Name)
+	assert.Equal(t, wallet.Public(), user.Public())
+	assert.Equal(t, wallet.Seed, user.Wallet().Seed)
+}
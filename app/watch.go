@@ -0,0 +1,67 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+var (
+	// ErrAlreadyWatched is returned when watching an address that is
+	// already watched, or a label that is already used.
+	ErrAlreadyWatched = errors.New("Address or label is already watched")
+	// ErrNotWatched is returned when no watch has the given address or label.
+	ErrNotWatched = errors.New("No watched address or label")
+	// ErrWatchOwnAddress is returned when watching an address of one of the
+	// user's accounts, which is already tracked.
+	ErrWatchOwnAddress = errors.New("Address belongs to one of your accounts")
+)
+
+// Watch starts following the balance and transactions of an address, given
+// as its Repr or as its public key, under the given (possibly empty) label.
+// The watch is refreshed from the given blockchain. The user can't spend
+// from watched addresses.
+func (u *User) Watch(addr, label string, bc *blockchain.BlockChain) (*blockchain.Watch, error) {
+	watch, err := blockchain.NewWatch(addr, label)
+	if err != nil {
+		return nil, err
+	}
+	if _, w := u.owner(watch.Repr); w != nil {
+		return nil, ErrWatchOwnAddress
+	}
+	if _, err := u.FindWatch(watch.Repr); err == nil {
+		return nil, ErrAlreadyWatched
+	}
+	if _, err := u.FindWatch(label); label != "" && err == nil {
+		return nil, ErrAlreadyWatched
+	}
+	watch.Refresh(bc)
+	u.Watches = append(u.Watches, watch)
+	return watch, nil
+}
+
+// Unwatch stops following the watched address with the given address or
+// label.
+func (u *User) Unwatch(addrOrLabel string) error {
+	watch, err := u.FindWatch(addrOrLabel)
+	if err != nil {
+		return err
+	}
+	for i, w := range u.Watches {
+		if w == watch {
+			u.Watches = append(u.Watches[:i], u.Watches[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// FindWatch returns the watch with the given address or label.
+func (u *User) FindWatch(addrOrLabel string) (*blockchain.Watch, error) {
+	for _, watch := range u.Watches {
+		if watch.Repr == addrOrLabel || (watch.Label != "" && watch.Label == addrOrLabel) {
+			return watch, nil
+		}
+	}
+	return nil, ErrNotWatched
+}
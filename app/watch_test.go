@@ -0,0 +1,81 @@
+package app
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+func TestWatch(t *testing.T) {
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	user := NewUser()
+
+	alice, err := user.Watch(wallets["alice"].Public().Repr(), "deposits", bc)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), alice.Balance)
+	assert.Len(t, alice.History, 1)
+	bob, err := user.Watch(wallets["bob"].Public().PublicKey(), "", bc)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), bob.Balance)
+
+	_, err = user.Watch(wallets["alice"].Public().PublicKey(), "", bc)
+	assert.Equal(t, ErrAlreadyWatched, err)
+	_, err = user.Watch(wallets["sender"].Public().Repr(), "deposits", bc)
+	assert.Equal(t, ErrAlreadyWatched, err)
+	_, err = user.Watch(user.Public().Repr(), "", bc)
+	assert.Equal(t, ErrWatchOwnAddress, err)
+
+	found, err := user.FindWatch("deposits")
+	assert.Nil(t, err)
+	assert.Equal(t, alice, found)
+	found, err = user.FindWatch(wallets["bob"].Public().Repr())
+	assert.Nil(t, err)
+	assert.Equal(t, bob, found)
+	_, err = user.FindWatch("")
+	assert.Equal(t, ErrNotWatched, err)
+
+	assert.Nil(t, user.Unwatch("deposits"))
+	assert.Equal(t, ErrNotWatched, user.Unwatch("deposits"))
+	assert.Equal(t, []*blockchain.Watch{bob}, user.Watches)
+}
+
+func TestWatchedAddressesAreTrackedButNotSpent(t *testing.T) {
+	fileName := "userTestFile.json"
+	defer os.Remove(fileName)
+
+	a := newTestApp()
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	a.Chain = bc
+	alice, err := a.CurrentUser.Watch(wallets["alice"].Public().Repr(), "alice", bc)
+	assert.Nil(t, err)
+
+	// The user can't spend from a watched address.
+	assert.NotNil(t, a.Pay(wallets["bob"].Public().Repr(), 1))
+
+	// The watch follows the address's payments as blocks arrive.
+	user := NewUser()
+	user.Wallet().Import(wallets["alice"])
+	assert.Nil(t, user.Refresh(bc))
+	a.CurrentUser, user = user, a.CurrentUser
+	assert.Nil(t, a.Pay(wallets["bob"].Public().Repr(), 3))
+	b := a.Pool.NextBlock(bc, blockchain.NewWallet().Public(), 1<<18)
+	assert.Nil(t, bc.AppendBlock(b))
+	assert.Nil(t, user.Update(b, bc))
+	assert.Equal(t, uint64(0), alice.Balance)
+	assert.Len(t, alice.History, 2)
+	assert.True(t, alice.History[1].Outgoing)
+	assert.Equal(t, uint64(3), alice.History[1].Amount)
+	assert.Equal(t, wallets["bob"].Public().Repr(), alice.History[1].Counterparty)
+
+	assert.Nil(t, user.Revert(b, bc))
+	assert.Equal(t, uint64(3), alice.Balance)
+	assert.Len(t, alice.History, 1)
+
+	// Watches are saved with the user.
+	assert.Nil(t, user.Save(fileName))
+	loaded, err := LoadUser(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, user.Watches, loaded.Watches)
+}
@@ -17,6 +17,9 @@ type BlockHeader struct {
 	BlockNumber uint32
 	// LastBlock is the hash of the previous block
 	LastBlock Hash
+	// MerkleRoot is the root of the Merkle tree over the hashes of the
+	// transactions in the block
+	MerkleRoot Hash
 	// Target is the current target
 	Target Hash
 	// Time is represented as the number of seconds elapsed
@@ -34,6 +37,7 @@ func (bh *BlockHeader) Marshal() []byte {
 	var buf []byte
 	buf = util.AppendUint32(buf, bh.BlockNumber)
 	buf = append(buf, bh.LastBlock.Marshal()...)
+	buf = append(buf, bh.MerkleRoot.Marshal()...)
 	buf = append(buf, bh.Target.Marshal()...)
 	buf = util.AppendUint32(buf, bh.Time)
 	buf = util.AppendUint64(buf, bh.Nonce)
@@ -47,6 +51,7 @@ func (bh *BlockHeader) Marshal() []byte {
 func (bh *BlockHeader) Equal(otherHeader *BlockHeader) bool {
 	return bh.BlockNumber == otherHeader.BlockNumber &&
 		bh.LastBlock == otherHeader.LastBlock &&
+		bh.MerkleRoot == otherHeader.MerkleRoot &&
 		bh.Target == otherHeader.Target &&
 		bh.Time == otherHeader.Time &&
 		bh.Nonce == otherHeader.Nonce
@@ -63,19 +68,20 @@ type Block struct {
 	Transactions []*Transaction
 }
 
-// Len returns the length in bytes of the Block.
+// Len returns the length in bytes of the Block, including its transactions.
 func (b *Block) Len() int {
-	return len(b.Marshal())
+	n := b.BlockHeader.Len()
+	for _, t := range b.Transactions {
+		n += t.Len()
+	}
+	return n
 }
 
-// Marshal converts a Block to a byte slice.
+// Marshal converts a Block to a byte slice. Only the header is included, so
+// the hash and proof of work of a block can be checked without its
+// transactions, which the header commits to with its MerkleRoot.
 func (b Block) Marshal() []byte {
-	var buf []byte
-	buf = append(buf, b.BlockHeader.Marshal()...)
-	for _, t := range b.Transactions {
-		buf = append(buf, t.Marshal()...)
-	}
-	return buf
+	return b.BlockHeader.Marshal()
 }
 
 // DecodeBlockJSON returns a block read from the given marshalled block, or an
@@ -109,7 +115,7 @@ func (b *Block) GetCloudBaseTransaction() *Transaction {
 func (b *Block) GetTransactionsFrom(sender string) []*Transaction {
 	txns := make([]*Transaction, 0)
 	for _, txn := range b.Transactions {
-		if txn.TxBody.Sender.Repr() == sender {
+		if txn.From() == sender {
 			txns = append(txns, txn)
 		}
 	}
@@ -133,8 +139,20 @@ func (b *Block) GetTransactionsTo(recipient string) []*Transaction {
 // transactions in the given block could not be found in the blockchain.
 func (b *Block) GetTotalInputFrom(sender string, bc *BlockChain) (uint64, error) {
 	totalInput := uint64(0)
+
+	// If the block is on the main chain we know what it spent, even if its
+	// inputs have been pruned.
+	if spent, ok := bc.spentBy(HashSum(b)); ok {
+		for _, entry := range spent {
+			if entry.Recipient == sender {
+				totalInput += entry.Amount
+			}
+		}
+		return totalInput, nil
+	}
+
 	for _, t := range b.Transactions {
-		if t.Sender.Repr() == sender {
+		if t.From() == sender {
 			input, err := t.GetTotalInput(bc)
 			if err != nil {
 				return 0, err
@@ -145,6 +163,31 @@ func (b *Block) GetTotalInputFrom(sender string, bc *BlockChain) (uint64, error)
 	return totalInput, nil
 }
 
+// GetTotalFees sums the fees of the transactions in the given block after its
+// CloudBase transaction, which must be first. Transactions may spend the
+// outputs of transactions before them in the block. Must be called before the
+// block is added to the main chain. Returns an error if the fee of any of the
+// transactions can't be determined, or the fees overflow.
+func (b *Block) GetTotalFees(bc *BlockChain) (uint64, error) {
+	total := uint64(0)
+	if len(b.Transactions) == 0 {
+		return total, nil
+	}
+	view := bc.NewUTXOView()
+	for _, t := range b.Transactions[1:] {
+		fee, err := view.Fee(t)
+		if err != nil {
+			return 0, err
+		}
+		view.Apply(t)
+		var ok bool
+		if total, ok = AddAmount(total, fee); !ok {
+			return 0, ErrAmountOverflow
+		}
+	}
+	return total, nil
+}
+
 // GetTotalOutputFor sums the outputs referenced to a specific recipient in the
 // given block. recipient is an address checksum hex string.
 func (b *Block) GetTotalOutputFor(recipient string) uint64 {
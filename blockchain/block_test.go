@@ -32,13 +32,14 @@ func TestBlockHeaderLen(t *testing.T) {
 	bh := &BlockHeader{
 		0,
 		NewTestHash(),
+		NewTestHash(),
 		NewValidTestTarget(),
 		util.UnixNow(),
 		0,
 		[]byte{0x00, 0x01, 0x02},
 	}
 
-	len := 2*(32/8) + 64/8 + 2*HashLen + 3
+	len := 2*(32/8) + 64/8 + 3*HashLen + 3
 
 	if bh.Len() != len {
 		t.Fail()
@@ -47,13 +48,14 @@ func TestBlockHeaderLen(t *testing.T) {
 	bh = &BlockHeader{
 		0,
 		NewTestHash(),
+		NewTestHash(),
 		NewValidTestTarget(),
 		util.UnixNow(),
 		0,
 		[]byte{},
 	}
 
-	len = 2*(32/8) + 64/8 + 2*HashLen
+	len = 2*(32/8) + 64/8 + 3*HashLen
 
 	if bh.Len() != len {
 		t.Fail()
@@ -67,6 +69,7 @@ func TestEqual(t *testing.T) {
 	equalBlockHeader := BlockHeader{
 		BlockNumber: block1.BlockNumber,
 		LastBlock:   block1.LastBlock,
+		MerkleRoot:  block1.MerkleRoot,
 		Target:      block1.Target,
 		Time:        block1.Time,
 		Nonce:       block1.Nonce,
@@ -80,7 +83,20 @@ func TestEqual(t *testing.T) {
 }
 
 func TestBlockLen(t *testing.T) {
-	assert.True(t, NewTestBlock().Len() > 0)
+	b := NewTestBlock()
+	assert.True(t, b.Len() > b.BlockHeader.Len())
+}
+
+func TestBlockHashCoversHeaderOnly(t *testing.T) {
+	b := NewTestBlock()
+	hash := HashSum(b)
+	assert.Equal(t, HashSum(&b.BlockHeader), hash)
+
+	// The transactions only affect the hash through the Merkle root.
+	b.Transactions = b.Transactions[1:]
+	assert.Equal(t, hash, HashSum(b))
+	b.UpdateMerkleRoot()
+	assert.NotEqual(t, hash, HashSum(b))
 }
 
 func TestGetCloudBaseTransaction(t *testing.T) {
@@ -122,3 +138,15 @@ func TestBlockGetTotalOutputFor(t *testing.T) {
 	amount := bc.Blocks[2].GetTotalOutputFor(bobHash)
 	assert.Equal(t, amount, expectedAmount)
 }
+
+func TestGetTotalFees(t *testing.T) {
+	bc, b := NewValidTestChainAndBlock()
+	fees, err := b.GetTotalFees(bc)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), fees)
+
+	bc, b = NewValidTestChainAndBlockWithFee(1)
+	fees, err = b.GetTotalFees(bc)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), fees)
+}
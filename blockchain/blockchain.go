@@ -5,6 +5,8 @@ import (
 	"errors"
 	"os"
 	"sync"
+
+	log "github.com/Sirupsen/logrus"
 )
 
 const (
@@ -23,14 +25,38 @@ type BlockChain struct {
 	Blocks []*Block
 	Head   Hash
 	lock   *sync.RWMutex
+	store  *BlockStore
+	nodes  map[Hash]*blockNode
+	utxos  *UTXOSet
+	// children maps the hash of a block to the known blocks that build on it.
+	children map[Hash][]*blockNode
+	// txns maps the hash of each transaction on the main chain to its
+	// location.
+	txns map[Hash]TxHashPointer
+	// addrs counts the transactions on the main chain that each address has
+	// sent or received, including those in pruned blocks.
+	addrs map[string]int
+	// pruneDepth is the number of blocks at the end of the main chain whose
+	// transactions are kept, or 0 if the blockchain is not pruned.
+	pruneDepth uint32
+	// pruned is the number of blocks at the start of the main chain whose
+	// transactions have been discarded, and storePruned is the number of
+	// those that have been discarded from the block store.
+	pruned      uint32
+	storePruned uint32
 }
 
 // New returns a new blockchain
 func New() *BlockChain {
 	return &BlockChain{
-		Blocks: make([]*Block, 0),
-		Head:   NilHash,
-		lock:   &sync.RWMutex{},
+		Blocks:   make([]*Block, 0),
+		Head:     NilHash,
+		lock:     &sync.RWMutex{},
+		nodes:    make(map[Hash]*blockNode),
+		utxos:    NewUTXOSet(),
+		children: make(map[Hash][]*blockNode),
+		txns:     make(map[Hash]TxHashPointer),
+		addrs:    make(map[string]int),
 	}
 }
 
@@ -62,8 +88,12 @@ func (bc *BlockChain) Len() int {
 // Marshal converts the BlockChain to a byte slice.
 func (bc *BlockChain) Marshal() []byte {
 	var buf []byte
-	for _, b := range bc.Blocks {
+	for i := range bc.Blocks {
+		b := bc.block(i)
 		buf = append(buf, b.Marshal()...)
+		for _, t := range b.Transactions {
+			buf = append(buf, t.Marshal()...)
+		}
 	}
 	return append(buf, bc.Head.Marshal()...)
 }
@@ -108,13 +138,180 @@ func Load(fileName string) (*BlockChain, error) {
 		return nil, err
 	}
 	bc.lock = &sync.RWMutex{}
+	bc.reindex()
+	bc.rebuildUTXOs()
 	return &bc, nil
 }
 
-// AppendBlock adds a block to the end of the block chain.
-func (bc *BlockChain) AppendBlock(b *Block) {
+// Open loads the blockchain persisted in the block store with the given file
+// name, creating an empty store if none exists. Blocks appended to the returned
+// blockchain are written to the store as they are added. Only the block index
+// saved when the blockchain was last closed and the last block are loaded;
+// other blocks are read from the store when they are needed. If the index is
+// missing or stale, it is rebuilt from every block in the store. The saved UTXO
+// set is brought up to date with any blocks appended after it was saved.
+// Returns an error if the store could not be opened or one of its blocks could
+// not be decoded.
+func Open(fileName string) (*BlockChain, error) {
+	store, err := OpenBlockStore(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't bother updating the UTXO set as we load the blocks, we will either
+	// load the one saved when the blockchain was closed or rebuild it.
+	bc := New()
+	bc.utxos = nil
+	bc.store = store
+	if err := bc.loadIndex(fileName+indexFileSuffix, store); err != nil {
+		if store.Len() > 0 {
+			log.WithError(err).Debug("Rebuilding block index")
+		}
+		if err := bc.reindexStore(); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	bc.storePruned = bc.pruned
+
+	if err := bc.loadUTXOs(fileName + utxoFileSuffix); err != nil {
+		if bc.pruned > 0 {
+			store.Close()
+			return nil, errors.New("Cannot rebuild UTXO set of pruned blockchain: " +
+				err.Error())
+		}
+		log.WithError(err).Debug("Rebuilding UTXO set")
+		bc.rebuildUTXOs()
+	}
+	return bc, nil
+}
+
+// reindexStore builds the main chain from every block in the blockchain's
+// block store, keeping only the headers of all but the last block in memory.
+func (bc *BlockChain) reindexStore() error {
+	n := bc.store.Len()
+	for i := 0; i < n; i++ {
+		b, err := bc.store.Get(i)
+		if err != nil {
+			return err
+		}
+		bc.connect(b, HashSum(b))
+		if int(bc.pruned) == i && b.isPruned() {
+			bc.pruned++
+		}
+		if i > 0 {
+			bc.dropTransactions(i - 1)
+		}
+	}
+	return nil
+}
+
+// Close closes the block store backing the blockchain, if there is one, and
+// saves the block index and UTXO set alongside it so they don't have to be
+// rebuilt when the blockchain is next opened.
+func (bc *BlockChain) Close() error {
+	if bc.store == nil {
+		return nil
+	}
+	bc.pruneStore()
+	if err := bc.saveState(); err != nil {
+		log.WithError(err).Error("Failed to save block index and UTXO set")
+	}
+	err := bc.store.Close()
+	bc.store = nil
+	return err
+}
+
+// saveState saves the block index and UTXO set alongside the block store
+// backing the blockchain. The UTXO set of a pruned blockchain can't be rebuilt,
+// so this is also done before blocks are pruned from or removed from the store,
+// keeping the saved set at a block in the store after which no block has been
+// pruned.
+func (bc *BlockChain) saveState() error {
+	fileName := bc.store.file.Name()
+	if err := bc.unspent().save(fileName+utxoFileSuffix, bc.Head); err != nil {
+		return err
+	}
+	return bc.saveIndex(fileName + indexFileSuffix)
+}
+
+// AppendBlock adds a block to the end of the block chain. If the blockchain is
+// backed by a block store the block is persisted before it is added, and an
+// error is returned if it could not be written.
+func (bc *BlockChain) AppendBlock(b *Block) error {
+	if bc.store != nil {
+		if err := bc.store.Append(b); err != nil {
+			return err
+		}
+	}
+	bc.connect(b, HashSum(b))
+	if bc.store != nil && len(bc.Blocks) > 1 {
+		bc.dropTransactions(len(bc.Blocks) - 2)
+	}
+	bc.pruneBlocks()
+	return nil
+}
+
+// connect adds a block with the given hash to the end of the main chain in
+// memory, adding it to the block tree as a child of the current tip if it is
+// not already there.
+func (bc *BlockChain) connect(b *Block, hash Hash) {
+	if _, ok := bc.nodes[hash]; !ok {
+		bc.addNode(b, hash, bc.nodes[bc.Head])
+	}
 	bc.Blocks = append(bc.Blocks, b)
-	bc.Head = HashSum(b)
+	bc.Head = hash
+	bc.indexTransactions(b, uint32(len(bc.Blocks)-1))
+	bc.indexAddresses(b, 1)
+	if bc.utxos != nil {
+		bc.utxos.apply(b, hash)
+	}
+}
+
+// block returns the block at the given position in the main chain. If the
+// blockchain is backed by a block store, only the last block is kept in memory
+// with its transactions, and the others are read back from the store. Pruned
+// blocks are returned as their headers.
+func (bc *BlockChain) block(i int) *Block {
+	b := bc.Blocks[i]
+	if bc.store == nil || i < int(bc.pruned) || len(b.Transactions) > 0 {
+		return b
+	}
+	stored, err := bc.store.Get(i)
+	if err != nil {
+		log.WithError(err).Error("Failed to read block from block store")
+		return b
+	}
+	return stored
+}
+
+// setBlock replaces the block at the given position in the main chain, which
+// has the given hash, in the main chain and the block tree.
+func (bc *BlockChain) setBlock(i int, hash Hash, b *Block) {
+	bc.Blocks[i] = b
+	if node, ok := bc.nodes[hash]; ok {
+		node.block = b
+	}
+}
+
+// dropTransactions replaces the block at the given position in the main chain,
+// which must not be the last block, with its header. Its transactions can be
+// read back from the block store with block.
+func (bc *BlockChain) dropTransactions(i int) {
+	b := bc.Blocks[i]
+	bc.setBlock(i, HashSum(b), &Block{BlockHeader: b.BlockHeader})
+}
+
+// loadTip reads the transactions of the last block in the main chain back from
+// the block store if they were dropped from memory.
+func (bc *BlockChain) loadTip() {
+	i := len(bc.Blocks) - 1
+	if i < 0 {
+		return
+	}
+	if b := bc.block(i); b != bc.Blocks[i] {
+		bc.setBlock(i, bc.Head, b)
+	}
 }
 
 // LastBlock returns a pointer to the last block in the given blockchain, or nil
@@ -127,13 +324,25 @@ func (bc *BlockChain) LastBlock() *Block {
 }
 
 // GetInputTransaction returns the input Transaction referenced by TxHashPointer.
-// If the Transaction does not exist, then GetInputTransaction returns nil.
+// If the Transaction does not exist, or its hash does not match the Hash in the
+// TxHashPointer, then GetInputTransaction returns nil. Unconfirmed pointers are
+// looked up by hash on the main chain.
 func (bc *BlockChain) GetInputTransaction(t *TxHashPointer) *Transaction {
-	if t.BlockNumber > uint32(len(bc.Blocks)) {
+	if t.Unconfirmed() {
+		txn, _, err := bc.GetTransactionByHash(t.Hash)
+		if err != nil {
+			return nil
+		}
+		return txn
+	}
+	if t.BlockNumber >= uint32(len(bc.Blocks)) {
+		return nil
+	}
+	b := bc.block(int(t.BlockNumber))
+	if t.Index >= uint32(len(b.Transactions)) {
 		return nil
 	}
-	b := bc.Blocks[t.BlockNumber]
-	if t.Index > uint32(len(b.Transactions)) {
+	if HashSum(b.Transactions[t.Index]) != t.Hash {
 		return nil
 	}
 	return b.Transactions[t.Index]
@@ -158,9 +367,9 @@ func (bc *BlockChain) GetAllInputs(t *Transaction) ([]*Transaction, error) {
 // if the BlockChain contains the transaction in a block between start and stop
 // indexes.
 func (bc *BlockChain) ContainsTransaction(t *Transaction, start, stop uint32) (bool, uint32, uint32) {
-	for i := start; i < stop; i++ {
-		if exists, j := bc.Blocks[i].ContainsTransaction(t); exists {
-			return true, i, j
+	if ptr, ok := bc.txns[HashSum(t)]; ok {
+		if ptr.BlockNumber >= start && ptr.BlockNumber < stop {
+			return true, ptr.BlockNumber, ptr.Index
 		}
 	}
 	return false, 0, 0
@@ -170,27 +379,42 @@ func (bc *BlockChain) ContainsTransaction(t *Transaction, start, stop uint32) (b
 // comes directly after the block with the given hash. Returns error if no such
 // block is found.
 func (bc *BlockChain) GetBlockByLastBlockHash(hash Hash) (*Block, error) {
-	// Find the block with the given hash
-	for _, block := range bc.Blocks {
-		if block.LastBlock == hash {
-			return block, nil
+	for _, child := range bc.children[hash] {
+		if bc.onMainChain(child) {
+			return bc.block(int(child.height)), nil
 		}
 	}
 	return nil, errors.New("No such block")
 }
 
-// RollBack removes the last block from the blockchain. Returns the block that
-// was removed from the end of the chain, or nil if the blockchain is empty.
+// RollBack removes the last block from the blockchain and forgets about it.
+// Returns the block that was removed from the end of the chain, or nil if the
+// blockchain is empty or the last block has been pruned.
 func (bc *BlockChain) RollBack() *Block {
-	if len(bc.Blocks) == 0 {
+	if len(bc.Blocks) <= int(bc.pruned) {
 		return nil
 	}
-	prevHead := bc.LastBlock()
-	bc.Blocks = bc.Blocks[:len(bc.Blocks)-1]
-	if len(bc.Blocks) == 0 {
-		bc.Head = NilHash
-	} else {
-		bc.Head = HashSum(bc.LastBlock())
+	node := bc.nodes[bc.Head]
+	prevHead := bc.disconnectTip()
+	if node != nil {
+		bc.prune(node)
 	}
 	return prevHead
 }
+
+// truncateStore removes any blocks that are no longer on the main chain from
+// the end of the block store backing the blockchain, if there is one. If the
+// store has been pruned, the block index and UTXO set are saved first.
+func (bc *BlockChain) truncateStore() {
+	if bc.store == nil {
+		return
+	}
+	if bc.storePruned > 0 {
+		if err := bc.saveState(); err != nil {
+			log.WithError(err).Error("Failed to save block index and UTXO set")
+		}
+	}
+	if err := bc.store.Truncate(len(bc.Blocks)); err != nil {
+		log.WithError(err).Error("Failed to remove block from block store")
+	}
+}
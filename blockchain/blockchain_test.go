@@ -37,6 +37,46 @@ func TestSaveAndLoad(t *testing.T) {
 	assert.Nil(t, os.Remove("blockchainTestFile.json"))
 }
 
+func TestOpen(t *testing.T) {
+	bc1, b := NewValidTestChainAndBlock()
+	bc2, err := Open("blockchainTestFile.dat")
+	assert.Nil(t, err)
+	defer os.Remove("blockchainTestFile.dat")
+	defer os.Remove("blockchainTestFile.dat" + utxoFileSuffix)
+	defer os.Remove("blockchainTestFile.dat" + indexFileSuffix)
+
+	for _, blk := range append(bc1.Blocks, b) {
+		assert.Nil(t, bc2.AppendBlock(blk))
+	}
+	bc2.RollBack()
+	assert.Nil(t, bc2.Close())
+
+	bc3, err := Open("blockchainTestFile.dat")
+	assert.Nil(t, err)
+	assert.Equal(t, len(bc1.Blocks), len(bc3.Blocks))
+	assert.Equal(t, HashSum(bc1.LastBlock()), bc3.Head)
+
+	// Only the last block is decoded, the others are read when needed.
+	assert.Empty(t, bc3.Blocks[1].Transactions)
+	assert.Equal(t, bc1.LastBlock(), bc3.LastBlock())
+	b1, err := bc3.GetBlockByNumber(1)
+	assert.Nil(t, err)
+	assert.Equal(t, HashSum(bc1.Blocks[1]), HashSum(b1))
+	txn := bc1.Blocks[1].Transactions[1]
+	stored, _, err := bc3.GetTransactionByHash(HashSum(txn))
+	assert.Nil(t, err)
+	assert.Equal(t, HashSum(txn), HashSum(stored))
+	assert.Nil(t, bc3.Close())
+
+	// Without its index the blockchain is rebuilt from the block store.
+	assert.Nil(t, os.Remove("blockchainTestFile.dat"+indexFileSuffix))
+	bc4, err := Open("blockchainTestFile.dat")
+	assert.Nil(t, err)
+	assert.Equal(t, bc3.Blocks, bc4.Blocks)
+	assert.Equal(t, bc3.txns, bc4.txns)
+	assert.Nil(t, bc4.Close())
+}
+
 func TestGetBlock(t *testing.T) {
 	bc, _ := NewValidTestChainAndBlock()
 	b, _ := bc.GetBlockByLastBlockHash(bc.Blocks[1].LastBlock)
@@ -85,6 +125,7 @@ func TestGetInputTransaction(t *testing.T) {
 	bc := NewTestBlockChain()
 	txnPtr := &TxHashPointer{
 		BlockNumber: 0,
+		Hash:        HashSum(bc.Blocks[0].Transactions[0]),
 		Index:       0,
 	}
 	assert.NotNil(t, bc.GetInputTransaction(txnPtr))
@@ -41,6 +41,7 @@ func Genesis(miner Address, target Hash, blockReward uint64, extraData []byte) *
 		},
 		Transactions: []*Transaction{cbTx},
 	}
+	genesisBlock.UpdateMerkleRoot()
 
 	return genesisBlock
 }
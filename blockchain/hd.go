@@ -0,0 +1,117 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+const (
+	// HardenedIndex is the first index of hardened child keys. A hardened
+	// child can only be derived from its parent's private key.
+	HardenedIndex uint32 = 1 << 31
+	// ReceiveChain is the index of the chain of keys whose addresses are
+	// given out to receive payments.
+	ReceiveChain uint32 = 0
+	// ChangeChain is the index of the chain of keys whose addresses receive
+	// the change of our own payments.
+	ChangeChain uint32 = 1
+	// ChainCodeLen is the length in bytes of extended key chain codes.
+	ChainCodeLen = 32
+)
+
+var (
+	// ErrBadSeed is returned when a seed doesn't give a valid master key.
+	ErrBadSeed = errors.New("Seed does not give a valid master key")
+	// ErrBadChild is returned for the rare child indices that don't give a
+	// valid key. The next index should be used instead.
+	ErrBadChild = errors.New("Child index does not give a valid key")
+
+	// masterKeySalt is the HMAC key used to derive master keys from seeds.
+	masterKeySalt = []byte("Cumulus seed")
+)
+
+// ExtendedKey is a private key along with a chain code, from which child keys
+// can be derived deterministically. Derivation follows BIP 32 on our curve.
+type ExtendedKey struct {
+	D         *big.Int
+	ChainCode []byte
+}
+
+// NewMasterKey returns the extended key at the root of the tree of keys
+// derived from the given seed.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	mac := hmac.New(sha512.New, masterKeySalt)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	d := new(big.Int).SetBytes(sum[:CoordLen])
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrBadSeed
+	}
+	return &ExtendedKey{D: d, ChainCode: sum[CoordLen:]}, nil
+}
+
+// Child returns the child key with the given index. Indices from
+// HardenedIndex up give hardened keys.
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	var data []byte
+	if i >= HardenedIndex {
+		data = append([]byte{0}, pad32(k.D)...)
+	} else {
+		// Non-hardened children are derived from the compressed public key.
+		x, y := curve.ScalarBaseMult(pad32(k.D))
+		data = append([]byte{2 + byte(y.Bit(0))}, pad32(x)...)
+	}
+	index := make([]byte, 4)
+	binary.BigEndian.PutUint32(index, i)
+	data = append(data, index...)
+
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	n := curve.Params().N
+	tweak := new(big.Int).SetBytes(sum[:CoordLen])
+	if tweak.Cmp(n) >= 0 {
+		return nil, ErrBadChild
+	}
+	d := tweak.Add(tweak, k.D)
+	d.Mod(d, n)
+	if d.Sign() == 0 {
+		return nil, ErrBadChild
+	}
+	return &ExtendedKey{D: d, ChainCode: sum[CoordLen:]}, nil
+}
+
+// Path returns the key derived by following the given child indices from k.
+func (k *ExtendedKey) Path(indices ...uint32) (*ExtendedKey, error) {
+	key := k
+	for _, i := range indices {
+		child, err := key.Child(i)
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+	return key, nil
+}
+
+// Wallet returns a wallet that signs with the extended key's private key.
+func (k *ExtendedKey) Wallet() *Wallet {
+	priv := &ecdsa.PrivateKey{D: new(big.Int).Set(k.D)}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(pad32(k.D))
+	return &Wallet{PrivateKey: priv}
+}
+
+// pad32 returns n in big endian, padded with leading zeros to CoordLen bytes.
+func pad32(n *big.Int) []byte {
+	buf := make([]byte, CoordLen)
+	b := n.Bytes()
+	copy(buf[len(buf)-len(b):], b)
+	return buf
+}
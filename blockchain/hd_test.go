@@ -0,0 +1,42 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	crand "crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtendedKeyDerivation(t *testing.T) {
+	master, err := NewMasterKey(bytes.Repeat([]byte{7}, SeedLen))
+	assert.Nil(t, err)
+	assert.Len(t, master.ChainCode, ChainCodeLen)
+
+	// Derivation is deterministic.
+	again, _ := NewMasterKey(bytes.Repeat([]byte{7}, SeedLen))
+	assert.Equal(t, master, again)
+
+	child, err := master.Child(0)
+	assert.Nil(t, err)
+	hardened, err := master.Child(HardenedIndex)
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, child.D.Cmp(master.D))
+	assert.NotEqual(t, 0, child.D.Cmp(hardened.D))
+
+	path, err := master.Path(HardenedIndex, 1, 2)
+	assert.Nil(t, err)
+	step, _ := hardened.Child(1)
+	step, _ = step.Child(2)
+	assert.Equal(t, step, path)
+
+	// Wallets sign with the derived key.
+	w := path.Wallet()
+	assert.Equal(t, 0, w.D.Cmp(path.D))
+	assert.True(t, curve.IsOnCurve(w.X, w.Y))
+	digest := NewTestHash()
+	sig, err := w.Sign(digest, crand.Reader)
+	assert.Nil(t, err)
+	assert.True(t, ecdsa.Verify(w.Public().Key(), digest.Marshal(), sig.R, sig.S))
+}
@@ -0,0 +1,321 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultGapLimit is the number of consecutive unused addresses after which
+// recovery stops looking for more.
+const DefaultGapLimit = 20
+
+var (
+	// ErrNoSeed is returned when deriving keys from a wallet without a seed.
+	ErrNoSeed = errors.New("Wallet has no seed")
+	// ErrNotOurs is returned for transactions that weren't sent by a wallet
+	// of the HDWallet.
+	ErrNotOurs = errors.New("Transaction was not sent by this wallet")
+)
+
+// HDWallet is a hierarchical deterministic wallet: a collection of wallets
+// whose keys are all derived from a single seed, which can in turn be restored
+// from a mnemonic phrase. The key of the wallet at index i of a chain is at
+// path m/Account'/chain/i, where chain is ReceiveChain or ChangeChain. Each
+// payment should use a fresh address so that addresses are not reused.
+type HDWallet struct {
+	// Entropy is encoded by the wallet's mnemonic phrase.
+	Entropy []byte
+	// Seed is derived from the mnemonic phrase and passphrase.
+	Seed []byte
+	// Account is the hardened index of the wallet's keys under the master key.
+	Account uint32
+	// Receive and Change hold the wallets of the keys derived so far on each
+	// chain, in order of index.
+	Receive []*Wallet
+	Change  []*Wallet
+	// Imported holds wallets whose keys are not derived from the seed, and
+	// which can't be recovered from the mnemonic phrase.
+	Imported []*Wallet
+}
+
+// NewHDWallet returns an HDWallet with a new random mnemonic phrase, reading
+// randomness from r, protected by the given (possibly empty) passphrase. It
+// has one receive address.
+func NewHDWallet(r io.Reader, passphrase string) (*HDWallet, error) {
+	entropy, err := NewEntropy(r)
+	if err != nil {
+		return nil, err
+	}
+	mnemonic, err := EntropyToMnemonic(entropy)
+	if err != nil {
+		return nil, err
+	}
+	return RestoreHDWallet(mnemonic, passphrase)
+}
+
+// RestoreHDWallet returns the HDWallet with the given mnemonic phrase and
+// passphrase. It has one receive address; Recover finds the rest of the
+// addresses it has used.
+func RestoreHDWallet(mnemonic, passphrase string) (*HDWallet, error) {
+	entropy, err := MnemonicToEntropy(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	hd := &HDWallet{Entropy: entropy, Seed: seed}
+	if _, err := hd.NextReceive(); err != nil {
+		return nil, err
+	}
+	return hd, nil
+}
+
+// NewAccount returns an HDWallet with the same seed whose keys are derived
+// under the given account index instead. It has one receive address.
+func (hd *HDWallet) NewAccount(account uint32) (*HDWallet, error) {
+	if len(hd.Seed) == 0 {
+		return nil, ErrNoSeed
+	}
+	other := &HDWallet{
+		Entropy: append([]byte{}, hd.Entropy...),
+		Seed:    append([]byte{}, hd.Seed...),
+		Account: account,
+	}
+	if _, err := other.NextReceive(); err != nil {
+		return nil, err
+	}
+	return other, nil
+}
+
+// WatchOnly returns a copy of the HDWallet without its seed or any of its
+// private keys.
+func (hd *HDWallet) WatchOnly() *HDWallet {
+	watch := func(wallets []*Wallet) []*Wallet {
+		if wallets == nil {
+			return nil
+		}
+		copies := make([]*Wallet, len(wallets))
+		for i, w := range wallets {
+			copies[i] = w.WatchOnly()
+		}
+		return copies
+	}
+	return &HDWallet{
+		Account:  hd.Account,
+		Receive:  watch(hd.Receive),
+		Change:   watch(hd.Change),
+		Imported: watch(hd.Imported),
+	}
+}
+
+// Used returns true if any of the wallet's keys has sent or received a
+// transaction in the blockchain.
+func (hd *HDWallet) Used(bc *BlockChain) bool {
+	for _, w := range hd.Wallets() {
+		if bc.AddressUsed(w.Public().Repr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mnemonic returns the mnemonic phrase from which the wallet can be restored.
+func (hd *HDWallet) Mnemonic() (string, error) {
+	return EntropyToMnemonic(hd.Entropy)
+}
+
+// Derive returns the wallet of the key with the given index on the given
+// chain. An index gives an invalid key with probability below 2^-127, in
+// which case ErrBadChild is returned.
+func (hd *HDWallet) Derive(chain, index uint32) (*Wallet, error) {
+	if len(hd.Seed) == 0 {
+		return nil, ErrNoSeed
+	}
+	master, err := NewMasterKey(hd.Seed)
+	if err != nil {
+		return nil, err
+	}
+	key, err := master.Path(HardenedIndex+hd.Account, chain, index)
+	if err != nil {
+		return nil, err
+	}
+	return key.Wallet(), nil
+}
+
+// NextReceive derives a fresh receive address and returns its wallet.
+func (hd *HDWallet) NextReceive() (*Wallet, error) {
+	w, err := hd.Derive(ReceiveChain, uint32(len(hd.Receive)))
+	if err != nil {
+		return nil, err
+	}
+	hd.Receive = append(hd.Receive, w)
+	return w, nil
+}
+
+// NextChange derives a fresh change address and returns its wallet.
+func (hd *HDWallet) NextChange() (*Wallet, error) {
+	w, err := hd.Derive(ChangeChain, uint32(len(hd.Change)))
+	if err != nil {
+		return nil, err
+	}
+	hd.Change = append(hd.Change, w)
+	return w, nil
+}
+
+// Import adds a wallet whose key was not derived from the seed.
+func (hd *HDWallet) Import(w *Wallet) {
+	hd.Imported = append(hd.Imported, w)
+}
+
+// Public returns the address of the most recent receive key, which receives
+// payments that don't ask for a fresh address, such as mining rewards.
+func (hd *HDWallet) Public() Address {
+	if len(hd.Receive) == 0 {
+		return hd.Imported[len(hd.Imported)-1].Public()
+	}
+	return hd.Receive[len(hd.Receive)-1].Public()
+}
+
+// Wallets returns all of the wallets in the HDWallet.
+func (hd *HDWallet) Wallets() []*Wallet {
+	wallets := make([]*Wallet, 0, len(hd.Receive)+len(hd.Change)+len(hd.Imported))
+	wallets = append(wallets, hd.Receive...)
+	wallets = append(wallets, hd.Change...)
+	return append(wallets, hd.Imported...)
+}
+
+// Find returns the wallet with the given address, or nil if the HDWallet
+// doesn't hold it.
+func (hd *HDWallet) Find(addr string) *Wallet {
+	for _, w := range hd.Wallets() {
+		if w.Public().Repr() == addr {
+			return w
+		}
+	}
+	return nil
+}
+
+// Balance returns the sum of the balances of the wallets.
+func (hd *HDWallet) Balance() uint64 {
+	total := uint64(0)
+	for _, w := range hd.Wallets() {
+		total += w.Balance
+	}
+	return total
+}
+
+// GetEffectiveBalance returns the balance less what the pending transactions
+// of the wallets pay to others. Unlike the effective balance of a single
+// wallet, change sent to another of our addresses is not deducted.
+func (hd *HDWallet) GetEffectiveBalance() uint64 {
+	return hd.Balance() - hd.pendingSpend(hd.PendingTxns()...)
+}
+
+// pendingSpend returns the total that the given transactions pay to
+// addresses outside of the HDWallet.
+func (hd *HDWallet) pendingSpend(txns ...*Transaction) uint64 {
+	total := uint64(0)
+	for _, t := range txns {
+		for _, o := range t.Outputs {
+			if hd.Find(o.Recipient) == nil {
+				total += o.Amount
+			}
+		}
+	}
+	return total
+}
+
+// SetPending adds a transaction sent from one of the wallets to its pending
+// transactions if the effective balance is high enough to accomodate it. The
+// transaction may spend outputs of other pending transactions, so the sending
+// wallet's own balance is not checked.
+func (hd *HDWallet) SetPending(txn *Transaction) error {
+	w := hd.Find(txn.From())
+	if w == nil {
+		return ErrNotOurs
+	}
+	bal := hd.GetEffectiveBalance()
+	spend := hd.pendingSpend(txn)
+	if bal < spend {
+		return fmt.Errorf("Wallet balance is too low %v < %v", bal, spend)
+	}
+	w.PendingTxns = append(w.PendingTxns, txn)
+	return nil
+}
+
+// PendingTxns returns the pending transactions of all of the wallets.
+func (hd *HDWallet) PendingTxns() []*Transaction {
+	pending := make([]*Transaction, 0)
+	for _, w := range hd.Wallets() {
+		pending = append(pending, w.PendingTxns...)
+	}
+	return pending
+}
+
+// Update updates each wallet based on the transactions in the given block.
+func (hd *HDWallet) Update(block *Block, bc *BlockChain) error {
+	for _, w := range hd.Wallets() {
+		if err := w.Update(block, bc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revert undoes the effect of the given block on each wallet.
+func (hd *HDWallet) Revert(block *Block, bc *BlockChain) error {
+	for _, w := range hd.Wallets() {
+		if err := w.Revert(block, bc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refresh sets the balance and pending transactions of each wallet from the
+// given blockchain.
+func (hd *HDWallet) Refresh(bc *BlockChain) error {
+	for _, w := range hd.Wallets() {
+		if err := w.Refresh(bc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recover derives the keys of each chain that the blockchain shows have been
+// used, stopping after gapLimit consecutive unused keys, and refreshes the
+// wallet from the blockchain. A key has been used if it has sent or received
+// a transaction. The next receive address after the last used one is handed
+// out. It is used to restore a wallet from its mnemonic phrase.
+func (hd *HDWallet) Recover(bc *BlockChain, gapLimit uint32) error {
+	if gapLimit == 0 {
+		gapLimit = DefaultGapLimit
+	}
+	for _, chain := range []uint32{ReceiveChain, ChangeChain} {
+		wallets := make([]*Wallet, 0)
+		found := 0
+		for index, gap := uint32(0), uint32(0); gap < gapLimit; index++ {
+			w, err := hd.Derive(chain, index)
+			if err != nil {
+				return err
+			}
+			wallets = append(wallets, w)
+			if bc.AddressUsed(w.Public().Repr()) {
+				found = len(wallets)
+				gap = 0
+			} else {
+				gap++
+			}
+		}
+		if chain == ReceiveChain {
+			hd.Receive = wallets[:found+1]
+		} else {
+			hd.Change = wallets[:found]
+		}
+	}
+	return hd.Refresh(bc)
+}
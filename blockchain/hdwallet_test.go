@@ -0,0 +1,102 @@
+package blockchain
+
+import (
+	crand "crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHDWallet(t *testing.T) {
+	hd, err := NewHDWallet(crand.Reader, "")
+	assert.Nil(t, err)
+	assert.Len(t, hd.Receive, 1)
+	assert.Empty(t, hd.Change)
+	assert.Equal(t, hd.Receive[0].Public(), hd.Public())
+
+	// The wallet can be restored from its mnemonic phrase.
+	mnemonic, err := hd.Mnemonic()
+	assert.Nil(t, err)
+	restored, err := RestoreHDWallet(mnemonic, "")
+	assert.Nil(t, err)
+	assert.Equal(t, hd.Public(), restored.Public())
+
+	// But not with a different passphrase.
+	other, err := RestoreHDWallet(mnemonic, "passphrase")
+	assert.Nil(t, err)
+	assert.NotEqual(t, hd.Public(), other.Public())
+}
+
+func TestHDWalletNextAddresses(t *testing.T) {
+	hd, _ := NewHDWallet(crand.Reader, "")
+	first := hd.Public()
+	receive, err := hd.NextReceive()
+	assert.Nil(t, err)
+	change, err := hd.NextChange()
+	assert.Nil(t, err)
+
+	// Every address is fresh.
+	assert.Equal(t, receive.Public(), hd.Public())
+	assert.NotEqual(t, first, receive.Public())
+	assert.NotEqual(t, first, change.Public())
+	assert.Len(t, hd.Wallets(), 3)
+	assert.Equal(t, change, hd.Find(change.Public().Repr()))
+	assert.Nil(t, hd.Find(NewWallet().Public().Repr()))
+
+	derived, err := hd.Derive(ChangeChain, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, change.Public(), derived.Public())
+
+	_, err = (&HDWallet{}).Derive(ReceiveChain, 0)
+	assert.Equal(t, ErrNoSeed, err)
+}
+
+func TestHDWalletBalances(t *testing.T) {
+	hd, _ := NewHDWallet(crand.Reader, "")
+	change, _ := hd.NextChange()
+	imported := NewWallet()
+	hd.Import(imported)
+	hd.Receive[0].Balance = 5
+	imported.Balance = 2
+	assert.Equal(t, uint64(7), hd.Balance())
+
+	// A pending payment only reduces the effective balance by what it sends
+	// to others.
+	txn, _ := TxBody{
+		Sender: imported.Public(),
+		Inputs: []TxHashPointer{NewTestTxHashPointer()},
+		Outputs: []TxOutput{
+			TxOutput{Amount: 1, Recipient: "badf00d"},
+			TxOutput{Amount: 1, Recipient: change.Public().Repr()},
+		},
+	}.Sign(*imported, crand.Reader)
+	assert.Nil(t, hd.SetPending(txn))
+	assert.Equal(t, []*Transaction{txn}, hd.PendingTxns())
+	assert.Equal(t, uint64(6), hd.GetEffectiveBalance())
+
+	assert.Equal(t, ErrNotOurs, hd.SetPending(NewTestTransaction()))
+}
+
+func TestHDWalletRecover(t *testing.T) {
+	hd, _ := NewHDWallet(crand.Reader, "")
+	hd.NextReceive()
+	hd.NextReceive()
+	change, _ := hd.NextChange()
+
+	// Only the third receive address has been used.
+	bc, _, _ := NewValidTestChainAndSpend(&TxOutput{Recipient: hd.Receive[2].Public().Repr()})
+	mnemonic, _ := hd.Mnemonic()
+	restored, _ := RestoreHDWallet(mnemonic, "")
+	assert.Nil(t, restored.Recover(bc, 3))
+	assert.Len(t, restored.Receive, 4)
+	assert.Equal(t, hd.Receive[2].Public(), restored.Receive[2].Public())
+	assert.Empty(t, restored.Change)
+	assert.Equal(t, uint64(3), restored.Balance())
+
+	// The gap limit stops the search before the change address.
+	bc, _, _ = NewValidTestChainAndSpend(&TxOutput{Recipient: change.Public().Repr()})
+	assert.Nil(t, restored.Recover(bc, 1))
+	assert.Len(t, restored.Change, 1)
+	assert.Len(t, restored.Receive, 1)
+	assert.Equal(t, uint64(3), restored.Balance())
+}
@@ -0,0 +1,185 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// indexFileSuffix is appended to the file name of a block store to get the
+// name of the file the blockchain's block index is saved to when it is closed.
+const indexFileSuffix = ".index"
+
+// errStaleIndex is returned when a saved block index does not match the block
+// store it is being loaded for.
+var errStaleIndex = errors.New("Block index does not match block store")
+
+// indexSnapshot is the on-disk representation of the block index of a
+// blockchain backed by a block store: the headers of the blocks on the main
+// chain, the transaction and address indexes, and the hash of the last block.
+type indexSnapshot struct {
+	Head      Hash
+	Pruned    uint32
+	Headers   []BlockHeader
+	Txns      []TxHashPointer
+	Addresses map[string]int
+}
+
+// indexTransactions adds the transactions in the given block, which has just
+// been added to the main chain at the given height, to the transaction index.
+func (bc *BlockChain) indexTransactions(b *Block, height uint32) {
+	if bc.txns == nil {
+		bc.txns = make(map[Hash]TxHashPointer)
+	}
+	for i, t := range b.Transactions {
+		hash := HashSum(t)
+		if _, ok := bc.txns[hash]; ok {
+			// Keep the earliest copy of a transaction that appears more than
+			// once (e.g. identical CloudBase transactions).
+			continue
+		}
+		bc.txns[hash] = TxHashPointer{
+			BlockNumber: height,
+			Hash:        hash,
+			Index:       uint32(i),
+		}
+	}
+}
+
+// unindexTransactions removes the transactions in the given block, which is
+// about to be removed from the main chain at the given height, from the
+// transaction index.
+func (bc *BlockChain) unindexTransactions(b *Block, height uint32) {
+	for _, t := range b.Transactions {
+		hash := HashSum(t)
+		if ptr, ok := bc.txns[hash]; ok && ptr.BlockNumber == height {
+			delete(bc.txns, hash)
+		}
+	}
+}
+
+// indexAddresses adds delta to the transaction counts of the addresses that
+// send or receive in the given block, which is being added to or removed from
+// the main chain.
+func (bc *BlockChain) indexAddresses(b *Block, delta int) {
+	if bc.addrs == nil {
+		bc.addrs = make(map[string]int)
+	}
+	for _, t := range b.Transactions {
+		addrs := []string{t.From()}
+		for _, out := range t.Outputs {
+			addrs = append(addrs, out.Recipient)
+		}
+		for _, addr := range addrs {
+			if bc.addrs[addr] += delta; bc.addrs[addr] <= 0 {
+				delete(bc.addrs, addr)
+			}
+		}
+	}
+}
+
+// AddressUsed returns true if the given address has sent or received a
+// transaction on the main chain. Addresses that only appear in blocks that
+// were pruned before the address index was built are only found if they have
+// unspent outputs.
+func (bc *BlockChain) AddressUsed(addr string) bool {
+	return bc.addrs[addr] > 0 || len(bc.unspent().outputs[addr]) > 0
+}
+
+// GetBlockByHash returns the block on the main chain with the given hash.
+// Returns an error if no such block is found.
+func (bc *BlockChain) GetBlockByHash(hash Hash) (*Block, error) {
+	if node, ok := bc.nodes[hash]; ok && bc.onMainChain(node) {
+		return bc.block(int(node.height)), nil
+	}
+	return nil, errors.New("No such block")
+}
+
+// GetBlockByNumber returns the block on the main chain with the given block
+// number. Returns an error if no such block is found.
+func (bc *BlockChain) GetBlockByNumber(n uint32) (*Block, error) {
+	if n >= uint32(len(bc.Blocks)) {
+		return nil, errors.New("No such block")
+	}
+	return bc.block(int(n)), nil
+}
+
+// GetTransactionByHash returns the transaction on the main chain with the
+// given hash, along with a TxHashPointer to it. Returns an error if no such
+// transaction is found.
+func (bc *BlockChain) GetTransactionByHash(hash Hash) (*Transaction, TxHashPointer, error) {
+	ptr, ok := bc.txns[hash]
+	if !ok {
+		return nil, TxHashPointer{}, errors.New("No such transaction")
+	}
+	b := bc.block(int(ptr.BlockNumber))
+	if ptr.Index >= uint32(len(b.Transactions)) {
+		return nil, TxHashPointer{}, errors.New("No such transaction")
+	}
+	return b.Transactions[ptr.Index], ptr, nil
+}
+
+// saveIndex writes the blockchain's block index to the file with the given
+// name, replacing it atomically.
+func (bc *BlockChain) saveIndex(fileName string) error {
+	snapshot := indexSnapshot{
+		Head:      bc.Head,
+		Pruned:    bc.pruned,
+		Headers:   make([]BlockHeader, len(bc.Blocks)),
+		Txns:      make([]TxHashPointer, 0, len(bc.txns)),
+		Addresses: bc.addrs,
+	}
+	for i, b := range bc.Blocks {
+		snapshot.Headers[i] = b.BlockHeader
+	}
+	for _, ptr := range bc.txns {
+		snapshot.Txns = append(snapshot.Txns, ptr)
+	}
+
+	return saveJSON(fileName, snapshot)
+}
+
+// loadIndex builds the main chain from the block index saved to the file with
+// the given name and the last block in the given store, which is the only
+// block that is decoded. The other blocks are kept as their headers. Returns
+// an error if the index could not be read or was not saved for the store.
+func (bc *BlockChain) loadIndex(fileName string, store *BlockStore) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var snapshot indexSnapshot
+	if err := json.NewDecoder(file).Decode(&snapshot); err != nil {
+		return err
+	}
+	n := len(snapshot.Headers)
+	if n == 0 || n != store.Len() || snapshot.Addresses == nil {
+		return errStaleIndex
+	}
+	tip, err := store.Get(n - 1)
+	if err != nil {
+		return err
+	}
+	if HashSum(tip) != snapshot.Head {
+		return errStaleIndex
+	}
+
+	var parent *blockNode
+	for i, header := range snapshot.Headers {
+		b := &Block{BlockHeader: header}
+		if i+1 == n {
+			b = tip
+		}
+		parent = bc.addNode(b, HashSum(b), parent)
+		bc.Blocks = append(bc.Blocks, b)
+	}
+	bc.Head = snapshot.Head
+	bc.pruned = snapshot.Pruned
+	for _, ptr := range snapshot.Txns {
+		bc.txns[ptr.Hash] = ptr
+	}
+	bc.addrs = snapshot.Addresses
+	return nil
+}
@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBlockByHash(t *testing.T) {
+	bc, b := NewValidTestChainAndBlock()
+	for _, blk := range bc.Blocks {
+		found, err := bc.GetBlockByHash(HashSum(blk))
+		assert.Nil(t, err)
+		assert.Equal(t, blk, found)
+	}
+
+	// Blocks that aren't on the main chain aren't found.
+	_, err := bc.GetBlockByHash(HashSum(b))
+	assert.NotNil(t, err)
+	bc.AppendBlock(b)
+	bc.RollBack()
+	_, err = bc.GetBlockByHash(HashSum(b))
+	assert.NotNil(t, err)
+}
+
+func TestGetBlockByNumber(t *testing.T) {
+	bc, _ := NewValidTestChainAndBlock()
+	found, err := bc.GetBlockByNumber(1)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.Blocks[1], found)
+	_, err = bc.GetBlockByNumber(uint32(len(bc.Blocks)))
+	assert.NotNil(t, err)
+}
+
+func TestGetTransactionByHash(t *testing.T) {
+	bc, b := NewValidTestChainAndBlock()
+	txn := bc.Blocks[2].Transactions[1]
+	found, ptr, err := bc.GetTransactionByHash(HashSum(txn))
+	assert.Nil(t, err)
+	assert.Equal(t, txn, found)
+	assert.Equal(t, TxHashPointer{2, HashSum(txn), 1, 0}, ptr)
+
+	// Transactions are removed from the index when their block is.
+	bc.AppendBlock(b)
+	_, _, err = bc.GetTransactionByHash(HashSum(b.Transactions[1]))
+	assert.Nil(t, err)
+	bc.RollBack()
+	_, _, err = bc.GetTransactionByHash(HashSum(b.Transactions[1]))
+	assert.NotNil(t, err)
+}
+
+func TestGetBlockByLastBlockHashSideBranch(t *testing.T) {
+	bc, _, hard := newTestTree()
+
+	// A side block building on the same parent doesn't hide the block on the
+	// main chain.
+	side := NewTestChildBlock(bc.Blocks[0], hard)
+	bc.AddSideBlock(side)
+	found, err := bc.GetBlockByLastBlockHash(HashSum(bc.Blocks[0]))
+	assert.Nil(t, err)
+	assert.Equal(t, bc.Blocks[1], found)
+
+	// The genesis block is found by its last block hash.
+	found, err = bc.GetBlockByLastBlockHash(bc.Blocks[0].LastBlock)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.Blocks[0], found)
+
+	_, err = bc.GetBlockByLastBlockHash(bc.Head)
+	assert.NotNil(t, err)
+}
+
+func TestGetInputTransactionChecksHash(t *testing.T) {
+	bc, _ := NewValidTestChainAndBlock()
+	ptr := TxHashPointer{2, HashSum(bc.Blocks[2].Transactions[1]), 1, 0}
+	assert.Equal(t, bc.Blocks[2].Transactions[1], bc.GetInputTransaction(&ptr))
+	ptr.Hash = NewTestHash()
+	assert.Nil(t, bc.GetInputTransaction(&ptr))
+}
+
+func TestAddressUsed(t *testing.T) {
+	bc, b := NewValidTestChainAndBlock()
+	to := b.Transactions[1].Outputs[0].Recipient
+	assert.True(t, bc.AddressUsed(b.Transactions[1].From()))
+	assert.False(t, bc.AddressUsed(NewWallet().Public().Repr()))
+
+	// Addresses are forgotten when the only block they appear in is rolled
+	// back.
+	fresh := NewWallet().Public().Repr()
+	b.Transactions[0].Outputs[0].Recipient = fresh
+	b.UpdateMerkleRoot()
+	bc.AppendBlock(b)
+	assert.True(t, bc.AddressUsed(fresh))
+	assert.True(t, bc.AddressUsed(to))
+	bc.RollBack()
+	assert.False(t, bc.AddressUsed(fresh))
+}
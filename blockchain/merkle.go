@@ -0,0 +1,127 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+)
+
+// merkleNodePrefix is prepended to the concatenation of two child hashes when
+// hashing an interior node of a Merkle tree, so that interior nodes can never
+// be mistaken for transaction hashes.
+const merkleNodePrefix = 0x01
+
+// MerkleProof is a proof that a transaction is included in a block. It holds
+// the sibling hashes on the path from the transaction's leaf to the root of the
+// Merkle tree over the block's transactions, from the bottom of the tree up.
+type MerkleProof struct {
+	// Index is the position of the transaction in the block.
+	Index uint32
+	// NumLeaves is the number of transactions in the block.
+	NumLeaves uint32
+	// Hashes are the sibling hashes on the path to the root.
+	Hashes []Hash
+}
+
+// merkleParent returns the hash of the interior node with the given children.
+func merkleParent(left, right Hash) Hash {
+	buf := make([]byte, 0, 1+2*HashLen)
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	hash := sha256.Sum256(buf)
+	return sha256.Sum256(hash[:])
+}
+
+// merkleLevel returns the level of the Merkle tree above the given level. If
+// the level has an odd number of nodes the last one is carried up unchanged.
+func merkleLevel(level []Hash) []Hash {
+	next := make([]Hash, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+		} else {
+			next = append(next, merkleParent(level[i], level[i+1]))
+		}
+	}
+	return next
+}
+
+// merkleLeaves returns the hashes of the given transactions.
+func merkleLeaves(txns []*Transaction) []Hash {
+	leaves := make([]Hash, len(txns))
+	for i, t := range txns {
+		leaves[i] = HashSum(t)
+	}
+	return leaves
+}
+
+// MerkleRoot returns the root of the Merkle tree over the hashes of the given
+// transactions, or NilHash if there are no transactions.
+func MerkleRoot(txns []*Transaction) Hash {
+	if len(txns) == 0 {
+		return NilHash
+	}
+	level := merkleLeaves(txns)
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return level[0]
+}
+
+// UpdateMerkleRoot sets the block's MerkleRoot to the root of the Merkle tree
+// over its transactions. This must be called whenever the block's transactions
+// change.
+func (b *Block) UpdateMerkleRoot() {
+	b.MerkleRoot = MerkleRoot(b.Transactions)
+}
+
+// MerkleProof builds a proof that the given transaction is included in the
+// block. Returns an error if the block does not contain the transaction.
+func (b *Block) MerkleProof(t *Transaction) (*MerkleProof, error) {
+	exists, index := b.ContainsTransaction(t)
+	if !exists {
+		return nil, errors.New("Transaction not in block")
+	}
+
+	proof := &MerkleProof{
+		Index:     index,
+		NumLeaves: uint32(len(b.Transactions)),
+		Hashes:    make([]Hash, 0),
+	}
+	level := merkleLeaves(b.Transactions)
+	for i := int(index); len(level) > 1; i /= 2 {
+		sibling := i ^ 1
+		if sibling < len(level) {
+			proof.Hashes = append(proof.Hashes, level[sibling])
+		}
+		level = merkleLevel(level)
+	}
+	return proof, nil
+}
+
+// Verify returns true if the proof shows that the transaction with the given
+// hash is included in a block with the given Merkle root.
+func (p *MerkleProof) Verify(root Hash, txnHash Hash) bool {
+	if p.Index >= p.NumLeaves {
+		return false
+	}
+
+	hash := txnHash
+	used := 0
+	for i, n := p.Index, p.NumLeaves; n > 1; i, n = i/2, (n+1)/2 {
+		if i == n-1 && n%2 == 1 {
+			// The node has no sibling and is carried up unchanged.
+			continue
+		}
+		if used == len(p.Hashes) {
+			return false
+		}
+		if i%2 == 0 {
+			hash = merkleParent(hash, p.Hashes[used])
+		} else {
+			hash = merkleParent(p.Hashes[used], hash)
+		}
+		used++
+	}
+	return used == len(p.Hashes) && hash == root
+}
@@ -0,0 +1,70 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTransactions(n int) []*Transaction {
+	txns := make([]*Transaction, n)
+	for i := range txns {
+		txns[i] = NewTestTransaction()
+	}
+	return txns
+}
+
+func TestMerkleRoot(t *testing.T) {
+	assert.Equal(t, NilHash, MerkleRoot([]*Transaction{}))
+
+	txns := newTestTransactions(3)
+	assert.Equal(t, HashSum(txns[0]), MerkleRoot(txns[:1]))
+
+	// The last transaction is carried up when there is an odd number.
+	left := merkleParent(HashSum(txns[0]), HashSum(txns[1]))
+	assert.Equal(t, left, MerkleRoot(txns[:2]))
+	assert.Equal(t, merkleParent(left, HashSum(txns[2])), MerkleRoot(txns))
+
+	// Reordering or duplicating transactions changes the root.
+	assert.NotEqual(t, MerkleRoot(txns), MerkleRoot([]*Transaction{txns[1], txns[0], txns[2]}))
+	assert.NotEqual(t, MerkleRoot(txns), MerkleRoot(append(txns, txns[2])))
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		b := &Block{Transactions: newTestTransactions(n)}
+		b.UpdateMerkleRoot()
+
+		for i, txn := range b.Transactions {
+			proof, err := b.MerkleProof(txn)
+			assert.Nil(t, err)
+			assert.Equal(t, uint32(i), proof.Index)
+			assert.True(t, proof.Verify(b.MerkleRoot, HashSum(txn)))
+
+			// The proof doesn't work for other transactions or roots.
+			assert.False(t, proof.Verify(b.MerkleRoot, NewTestHash()))
+			assert.False(t, proof.Verify(NewTestHash(), HashSum(txn)))
+		}
+	}
+}
+
+func TestMerkleProofTampered(t *testing.T) {
+	b := &Block{Transactions: newTestTransactions(5)}
+	b.UpdateMerkleRoot()
+	txn := b.Transactions[2]
+
+	proof, _ := b.MerkleProof(txn)
+	proof.Index = 3
+	assert.False(t, proof.Verify(b.MerkleRoot, HashSum(txn)))
+
+	proof, _ = b.MerkleProof(txn)
+	proof.Hashes = proof.Hashes[1:]
+	assert.False(t, proof.Verify(b.MerkleRoot, HashSum(txn)))
+
+	proof, _ = b.MerkleProof(txn)
+	proof.NumLeaves = 2
+	assert.False(t, proof.Verify(b.MerkleRoot, HashSum(txn)))
+
+	_, err := b.MerkleProof(NewTestTransaction())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// EntropyLen is the length in bytes of the random entropy that a
+	// mnemonic phrase encodes.
+	EntropyLen = 16
+	// MnemonicLen is the number of words in a mnemonic phrase: one for each
+	// byte of entropy and one for its checksum.
+	MnemonicLen = EntropyLen + 1
+	// SeedLen is the length in bytes of the seeds derived from mnemonics.
+	SeedLen = 64
+	// seedIterations is the number of PBKDF2 iterations used to derive a seed
+	// from a mnemonic.
+	seedIterations = 2048
+)
+
+var (
+	// ErrBadMnemonic is returned when a mnemonic phrase has the wrong number
+	// of words or contains a word that is not in the word list.
+	ErrBadMnemonic = errors.New("Invalid mnemonic phrase")
+	// ErrMnemonicChecksum is returned when the checksum word of a mnemonic
+	// phrase does not match the rest of it, which usually means a word was
+	// copied down wrong.
+	ErrMnemonicChecksum = errors.New("Mnemonic phrase checksum does not match")
+	// ErrBadEntropy is returned when entropy is not EntropyLen bytes long.
+	ErrBadEntropy = errors.New("Entropy must be 16 bytes long")
+)
+
+// wordIndex maps each word of the word list to its index.
+var wordIndex = make(map[string]byte, len(mnemonicWords))
+
+func init() {
+	for i, word := range mnemonicWords {
+		wordIndex[word] = byte(i)
+	}
+}
+
+// NewEntropy returns EntropyLen random bytes read from r.
+func NewEntropy(r io.Reader) ([]byte, error) {
+	entropy := make([]byte, EntropyLen)
+	if _, err := io.ReadFull(r, entropy); err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+// EntropyToMnemonic returns the mnemonic phrase that encodes the given
+// entropy, one word per byte, followed by a checksum word.
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	if len(entropy) != EntropyLen {
+		return "", ErrBadEntropy
+	}
+	words := make([]string, 0, MnemonicLen)
+	for _, b := range entropy {
+		words = append(words, mnemonicWords[b])
+	}
+	words = append(words, mnemonicWords[checksumByte(entropy)])
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy returns the entropy encoded by a mnemonic phrase. Words
+// are case-insensitive and may be separated by any whitespace.
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) != MnemonicLen {
+		return nil, ErrBadMnemonic
+	}
+	decoded := make([]byte, 0, MnemonicLen)
+	for _, word := range words {
+		b, ok := wordIndex[word]
+		if !ok {
+			return nil, ErrBadMnemonic
+		}
+		decoded = append(decoded, b)
+	}
+	entropy := decoded[:EntropyLen]
+	if checksumByte(entropy) != decoded[EntropyLen] {
+		return nil, ErrMnemonicChecksum
+	}
+	return entropy, nil
+}
+
+// MnemonicToSeed returns the seed derived from a mnemonic phrase and an
+// optional passphrase. A different passphrase gives a different seed, and so
+// different keys. The seed is derived with PBKDF2 as in BIP 39, but the word
+// list and the one word per byte encoding are our own, so phrases can't be
+// used with BIP 39 tools.
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	entropy, err := MnemonicToEntropy(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	normalized, _ := EntropyToMnemonic(entropy)
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase),
+		seedIterations, SeedLen, sha512.New), nil
+}
+
+// checksumByte returns the first byte of the SHA-256 hash of the entropy.
+func checksumByte(entropy []byte) byte {
+	hash := sha256.Sum256(entropy)
+	return hash[0]
+}
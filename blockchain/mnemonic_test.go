@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMnemonicWords(t *testing.T) {
+	prefixes := make(map[string]bool)
+	for _, word := range mnemonicWords {
+		prefix := word
+		if len(prefix) > 4 {
+			prefix = prefix[:4]
+		}
+		assert.False(t, prefixes[prefix], word)
+		prefixes[prefix] = true
+	}
+}
+
+func TestMnemonicRoundTrip(t *testing.T) {
+	entropy, err := NewEntropy(crand.Reader)
+	assert.Nil(t, err)
+	mnemonic, err := EntropyToMnemonic(entropy)
+	assert.Nil(t, err)
+	assert.Len(t, strings.Fields(mnemonic), MnemonicLen)
+
+	decoded, err := MnemonicToEntropy(mnemonic)
+	assert.Nil(t, err)
+	assert.Equal(t, entropy, decoded)
+
+	// Case and spacing don't matter.
+	decoded, err = MnemonicToEntropy("  " + strings.ToUpper(mnemonic) + "\n")
+	assert.Nil(t, err)
+	assert.Equal(t, entropy, decoded)
+
+	_, err = EntropyToMnemonic(entropy[1:])
+	assert.Equal(t, ErrBadEntropy, err)
+}
+
+func TestMnemonicToEntropyErrors(t *testing.T) {
+	entropy := bytes.Repeat([]byte{0}, EntropyLen)
+	mnemonic, _ := EntropyToMnemonic(entropy)
+	words := strings.Fields(mnemonic)
+
+	_, err := MnemonicToEntropy(strings.Join(words[1:], " "))
+	assert.Equal(t, ErrBadMnemonic, err)
+
+	words[0] = "bitcoin"
+	_, err = MnemonicToEntropy(strings.Join(words, " "))
+	assert.Equal(t, ErrBadMnemonic, err)
+
+	// Swapping a word for another breaks the checksum.
+	words[0] = mnemonicWords[1]
+	_, err = MnemonicToEntropy(strings.Join(words, " "))
+	assert.Equal(t, ErrMnemonicChecksum, err)
+}
+
+func TestMnemonicToSeed(t *testing.T) {
+	mnemonic, _ := EntropyToMnemonic(bytes.Repeat([]byte{1}, EntropyLen))
+	seed, err := MnemonicToSeed(mnemonic, "")
+	assert.Nil(t, err)
+	assert.Len(t, seed, SeedLen)
+
+	again, _ := MnemonicToSeed(strings.ToUpper(mnemonic), "")
+	assert.Equal(t, seed, again)
+
+	other, _ := MnemonicToSeed(mnemonic, "passphrase")
+	assert.NotEqual(t, seed, other)
+
+	_, err = MnemonicToSeed("able", "")
+	assert.Equal(t, ErrBadMnemonic, err)
+}
@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/ubclaunchpad/cumulus/common/util"
+)
+
+const (
+	// MultisigVersion is the version of the multisig address protocol. It
+	// differs from AddressVersion so multisig addresses can't collide with the
+	// addresses of single keys.
+	MultisigVersion = 1
+	// MaxMultisigKeys is the most keys a multisig address can have.
+	MaxMultisigKeys = 16
+)
+
+var (
+	// ErrBadMultisig is returned when a multisig policy does not have between
+	// 1 and MaxMultisigKeys distinct keys and a threshold between 1 and the
+	// number of keys.
+	ErrBadMultisig = errors.New("Invalid multisig policy")
+	// ErrNotMultisig is returned when a transaction does not spend from a
+	// multisig address.
+	ErrNotMultisig = errors.New("Transaction does not spend from a multisig address")
+	// ErrNotCosigner is returned when an account signing a multisig
+	// transaction is not one of the keys of its multisig address.
+	ErrNotCosigner = errors.New("Account is not a cosigner of the transaction")
+	// ErrBodiesDiffer is returned when merging the signatures of transactions
+	// with different bodies.
+	ErrBodiesDiffer = errors.New("Transactions have different bodies")
+)
+
+// Multisig is an address whose outputs can only be spent by transactions
+// signed by at least M of its Keys.
+type Multisig struct {
+	M    uint32
+	Keys []Address
+}
+
+// NewMultisig returns the multisig address of the given keys that requires m
+// of them to sign. Returns an error if the policy is not valid.
+func NewMultisig(m uint32, keys ...Address) (*Multisig, error) {
+	ms := &Multisig{M: m, Keys: keys}
+	if !ms.Valid() {
+		return nil, ErrBadMultisig
+	}
+	return ms, nil
+}
+
+// Valid returns true if the multisig address has between 1 and
+// MaxMultisigKeys distinct keys and requires between 1 and all of them to
+// sign.
+func (ms *Multisig) Valid() bool {
+	n := len(ms.Keys)
+	if n == 0 || n > MaxMultisigKeys || ms.M == 0 || int(ms.M) > n {
+		return false
+	}
+	seen := make(map[string]bool, n)
+	for _, key := range ms.Keys {
+		if key.X == nil || key.Y == nil || seen[key.Repr()] {
+			return false
+		}
+		seen[key.Repr()] = true
+	}
+	return true
+}
+
+// Marshal converts a Multisig to a byte slice.
+func (ms *Multisig) Marshal() []byte {
+	var buf []byte
+	buf = util.AppendUint32(buf, ms.M)
+	buf = util.AppendUint32(buf, uint32(len(ms.Keys)))
+	for _, key := range ms.Keys {
+		buf = append(buf, key.Marshal()...)
+	}
+	return buf
+}
+
+// Repr returns the string representation of the multisig address, which
+// outputs to it use as their recipient. It is built the same way as the
+// representation of an Address, from the policy instead of a single key.
+func (ms *Multisig) Repr() string {
+	prefix := append([]byte{MultisigVersion}, ms.Marshal()...)
+	hash := sha256.Sum256(prefix)
+	return hex.EncodeToString(hash[96/8 : 256/8])
+}
+
+// index returns the position of the given key in the multisig address, or -1
+// if it is not one of its keys.
+func (ms *Multisig) index(key Address) int {
+	for i, k := range ms.Keys {
+		if k.Repr() == key.Repr() {
+			return i
+		}
+	}
+	return -1
+}
+
+// Verify returns true if at least M of the given signatures, which are in the
+// same order as the keys, are valid signatures of digest by their keys. Keys
+// that have not signed have a NilSig. Returns false if any other signature is
+// not valid.
+func (ms *Multisig) Verify(digest Hash, sigs []Signature) bool {
+	if len(sigs) != len(ms.Keys) {
+		return false
+	}
+	signed := uint32(0)
+	for i, sig := range sigs {
+		if sig.IsNil() {
+			continue
+		}
+		if !ecdsa.Verify(ms.Keys[i].Key(), digest.Marshal(), sig.R, sig.S) {
+			return false
+		}
+		signed++
+	}
+	return signed >= ms.M
+}
+
+// IsNil returns true if the signature is NilSig or unset.
+func (s *Signature) IsNil() bool {
+	return s.R == nil || s.S == nil || (s.R.Sign() == 0 && s.S.Sign() == 0)
+}
+
+// NewMultisigTransaction returns an unsigned transaction from a TxBody that
+// spends from a multisig address. The cosigners add their signatures with
+// AddSignature, or sign copies that are combined with Merge. Returns an error
+// if the body does not have a valid multisig address.
+func NewMultisigTransaction(tb TxBody) (*Transaction, error) {
+	if tb.Multisig == nil {
+		return nil, ErrNotMultisig
+	}
+	if !tb.Multisig.Valid() {
+		return nil, ErrBadMultisig
+	}
+	sigs := make([]Signature, len(tb.Multisig.Keys))
+	for i := range sigs {
+		sigs[i] = NilSig
+	}
+	return &Transaction{TxBody: tb, Sig: NilSig, Sigs: sigs}, nil
+}
+
+// AddSignature signs the multisig transaction with the given account, which
+// must be one of the keys of the address the transaction spends from.
+func (t *Transaction) AddSignature(a Account, r io.Reader) error {
+	if t.Multisig == nil {
+		return ErrNotMultisig
+	}
+	i := t.Multisig.index(a.Public())
+	if i < 0 {
+		return ErrNotCosigner
+	}
+	sig, err := a.Sign(HashSum(t.TxBody), r)
+	if err != nil {
+		return err
+	}
+	t.Sigs[i] = sig
+	return nil
+}
+
+// Merge adds the signatures of other, a copy of the multisig transaction
+// signed by other cosigners, to the transaction.
+func (t *Transaction) Merge(other *Transaction) error {
+	if t.Multisig == nil {
+		return ErrNotMultisig
+	}
+	if HashSum(t.TxBody) != HashSum(other.TxBody) || len(t.Sigs) != len(other.Sigs) {
+		return ErrBodiesDiffer
+	}
+	for i, sig := range other.Sigs {
+		if t.Sigs[i].IsNil() && !sig.IsNil() {
+			t.Sigs[i] = sig
+		}
+	}
+	return nil
+}
+
+// Signed returns the number of cosigners that have signed the multisig
+// transaction.
+func (t *Transaction) Signed() int {
+	signed := 0
+	for _, sig := range t.Sigs {
+		if !sig.IsNil() {
+			signed++
+		}
+	}
+	return signed
+}
+
+// Complete returns true if enough cosigners have signed the multisig
+// transaction for it to be spent.
+func (t *Transaction) Complete() bool {
+	return t.Multisig != nil && t.Signed() >= int(t.Multisig.M)
+}
@@ -0,0 +1,97 @@
+package blockchain
+
+import (
+	crand "crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMultisig(t *testing.T) {
+	a, b := NewWallet().Public(), NewWallet().Public()
+
+	ms, err := NewMultisig(2, a, b)
+	assert.Nil(t, err)
+	assert.Len(t, ms.Repr(), ReprLen)
+	assert.NotEqual(t, a.Repr(), ms.Repr())
+
+	// The address depends on the threshold and the keys.
+	other, _ := NewMultisig(1, a, b)
+	assert.NotEqual(t, ms.Repr(), other.Repr())
+
+	_, err = NewMultisig(0, a, b)
+	assert.Equal(t, ErrBadMultisig, err)
+	_, err = NewMultisig(3, a, b)
+	assert.Equal(t, ErrBadMultisig, err)
+	_, err = NewMultisig(1, a, a)
+	assert.Equal(t, ErrBadMultisig, err)
+	_, err = NewMultisig(1)
+	assert.Equal(t, ErrBadMultisig, err)
+}
+
+// newTestMultisigTxn returns an unsigned transaction spending coins sent to a
+// 2-of-3 multisig address, along with the wallets of the address's keys.
+func newTestMultisigTxn() (*Transaction, []*Wallet) {
+	ms, cosigners := NewTestMultisig()
+	_, body, _ := NewValidTestChainAndSpend(&TxOutput{Recipient: ms.Repr()})
+	body.Multisig = ms
+	txn, _ := NewMultisigTransaction(body)
+	return txn, cosigners
+}
+
+func TestMultisigTransactionSigning(t *testing.T) {
+	txn, cosigners := newTestMultisigTxn()
+	assert.Equal(t, txn.Multisig.Repr(), txn.From())
+	assert.False(t, txn.Complete())
+
+	// Cosigners can sign their own copies, which are then merged.
+	copied := *txn
+	copied.Sigs = append([]Signature{}, txn.Sigs...)
+	assert.Nil(t, txn.AddSignature(cosigners[0], crand.Reader))
+	assert.Nil(t, copied.AddSignature(cosigners[2], crand.Reader))
+	assert.Equal(t, 1, txn.Signed())
+
+	assert.Nil(t, txn.Merge(&copied))
+	assert.Equal(t, 2, txn.Signed())
+	assert.True(t, txn.Complete())
+	assert.True(t, txn.Multisig.Verify(HashSum(txn.TxBody), txn.Sigs))
+	assert.True(t, txn.Sigs[1].IsNil())
+
+	// Only the keys of the multisig address can sign.
+	assert.Equal(t, ErrNotCosigner, txn.AddSignature(NewWallet(), crand.Reader))
+
+	// Signatures of different transactions can't be merged.
+	copied.Outputs = []TxOutput{TxOutput{Amount: 1, Recipient: txn.From()}}
+	assert.Equal(t, ErrBodiesDiffer, txn.Merge(&copied))
+}
+
+func TestMultisigVerifyBadSignature(t *testing.T) {
+	txn, cosigners := newTestMultisigTxn()
+	txn.AddSignature(cosigners[0], crand.Reader)
+	txn.AddSignature(cosigners[1], crand.Reader)
+
+	// A signature in the wrong place is not valid.
+	txn.Sigs[1], txn.Sigs[2] = txn.Sigs[2], txn.Sigs[1]
+	assert.False(t, txn.Multisig.Verify(HashSum(txn.TxBody), txn.Sigs))
+}
+
+func TestMultisigTransactionMarshal(t *testing.T) {
+	txn, cosigners := newTestMultisigTxn()
+	unsigned := HashSum(txn)
+	body := HashSum(txn.TxBody)
+	txn.AddSignature(cosigners[0], crand.Reader)
+
+	// The signatures are part of the transaction but not its body.
+	assert.NotEqual(t, unsigned, HashSum(txn))
+	assert.Equal(t, body, HashSum(txn.TxBody))
+
+	// The multisig address is part of the signed body.
+	single := txn.TxBody
+	single.Multisig = nil
+	assert.NotEqual(t, body, HashSum(single))
+}
+
+func TestNewMultisigTransactionNotMultisig(t *testing.T) {
+	_, err := NewMultisigTransaction(NewTestTxBody())
+	assert.Equal(t, ErrNotMultisig, err)
+}
@@ -0,0 +1,86 @@
+package blockchain
+
+import log "github.com/Sirupsen/logrus"
+
+// SetPruneDepth enables pruning of the blockchain. Only the transactions of
+// the last depth blocks on the main chain are kept; older blocks are reduced
+// to their headers, which is enough to keep validating new blocks using the
+// UTXO set. Reorgs can't disconnect pruned blocks, so depth limits how deep a
+// reorg can be. A depth of 0 disables pruning, but blocks that have already
+// been pruned can't be restored.
+func (bc *BlockChain) SetPruneDepth(depth uint32) {
+	bc.pruneDepth = depth
+	if bc.utxos != nil {
+		bc.utxos.setDepth(undoDepth(depth))
+	}
+	bc.pruneBlocks()
+}
+
+// PruneDepth returns the number of blocks whose transactions are kept, or 0
+// if the blockchain is not pruned.
+func (bc *BlockChain) PruneDepth() uint32 {
+	return bc.pruneDepth
+}
+
+// PrunedHeight returns the number of blocks at the start of the main chain
+// whose transactions have been discarded.
+func (bc *BlockChain) PrunedHeight() uint32 {
+	return bc.pruned
+}
+
+// isPruned returns true if the block's transactions have been discarded.
+func (b *Block) isPruned() bool {
+	return len(b.Transactions) == 0 && b.MerkleRoot != NilHash
+}
+
+// pruneBlocks discards the transactions of main chain blocks that are more
+// than pruneDepth blocks from the tip, along with side branches that fork from
+// the main chain before them, which can no longer be reorganized to.
+func (bc *BlockChain) pruneBlocks() {
+	if bc.pruneDepth == 0 {
+		return
+	}
+	for int(bc.pruned)+int(bc.pruneDepth) < len(bc.Blocks) {
+		height := bc.pruned
+		b := bc.block(int(height))
+		hash := HashSum(b)
+		bc.unindexTransactions(b, height)
+		if bc.utxos != nil {
+			bc.utxos.forget(hash)
+		}
+
+		bc.setBlock(int(height), hash, &Block{BlockHeader: b.BlockHeader})
+		node := bc.nodes[hash]
+		for _, sibling := range append([]*blockNode{}, bc.children[b.LastBlock]...) {
+			if sibling != node {
+				bc.prune(sibling)
+			}
+		}
+		bc.pruned++
+	}
+
+	// Rewriting the block store is expensive, so only do it once enough
+	// blocks have been pruned.
+	if bc.pruned >= bc.storePruned+bc.pruneDepth {
+		bc.pruneStore()
+	}
+}
+
+// pruneStore discards the transactions of pruned blocks from the block store
+// backing the blockchain, if there is one.
+func (bc *BlockChain) pruneStore() {
+	if bc.store == nil || bc.storePruned == bc.pruned {
+		return
+	}
+	// Once the transactions are gone from the store the UTXO set can't be
+	// rebuilt, so make sure it is saved first.
+	if err := bc.saveState(); err != nil {
+		log.WithError(err).Error("Failed to save UTXO set before pruning block store")
+		return
+	}
+	if err := bc.store.Prune(int(bc.pruned)); err != nil {
+		log.WithError(err).Error("Failed to prune block store")
+		return
+	}
+	bc.storePruned = bc.pruned
+}
@@ -0,0 +1,117 @@
+package blockchain
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetPruneDepth(t *testing.T) {
+	bc, b := NewValidTestChainAndBlock()
+	hashes := []Hash{HashSum(bc.Blocks[0]), HashSum(bc.Blocks[1]), HashSum(bc.Blocks[2])}
+	header := bc.Blocks[0].BlockHeader
+	txn := bc.Blocks[0].Transactions[1]
+	utxos := bc.UnspentOutputsFor(txn.Outputs[0].Recipient)
+
+	bc.SetPruneDepth(2)
+	assert.Equal(t, uint32(1), bc.PrunedHeight())
+	assert.Equal(t, header, bc.Blocks[0].BlockHeader)
+	assert.Empty(t, bc.Blocks[0].Transactions)
+	assert.NotEmpty(t, bc.Blocks[1].Transactions)
+
+	// Pruned blocks are still known by hash, but their transactions aren't.
+	pruned, err := bc.GetBlockByHash(hashes[0])
+	assert.Nil(t, err)
+	assert.Equal(t, bc.Blocks[0], pruned)
+	_, _, err = bc.GetTransactionByHash(HashSum(txn))
+	assert.NotNil(t, err)
+	assert.Equal(t, utxos, bc.UnspentOutputsFor(txn.Outputs[0].Recipient))
+
+	// Appending a block prunes another one.
+	assert.Nil(t, bc.AppendBlock(b))
+	assert.Equal(t, uint32(2), bc.PrunedHeight())
+	// A pruned block's header still hashes to the block's hash.
+	assert.Equal(t, hashes[1], HashSum(bc.Blocks[1]))
+	assert.Equal(t, hashes[2], bc.Blocks[3].LastBlock)
+}
+
+func TestPrunedBlocksCanBeReverted(t *testing.T) {
+	bc, b := NewValidTestChainAndBlock()
+	bc.SetPruneDepth(1)
+	expected := bc.UnspentOutputsFor(b.Transactions[1].Sender.Repr())
+
+	// The block spends outputs in blocks that are pruned as soon as it is
+	// appended, but it can still be rolled back.
+	assert.Nil(t, bc.AppendBlock(b))
+	assert.Equal(t, uint32(3), bc.PrunedHeight())
+	assert.Equal(t, b, bc.RollBack())
+	assert.Equal(t, expected, bc.UnspentOutputsFor(b.Transactions[1].Sender.Repr()))
+
+	// Pruned blocks can't be rolled back or forked from.
+	assert.Nil(t, bc.RollBack())
+	_, err := bc.AddSideBlock(NewTestChildBlock(bc.Blocks[0], bc.Blocks[1].Target))
+	assert.NotNil(t, err)
+}
+
+func TestOpenPrunedBlockChain(t *testing.T) {
+	bc1, b := NewValidTestChainAndBlock()
+	bc2, err := Open("pruneTestFile.dat")
+	assert.Nil(t, err)
+	defer os.Remove("pruneTestFile.dat")
+	defer os.Remove("pruneTestFile.dat" + utxoFileSuffix)
+	defer os.Remove("pruneTestFile.dat" + indexFileSuffix)
+
+	for _, blk := range append(bc1.Blocks, b) {
+		assert.Nil(t, bc2.AppendBlock(blk))
+	}
+	bc2.SetPruneDepth(2)
+	assert.Nil(t, bc2.Close())
+
+	// The pruned blocks are stored without their transactions.
+	bc3, err := Open("pruneTestFile.dat")
+	assert.Nil(t, err)
+	assert.Equal(t, bc2.Head, bc3.Head)
+	assert.Equal(t, uint32(2), bc3.PrunedHeight())
+	assert.Empty(t, bc3.Blocks[1].Transactions)
+	assert.Equal(t, bc2.utxos, bc3.utxos)
+	assert.NotNil(t, bc3.GetKnownBlock(HashSum(bc1.Blocks[0])))
+	assert.Nil(t, bc3.Close())
+
+	// The pruned blockchain can't be opened without its UTXO set.
+	os.Remove("pruneTestFile.dat" + utxoFileSuffix)
+	_, err = Open("pruneTestFile.dat")
+	assert.NotNil(t, err)
+}
+
+func TestOpenPrunedBlockChainAfterCrash(t *testing.T) {
+	bc1, b := NewValidTestChainAndBlock()
+	bc2, err := Open("pruneTestFile.dat")
+	assert.Nil(t, err)
+	defer os.Remove("pruneTestFile.dat")
+	defer os.Remove("pruneTestFile.dat" + utxoFileSuffix)
+	defer os.Remove("pruneTestFile.dat" + indexFileSuffix)
+
+	// The UTXO set is saved before the block store is pruned, and the blocks
+	// appended after that are applied to it when the chain is reopened.
+	for _, blk := range append(bc1.Blocks, b) {
+		assert.Nil(t, bc2.AppendBlock(blk))
+	}
+	bc2.SetPruneDepth(2)
+	assert.Equal(t, uint32(2), bc2.storePruned)
+	child := NewTestChildBlock(b, b.Target)
+	cb, _ := NewValidCloudBaseTestTransaction()
+	child.Transactions = []*Transaction{cb}
+	child.UpdateMerkleRoot()
+	assert.Nil(t, bc2.AppendBlock(child))
+	assert.Equal(t, uint32(2), bc2.storePruned)
+	bc2.store.Close()
+
+	bc3, err := Open("pruneTestFile.dat")
+	assert.Nil(t, err)
+	assert.Equal(t, bc2.Head, bc3.Head)
+	assert.Equal(t, uint32(2), bc3.PrunedHeight())
+	recipient := cb.Outputs[0].Recipient
+	assert.Equal(t, bc2.UnspentOutputsFor(recipient), bc3.UnspentOutputsFor(recipient))
+	assert.Nil(t, bc3.Close())
+}
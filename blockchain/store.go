@@ -0,0 +1,311 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// recordHeaderLen is the length in bytes of the header that precedes every
+	// block record in a BlockStore: a 4 byte payload length followed by a 4
+	// byte CRC-32 checksum of the payload.
+	recordHeaderLen = 8
+	// maxRecordLen is the largest payload we will accept when reading a record.
+	// Anything larger is treated as corruption.
+	maxRecordLen = 1 << 28
+)
+
+var (
+	// crcTable is the CRC-32 table used to checksum block records.
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+	// errBadRecord is returned when a record in the store is torn or corrupt.
+	errBadRecord = errors.New("Corrupt block record")
+)
+
+// BlockStore is an append-only file of checksummed block records, one record
+// per block in the order the blocks appear in the blockchain. Every append is
+// flushed to disk before it returns, so a crash loses at most the block that
+// was being written, and a torn record at the end of the file is discarded
+// when the store is next opened. A corrupt record anywhere else is an error.
+type BlockStore struct {
+	file    *os.File
+	offsets []int64
+	size    int64
+	lock    sync.Mutex
+}
+
+// OpenBlockStore opens the block store with the given file name, creating it
+// if it does not exist. The store is scanned and a torn record at the end of
+// the file is truncated away. Returns an error if any other record is corrupt.
+func OpenBlockStore(fileName string) (*BlockStore, error) {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BlockStore{
+		file:    file,
+		offsets: make([]int64, 0),
+	}
+	if err := s.scan(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// scan reads the headers and checksums of all records in the store, building
+// the record offset index. A torn tail is truncated, but the blocks after a
+// corrupt record are never discarded; an error is returned instead.
+func (s *BlockStore) scan() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+
+	offset := int64(0)
+	for offset < fileSize {
+		payload, err := s.readRecord(offset, fileSize)
+		if err == errBadRecord {
+			if !s.tornTail(offset, fileSize) {
+				return fmt.Errorf("Block record %d in %s is corrupt",
+					len(s.offsets), s.file.Name())
+			}
+			log.Warnf("Truncating %d bytes of torn block record from %s",
+				fileSize-offset, s.file.Name())
+			if err := s.file.Truncate(offset); err != nil {
+				return err
+			}
+			if err := s.file.Sync(); err != nil {
+				return err
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+		s.offsets = append(s.offsets, offset)
+		offset += recordHeaderLen + int64(len(payload))
+	}
+	s.size = offset
+	return nil
+}
+
+// tornTail returns true if the bad record at the given offset could be the
+// last record in the file cut short by a crash while it was being appended:
+// either its header is incomplete, or its length is sane and the record runs
+// up to or past the end of the file.
+func (s *BlockStore) tornTail(offset, fileSize int64) bool {
+	if fileSize-offset < recordHeaderLen {
+		return true
+	}
+	header := make([]byte, recordHeaderLen)
+	if _, err := s.file.ReadAt(header, offset); err != nil {
+		return false
+	}
+	length := int64(binary.LittleEndian.Uint32(header[0:4]))
+	return length <= maxRecordLen && offset+recordHeaderLen+length >= fileSize
+}
+
+// readRecord reads and verifies the payload of the record at the given offset.
+// Returns errBadRecord if the record is incomplete or its checksum does not
+// match.
+func (s *BlockStore) readRecord(offset, fileSize int64) ([]byte, error) {
+	if fileSize-offset < recordHeaderLen {
+		return nil, errBadRecord
+	}
+
+	header := make([]byte, recordHeaderLen)
+	if _, err := s.file.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+	length := int64(binary.LittleEndian.Uint32(header[0:4]))
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	if length > maxRecordLen || fileSize-offset-recordHeaderLen < length {
+		return nil, errBadRecord
+	}
+
+	payload := make([]byte, length)
+	if _, err := s.file.ReadAt(payload, offset+recordHeaderLen); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return nil, errBadRecord
+	}
+	return payload, nil
+}
+
+// Len returns the number of blocks in the store.
+func (s *BlockStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.offsets)
+}
+
+// Append writes the given block to the end of the store and flushes it to
+// disk.
+func (s *BlockStore) Append(b *Block) error {
+	payload, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	record := encodeRecord(payload)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.file.WriteAt(record, s.size); err != nil {
+		// Cut off whatever part of the record made it to disk.
+		s.file.Truncate(s.size)
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.offsets = append(s.offsets, s.size)
+	s.size += int64(len(record))
+	return nil
+}
+
+// encodeRecord returns the record for the given payload.
+func encodeRecord(payload []byte) []byte {
+	record := make([]byte, recordHeaderLen, recordHeaderLen+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	return append(record, payload...)
+}
+
+// Get reads and decodes the block at the given position in the store.
+func (s *BlockStore) Get(i int) (*Block, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if i < 0 || i >= len(s.offsets) {
+		return nil, errors.New("No such block")
+	}
+	payload, err := s.readRecord(s.offsets[i], s.size)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeBlockJSON(payload)
+}
+
+// Truncate removes every block at position n and above from the store.
+func (s *BlockStore) Truncate(n int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if n < 0 || n >= len(s.offsets) {
+		return nil
+	}
+	if err := s.file.Truncate(s.offsets[n]); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.size = s.offsets[n]
+	s.offsets = s.offsets[:n]
+	return nil
+}
+
+// Prune discards the transactions of the first n blocks in the store, keeping
+// their headers. The store is rewritten to a temporary file which then
+// replaces the original, so a crash part way through leaves the store intact.
+func (s *BlockStore) Prune(n int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fileName := s.file.Name()
+	tmp, err := os.Create(fileName + ".tmp")
+	if err != nil {
+		return err
+	}
+	offset := int64(0)
+	for i, recordOffset := range s.offsets {
+		payload, err := s.readRecord(recordOffset, s.size)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if i < n {
+			b, err := DecodeBlockJSON(payload)
+			if err != nil {
+				tmp.Close()
+				return err
+			}
+			b.Transactions = nil
+			if payload, err = json.Marshal(b); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+		record := encodeRecord(payload)
+		if _, err := tmp.WriteAt(record, offset); err != nil {
+			tmp.Close()
+			return err
+		}
+		offset += int64(len(record))
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(fileName+".tmp", fileName); err != nil {
+		return err
+	}
+
+	// Switch over to the new file.
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = file
+	s.offsets = make([]int64, 0)
+	return s.scan()
+}
+
+// Close closes the underlying file.
+func (s *BlockStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+// saveJSON writes v in JSON format to a temporary file, syncs it to disk and
+// renames it to the given file name, so a crash while saving leaves the
+// previous copy of the file intact.
+func saveJSON(fileName string, v interface{}) error {
+	tmpFileName := fileName + ".tmp"
+	file, err := os.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(v); err != nil {
+		file.Close()
+		os.Remove(tmpFileName)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpFileName)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
@@ -0,0 +1,135 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const storeTestFile = "storeTestFile.dat"
+
+func TestBlockStoreAppendAndGet(t *testing.T) {
+	bc, b := NewValidTestChainAndBlock()
+	s, err := OpenBlockStore(storeTestFile)
+	assert.Nil(t, err)
+	defer os.Remove(storeTestFile)
+
+	for _, blk := range append(bc.Blocks, b) {
+		assert.Nil(t, s.Append(blk))
+	}
+	assert.Equal(t, 4, s.Len())
+	assert.Nil(t, s.Close())
+
+	s, err = OpenBlockStore(storeTestFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, s.Len())
+	for i, blk := range append(bc.Blocks, b) {
+		stored, err := s.Get(i)
+		assert.Nil(t, err)
+		assert.Equal(t, HashSum(blk), HashSum(stored))
+	}
+	_, err = s.Get(4)
+	assert.NotNil(t, err)
+	assert.Nil(t, s.Close())
+}
+
+func TestBlockStoreTornTail(t *testing.T) {
+	bc, _ := NewValidTestChainAndBlock()
+	s, err := OpenBlockStore(storeTestFile)
+	assert.Nil(t, err)
+	defer os.Remove(storeTestFile)
+
+	for _, blk := range bc.Blocks {
+		assert.Nil(t, s.Append(blk))
+	}
+	size := s.size
+	assert.Nil(t, s.Close())
+
+	// Simulate a crash in the middle of writing the last block.
+	assert.Nil(t, os.Truncate(storeTestFile, size-10))
+	s, err = OpenBlockStore(storeTestFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, s.Len())
+
+	// The store should be writable again after the tail is discarded.
+	assert.Nil(t, s.Append(bc.Blocks[2]))
+	assert.Nil(t, s.Close())
+	s, err = OpenBlockStore(storeTestFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, s.Len())
+	assert.Nil(t, s.Close())
+}
+
+func TestBlockStoreBadChecksum(t *testing.T) {
+	bc, _ := NewValidTestChainAndBlock()
+	s, err := OpenBlockStore(storeTestFile)
+	assert.Nil(t, err)
+	defer os.Remove(storeTestFile)
+
+	for _, blk := range bc.Blocks {
+		assert.Nil(t, s.Append(blk))
+	}
+	offsets, size := s.offsets, s.size
+	assert.Nil(t, s.Close())
+
+	corrupt := func(at int64) {
+		f, err := os.OpenFile(storeTestFile, os.O_RDWR, 0644)
+		assert.Nil(t, err)
+		_, err = f.WriteAt([]byte{'!'}, at)
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+	}
+
+	// A bad last record is treated as torn and dropped.
+	corrupt(size - 1)
+	s, err = OpenBlockStore(storeTestFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, s.Len())
+	assert.Nil(t, s.Append(bc.Blocks[2]))
+	assert.Nil(t, s.Close())
+
+	// The blocks after a bad record in the middle are not thrown away.
+	corrupt(offsets[1] + recordHeaderLen + 1)
+	_, err = OpenBlockStore(storeTestFile)
+	assert.NotNil(t, err)
+	info, err := os.Stat(storeTestFile)
+	assert.Nil(t, err)
+	assert.Equal(t, size, info.Size())
+}
+
+func TestBlockStoreTruncate(t *testing.T) {
+	bc, _ := NewValidTestChainAndBlock()
+	s, err := OpenBlockStore(storeTestFile)
+	assert.Nil(t, err)
+	defer os.Remove(storeTestFile)
+
+	for _, blk := range bc.Blocks {
+		assert.Nil(t, s.Append(blk))
+	}
+	assert.Nil(t, s.Truncate(1))
+	assert.Equal(t, 1, s.Len())
+	assert.Nil(t, s.Close())
+
+	s, err = OpenBlockStore(storeTestFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, s.Len())
+	assert.Nil(t, s.Close())
+}
+
+func TestSaveJSON(t *testing.T) {
+	assert.Nil(t, saveJSON(storeTestFile, []int{1, 2}))
+	defer os.Remove(storeTestFile)
+	assert.Nil(t, saveJSON(storeTestFile, []int{3}))
+
+	// The temporary file is renamed over the old copy.
+	_, err := os.Stat(storeTestFile + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+	file, err := os.Open(storeTestFile)
+	assert.Nil(t, err)
+	defer file.Close()
+	var saved []int
+	assert.Nil(t, json.NewDecoder(file).Decode(&saved))
+	assert.Equal(t, []int{3}, saved)
+}
@@ -6,9 +6,9 @@ import (
 	"math"
 	"math/big"
 	mrand "math/rand"
-	"sync"
 
 	c "github.com/ubclaunchpad/cumulus/common/constants"
+	"github.com/ubclaunchpad/cumulus/common/util"
 )
 
 // NewTestHash produces a hash.
@@ -29,8 +29,10 @@ func NewTestTxHashPointer() TxHashPointer {
 
 // NewTestTxOutput random txn output.
 func NewTestTxOutput() TxOutput {
+	// Keep amounts small enough that the outputs of a test transaction can't
+	// overflow.
 	return TxOutput{
-		Amount:    uint64(mrand.Int63()),
+		Amount:    uint64(mrand.Int63n(math.MaxInt64 / 4)),
 		Recipient: NewWallet().Public().Repr(),
 	}
 }
@@ -84,6 +86,7 @@ func NewTestBlock() *Block {
 	for i := 0; i < nTransactions; i++ {
 		b.Transactions[i] = NewTestTransaction()
 	}
+	b.UpdateMerkleRoot()
 	return &b
 }
 
@@ -91,13 +94,11 @@ func NewTestBlock() *Block {
 func NewTestBlockChain() *BlockChain {
 	// Uniform distribution on [10, 50]
 	nBlocks := mrand.Intn(40) + 10
-	bc := BlockChain{Blocks: make([]*Block, nBlocks)}
+	bc := New()
 	for i := 0; i < nBlocks; i++ {
-		bc.Blocks[i] = NewTestBlock()
+		bc.AppendBlock(NewTestBlock())
 	}
-	bc.Head = HashSum(bc.Blocks[nBlocks-1])
-	bc.lock = &sync.RWMutex{}
-	return &bc
+	return bc
 }
 
 // NewValidBlockChainFixture creates a valid blockchain of three blocks
@@ -134,12 +135,13 @@ func NewValidBlockChainFixture() (*BlockChain, map[string]*Wallet) {
 			BlockNumber: 0,
 			LastBlock:   NewTestHash(),
 			Target:      NewValidTestTarget(),
-			Time:        mrand.Uint32(),
+			Time:        util.UnixNow() - 300,
 			Nonce:       0,
 		},
 		// Block0 is a cb and a transaction.
 		Transactions: []*Transaction{cbA, tA},
 	}
+	block0.UpdateMerkleRoot()
 
 	// Transaction B is at index 1 in block 1 (sender sends 3 coins to alice).
 	tB, _ := TxBody{
@@ -150,6 +152,13 @@ func NewValidBlockChainFixture() (*BlockChain, map[string]*Wallet) {
 				BlockNumber: 0,
 				Index:       1, // Cloudbase will bump transactions forward.
 				Hash:        HashSum(tA),
+				Output:      0,
+			},
+			TxHashPointer{
+				BlockNumber: 0,
+				Index:       1,
+				Hash:        HashSum(tA),
+				Output:      1,
 			},
 		},
 		// Send some outputs to alice, some back to sender.
@@ -171,11 +180,12 @@ func NewValidBlockChainFixture() (*BlockChain, map[string]*Wallet) {
 			BlockNumber: 1,
 			LastBlock:   HashSum(block0),
 			Target:      NewValidTestTarget(),
-			Time:        mrand.Uint32(),
+			Time:        block0.Time + 60,
 			Nonce:       0,
 		},
 		Transactions: []*Transaction{cbB, tB},
 	}
+	block1.UpdateMerkleRoot()
 
 	// Sender has 1 coin left to send to bob.
 	tC, _ := TxBody{
@@ -186,6 +196,7 @@ func NewValidBlockChainFixture() (*BlockChain, map[string]*Wallet) {
 				BlockNumber: 1,
 				Index:       1, // skip cb
 				Hash:        HashSum(tB),
+				Output:      1, // the coin sent back to sender
 			},
 		},
 		// One coin output to bob.
@@ -203,11 +214,12 @@ func NewValidBlockChainFixture() (*BlockChain, map[string]*Wallet) {
 			BlockNumber: 2,
 			LastBlock:   HashSum(block1),
 			Target:      NewValidTestTarget(),
-			Time:        mrand.Uint32(),
+			Time:        block1.Time + 60,
 			Nonce:       0,
 		},
 		Transactions: []*Transaction{cbC, tC},
 	}
+	block2.UpdateMerkleRoot()
 
 	wallets := map[string]*Wallet{
 		"alice":  alice,
@@ -216,40 +228,37 @@ func NewValidBlockChainFixture() (*BlockChain, map[string]*Wallet) {
 	}
 
 	bc := New()
-	bc.Blocks = []*Block{&block0, &block1, &block2}
-	bc.Head = NewTestHash()
+	for _, b := range []*Block{&block0, &block1, &block2} {
+		bc.AppendBlock(b)
+	}
 	return bc, wallets
 }
 
 // NewValidTestChainAndBlock creates a valid BlockChain of 3 blocks,
 // and a new block which is valid with respect to the blockchain.
 func NewValidTestChainAndBlock() (*BlockChain, *Block) {
-	bc, wallets := NewValidBlockChainFixture()
+	return NewValidTestChainAndBlockWithFee(0)
+}
+
+// NewValidTestChainAndBlockWithFee creates a valid BlockChain of 3 blocks, and
+// a new block which is valid with respect to the blockchain and contains a
+// transaction that pays the given fee, which must be 0 or 1.
+func NewValidTestChainAndBlockWithFee(fee uint64) (*BlockChain, *Block) {
+	bc, body, wallets := NewValidTestChainAndSpend(nil)
 
 	// Alice wants to send 2 coins to bob and bob wants to send
-	// his coin back to the sender.
-	aliceToBob, _ := TxBody{
-		Sender: wallets["alice"].Public(),
-		Inputs: []TxHashPointer{
-			TxHashPointer{
-				// Block 1, transaction 1 is where this input comes from.
-				BlockNumber: 1,
-				Index:       1,
-				Hash:        HashSum(bc.Blocks[1].Transactions[1]),
-			},
+	// his coin back to the sender. Alice keeps what's left after the fee.
+	body.Outputs = []TxOutput{
+		TxOutput{
+			Amount:    2,
+			Recipient: wallets["bob"].Public().Repr(),
 		},
-		// One output to bob, one back to alice.
-		Outputs: []TxOutput{
-			TxOutput{
-				Amount:    2,
-				Recipient: wallets["bob"].Public().Repr(),
-			},
-			TxOutput{
-				Amount:    1,
-				Recipient: wallets["alice"].Public().Repr(),
-			},
+		TxOutput{
+			Amount:    1 - fee,
+			Recipient: wallets["alice"].Public().Repr(),
 		},
-	}.Sign(*wallets["alice"], crand.Reader)
+	}
+	aliceToBob, _ := body.Sign(*wallets["alice"], crand.Reader)
 
 	bobToSender, _ := TxBody{
 		Sender: wallets["bob"].Public(),
@@ -274,18 +283,19 @@ func NewValidTestChainAndBlock() (*BlockChain, *Block) {
 
 	// Update CloudBase transaction amount so it fits the blockchain
 	timesHalved := float64((len(bc.Blocks) / BlockRewardHalvingRate))
-	cb.Outputs[0].Amount = StartingBlockReward / uint64(math.Pow(float64(2), timesHalved))
+	cb.Outputs[0].Amount = StartingBlockReward/uint64(math.Pow(float64(2), timesHalved)) + fee
 
 	blk := Block{
 		BlockHeader: BlockHeader{
 			BlockNumber: 3,
 			LastBlock:   HashSum(bc.Blocks[2]),
 			Target:      NewValidTestTarget(),
-			Time:        mrand.Uint32(),
+			Time:        bc.Blocks[2].Time + 60,
 			Nonce:       0,
 		},
 		Transactions: []*Transaction{cb, aliceToBob, bobToSender},
 	}
+	blk.UpdateMerkleRoot()
 
 	return bc, &blk
 }
@@ -297,6 +307,114 @@ func NewValidChainAndTxn() (*BlockChain, *Transaction) {
 	return bc, b.Transactions[1]
 }
 
+// NewValidTestChainAndSpend creates a valid BlockChain and an unsigned
+// transaction body that sends 3 coins to bob. It also returns the wallets
+// involved.
+//
+// If fund is nil, the chain has 3 blocks and the body spends alice's 3 coins,
+// confirmed in block 1. Otherwise a fourth block sends alice's coins to fund,
+// whose Amount is ignored, and the body spends that output with no sender.
+func NewValidTestChainAndSpend(fund *TxOutput) (*BlockChain, TxBody, map[string]*Wallet) {
+	bc, wallets := NewValidBlockChainFixture()
+	alice := wallets["alice"]
+
+	body := TxBody{
+		Sender: alice.Public(),
+		Inputs: []TxHashPointer{
+			TxHashPointer{
+				// Block 1, transaction 1 is where alice's coins come from.
+				BlockNumber: 1,
+				Index:       1,
+				Hash:        HashSum(bc.Blocks[1].Transactions[1]),
+			},
+		},
+		Outputs: []TxOutput{
+			TxOutput{
+				Amount:    3,
+				Recipient: wallets["bob"].Public().Repr(),
+			},
+		},
+	}
+	if fund == nil {
+		return bc, body, wallets
+	}
+
+	out := *fund
+	out.Amount = 3
+	funding := body
+	funding.Outputs = []TxOutput{out}
+	txn, _ := funding.Sign(*alice, crand.Reader)
+
+	cb, _ := NewValidCloudBaseTestTransaction()
+	block3 := Block{
+		BlockHeader: BlockHeader{
+			BlockNumber: 3,
+			LastBlock:   HashSum(bc.LastBlock()),
+			Target:      NewValidTestTarget(),
+			Time:        bc.LastBlock().Time + 60,
+			Nonce:       0,
+		},
+		Transactions: []*Transaction{cb, txn},
+	}
+	block3.UpdateMerkleRoot()
+	bc.AppendBlock(&block3)
+
+	body.Sender = NilAddr
+	body.Inputs = []TxHashPointer{
+		TxHashPointer{
+			BlockNumber: 3,
+			Index:       1,
+			Hash:        HashSum(txn),
+		},
+	}
+	return bc, body, wallets
+}
+
+// NewValidTestChainAndChainedTxns creates a valid BlockChain of 3 blocks, a
+// transaction that is valid with respect to the BlockChain, and a second
+// transaction from the same sender that spends the change of the first before
+// it is confirmed.
+func NewValidTestChainAndChainedTxns() (*BlockChain, *Transaction, *Transaction) {
+	bc, body, wallets := NewValidTestChainAndSpend(nil)
+	alice := wallets["alice"]
+
+	// Alice sends 2 of her 3 coins to bob and keeps 1 as change.
+	body.Outputs = []TxOutput{
+		TxOutput{
+			Amount:    2,
+			Recipient: wallets["bob"].Public().Repr(),
+		},
+		TxOutput{
+			Amount:    1,
+			Recipient: alice.Public().Repr(),
+		},
+	}
+	parent, _ := body.Sign(*alice, crand.Reader)
+
+	// Alice sends her change, the parent's second output, to bob too.
+	child, _ := TxBody{
+		Sender: alice.Public(),
+		Inputs: []TxHashPointer{UnconfirmedInput(HashSum(parent), 1)},
+		Outputs: []TxOutput{
+			TxOutput{
+				Amount:    1,
+				Recipient: wallets["bob"].Public().Repr(),
+			},
+		},
+	}.Sign(*alice, crand.Reader)
+
+	return bc, parent, child
+}
+
+// NewTestMultisig creates a 2-of-3 multisig address and returns it along with
+// the wallets of its keys.
+func NewTestMultisig() (*Multisig, []*Wallet) {
+	cosigners := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	ms, _ := NewMultisig(2, cosigners[0].Public(), cosigners[1].Public(),
+		cosigners[2].Public())
+	return ms, cosigners
+}
+
 // NewValidTestTarget creates a new valid target that is a random value between the
 // max and min difficulties
 func NewValidTestTarget() Hash {
@@ -332,3 +450,18 @@ func NewValidCloudBaseTestTransaction() (*Transaction, Address) {
 	}
 	return cbTx, w.Public()
 }
+
+// NewTestChildBlock produces a block with no transactions that builds on the
+// given parent and has the given target.
+func NewTestChildBlock(parent *Block, target Hash) *Block {
+	return &Block{
+		BlockHeader: BlockHeader{
+			BlockNumber: parent.BlockNumber + 1,
+			LastBlock:   HashSum(parent),
+			Target:      target,
+			Time:        mrand.Uint32(),
+			Nonce:       0,
+		},
+		Transactions: []*Transaction{},
+	}
+}
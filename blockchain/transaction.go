@@ -2,7 +2,10 @@ package blockchain
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"io"
 	"math"
 
@@ -10,26 +13,95 @@ import (
 	"gopkg.in/fatih/set.v0"
 )
 
-// TxHashPointer is a reference to a transaction on the blockchain.
+// UnconfirmedBlock is the BlockNumber of a TxHashPointer to a transaction that
+// was not on the blockchain when the pointer was made. Such pointers identify
+// the transaction by its Hash alone.
+const UnconfirmedBlock = math.MaxUint32
+
+// ErrAmountOverflow is returned when a sum of amounts is too large to be
+// represented.
+var ErrAmountOverflow = errors.New("Sum of amounts overflows")
+
+// AddAmount returns the sum of a and b, and false if the sum overflows.
+func AddAmount(a, b uint64) (uint64, bool) {
+	sum := a + b
+	return sum, sum >= a
+}
+
+// TxHashPointer is a reference to an output of a transaction on the
+// blockchain. Hash is the hash of the referenced transaction, which is at
+// position Index in the block with number BlockNumber, and Output is the
+// position of the output in the transaction's outputs.
 type TxHashPointer struct {
 	BlockNumber uint32
 	Hash        Hash
 	Index       uint32
+	Output      uint32
+}
+
+// UnconfirmedInput returns a TxHashPointer to the output at the given position
+// in the transaction with the given hash, which may not be on the blockchain
+// yet.
+func UnconfirmedInput(hash Hash, output uint32) TxHashPointer {
+	return TxHashPointer{
+		BlockNumber: UnconfirmedBlock,
+		Hash:        hash,
+		Index:       0,
+		Output:      output,
+	}
+}
+
+// Unconfirmed returns true if the TxHashPointer identifies a transaction by
+// its hash alone.
+func (thp TxHashPointer) Unconfirmed() bool {
+	return thp.BlockNumber == UnconfirmedBlock
 }
 
-// Marshal converts a TxHashPointer to a byte slice
+// Marshal converts a TxHashPointer to a byte slice. Output is only included
+// if it isn't zero, so the pointers in transactions made before inputs
+// referenced a single output marshal the same way and their hashes don't
+// change.
 func (thp TxHashPointer) Marshal() []byte {
 	var buf []byte
 	buf = util.AppendUint32(buf, thp.BlockNumber)
 	buf = append(buf, thp.Hash.Marshal()...)
 	buf = util.AppendUint32(buf, thp.Index)
+	if thp.Output != 0 {
+		buf = util.AppendUint32(buf, thp.Output)
+	}
 	return buf
 }
 
-// TxOutput defines an output to a transaction
+// Spends returns the output of the given transaction the TxHashPointer refers
+// to, and false if the transaction has no output at that position.
+func (thp TxHashPointer) Spends(t *Transaction) (TxOutput, bool) {
+	if t == nil || thp.Output >= uint32(len(t.Outputs)) {
+		return TxOutput{}, false
+	}
+	return t.Outputs[thp.Output], true
+}
+
+// ScriptVersion is the version of the script address protocol. It differs
+// from AddressVersion and MultisigVersion so script addresses can't collide
+// with other addresses.
+const ScriptVersion = 2
+
+// ScriptRepr returns the string representation of the address of outputs
+// locked by the given script. It is built the same way as the representation
+// of an Address, from the script instead of a key.
+func ScriptRepr(script []byte) string {
+	prefix := append([]byte{ScriptVersion}, script...)
+	hash := sha256.Sum256(prefix)
+	return hex.EncodeToString(hash[96/8 : 256/8])
+}
+
+// TxOutput defines an output to a transaction. Outputs that can only be
+// spent by meeting the conditions of a locking script carry the script, and
+// their Recipient is the script's address.
 type TxOutput struct {
 	Amount    uint64
 	Recipient string
+	Script    []byte
 }
 
 // Marshal converts a TxOutput to a byte slice
@@ -37,14 +109,67 @@ func (to TxOutput) Marshal() []byte {
 	buf := make([]byte, 8)
 	binary.LittleEndian.PutUint64(buf, to.Amount)
 	buf = append(buf, []byte(to.Recipient)...)
+	if len(to.Script) > 0 {
+		buf = util.AppendUint32(buf, uint32(len(to.Script)))
+		buf = append(buf, to.Script...)
+	}
 	return buf
 }
 
+// LockTimeThreshold is the smallest LockTime that is interpreted as a unix
+// time. Smaller lock times are block numbers.
+const LockTimeThreshold = 500000000
+
 // TxBody contains all relevant information about a transaction
 type TxBody struct {
 	Sender  Address
 	Inputs  []TxHashPointer
 	Outputs []TxOutput
+	// LockTime is the block number, or the unix time if it is at least
+	// LockTimeThreshold, before which the transaction can't be mined. Zero
+	// means the transaction is not locked.
+	LockTime uint32
+	// RelativeLocks holds the number of blocks each input must have been
+	// confirmed for before the transaction can be mined, in the same order as
+	// Inputs. It is either empty or has a lock for every input. Zero means an
+	// input is not locked.
+	RelativeLocks []uint32
+	// Multisig is the multisig address the transaction spends from, or nil
+	// if it spends from Sender. Transactions that spend from a multisig
+	// address have a NilAddr Sender and are signed by its keys.
+	Multisig *Multisig
+	// Script is the locking script of the script address the transaction
+	// spends from, if any. Transactions that spend from a script address have
+	// a NilAddr Sender, and an unlocking script for each input instead of a
+	// signature.
+	Script []byte
+}
+
+// From returns the representation of the address the transaction spends
+// from: its script or multisig address if it has one, and its sender
+// otherwise.
+func (tb TxBody) From() string {
+	if len(tb.Script) > 0 {
+		return ScriptRepr(tb.Script)
+	}
+	if tb.Multisig != nil {
+		return tb.Multisig.Repr()
+	}
+	return tb.Sender.Repr()
+}
+
+// Locked returns true if the transaction has an absolute or relative lock.
+func (tb TxBody) Locked() bool {
+	return tb.LockTime != 0 || len(tb.RelativeLocks) > 0
+}
+
+// RelativeLock returns the relative lock of the input at position i, which is
+// zero if the transaction has no relative locks.
+func (tb TxBody) RelativeLock(i int) uint32 {
+	if i < len(tb.RelativeLocks) {
+		return tb.RelativeLocks[i]
+	}
+	return 0
 }
 
 // Len returns the length of a transaction body
@@ -62,6 +187,23 @@ func (tb TxBody) Marshal() []byte {
 	for _, out := range tb.Outputs {
 		buf = append(buf, out.Marshal()...)
 	}
+	// Only locked, multisig and script transactions include their locks, so
+	// the bodies of transactions made before locks existed marshal the same
+	// way.
+	if tb.Locked() || tb.Multisig != nil || len(tb.Script) > 0 {
+		buf = util.AppendUint32(buf, tb.LockTime)
+		buf = util.AppendUint32(buf, uint32(len(tb.RelativeLocks)))
+		for _, lock := range tb.RelativeLocks {
+			buf = util.AppendUint32(buf, lock)
+		}
+	}
+	if tb.Multisig != nil {
+		buf = append(buf, tb.Multisig.Marshal()...)
+	}
+	if len(tb.Script) > 0 {
+		buf = util.AppendUint32(buf, uint32(len(tb.Script)))
+		buf = append(buf, tb.Script...)
+	}
 	return buf
 }
 
@@ -69,13 +211,19 @@ func (tb TxBody) Marshal() []byte {
 func (tb TxBody) Sign(w Wallet, r io.Reader) (*Transaction, error) {
 	digest := HashSum(tb)
 	sig, err := w.Sign(digest, r)
-	return &Transaction{tb, sig}, err
+	return &Transaction{TxBody: tb, Sig: sig}, err
 }
 
-// Transaction contains a TxBody and a signature verifying it
+// Transaction contains a TxBody and a signature verifying it. Transactions
+// that spend from a multisig address have a NilSig, and the signatures of the
+// keys of the address in Sigs instead, in the same order as the keys.
+// Transactions that spend from a script address have a NilSig, and an
+// unlocking script for each input in Unlocks instead.
 type Transaction struct {
 	TxBody
-	Sig Signature
+	Sig     Signature
+	Sigs    []Signature
+	Unlocks [][]byte
 }
 
 // Len returns the length in bytes of a transaction
@@ -94,6 +242,13 @@ func (t *Transaction) Marshal() []byte {
 	var buf []byte
 	buf = append(buf, t.TxBody.Marshal()...)
 	buf = append(buf, t.Sig.Marshal()...)
+	for _, sig := range t.Sigs {
+		buf = append(buf, sig.Marshal()...)
+	}
+	for _, unlock := range t.Unlocks {
+		buf = util.AppendUint32(buf, uint32(len(unlock)))
+		buf = append(buf, unlock...)
+	}
 	return buf
 }
 
@@ -115,13 +270,17 @@ func (t *Transaction) InputsEqualOutputs(other ...*Transaction) bool {
 	return (int(outAmount) - int(inAmount)) == 0
 }
 
-// GetTotalOutput sums the output amounts from the transaction.
-func (t *Transaction) GetTotalOutput() uint64 {
+// GetTotalOutput sums the output amounts from the transaction. Returns
+// ErrAmountOverflow if the sum overflows.
+func (t *Transaction) GetTotalOutput() (uint64, error) {
 	result := uint64(0)
 	for _, out := range t.Outputs {
-		result += out.Amount
+		var ok bool
+		if result, ok = AddAmount(result, out.Amount); !ok {
+			return 0, ErrAmountOverflow
+		}
 	}
-	return result
+	return result, nil
 }
 
 // GetTotalOutputFor sums the outputs referenced to a specific recipient.
@@ -136,22 +295,32 @@ func (t *Transaction) GetTotalOutputFor(recipient string) uint64 {
 	return result
 }
 
-// GetTotalInput sums the input amounts from the transaction.
-// Requires the blockchain for lookups.
+// GetTotalInput sums the amounts of the outputs the transaction's inputs
+// spend. Requires the blockchain for lookups.
 func (t *Transaction) GetTotalInput(bc *BlockChain) (uint64, error) {
 	result := uint64(0)
-	// This is a bit crazy; filter all input transactions
-	// by this senders address and sum the outputs.
 	inputs, err := bc.GetAllInputs(t)
 	if err != nil {
 		return 0, err
 	}
-	for _, in := range inputs {
-		result += in.GetTotalOutputFor(t.Sender.Repr())
+	for i, in := range inputs {
+		output, ok := t.Inputs[i].Spends(in)
+		if ok && output.Recipient == t.From() {
+			result += output.Amount
+		}
 	}
 	return result, nil
 }
 
+// GetFee returns the amount the transaction's inputs send to its sender less
+// the transaction's total output. The fee is claimed by the CloudBase
+// transaction of the block the transaction is included in. Returns an error if
+// an input is not an unspent output to the sender on the main chain, or the
+// transaction spends more than its inputs.
+func (t *Transaction) GetFee(bc *BlockChain) (uint64, error) {
+	return bc.NewUTXOView().Fee(t)
+}
+
 // GetBlockRange returns the start and end block indexes for the inputs
 // to a transaction.
 func (bc *BlockChain) GetBlockRange(t *Transaction) (uint32, uint32) {
@@ -186,29 +355,3 @@ func (t *Transaction) InputSet() *set.Set {
 	}
 	return set.New(a...)
 }
-
-// InputsSpentElsewhere returns true if inputs purported to be only spent
-// on transaction t have been spent elsewhere after block index `start`.
-func (t *Transaction) InputsSpentElsewhere(bc *BlockChain, start uint32) bool {
-	// Get the set of inputs for t.
-	inSet := t.InputSet()
-
-	// Look at each transaction in the chain from start on.
-	for _, b := range bc.Blocks[start:] {
-		for _, txn := range b.Transactions {
-
-			// If the inputs to t intersect with the inputs to txn...
-			if !set.Intersection(inSet, txn.InputSet()).IsEmpty() {
-
-				// ... and the sender is the same, then we have a respend.
-				if txn.Sender.Repr() == t.Sender.Repr() {
-					return true
-				}
-			}
-		}
-	}
-
-	// If we made it through all the transactions, without finding
-	// inputs respent anywhere, then we're good.
-	return false
-}
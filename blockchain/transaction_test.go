@@ -1,6 +1,7 @@
 package blockchain
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -16,6 +17,35 @@ func TestTxBodyLen(t *testing.T) {
 	assert.Equal(t, txBody.Len(), txBodyLen)
 }
 
+func TestTxHashPointerMarshalOutput(t *testing.T) {
+	p := NewTestTxHashPointer()
+	first := p.Marshal()
+
+	// Only pointers to outputs other than the first include the output.
+	assert.Equal(t, 2*(32/8)+HashLen, len(first))
+	p.Output = 1
+	assert.Equal(t, len(first)+32/8, len(p.Marshal()))
+}
+
+func TestTxBodyMarshalLocks(t *testing.T) {
+	txBody := NewTestTxBody()
+	unlocked := txBody.Marshal()
+	unlockedHash := HashSum(txBody)
+
+	// The locks are part of the body, and so are signed.
+	txBody.LockTime = 100
+	assert.Equal(t, len(unlocked)+2*(32/8), txBody.Len())
+	assert.NotEqual(t, unlockedHash, HashSum(txBody))
+
+	txBody.LockTime = 0
+	txBody.RelativeLocks = []uint32{1}
+	assert.Equal(t, len(unlocked)+3*(32/8), txBody.Len())
+	assert.NotEqual(t, unlocked, txBody.Marshal())
+
+	txBody.RelativeLocks = nil
+	assert.Equal(t, unlocked, txBody.Marshal())
+}
+
 func TestTransactionLen(t *testing.T) {
 	tx := NewTestTransaction()
 	senderLen := AddrLen
@@ -42,7 +72,17 @@ func TestTransactionGetTotalOutput(t *testing.T) {
 			Amount:    5,
 		},
 	}
-	assert.Equal(t, tx.GetTotalOutput(), uint64(5))
+	total, err := tx.GetTotalOutput()
+	assert.Nil(t, err)
+	assert.Equal(t, total, uint64(5))
+
+	// Outputs whose sum overflows are rejected.
+	tx.Outputs = append(tx.Outputs, TxOutput{
+		Recipient: tx.Outputs[0].Recipient,
+		Amount:    math.MaxUint64 - 4,
+	})
+	_, err = tx.GetTotalOutput()
+	assert.Equal(t, ErrAmountOverflow, err)
 }
 
 func TestInputSet(t *testing.T) {
@@ -77,3 +117,19 @@ func TestGetTotalOutputFor(t *testing.T) {
 	actual = t2.GetTotalOutputFor(wallets["bob"].Public().Repr())
 	assert.Equal(t, actual, uint64(1))
 }
+
+func TestGetFee(t *testing.T) {
+	bc, b := NewValidTestChainAndBlockWithFee(1)
+	txn := b.Transactions[1]
+
+	fee, err := txn.GetFee(bc)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), fee)
+
+	// Spending more than the inputs or spending unknown inputs is an error.
+	txn.Outputs[0].Amount = 5
+	_, err = txn.GetFee(bc)
+	assert.NotNil(t, err)
+	_, err = NewTestTransaction().GetFee(bc)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,319 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+
+	c "github.com/ubclaunchpad/cumulus/common/constants"
+)
+
+// blockNode is a block known to the blockchain, whether or not it is part of
+// the main chain. Nodes form a tree rooted at the first block of the chain.
+type blockNode struct {
+	block  *Block
+	hash   Hash
+	parent *blockNode
+	// height is the position of the block in the branch of the tree ending at
+	// it.
+	height uint32
+	// work is the cumulative proof-of-work of the branch ending at this block.
+	work *big.Int
+}
+
+// VerifyFunc checks whether a block is valid with respect to the given
+// blockchain, to which it is about to be appended.
+type VerifyFunc func(bc *BlockChain, b *Block) bool
+
+// Reorg describes a switch of the main chain from one branch of the block tree
+// to another.
+type Reorg struct {
+	OldTip Hash
+	NewTip Hash
+	// Disconnected holds the blocks removed from the main chain, tip first.
+	Disconnected []*Block
+	// Connected holds the blocks added to the main chain, in chain order.
+	Connected []*Block
+}
+
+// Depth returns the number of blocks that were removed from the main chain.
+func (r *Reorg) Depth() int {
+	return len(r.Disconnected)
+}
+
+// Work returns the expected number of hashes required to find a block that
+// meets the given target, computed as 2^256 / (target + 1).
+func Work(target Hash) *big.Int {
+	denominator := new(big.Int).Add(HashToBigInt(target), c.Big1)
+	return new(big.Int).Div(c.Big2Exp256, denominator)
+}
+
+// addNode adds a block to the block tree as a child of the given parent, which
+// may be nil if the block is the root of the tree.
+func (bc *BlockChain) addNode(b *Block, hash Hash, parent *blockNode) *blockNode {
+	if bc.nodes == nil {
+		bc.nodes = make(map[Hash]*blockNode)
+	}
+	work := Work(b.Target)
+	height := uint32(0)
+	if parent != nil {
+		work.Add(work, parent.work)
+		height = parent.height + 1
+	}
+	node := &blockNode{
+		block:  b,
+		hash:   hash,
+		parent: parent,
+		height: height,
+		work:   work,
+	}
+	bc.nodes[hash] = node
+	if bc.children == nil {
+		bc.children = make(map[Hash][]*blockNode)
+	}
+	bc.children[b.LastBlock] = append(bc.children[b.LastBlock], node)
+	return node
+}
+
+// reindex rebuilds the block tree and transaction index from the blocks in the
+// main chain.
+func (bc *BlockChain) reindex() {
+	bc.nodes = make(map[Hash]*blockNode)
+	bc.children = make(map[Hash][]*blockNode)
+	bc.txns = make(map[Hash]TxHashPointer)
+	bc.addrs = make(map[string]int)
+	var parent *blockNode
+	for _, b := range bc.Blocks {
+		parent = bc.addNode(b, HashSum(b), parent)
+		bc.indexTransactions(b, parent.height)
+		bc.indexAddresses(b, 1)
+	}
+}
+
+// onMainChain returns true if the given node is part of the main chain.
+func (bc *BlockChain) onMainChain(node *blockNode) bool {
+	return int(node.height) < len(bc.Blocks) && bc.Blocks[node.height] == node.block
+}
+
+// GetKnownBlock returns the block with the given hash if it is anywhere in the
+// block tree, on the main chain or on a side branch. Returns nil if the block
+// is not known.
+func (bc *BlockChain) GetKnownBlock(hash Hash) *Block {
+	if node, ok := bc.nodes[hash]; ok {
+		if bc.onMainChain(node) {
+			return bc.block(int(node.height))
+		}
+		return node.block
+	}
+	return nil
+}
+
+// IsAncestor returns true if the block with the given hash is on the branch
+// of the block tree ending at descendant, including descendant itself.
+func (bc *BlockChain) IsAncestor(hash, descendant Hash) bool {
+	node, ok := bc.nodes[hash]
+	if !ok {
+		return false
+	}
+	d, ok := bc.nodes[descendant]
+	if !ok {
+		return false
+	}
+	for d != nil && d.height > node.height {
+		d = d.parent
+	}
+	return d == node
+}
+
+// TotalWork returns the cumulative proof-of-work of the main chain.
+func (bc *BlockChain) TotalWork() *big.Int {
+	if node, ok := bc.nodes[bc.Head]; ok {
+		return new(big.Int).Set(node.work)
+	}
+	return new(big.Int)
+}
+
+// AddSideBlock adds a block that builds on a known block other than the tip of
+// the main chain to the block tree without connecting it to the main chain.
+// Returns true if the branch ending at the block has more cumulative work than
+// the main chain, or an error if the block's parent is not known, its block
+// number does not follow its parent's, or it forks the main chain before a
+// block that has been pruned.
+func (bc *BlockChain) AddSideBlock(b *Block) (bool, error) {
+	hash := HashSum(b)
+	if _, ok := bc.nodes[hash]; ok {
+		return false, errors.New("Block already known")
+	}
+	parent, ok := bc.nodes[b.LastBlock]
+	if !ok {
+		return false, errors.New("Parent block not known")
+	}
+	if b.BlockNumber != parent.block.BlockNumber+1 {
+		return false, errors.New("Block number does not follow parent")
+	}
+	if bc.onMainChain(parent) && parent.height+1 < bc.pruned {
+		return false, errors.New("Block forks the chain before a pruned block")
+	}
+
+	node := bc.addNode(b, hash, parent)
+	return node.work.Cmp(bc.TotalWork()) > 0, nil
+}
+
+// Fork describes the reorg that would make the branch of the block tree
+// ending at the block with the given hash the main chain, without modifying
+// the blockchain. Returns an error if the block is not known, is already on
+// the main chain, or does not share a block with the main chain.
+func (bc *BlockChain) Fork(tip Hash) (*Reorg, error) {
+	fork, branch, err := bc.branch(tip)
+	if err != nil {
+		return nil, err
+	}
+
+	reorg := &Reorg{
+		OldTip:       bc.Head,
+		NewTip:       tip,
+		Disconnected: make([]*Block, 0),
+		Connected:    make([]*Block, 0),
+	}
+	for i := len(bc.Blocks) - 1; i > int(fork.height); i-- {
+		reorg.Disconnected = append(reorg.Disconnected, bc.block(i))
+	}
+	for _, node := range branch {
+		reorg.Connected = append(reorg.Connected, node.block)
+	}
+	return reorg, nil
+}
+
+// branch returns the last block the branch ending at the given tip shares
+// with the main chain, and the nodes on the branch after that block in chain
+// order.
+func (bc *BlockChain) branch(tip Hash) (*blockNode, []*blockNode, error) {
+	tipNode, ok := bc.nodes[tip]
+	if !ok {
+		return nil, nil, errors.New("New tip not known")
+	}
+
+	// Walk back from the new tip to the fork point, collecting the blocks to
+	// connect.
+	branch := make([]*blockNode, 0)
+	fork := tipNode
+	for fork != nil && !bc.onMainChain(fork) {
+		branch = append([]*blockNode{fork}, branch...)
+		fork = fork.parent
+	}
+	if fork == nil {
+		return nil, nil, errors.New("New tip does not share a block with the main chain")
+	} else if len(branch) == 0 {
+		return nil, nil, errors.New("New tip is already on the main chain")
+	} else if fork.height+1 < bc.pruned {
+		return nil, nil, errors.New("Cannot disconnect pruned blocks")
+	}
+	return fork, branch, nil
+}
+
+// Reorganize makes the branch of the block tree ending at the block with the
+// given hash the main chain. Blocks on the current main chain after the fork
+// point are disconnected (but remain in the block tree), then the blocks on
+// the new branch are connected one at a time, each verified with verify
+// against the chain it is being appended to. If a block fails verification it
+// and its descendants are discarded, the original main chain is restored, and
+// an error is returned.
+func (bc *BlockChain) Reorganize(tip Hash, verify VerifyFunc) (*Reorg, error) {
+	fork, branch, err := bc.branch(tip)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hold off on pruning until we're done, in case we have to restore the
+	// blocks we disconnect.
+	depth := bc.pruneDepth
+	bc.pruneDepth = 0
+	defer func() {
+		bc.pruneDepth = depth
+		bc.pruneBlocks()
+	}()
+
+	reorg := &Reorg{
+		OldTip:       bc.Head,
+		NewTip:       tip,
+		Disconnected: make([]*Block, 0),
+		Connected:    make([]*Block, 0),
+	}
+	for bc.Head != fork.hash {
+		reorg.Disconnected = append(reorg.Disconnected, bc.disconnectTip())
+	}
+
+	for _, node := range branch {
+		if verify(bc, node.block) {
+			if err := bc.AppendBlock(node.block); err != nil {
+				bc.restore(reorg)
+				return nil, err
+			}
+			reorg.Connected = append(reorg.Connected, node.block)
+			continue
+		}
+
+		// The new branch is invalid from here on; forget about it.
+		bc.prune(node)
+		bc.restore(reorg)
+		return nil, errors.New("Block on new branch failed verification")
+	}
+	return reorg, nil
+}
+
+// disconnectTip removes the last block from the main chain, leaving it in the
+// block tree, and returns it.
+func (bc *BlockChain) disconnectTip() *Block {
+	tip := bc.LastBlock()
+	if bc.utxos != nil {
+		bc.utxos.revert(tip, bc.Head, bc)
+	}
+	bc.unindexTransactions(tip, uint32(len(bc.Blocks)-1))
+	bc.indexAddresses(tip, -1)
+	bc.Blocks = bc.Blocks[:len(bc.Blocks)-1]
+	if len(bc.Blocks) == 0 {
+		bc.Head = NilHash
+	} else if node, ok := bc.nodes[bc.Head]; ok && node.parent != nil {
+		bc.Head = node.parent.hash
+	} else {
+		bc.Head = HashSum(bc.LastBlock())
+	}
+	bc.truncateStore()
+	bc.loadTip()
+	return tip
+}
+
+// restore undoes a partially applied reorg, returning the main chain to the
+// state it was in before the reorg started.
+func (bc *BlockChain) restore(r *Reorg) {
+	for range r.Connected {
+		bc.disconnectTip()
+	}
+	for i := len(r.Disconnected) - 1; i >= 0; i-- {
+		bc.AppendBlock(r.Disconnected[i])
+	}
+}
+
+// prune removes the given node and all of its descendants from the block
+// tree.
+func (bc *BlockChain) prune(node *blockNode) {
+	siblings := bc.children[node.block.LastBlock]
+	for i, sibling := range siblings {
+		if sibling == node {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(bc.children, node.block.LastBlock)
+	} else {
+		bc.children[node.block.LastBlock] = siblings
+	}
+
+	doomed := []*blockNode{node}
+	for len(doomed) > 0 {
+		n := doomed[len(doomed)-1]
+		doomed = append(doomed[:len(doomed)-1], bc.children[n.hash]...)
+		delete(bc.children, n.hash)
+		delete(bc.nodes, n.hash)
+	}
+}
@@ -0,0 +1,139 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	c "github.com/ubclaunchpad/cumulus/common/constants"
+)
+
+// newTestTree creates a blockchain of three easy blocks along with a harder
+// target that can be used to build heavier side branches.
+func newTestTree() (*BlockChain, Hash, Hash) {
+	max := new(big.Int).Sub(c.Big2Exp256, c.Big1)
+	easy := BigIntToHash(max)
+	hard := BigIntToHash(new(big.Int).Div(max, big.NewInt(4)))
+
+	bc := New()
+	b := NewTestChildBlock(&Block{}, easy)
+	b.BlockNumber = 0
+	bc.AppendBlock(b)
+	for i := 0; i < 2; i++ {
+		b = NewTestChildBlock(b, easy)
+		bc.AppendBlock(b)
+	}
+	return bc, easy, hard
+}
+
+func TestWork(t *testing.T) {
+	_, easy, hard := newTestTree()
+	assert.Equal(t, big.NewInt(1), Work(easy))
+	assert.Equal(t, big.NewInt(4), Work(hard))
+	assert.Equal(t, c.Big2Exp256, Work(NilHash))
+}
+
+func TestTotalWork(t *testing.T) {
+	bc, _, _ := newTestTree()
+	assert.Equal(t, big.NewInt(3), bc.TotalWork())
+}
+
+func TestAddSideBlock(t *testing.T) {
+	bc, easy, hard := newTestTree()
+
+	// A lighter branch is kept but does not become the main chain.
+	light := NewTestChildBlock(bc.Blocks[1], easy)
+	heavier, err := bc.AddSideBlock(light)
+	assert.Nil(t, err)
+	assert.False(t, heavier)
+	assert.Equal(t, light, bc.GetKnownBlock(HashSum(light)))
+	assert.Equal(t, 3, len(bc.Blocks))
+
+	// A heavier branch is reported as such.
+	heavy := NewTestChildBlock(bc.Blocks[1], hard)
+	heavier, err = bc.AddSideBlock(heavy)
+	assert.Nil(t, err)
+	assert.True(t, heavier)
+	assert.Equal(t, HashSum(bc.Blocks[2]), bc.Head)
+
+	// Known blocks, unknown parents and bad block numbers are rejected.
+	_, err = bc.AddSideBlock(heavy)
+	assert.NotNil(t, err)
+	_, err = bc.AddSideBlock(NewTestChildBlock(NewTestBlock(), easy))
+	assert.NotNil(t, err)
+	bad := NewTestChildBlock(bc.Blocks[0], easy)
+	bad.BlockNumber = 5
+	_, err = bc.AddSideBlock(bad)
+	assert.NotNil(t, err)
+}
+
+func TestIsAncestor(t *testing.T) {
+	bc, easy, _ := newTestTree()
+	side := NewTestChildBlock(bc.Blocks[1], easy)
+	bc.AddSideBlock(side)
+	tip := HashSum(bc.Blocks[2])
+
+	assert.True(t, bc.IsAncestor(HashSum(bc.Blocks[0]), tip))
+	assert.True(t, bc.IsAncestor(tip, tip))
+	assert.True(t, bc.IsAncestor(HashSum(bc.Blocks[1]), HashSum(side)))
+	assert.False(t, bc.IsAncestor(tip, HashSum(bc.Blocks[1])))
+	assert.False(t, bc.IsAncestor(HashSum(side), tip))
+	assert.False(t, bc.IsAncestor(NewTestHash(), tip))
+	assert.False(t, bc.IsAncestor(tip, NewTestHash()))
+}
+
+func TestReorganize(t *testing.T) {
+	bc, _, hard := newTestTree()
+	oldTip := bc.Blocks[2]
+
+	heavy := NewTestChildBlock(bc.Blocks[1], hard)
+	bc.AddSideBlock(heavy)
+	next := NewTestChildBlock(heavy, hard)
+	bc.AddSideBlock(next)
+
+	// Fork describes the reorg without performing it.
+	plan, err := bc.Fork(HashSum(next))
+	assert.Nil(t, err)
+	assert.Equal(t, []*Block{oldTip}, plan.Disconnected)
+	assert.Equal(t, []*Block{heavy, next}, plan.Connected)
+	assert.Equal(t, HashSum(oldTip), bc.Head)
+
+	verify := func(bc *BlockChain, b *Block) bool { return true }
+	reorg, err := bc.Reorganize(HashSum(next), verify)
+	assert.Nil(t, err)
+	assert.Equal(t, plan, reorg)
+	assert.Equal(t, 1, reorg.Depth())
+	assert.Equal(t, []*Block{oldTip}, reorg.Disconnected)
+	assert.Equal(t, []*Block{heavy, next}, reorg.Connected)
+	assert.Equal(t, HashSum(oldTip), reorg.OldTip)
+	assert.Equal(t, HashSum(next), bc.Head)
+	assert.Equal(t, 4, len(bc.Blocks))
+
+	// The old tip is still known, and the new tip can't be reorganized to.
+	assert.Equal(t, oldTip, bc.GetKnownBlock(HashSum(oldTip)))
+	_, err = bc.Reorganize(HashSum(next), verify)
+	assert.NotNil(t, err)
+}
+
+func TestReorganizeInvalidBranch(t *testing.T) {
+	bc, _, hard := newTestTree()
+	oldHead := bc.Head
+	oldBlocks := append([]*Block{}, bc.Blocks...)
+
+	heavy := NewTestChildBlock(bc.Blocks[1], hard)
+	bc.AddSideBlock(heavy)
+	next := NewTestChildBlock(heavy, hard)
+	bc.AddSideBlock(next)
+
+	// Reject the second block on the new branch.
+	verify := func(bc *BlockChain, b *Block) bool { return b != next }
+	reorg, err := bc.Reorganize(HashSum(next), verify)
+	assert.NotNil(t, err)
+	assert.Nil(t, reorg)
+	assert.Equal(t, oldHead, bc.Head)
+	assert.Equal(t, oldBlocks, bc.Blocks)
+
+	// The invalid block is forgotten but its valid parent is kept.
+	assert.Nil(t, bc.GetKnownBlock(HashSum(next)))
+	assert.Equal(t, heavy, bc.GetKnownBlock(HashSum(heavy)))
+}
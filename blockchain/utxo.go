@@ -0,0 +1,381 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// utxoFileSuffix is appended to the file name of a block store to get the name
+// of the file the blockchain's UTXO set is saved to when it is closed.
+const utxoFileSuffix = ".utxo"
+
+// UndoDepth is the number of blocks at the end of the main chain whose spent
+// outputs the UTXO set remembers, unless the chain is pruned deeper than that.
+// Older blocks can still be reverted by looking up their inputs.
+const UndoDepth = 100
+
+// errStaleUTXOSet is returned when a saved UTXO set does not match the
+// blockchain it is being loaded for.
+var errStaleUTXOSet = errors.New("UTXO set does not match blockchain")
+
+// UTXOSet is an index of the transaction outputs on the main chain that have
+// not yet been spent. Outputs are keyed by recipient and then by a
+// TxHashPointer to the output, and the value is the amount of the output.
+type UTXOSet struct {
+	outputs map[string]map[TxHashPointer]uint64
+	// pointers maps the hash of each transaction with unspent outputs to the
+	// pointer to its place on the chain, so unconfirmed pointers can be
+	// resolved.
+	pointers map[Hash]utxoRef
+	// spent holds the outputs spent by the last depth blocks applied to the
+	// set, keyed by block hash, so they can be reverted without looking up
+	// their inputs. undo holds the same hashes in the order the blocks were
+	// applied.
+	spent map[Hash][]utxoEntry
+	undo  []Hash
+	depth int
+}
+
+// utxoRef is the pointer to a transaction with unspent outputs, along with the
+// number of its outputs that are unspent.
+type utxoRef struct {
+	pointer TxHashPointer
+	count   int
+}
+
+// utxoEntry is a single unspent output in a persisted UTXOSet.
+type utxoEntry struct {
+	Pointer   TxHashPointer
+	Recipient string
+	Amount    uint64
+}
+
+// utxoUndo is the list of outputs spent by a block in a persisted UTXOSet.
+type utxoUndo struct {
+	Block   Hash
+	Outputs []utxoEntry
+}
+
+// utxoSnapshot is the on-disk representation of a UTXOSet, along with the
+// hash of the last block that was applied to it.
+type utxoSnapshot struct {
+	Head    Hash
+	Outputs []utxoEntry
+	Spent   []utxoUndo
+}
+
+// NewUTXOSet returns an empty UTXOSet.
+func NewUTXOSet() *UTXOSet {
+	return &UTXOSet{
+		outputs:  make(map[string]map[TxHashPointer]uint64),
+		pointers: make(map[Hash]utxoRef),
+		spent:    make(map[Hash][]utxoEntry),
+		undo:     make([]Hash, 0),
+		depth:    UndoDepth,
+	}
+}
+
+// Len returns the number of unspent outputs in the set.
+func (u *UTXOSet) Len() int {
+	n := 0
+	for _, outputs := range u.outputs {
+		n += len(outputs)
+	}
+	return n
+}
+
+// Get returns the amount of the output referenced by p, and true if that
+// output is to recipient and has not been spent.
+func (u *UTXOSet) Get(p TxHashPointer, recipient string) (uint64, bool) {
+	amount, ok := u.outputs[recipient][u.resolve(p)]
+	return amount, ok
+}
+
+// resolve returns the pointer the output referenced by p is keyed by.
+// Unconfirmed pointers are resolved by hash; other pointers are returned as
+// they are.
+func (u *UTXOSet) resolve(p TxHashPointer) TxHashPointer {
+	if p.Unconfirmed() {
+		if ref, ok := u.pointers[p.Hash]; ok {
+			resolved := ref.pointer
+			resolved.Output = p.Output
+			return resolved
+		}
+	}
+	return p
+}
+
+// GetAll returns all the unspent outputs to the given recipient.
+func (u *UTXOSet) GetAll(recipient string) map[TxHashPointer]uint64 {
+	result := make(map[TxHashPointer]uint64, len(u.outputs[recipient]))
+	for p, amount := range u.outputs[recipient] {
+		result[p] = amount
+	}
+	return result
+}
+
+// add records an unspent output.
+func (u *UTXOSet) add(p TxHashPointer, recipient string, amount uint64) {
+	outputs, ok := u.outputs[recipient]
+	if !ok {
+		outputs = make(map[TxHashPointer]uint64)
+		u.outputs[recipient] = outputs
+	}
+	if _, ok := outputs[p]; !ok {
+		ref := u.pointers[p.Hash]
+		ref.pointer = p
+		ref.pointer.Output = 0
+		ref.count++
+		u.pointers[p.Hash] = ref
+	}
+	outputs[p] += amount
+}
+
+// remove deletes an output from the set.
+func (u *UTXOSet) remove(p TxHashPointer, recipient string) {
+	if outputs, ok := u.outputs[recipient]; ok {
+		if _, ok := outputs[p]; ok {
+			if ref := u.pointers[p.Hash]; ref.count > 1 {
+				ref.count--
+				u.pointers[p.Hash] = ref
+			} else {
+				delete(u.pointers, p.Hash)
+			}
+		}
+		delete(outputs, p)
+		if len(outputs) == 0 {
+			delete(u.outputs, recipient)
+		}
+	}
+}
+
+// apply updates the set to reflect the given block, which has the given hash,
+// being added to the end of the main chain. The outputs spent by the block's
+// transactions are removed and remembered, and the outputs they create are
+// added.
+func (u *UTXOSet) apply(b *Block, hash Hash) {
+	spent := make([]utxoEntry, 0)
+	for i, t := range b.Transactions {
+		for _, in := range t.Inputs {
+			in = u.resolve(in)
+			if amount, ok := u.Get(in, t.From()); ok {
+				spent = append(spent, utxoEntry{in, t.From(), amount})
+				u.remove(in, t.From())
+			}
+		}
+		p := TxHashPointer{
+			BlockNumber: b.BlockNumber,
+			Hash:        HashSum(t),
+			Index:       uint32(i),
+		}
+		for j, out := range t.Outputs {
+			p.Output = uint32(j)
+			u.add(p, out.Recipient, out.Amount)
+		}
+	}
+	u.spent[hash] = spent
+	u.undo = append(u.undo, hash)
+	u.trim()
+}
+
+// revert undoes apply for the given block, which has the given hash and must
+// have been the last block on the main chain. If the outputs the block spent
+// were not remembered by apply, the blocks containing the block's inputs must
+// still be on bc.
+func (u *UTXOSet) revert(b *Block, hash Hash, bc *BlockChain) {
+	spent, remembered := u.spent[hash]
+	u.forget(hash)
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		t := b.Transactions[i]
+		p := TxHashPointer{
+			BlockNumber: b.BlockNumber,
+			Hash:        HashSum(t),
+			Index:       uint32(i),
+		}
+		for j, out := range t.Outputs {
+			p.Output = uint32(j)
+			u.remove(p, out.Recipient)
+		}
+		if remembered {
+			continue
+		}
+		for _, in := range t.Inputs {
+			input := bc.GetInputTransaction(&in)
+			if input == nil || HashSum(input) != in.Hash {
+				continue
+			}
+			output, ok := in.Spends(input)
+			if !ok || output.Recipient != t.From() {
+				continue
+			}
+			if in.Unconfirmed() {
+				_, ptr, _ := bc.GetTransactionByHash(in.Hash)
+				ptr.Output = in.Output
+				in = ptr
+			}
+			u.add(in, t.From(), output.Amount)
+		}
+	}
+	for _, entry := range spent {
+		u.add(entry.Pointer, entry.Recipient, entry.Amount)
+	}
+}
+
+// forget discards the outputs remembered as spent by the block with the given
+// hash. The block can no longer be reverted without its inputs.
+func (u *UTXOSet) forget(hash Hash) {
+	if _, ok := u.spent[hash]; !ok {
+		return
+	}
+	delete(u.spent, hash)
+	for i := len(u.undo) - 1; i >= 0; i-- {
+		if u.undo[i] == hash {
+			u.undo = append(u.undo[:i], u.undo[i+1:]...)
+			break
+		}
+	}
+}
+
+// setDepth sets the number of blocks whose spent outputs are remembered,
+// forgetting those of older blocks.
+func (u *UTXOSet) setDepth(depth int) {
+	u.depth = depth
+	u.trim()
+}
+
+// trim forgets the spent outputs of all but the last depth blocks applied.
+func (u *UTXOSet) trim() {
+	n := len(u.undo) - u.depth
+	if n <= 0 {
+		return
+	}
+	for _, hash := range u.undo[:n] {
+		delete(u.spent, hash)
+	}
+	u.undo = append(u.undo[:0], u.undo[n:]...)
+}
+
+// save writes the set to the file with the given name, along with the hash of
+// the last block applied to it, replacing the file atomically.
+func (u *UTXOSet) save(fileName string, head Hash) error {
+	snapshot := utxoSnapshot{
+		Head:    head,
+		Outputs: make([]utxoEntry, 0, u.Len()),
+		Spent:   make([]utxoUndo, 0, len(u.spent)),
+	}
+	for recipient, outputs := range u.outputs {
+		for p, amount := range outputs {
+			snapshot.Outputs = append(snapshot.Outputs, utxoEntry{p, recipient, amount})
+		}
+	}
+	for _, hash := range u.undo {
+		snapshot.Spent = append(snapshot.Spent, utxoUndo{hash, u.spent[hash]})
+	}
+	return saveJSON(fileName, snapshot)
+}
+
+// loadUTXOSet reads a set written by save from the file with the given name,
+// and returns it along with the hash of the last block applied to it. Returns
+// an error if the file could not be read.
+func loadUTXOSet(fileName string) (*UTXOSet, Hash, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, NilHash, err
+	}
+	defer file.Close()
+
+	var snapshot utxoSnapshot
+	if err := json.NewDecoder(file).Decode(&snapshot); err != nil {
+		return nil, NilHash, err
+	}
+
+	u := NewUTXOSet()
+	for _, entry := range snapshot.Outputs {
+		u.add(entry.Pointer, entry.Recipient, entry.Amount)
+	}
+	for _, undo := range snapshot.Spent {
+		u.spent[undo.Block] = undo.Outputs
+		u.undo = append(u.undo, undo.Block)
+	}
+	return u, snapshot.Head, nil
+}
+
+// loadUTXOs sets the blockchain's UTXO set to the one saved to the file with
+// the given name, applying the blocks on the main chain after the one it was
+// saved at. Returns an error if the set could not be read, or if it was saved
+// at a block that is not on the main chain or the blocks after it have been
+// pruned.
+func (bc *BlockChain) loadUTXOs(fileName string) error {
+	u, head, err := loadUTXOSet(fileName)
+	if err != nil {
+		return err
+	}
+	start := 0
+	if head != NilHash {
+		node, ok := bc.nodes[head]
+		if !ok || !bc.onMainChain(node) {
+			return errStaleUTXOSet
+		}
+		start = int(node.height) + 1
+	}
+	for i := start; i < len(bc.Blocks); i++ {
+		b := bc.block(i)
+		if b.isPruned() {
+			return errStaleUTXOSet
+		}
+		u.apply(b, HashSum(b))
+	}
+	bc.utxos = u
+	return nil
+}
+
+// UnspentOutput returns the amount the transaction referenced by p sends to
+// recipient, and true if that output exists on the main chain and has not been
+// spent.
+func (bc *BlockChain) UnspentOutput(p TxHashPointer, recipient string) (uint64, bool) {
+	return bc.unspent().Get(p, recipient)
+}
+
+// UnspentOutputsFor returns all the unspent outputs on the main chain to the
+// given recipient.
+func (bc *BlockChain) UnspentOutputsFor(recipient string) map[TxHashPointer]uint64 {
+	return bc.unspent().GetAll(recipient)
+}
+
+// unspent returns the blockchain's UTXO set, building it from the main chain
+// if the blockchain was not created with New, Load or Open.
+func (bc *BlockChain) unspent() *UTXOSet {
+	if bc.utxos == nil {
+		bc.rebuildUTXOs()
+	}
+	return bc.utxos
+}
+
+// rebuildUTXOs rebuilds the blockchain's UTXO set from the blocks in the main
+// chain. This can't be done once the chain has been pruned.
+func (bc *BlockChain) rebuildUTXOs() {
+	bc.utxos = NewUTXOSet()
+	bc.utxos.setDepth(undoDepth(bc.pruneDepth))
+	for i := range bc.Blocks {
+		b := bc.block(i)
+		bc.utxos.apply(b, HashSum(b))
+	}
+}
+
+// undoDepth returns the number of blocks whose spent outputs the UTXO set of a
+// chain pruned to the given depth must remember. Blocks whose inputs may have
+// been pruned can't be reverted any other way.
+func undoDepth(pruneDepth uint32) int {
+	if int(pruneDepth) > UndoDepth {
+		return int(pruneDepth)
+	}
+	return UndoDepth
+}
+
+// spentBy returns the outputs spent by the main chain block with the given
+// hash, and false if they aren't known.
+func (bc *BlockChain) spentBy(hash Hash) ([]utxoEntry, bool) {
+	spent, ok := bc.unspent().spent[hash]
+	return spent, ok
+}
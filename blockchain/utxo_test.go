@@ -0,0 +1,140 @@
+package blockchain
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnspentOutput(t *testing.T) {
+	bc, wallets := NewValidBlockChainFixture()
+	sender := wallets["sender"].Public().Repr()
+	alice := wallets["alice"].Public().Repr()
+	bob := wallets["bob"].Public().Repr()
+
+	// The sender's first transaction was spent in block 1.
+	tA := TxHashPointer{0, HashSum(bc.Blocks[0].Transactions[1]), 1, 0}
+	_, ok := bc.UnspentOutput(tA, sender)
+	assert.False(t, ok)
+
+	// Alice hasn't spent the output she received in block 1, but the sender
+	// spent their change in block 2.
+	tB := TxHashPointer{1, HashSum(bc.Blocks[1].Transactions[1]), 1, 0}
+	amount, ok := bc.UnspentOutput(tB, alice)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), amount)
+	_, ok = bc.UnspentOutput(tB, sender)
+	assert.False(t, ok)
+	change := TxHashPointer{1, HashSum(bc.Blocks[1].Transactions[1]), 1, 1}
+	_, ok = bc.UnspentOutput(change, sender)
+	assert.False(t, ok)
+
+	tC := TxHashPointer{2, HashSum(bc.Blocks[2].Transactions[1]), 1, 0}
+	assert.Equal(t, map[TxHashPointer]uint64{tC: 1}, bc.UnspentOutputsFor(bob))
+
+	// Rolling back block 2 unspends the sender's change from block 1 and
+	// removes bob's output.
+	bc.RollBack()
+	amount, ok = bc.UnspentOutput(change, sender)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), amount)
+	assert.Empty(t, bc.UnspentOutputsFor(bob))
+
+	// Each output of a transaction that pays the same recipient twice is
+	// unspent on its own.
+	bc.RollBack()
+	second := tA
+	second.Output = 1
+	assert.Equal(t, map[TxHashPointer]uint64{tA: 2, second: 2},
+		bc.UnspentOutputsFor(sender))
+}
+
+func TestUnspentOutputsMatchRebuild(t *testing.T) {
+	bc, b := NewValidTestChainAndBlock()
+	bc.AppendBlock(b)
+	bc.RollBack()
+	bc.AppendBlock(b)
+
+	expected := bc.utxos
+	bc.rebuildUTXOs()
+	assert.Equal(t, expected, bc.utxos)
+}
+
+func TestUTXOSetUndoDepth(t *testing.T) {
+	bc, b := NewValidTestChainAndBlock()
+	bc.AppendBlock(b)
+	expected := bc.UnspentOutputsFor(b.Transactions[1].From())
+	assert.Equal(t, len(bc.Blocks), len(bc.utxos.spent))
+
+	// Only the spent outputs of the last block are remembered, but older
+	// blocks can still be reverted from their inputs.
+	bc.utxos.setDepth(1)
+	assert.Equal(t, 1, len(bc.utxos.spent))
+	assert.Equal(t, []Hash{bc.Head}, bc.utxos.undo)
+	tip := bc.RollBack()
+	parent := bc.RollBack()
+	assert.Empty(t, bc.utxos.spent)
+	rolledBack := bc.utxos.outputs
+	bc.rebuildUTXOs()
+	assert.Equal(t, bc.utxos.outputs, rolledBack)
+
+	bc.utxos.setDepth(1)
+	bc.AppendBlock(parent)
+	bc.AppendBlock(tip)
+	assert.Equal(t, 1, len(bc.utxos.spent))
+	assert.Equal(t, expected, bc.UnspentOutputsFor(b.Transactions[1].From()))
+}
+
+func TestUTXOSetSaveAndLoad(t *testing.T) {
+	bc1, b := NewValidTestChainAndBlock()
+	bc2, err := Open("utxoTestFile.dat")
+	assert.Nil(t, err)
+	defer os.Remove("utxoTestFile.dat")
+	defer os.Remove("utxoTestFile.dat" + utxoFileSuffix)
+	defer os.Remove("utxoTestFile.dat" + indexFileSuffix)
+
+	for _, blk := range append(bc1.Blocks, b) {
+		assert.Nil(t, bc2.AppendBlock(blk))
+	}
+	assert.Nil(t, bc2.Close())
+
+	// The saved set is loaded when the chain is reopened.
+	u, head, err := loadUTXOSet("utxoTestFile.dat" + utxoFileSuffix)
+	assert.Nil(t, err)
+	assert.Equal(t, bc2.Head, head)
+	assert.Equal(t, bc2.utxos, u)
+	bc3, err := Open("utxoTestFile.dat")
+	assert.Nil(t, err)
+	assert.Equal(t, bc2.utxos, bc3.utxos)
+	assert.Nil(t, bc3.Close())
+
+	// A set saved for another chain is ignored.
+	other := New()
+	other.AppendBlock(bc1.Blocks[0])
+	assert.Equal(t, errStaleUTXOSet, other.loadUTXOs("utxoTestFile.dat"+utxoFileSuffix))
+}
+
+func TestUTXOSetCatchesUp(t *testing.T) {
+	bc1, b := NewValidTestChainAndBlock()
+	bc2, err := Open("utxoTestFile.dat")
+	assert.Nil(t, err)
+	defer os.Remove("utxoTestFile.dat")
+	defer os.Remove("utxoTestFile.dat" + utxoFileSuffix)
+	defer os.Remove("utxoTestFile.dat" + indexFileSuffix)
+
+	for _, blk := range bc1.Blocks {
+		assert.Nil(t, bc2.AppendBlock(blk))
+	}
+	assert.Nil(t, bc2.saveState())
+
+	// Blocks appended after the set was saved are applied to it when the
+	// chain is reopened after a crash.
+	assert.Nil(t, bc2.AppendBlock(b))
+	bc2.store.Close()
+	bc3, err := Open("utxoTestFile.dat")
+	assert.Nil(t, err)
+	assert.Equal(t, bc2.Head, bc3.Head)
+	assert.Equal(t, bc2.utxos, bc3.utxos)
+	assert.Nil(t, bc3.Close())
+}
@@ -0,0 +1,106 @@
+package blockchain
+
+import "errors"
+
+// UTXOView is the set of unspent outputs on the main chain with the effects of
+// transactions that are not on the chain yet applied on top of it. It is used
+// to validate transactions that spend the outputs of other unconfirmed
+// transactions. The outputs of transactions applied to the view are keyed by
+// UnconfirmedInput pointers. The main chain must not change while the view is
+// in use.
+type UTXOView struct {
+	bc      *BlockChain
+	outputs map[string]map[TxHashPointer]uint64
+	spent   map[string]map[TxHashPointer]bool
+	txns    map[Hash]*Transaction
+}
+
+// NewUTXOView returns a view of the unspent outputs on the main chain with no
+// transactions applied to it.
+func (bc *BlockChain) NewUTXOView() *UTXOView {
+	return &UTXOView{
+		bc:      bc,
+		outputs: make(map[string]map[TxHashPointer]uint64),
+		spent:   make(map[string]map[TxHashPointer]bool),
+		txns:    make(map[Hash]*Transaction),
+	}
+}
+
+// Resolve returns the pointer the output referenced by p is keyed by in the
+// view. Unconfirmed pointers to transactions on the main chain are resolved to
+// pointers to their place on the chain.
+func (v *UTXOView) Resolve(p TxHashPointer) TxHashPointer {
+	if _, ok := v.txns[p.Hash]; ok && p.Unconfirmed() {
+		return p
+	}
+	return v.bc.unspent().resolve(p)
+}
+
+// UnspentOutput returns the amount of the output referenced by p, and true if
+// that output is to recipient, exists in the view and has not been spent.
+func (v *UTXOView) UnspentOutput(p TxHashPointer, recipient string) (uint64, bool) {
+	p = v.Resolve(p)
+	if v.spent[recipient][p] {
+		return 0, false
+	}
+	if amount, ok := v.outputs[recipient][p]; ok {
+		return amount, true
+	}
+	return v.bc.unspent().Get(p, recipient)
+}
+
+// GetInputTransaction returns the transaction referenced by p, which is either
+// applied to the view or on the main chain, or nil if there is no such
+// transaction.
+func (v *UTXOView) GetInputTransaction(p *TxHashPointer) *Transaction {
+	if t, ok := v.txns[p.Hash]; ok && p.Unconfirmed() {
+		return t
+	}
+	return v.bc.GetInputTransaction(p)
+}
+
+// Fee returns the total amount of the outputs the transaction's inputs spend
+// less the transaction's total output. Returns an error if an input is not an unspent
+// output to the sender in the view, or the transaction spends more than its
+// inputs or its inputs or outputs overflow.
+func (v *UTXOView) Fee(t *Transaction) (uint64, error) {
+	in := uint64(0)
+	for _, input := range t.Inputs {
+		amount, ok := v.UnspentOutput(input, t.From())
+		if !ok {
+			return 0, errors.New("Input is not an unspent output")
+		}
+		if in, ok = AddAmount(in, amount); !ok {
+			return 0, ErrAmountOverflow
+		}
+	}
+	out, err := t.GetTotalOutput()
+	if err != nil {
+		return 0, err
+	}
+	if out > in {
+		return 0, errors.New("Transaction spends more than its inputs")
+	}
+	return in - out, nil
+}
+
+// Apply updates the view to reflect t being confirmed: the outputs it spends
+// are marked spent and the outputs it creates are added. t is not validated.
+func (v *UTXOView) Apply(t *Transaction) {
+	sender := t.From()
+	for _, input := range t.Inputs {
+		if v.spent[sender] == nil {
+			v.spent[sender] = make(map[TxHashPointer]bool)
+		}
+		v.spent[sender][v.Resolve(input)] = true
+	}
+
+	hash := HashSum(t)
+	v.txns[hash] = t
+	for i, out := range t.Outputs {
+		if v.outputs[out.Recipient] == nil {
+			v.outputs[out.Recipient] = make(map[TxHashPointer]uint64)
+		}
+		v.outputs[out.Recipient][UnconfirmedInput(hash, uint32(i))] = out.Amount
+	}
+}
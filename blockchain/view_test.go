@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUTXOViewChainedTransactions(t *testing.T) {
+	bc, parent, child := NewValidTestChainAndChainedTxns()
+	sender := child.Sender.Repr()
+	input := UnconfirmedInput(HashSum(parent), 1)
+
+	// The child can't be spent until its parent is applied.
+	view := bc.NewUTXOView()
+	_, err := view.Fee(child)
+	assert.NotNil(t, err)
+	fee, err := view.Fee(parent)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), fee)
+
+	view.Apply(parent)
+	amount, ok := view.UnspentOutput(input, sender)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), amount)
+	assert.Equal(t, parent, view.GetInputTransaction(&input))
+	_, err = view.Fee(parent)
+	assert.NotNil(t, err)
+
+	view.Apply(child)
+	_, ok = view.UnspentOutput(input, sender)
+	assert.False(t, ok)
+
+	// The chain itself is unchanged.
+	_, ok = bc.UnspentOutput(parent.Inputs[0], sender)
+	assert.True(t, ok)
+}
+
+func TestUTXOViewResolvesConfirmedTransactions(t *testing.T) {
+	bc, _ := NewValidBlockChainFixture()
+	txn := bc.Blocks[1].Transactions[1]
+	ptr := TxHashPointer{BlockNumber: 1, Index: 1, Hash: HashSum(txn)}
+	input := UnconfirmedInput(ptr.Hash, 0)
+
+	view := bc.NewUTXOView()
+	assert.Equal(t, ptr, view.Resolve(input))
+	ptr.Output = 1
+	assert.Equal(t, ptr, view.Resolve(UnconfirmedInput(ptr.Hash, 1)))
+	assert.Equal(t, txn, view.GetInputTransaction(&input))
+	amount, ok := bc.UnspentOutput(input, txn.Outputs[0].Recipient)
+	assert.True(t, ok)
+	assert.Equal(t, txn.Outputs[0].Amount, amount)
+}
+
+func TestAppendChainedTransactions(t *testing.T) {
+	bc, parent, child := NewValidTestChainAndChainedTxns()
+	cb, _ := NewValidCloudBaseTestTransaction()
+	b := NewTestChildBlock(bc.LastBlock(), bc.LastBlock().Target)
+	b.Transactions = []*Transaction{cb, parent, child}
+	b.UpdateMerkleRoot()
+
+	fees, err := b.GetTotalFees(bc)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), fees)
+
+	sender := child.Sender.Repr()
+	bob := child.Outputs[0].Recipient
+	assert.Nil(t, bc.AppendBlock(b))
+	_, ok := bc.UnspentOutput(UnconfirmedInput(HashSum(parent), 1), sender)
+	assert.False(t, ok)
+	amount, ok := bc.UnspentOutput(TxHashPointer{
+		BlockNumber: b.BlockNumber,
+		Hash:        HashSum(child),
+		Index:       2,
+	}, bob)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), amount)
+
+	// Rolling the block back restores the chain's outputs.
+	bc.RollBack()
+	_, ok = bc.UnspentOutput(parent.Inputs[0], sender)
+	assert.True(t, ok)
+	_, ok = bc.UnspentOutput(UnconfirmedInput(HashSum(parent), 0), bob)
+	assert.False(t, ok)
+}
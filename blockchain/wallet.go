@@ -1,13 +1,13 @@
 package blockchain
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	crand "crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -28,6 +28,8 @@ const (
 	SigLen = AddrLen
 	// AddressVersion is the version of the address shortening protocol.
 	AddressVersion = 0
+	// WalletVersion is the version of the format in which wallets are saved.
+	WalletVersion = 1
 )
 
 var (
@@ -37,6 +39,22 @@ var (
 	NilSig = Signature{c.Big0, c.Big0}
 	// NilAddr is an address representing no address
 	NilAddr = Address{c.Big0, c.Big0}
+
+	// ErrWalletVersion is returned when loading a wallet saved in a format
+	// this version doesn't know.
+	ErrWalletVersion = errors.New("Unknown wallet format version")
+	// ErrBadPublicKey is returned when a saved wallet's public key is missing
+	// or is not a point on the curve.
+	ErrBadPublicKey = errors.New("Invalid wallet public key")
+	// ErrBadPrivateKey is returned when a private key is out of range or
+	// does not match its wallet's public key.
+	ErrBadPrivateKey = errors.New("Private key does not match wallet public key")
+	// ErrNoPrivateKey is returned for wallets that are missing the private
+	// key they should have.
+	ErrNoPrivateKey = errors.New("Wallet has no private key")
+	// ErrWalletLocked is returned when signing with a wallet whose private
+	// key is encrypted or kept elsewhere.
+	ErrWalletLocked = errors.New("Wallet is locked")
 )
 
 // Address represents a wallet that can be a recipient in a transaction.
@@ -99,6 +117,13 @@ func (a Address) Key() *ecdsa.PublicKey {
 	}
 }
 
+// PublicKey returns the public key of the address in hex, as accepted by
+// NewWatch: its X and Y coordinates in big endian, each padded to CoordLen
+// bytes.
+func (a Address) PublicKey() string {
+	return hex.EncodeToString(append(pad32(a.X), pad32(a.Y)...))
+}
+
 // Account represents a wallet that we have the ability to sign for.
 type Account interface {
 	Public() Address
@@ -110,6 +135,9 @@ type Wallet struct {
 	*ecdsa.PrivateKey
 	PendingTxns []*Transaction
 	Balance     uint64
+	// EncryptedKey may hold the private key encrypted by its owner while the
+	// wallet is locked. A locked wallet can't sign and PrivateKey.D is nil.
+	EncryptedKey []byte
 }
 
 // Key retreives the underlying private key from a wallet.
@@ -124,74 +152,200 @@ func (w *Wallet) Public() Address {
 
 // Sign returns a signature of the digest.
 func (w *Wallet) Sign(digest Hash, random io.Reader) (Signature, error) {
+	if w.Locked() {
+		return NilSig, ErrWalletLocked
+	}
 	r, s, err := ecdsa.Sign(random, w.key(), digest.Marshal())
 	return Signature{R: r, S: s}, err
 }
 
-// UnmarshalJSON unmarshals the given byte slice into the given wallet, and
-// returns an error if one occurs.
-func (w *Wallet) UnmarshalJSON(walletBytes []byte) error {
-	var walletParams map[string]interface{}
-	dec := json.NewDecoder(bytes.NewReader(walletBytes))
-	dec.UseNumber()
-	if err := dec.Decode(&walletParams); err != nil {
-		return err
+// Locked returns true if the wallet doesn't have its private key, in which
+// case it can't sign.
+func (w *Wallet) Locked() bool {
+	return w.PrivateKey.D == nil
+}
+
+// PrivateKeyBytes returns the wallet's private key in big endian, padded to
+// CoordLen bytes, or nil if the wallet is locked.
+func (w *Wallet) PrivateKeyBytes() []byte {
+	if w.Locked() {
+		return nil
 	}
+	return pad32(w.PrivateKey.D)
+}
 
-	// Initialize private key to avoid SIGSEGV
-	key, err := ecdsa.GenerateKey(curve, crand.Reader)
-	if err != nil {
-		return err
+// Lock wipes the wallet's private key from memory and replaces it with the
+// given encryption of it, which may be nil if the key is kept elsewhere.
+func (w *Wallet) Lock(encryptedKey []byte) {
+	if w.PrivateKey.D != nil {
+		words := w.PrivateKey.D.Bits()
+		for i := range words {
+			words[i] = 0
+		}
 	}
-	w.PrivateKey = key
+	w.PrivateKey.D = nil
+	w.EncryptedKey = encryptedKey
+}
 
-	// Get pending transactions
-	txnBytes, err := json.Marshal(walletParams["PendingTxns"])
-	if err != nil {
+// CheckKey returns ErrBadPrivateKey unless the given private key in big
+// endian matches the wallet's public key.
+func (w *Wallet) CheckKey(key []byte) error {
+	return checkKey(new(big.Int).SetBytes(key), w.PrivateKey.X, w.PrivateKey.Y)
+}
+
+// Unlock sets the wallet's private key to the given one in big endian, which
+// must match the wallet's public key, and drops its encrypted key.
+func (w *Wallet) Unlock(key []byte) error {
+	if err := w.CheckKey(key); err != nil {
 		return err
 	}
-	txnDecoder := json.NewDecoder(bytes.NewReader(txnBytes))
-	txnDecoder.UseNumber()
-	if err := txnDecoder.Decode(&w.PendingTxns); err != nil {
+	w.PrivateKey.D = new(big.Int).SetBytes(key)
+	w.EncryptedKey = nil
+	return nil
+}
+
+// WatchOnly returns a copy of the wallet without its private key, which
+// follows the same balance but can't sign.
+func (w *Wallet) WatchOnly() *Wallet {
+	priv := &ecdsa.PrivateKey{PublicKey: w.PrivateKey.PublicKey}
+	return &Wallet{PrivateKey: priv, PendingTxns: w.PendingTxns, Balance: w.Balance}
+}
+
+// walletJSON is the format in which wallets are saved. Keys are in hex, the
+// public key being the padded X and Y coordinates one after the other. At
+// most one of Key and EncryptedKey is set, and neither is if the wallet is
+// locked and its key is kept elsewhere.
+type walletJSON struct {
+	Version      int
+	Public       string
+	Key          string `json:",omitempty"`
+	EncryptedKey string `json:",omitempty"`
+	PendingTxns  []*Transaction
+	Balance      uint64
+}
+
+// legacyWalletJSON is the format in which wallets were saved before
+// WalletVersion 1, which was the encoding of the struct. An encrypted key
+// was stored in place of D.
+type legacyWalletJSON struct {
+	X, Y, D     *big.Int
+	PendingTxns []*Transaction
+	Balance     uint64
+}
+
+// MarshalJSON returns the wallet in the current version of the wallet
+// format.
+func (w Wallet) MarshalJSON() ([]byte, error) {
+	pub := append(pad32(w.PrivateKey.X), pad32(w.PrivateKey.Y)...)
+	return json.Marshal(walletJSON{
+		Version:      WalletVersion,
+		Public:       hex.EncodeToString(pub),
+		Key:          hex.EncodeToString(w.PrivateKeyBytes()),
+		EncryptedKey: hex.EncodeToString(w.EncryptedKey),
+		PendingTxns:  w.PendingTxns,
+		Balance:      w.Balance,
+	})
+}
+
+// UnmarshalJSON sets the wallet from its JSON encoding in any version of the
+// wallet format. Returns an error if the encoding is malformed or its private
+// key does not match its public key, rather than loading a different key.
+func (w *Wallet) UnmarshalJSON(walletBytes []byte) error {
+	var saved walletJSON
+	if err := json.Unmarshal(walletBytes, &saved); err != nil {
 		return err
 	}
+	switch saved.Version {
+	case 0:
+		return w.unmarshalLegacy(walletBytes)
+	case WalletVersion:
+	default:
+		return ErrWalletVersion
+	}
 
-	// Get balance
-	balanceBytes, err := json.Marshal(walletParams["Balance"])
+	pub, err := hex.DecodeString(saved.Public)
+	if err != nil || len(pub) != AddrLen {
+		return ErrBadPublicKey
+	}
+	key, err := hex.DecodeString(saved.Key)
 	if err != nil {
 		return err
 	}
-	if err := json.Unmarshal(balanceBytes, &w.Balance); err != nil {
+	encryptedKey, err := hex.DecodeString(saved.EncryptedKey)
+	if err != nil {
 		return err
 	}
+	if len(key) > 0 && (len(key) != CoordLen || len(encryptedKey) > 0) {
+		return ErrBadPrivateKey
+	}
 
-	// Get private/public keys
-	if err := w.decodeBigInt(walletParams["X"], w.PrivateKey.PublicKey.X); err != nil {
+	x := new(big.Int).SetBytes(pub[:CoordLen])
+	y := new(big.Int).SetBytes(pub[CoordLen:])
+	return w.set(x, y, key, encryptedKey, saved.PendingTxns, saved.Balance)
+}
+
+// unmarshalLegacy sets the wallet from its encoding in the format used before
+// WalletVersion 1. A D longer than a key is an encrypted key.
+func (w *Wallet) unmarshalLegacy(walletBytes []byte) error {
+	var saved legacyWalletJSON
+	if err := json.Unmarshal(walletBytes, &saved); err != nil {
 		return err
 	}
-	if err := w.decodeBigInt(walletParams["Y"], w.PrivateKey.PublicKey.Y); err != nil {
-		return err
+	if saved.X == nil || saved.Y == nil {
+		return ErrBadPublicKey
+	} else if saved.D == nil {
+		return ErrNoPrivateKey
 	}
-	if err := w.decodeBigInt(walletParams["D"], w.PrivateKey.D); err != nil {
-		return err
+	var key, encryptedKey []byte
+	if d := saved.D.Bytes(); len(d) > CoordLen {
+		encryptedKey = d
+	} else {
+		key = d
 	}
+	return w.set(saved.X, saved.Y, key, encryptedKey, saved.PendingTxns,
+		saved.Balance)
+}
 
-	// Add elliptic curve
-	w.PrivateKey.PublicKey.Curve = curve
+// set sets the wallet to the one with the given public key and the given
+// private key, after checking that they match, or else the given encrypted
+// key if any.
+func (w *Wallet) set(x, y *big.Int, key, encryptedKey []byte,
+	pending []*Transaction, balance uint64) error {
+	if !curve.IsOnCurve(x, y) {
+		return ErrBadPublicKey
+	}
+	if len(encryptedKey) == 0 {
+		encryptedKey = nil
+	}
+	priv := &ecdsa.PrivateKey{}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = x, y
+	loaded := &Wallet{
+		PrivateKey:   priv,
+		PendingTxns:  pending,
+		Balance:      balance,
+		EncryptedKey: encryptedKey,
+	}
+	if len(key) > 0 {
+		if err := loaded.Unlock(key); err != nil {
+			return err
+		}
+	}
+	*w = *loaded
 	return nil
 }
 
-// decodeBigInt is a helper function for wallet.UnmarshalJSON. It attempts to
-// set target to the big.Int decoded from the given param. Returns an error if
-// one occurs.
-func (w *Wallet) decodeBigInt(param interface{}, target *big.Int) error {
-	intBytes, err := json.Marshal(param)
-	if err != nil {
-		return err
+// checkKey returns an error unless d is a valid private key whose public key
+// is (x, y).
+func checkKey(d, x, y *big.Int) error {
+	if d.Sign() <= 0 || d.Cmp(curve.Params().N) >= 0 {
+		return ErrBadPrivateKey
+	}
+	px, py := curve.ScalarBaseMult(pad32(d))
+	if px.Cmp(x) != 0 || py.Cmp(y) != 0 {
+		return ErrBadPrivateKey
 	}
-	dec := json.NewDecoder(bytes.NewReader(intBytes))
-	dec.UseNumber()
-	return dec.Decode(target)
+	return nil
 }
 
 // Signature represents a signature of a transaction.
@@ -244,7 +398,10 @@ func (w *Wallet) SetAllPending(txns []*Transaction) {
 // if the wallet's effective balance is high enough to accomodate.
 func (w *Wallet) SetPending(txn *Transaction) error {
 	bal := w.GetEffectiveBalance()
-	spend := txn.GetTotalOutput()
+	spend, err := txn.GetTotalOutput()
+	if err != nil {
+		return err
+	}
 	if bal >= spend {
 		w.PendingTxns = append(w.PendingTxns, txn)
 	} else {
@@ -288,7 +445,10 @@ func (w *Wallet) IsPending(txn *Transaction) (bool, int) {
 func (w *Wallet) GetEffectiveBalance() uint64 {
 	r := w.Balance
 	for _, t := range w.PendingTxns {
-		r -= t.GetTotalOutput() - t.GetTotalOutputFor(t.Sender.Repr())
+		// Pending transactions were checked when they were set pending, so
+		// their outputs don't overflow.
+		out, _ := t.GetTotalOutput()
+		r -= out - t.GetTotalOutputFor(t.From())
 	}
 	return r
 }
@@ -314,14 +474,41 @@ func (w *Wallet) Update(block *Block, bc *BlockChain) error {
 	return nil
 }
 
-// Refresh sets the wallet's balance and  updates its set of pending transactions
-// based on the transaction information in the given blockchain. Returns an
-// error if any of the transactions in the blockchain cannot be found.
+// Revert undoes the effect of the given block on the wallet's balance and
+// returns the wallet's transactions in the block to its set of pending
+// transactions. It is the inverse of Update, and must be called while the
+// inputs to the block's transactions are still in the blockchain. Returns an
+// error if any of the transactions in the given block cannot be found in the
+// blockchain.
+func (w *Wallet) Revert(block *Block, bc *BlockChain) error {
+	totalOutput := block.GetTotalOutputFor(w.Public().Repr())
+	totalInput, err := block.GetTotalInputFrom(w.Public().Repr(), bc)
+	if err != nil {
+		return err
+	}
+	w.Balance += totalInput - totalOutput
+
+	// Our transactions in the block are no longer confirmed
+	for _, t := range block.GetTransactionsFrom(w.Public().Repr()) {
+		if p, _ := w.IsPending(t); !p {
+			w.PendingTxns = append(w.PendingTxns, t)
+		}
+	}
+	return nil
+}
+
+// Refresh sets the wallet's balance from the unspent outputs in the given
+// blockchain and drops pending transactions that the blockchain contains,
+// which are looked up in its transaction index rather than read from its
+// blocks.
 func (w *Wallet) Refresh(bc *BlockChain) error {
 	w.Balance = uint64(0)
-	for _, b := range bc.Blocks {
-		if err := w.Update(b, bc); err != nil {
-			return err
+	for _, amount := range bc.UnspentOutputsFor(w.Public().Repr()) {
+		w.Balance += amount
+	}
+	for i := len(w.PendingTxns) - 1; i >= 0; i-- {
+		if _, _, err := bc.GetTransactionByHash(HashSum(w.PendingTxns[i])); err == nil {
+			w.DropPending(i)
 		}
 	}
 	return nil
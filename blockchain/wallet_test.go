@@ -1,7 +1,12 @@
 package blockchain
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	crand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,7 +23,7 @@ func TestSetAllPending(t *testing.T) {
 	txn := NewTestTransaction()
 
 	// Set the balance approprately to handle the txn.
-	w.Balance = txn.GetTotalOutput()
+	w.Balance, _ = txn.GetTotalOutput()
 
 	// Set and check.
 	w.SetAllPending([]*Transaction{txn})
@@ -53,7 +58,7 @@ func TestDropAllPending(t *testing.T) {
 		Blocks: []*Block{block},
 	}
 
-	w.Balance = txn.GetTotalOutput()
+	w.Balance, _ = txn.GetTotalOutput()
 	w.SetAllPending([]*Transaction{txn})
 
 	// Drop all pending
@@ -67,10 +72,12 @@ func TestDropAllPending(t *testing.T) {
 func TestGetWalletBalances(t *testing.T) {
 	w := NewWallet()
 	txn := NewTestTransaction()
-	w.Balance = txn.GetTotalOutput()
+	w.Balance, _ = txn.GetTotalOutput()
 	w.SetAllPending([]*Transaction{txn})
 
-	assert.Equal(t, w.Balance, txn.GetTotalOutput())
+	total, err := txn.GetTotalOutput()
+	assert.Nil(t, err)
+	assert.Equal(t, w.Balance, total)
 	assert.Equal(t, w.GetEffectiveBalance(), uint64(0))
 }
 
@@ -84,6 +91,92 @@ func TestUnmarshalJSON(t *testing.T) {
 	assert.Equal(t, w1, &w2)
 }
 
+func TestUnmarshalJSONRejectsBadWallets(t *testing.T) {
+	w := NewWallet()
+	other := NewWallet()
+	pub := hex.EncodeToString(append(pad32(w.X), pad32(w.Y)...))
+	key := hex.EncodeToString(w.PrivateKeyBytes())
+	tests := map[string]error{
+		`{"Version":2,"Public":"` + pub + `","Key":"` + key + `"}`:                 ErrWalletVersion,
+		`{"Version":1,"Public":"` + pub[2:] + `","Key":"` + key + `"}`:             ErrBadPublicKey,
+		`{"Version":1,"Public":"` + pub[:64] + pub[:64] + `","Key":"` + key + `"}`: ErrBadPublicKey,
+		`{"Version":1,"Public":"` + pub + `","Key":"` + key[2:] + `"}`:             ErrBadPrivateKey,
+		`{"Version":1,"Public":"` + pub + `","Key":"` +
+			hex.EncodeToString(other.PrivateKeyBytes()) + `"}`: ErrBadPrivateKey,
+		`{"X":1,"Y":2,"D":3}`: ErrBadPublicKey,
+		`{"X":` + w.X.String() + `,"Y":` + w.Y.String() + `}`: ErrNoPrivateKey,
+		`{"X":` + w.X.String() + `,"Y":` + w.Y.String() +
+			`,"D":` + other.D.String() + `}`: ErrBadPrivateKey,
+	}
+	for walletJSON, expected := range tests {
+		var loaded Wallet
+		assert.Equal(t, expected, loaded.UnmarshalJSON([]byte(walletJSON)),
+			walletJSON)
+	}
+	var loaded Wallet
+	assert.NotNil(t, loaded.UnmarshalJSON([]byte(`{"Version":1,"Public":`)))
+}
+
+func TestUnmarshalLegacyJSON(t *testing.T) {
+	// Wallets used to be saved as the encoding of the struct.
+	w1 := NewWallet()
+	w1.Balance = 5
+	legacy := struct {
+		*ecdsa.PrivateKey
+		PendingTxns []*Transaction
+		Balance     uint64
+	}{w1.PrivateKey, w1.PendingTxns, w1.Balance}
+	walletBytes, err := json.Marshal(legacy)
+	assert.Nil(t, err)
+	var w2 Wallet
+	assert.Nil(t, json.Unmarshal(walletBytes, &w2))
+	assert.Equal(t, w1, &w2)
+
+	// An encrypted key was stored in place of D.
+	encrypted := bytes.Repeat([]byte{0xff}, 2*CoordLen)
+	legacy.PrivateKey = &ecdsa.PrivateKey{PublicKey: w1.PublicKey,
+		D: new(big.Int).SetBytes(encrypted)}
+	walletBytes, err = json.Marshal(legacy)
+	assert.Nil(t, err)
+	var w3 Wallet
+	assert.Nil(t, json.Unmarshal(walletBytes, &w3))
+	assert.True(t, w3.Locked())
+	assert.Equal(t, encrypted, w3.EncryptedKey)
+	assert.Equal(t, w1.Public(), w3.Public())
+}
+
+func TestLockAndUnlock(t *testing.T) {
+	w := NewWallet()
+	key := w.PrivateKeyBytes()
+	w.Lock([]byte("encrypted"))
+	assert.True(t, w.Locked())
+	_, err := w.Sign(NewTestHash(), crand.Reader)
+	assert.Equal(t, ErrWalletLocked, err)
+
+	// A locked wallet is saved with its encrypted key.
+	walletBytes, err := json.Marshal(w)
+	assert.Nil(t, err)
+	var loaded Wallet
+	assert.Nil(t, json.Unmarshal(walletBytes, &loaded))
+	assert.Equal(t, w, &loaded)
+
+	// So is one whose key is kept elsewhere.
+	w.Lock(nil)
+	walletBytes, err = json.Marshal(w)
+	assert.Nil(t, err)
+	loaded = Wallet{}
+	assert.Nil(t, json.Unmarshal(walletBytes, &loaded))
+	assert.Equal(t, w, &loaded)
+
+	assert.Equal(t, ErrBadPrivateKey, w.Unlock(NewWallet().PrivateKeyBytes()))
+	assert.True(t, w.Locked())
+	assert.Nil(t, w.Unlock(key))
+	assert.False(t, w.Locked())
+	assert.Nil(t, w.EncryptedKey)
+	_, err = w.Sign(NewTestHash(), crand.Reader)
+	assert.Nil(t, err)
+}
+
 func TestUpdate(t *testing.T) {
 	bc, wallets := NewValidBlockChainFixture()
 	assert.Nil(t, wallets["bob"].Update(bc.Blocks[2], bc))
@@ -95,3 +188,23 @@ func TestRefresh(t *testing.T) {
 	assert.Nil(t, wallets["bob"].Refresh(bc))
 	assert.Equal(t, wallets["bob"].Balance, uint64(1))
 }
+
+func TestRevert(t *testing.T) {
+	bc, wallets := NewValidBlockChainFixture()
+	sender := wallets["sender"]
+	assert.Nil(t, sender.Refresh(bc))
+	assert.Equal(t, uint64(0), sender.Balance)
+
+	// Reverting block 2 gives the sender back the coin they sent to bob, but
+	// the transaction is pending again so it can't be spent.
+	assert.Nil(t, sender.Revert(bc.Blocks[2], bc))
+	assert.Equal(t, uint64(1), sender.Balance)
+	assert.Equal(t, uint64(0), sender.GetEffectiveBalance())
+	pending, _ := sender.IsPending(bc.Blocks[2].Transactions[1])
+	assert.True(t, pending)
+
+	// Updating with the block again undoes the revert.
+	assert.Nil(t, sender.Update(bc.Blocks[2], bc))
+	assert.Equal(t, uint64(0), sender.Balance)
+	assert.Empty(t, sender.PendingTxns)
+}
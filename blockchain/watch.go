@@ -0,0 +1,144 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+)
+
+// ErrBadWatchAddress is returned when watching something that is neither an
+// address nor a public key.
+var ErrBadWatchAddress = errors.New("Expected an address or a public key in hex")
+
+// WatchedTxn is a transaction in the blockchain that sent to or from a
+// watched address.
+type WatchedTxn struct {
+	Hash        Hash
+	BlockNumber uint32
+	// Outgoing is true if the watched address sent the transaction.
+	Outgoing bool
+	// Amount is what the transaction paid to the watched address, or to
+	// other addresses if it is outgoing. Fees are not included.
+	Amount uint64
+	// Counterparty is the sender of an incoming transaction, or the first
+	// other recipient of an outgoing one.
+	Counterparty string
+}
+
+// Watch follows the balance and transactions of an address whose key we
+// don't hold, so it can't be spent from. The address may be a multisig or
+// script address.
+type Watch struct {
+	Label string
+	Repr  string
+	// Key is the public key of the address, if it was given.
+	Key *Address `json:",omitempty"`
+	// Balance is the total of the unspent outputs to the address.
+	Balance uint64
+	// History holds the transactions to and from the address, in the order
+	// of the blocks they are in.
+	History []WatchedTxn
+}
+
+// NewWatch returns a watch with the given label for an address, given as
+// its Repr or as its public key: the padded X and Y coordinates in hex.
+func NewWatch(addr, label string) (*Watch, error) {
+	b, err := hex.DecodeString(addr)
+	if err != nil {
+		return nil, ErrBadWatchAddress
+	}
+	switch len(b) {
+	case ReprLen / 2:
+		return &Watch{Label: label, Repr: hex.EncodeToString(b)}, nil
+	case AddrLen:
+		key := Address{
+			X: new(big.Int).SetBytes(b[:CoordLen]),
+			Y: new(big.Int).SetBytes(b[CoordLen:]),
+		}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrBadPublicKey
+		}
+		return &Watch{Label: label, Repr: key.Repr(), Key: &key}, nil
+	}
+	return nil, ErrBadWatchAddress
+}
+
+// Update updates the watch's balance and history with the transactions in
+// the given block. Returns an error if the outputs the block spends from the
+// address can't be found in the blockchain.
+func (w *Watch) Update(block *Block, bc *BlockChain) error {
+	received := block.GetTotalOutputFor(w.Repr)
+	spent, err := block.GetTotalInputFrom(w.Repr, bc)
+	if err != nil {
+		return err
+	}
+	w.Balance += received - spent
+	w.History = append(w.History, w.transactions(block)...)
+	return nil
+}
+
+// Revert undoes the effect of the given block on the watch. It is the
+// inverse of Update, and must be called while the inputs to the block's
+// transactions are still in the blockchain.
+func (w *Watch) Revert(block *Block, bc *BlockChain) error {
+	received := block.GetTotalOutputFor(w.Repr)
+	spent, err := block.GetTotalInputFrom(w.Repr, bc)
+	if err != nil {
+		return err
+	}
+	w.Balance += spent - received
+
+	reverted := make(map[Hash]bool, len(block.Transactions))
+	for _, t := range block.Transactions {
+		reverted[HashSum(t)] = true
+	}
+	history := make([]WatchedTxn, 0, len(w.History))
+	for _, t := range w.History {
+		if t.BlockNumber != block.BlockNumber || !reverted[t.Hash] {
+			history = append(history, t)
+		}
+	}
+	w.History = history
+	return nil
+}
+
+// Refresh sets the watch's balance from the unspent outputs in the given
+// blockchain, and its history from the blocks in it. Transactions in pruned
+// blocks are missing from the history.
+func (w *Watch) Refresh(bc *BlockChain) {
+	w.Balance = uint64(0)
+	for _, amount := range bc.UnspentOutputsFor(w.Repr) {
+		w.Balance += amount
+	}
+	w.History = make([]WatchedTxn, 0)
+	for i := range bc.Blocks {
+		w.History = append(w.History, w.transactions(bc.block(i))...)
+	}
+}
+
+// transactions returns the transactions in the block to or from the
+// watched address.
+func (w *Watch) transactions(block *Block) []WatchedTxn {
+	txns := make([]WatchedTxn, 0)
+	for _, t := range block.Transactions {
+		wt := WatchedTxn{Hash: HashSum(t), BlockNumber: block.BlockNumber}
+		if t.From() == w.Repr {
+			wt.Outgoing = true
+			for _, o := range t.Outputs {
+				if o.Recipient == w.Repr {
+					continue
+				}
+				if wt.Counterparty == "" {
+					wt.Counterparty = o.Recipient
+				}
+				wt.Amount += o.Amount
+			}
+		} else if wt.Amount = t.GetTotalOutputFor(w.Repr); wt.Amount > 0 {
+			wt.Counterparty = t.From()
+		} else {
+			continue
+		}
+		txns = append(txns, wt)
+	}
+	return txns
+}
@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWatch(t *testing.T) {
+	w := NewWallet()
+
+	watch, err := NewWatch(strings.ToUpper(w.Public().Repr()), "cold")
+	assert.Nil(t, err)
+	assert.Equal(t, w.Public().Repr(), watch.Repr)
+	assert.Equal(t, "cold", watch.Label)
+	assert.Nil(t, watch.Key)
+
+	watch, err = NewWatch(w.Public().PublicKey(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, w.Public().Repr(), watch.Repr)
+	assert.Equal(t, w.Public(), *watch.Key)
+
+	_, err = NewWatch("not hex", "")
+	assert.Equal(t, ErrBadWatchAddress, err)
+	_, err = NewWatch(w.Public().Repr()[2:], "")
+	assert.Equal(t, ErrBadWatchAddress, err)
+	_, err = NewWatch(strings.Repeat("01", AddrLen), "")
+	assert.Equal(t, ErrBadPublicKey, err)
+}
+
+func TestWatchRefresh(t *testing.T) {
+	bc, wallets := NewValidBlockChainFixture()
+	alice, _ := NewWatch(wallets["alice"].Public().Repr(), "alice")
+	alice.Refresh(bc)
+	assert.Equal(t, uint64(3), alice.Balance)
+	assert.Equal(t, []WatchedTxn{{
+		Hash:         HashSum(bc.Blocks[1].Transactions[1]),
+		BlockNumber:  1,
+		Amount:       3,
+		Counterparty: wallets["sender"].Public().Repr(),
+	}}, alice.History)
+
+	// Change sent back to the sender is not counted as sent.
+	sender, _ := NewWatch(wallets["sender"].Public().PublicKey(), "sender")
+	sender.Refresh(bc)
+	assert.Equal(t, uint64(0), sender.Balance)
+	assert.Len(t, sender.History, 3)
+	assert.True(t, sender.History[1].Outgoing)
+	assert.Equal(t, uint64(3), sender.History[1].Amount)
+	assert.Equal(t, wallets["alice"].Public().Repr(), sender.History[1].Counterparty)
+	assert.Equal(t, uint64(1), sender.History[2].Amount)
+}
+
+func TestWatchUpdateAndRevert(t *testing.T) {
+	bc, wallets := NewValidBlockChainFixture()
+	sender, _ := NewWatch(wallets["sender"].Public().Repr(), "")
+	sender.Refresh(bc)
+	bob, _ := NewWatch(wallets["bob"].Public().Repr(), "")
+	bob.Refresh(bc)
+
+	// Reverting block 2 gives the sender back the coin they sent to bob.
+	assert.Nil(t, sender.Revert(bc.Blocks[2], bc))
+	assert.Equal(t, uint64(1), sender.Balance)
+	assert.Len(t, sender.History, 2)
+	assert.Nil(t, bob.Revert(bc.Blocks[2], bc))
+	assert.Equal(t, uint64(0), bob.Balance)
+	assert.Empty(t, bob.History)
+
+	// Updating with the block again undoes the revert.
+	assert.Nil(t, sender.Update(bc.Blocks[2], bc))
+	assert.Equal(t, uint64(0), sender.Balance)
+	assert.Len(t, sender.History, 3)
+	assert.Nil(t, bob.Update(bc.Blocks[2], bc))
+	assert.Equal(t, uint64(1), bob.Balance)
+	assert.Len(t, bob.History, 1)
+}
@@ -0,0 +1,38 @@
+package blockchain
+
+// mnemonicWords are the words of mnemonic phrases. Each word encodes one byte,
+// its index in the list, and no two words share their first four letters.
+var mnemonicWords = [256]string{
+	"able", "acid", "adult", "agent", "album", "amber", "anchor", "ankle",
+	"april", "arctic", "armor", "artist", "atlas", "august", "autumn", "bacon",
+	"bakery", "balance", "banana", "basket", "beach", "bench", "bicycle", "bishop",
+	"blossom", "board", "border", "bracket", "bread", "bridge", "bubble", "bucket",
+	"butter", "cactus", "camera", "canyon", "captain", "carpet", "cattle", "cement",
+	"cherry", "circle", "citizen", "climb", "cloud", "coconut", "comet", "copper",
+	"cousin", "credit", "cricket", "dancer", "debate", "decade", "delta", "desert",
+	"diamond", "dolphin", "donkey", "drama", "drift", "drum", "dust", "earth",
+	"echo", "effort", "elbow", "elephant", "empire", "engine", "equal", "escape",
+	"evening", "fabric", "family", "fashion", "fence", "fiber", "fiction", "filter",
+	"finger", "flame", "flight", "flower", "forest", "fountain", "fox", "frost",
+	"funnel", "galaxy", "garlic", "gate", "ghost", "ginger", "giraffe", "glove",
+	"golden", "gorilla", "grape", "guitar", "gym", "hammer", "harbor", "hawk",
+	"helmet", "hero", "hockey", "honey", "horizon", "hunter", "icon", "idea",
+	"immune", "impact", "indoor", "inner", "insect", "ivory", "jaguar", "jazz",
+	"jewel", "journey", "judge", "jungle", "junior", "kettle", "kingdom", "kitchen",
+	"knife", "ladder", "lagoon", "language", "lava", "lemon", "letter", "library",
+	"lobster", "lumber", "lunar", "mammal", "maple", "marble", "meadow", "mercy",
+	"metal", "middle", "mirror", "monkey", "mosquito", "motor", "muffin", "mystery",
+	"napkin", "nature", "nephew", "nest", "noble", "noodle", "novel", "nurse",
+	"nutmeg", "object", "october", "office", "onion", "orange", "orbit", "organ",
+	"ostrich", "oven", "oyster", "paddle", "panda", "paper", "parade", "pencil",
+	"piano", "pigeon", "planet", "plastic", "polar", "powder", "pumpkin", "pyramid",
+	"quarter", "question", "quilt", "rabbit", "raccoon", "radio", "rain", "raven",
+	"rebel", "record", "relief", "rhythm", "ribbon", "robot", "rubber", "saddle",
+	"sandal", "satellite", "school", "seagull", "season", "shelf", "silver", "sister",
+	"sleeve", "snake", "soccer", "spoon", "squirrel", "station", "summer", "sunset",
+	"symbol", "tackle", "talent", "tennis", "ticket", "tiger", "toast", "tomato",
+	"tower", "travel", "trophy", "tunnel", "twelve", "umbrella", "unicorn", "upper",
+	"urban", "utility", "vacuum", "vapor", "venue", "verb", "victory", "violin",
+	"virus", "vital", "voyage", "wagon", "walrus", "warrior", "weasel", "whisper",
+	"window", "wisdom", "wolf", "wonder", "yellow", "youth", "zebra", "zigzag",
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/ubclaunchpad/cumulus/app"
+)
+
+// accountCmd represents the account command
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "Manage the accounts of the user in the current directory",
+	Long: `Account lists, creates, selects, renames and archives the accounts of the
+	user saved in the current directory. The node must not be running, since it
+	saves the user when it exits.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		withUser(all, func(user *app.User) error {
+			return nil
+		})
+	},
+}
+
+var accountNewCmd = &cobra.Command{
+	Use:   "new [name]",
+	Short: "Create an account",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !exactArgs(cmd, args, 1) {
+			return
+		}
+		withUser(false, func(user *app.User) error {
+			if user.CryptoWallet {
+				return errors.New("Keys are encrypted, create the account from the console")
+			}
+			_, err := user.NewAccount(args[0])
+			return err
+		})
+	},
+}
+
+var accountSelectCmd = &cobra.Command{
+	Use:   "select [name]",
+	Short: "Pay and receive with an account by default",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !exactArgs(cmd, args, 1) {
+			return
+		}
+		withUser(false, func(user *app.User) error {
+			return user.Select(args[0])
+		})
+	},
+}
+
+var accountRenameCmd = &cobra.Command{
+	Use:   "rename [name] [new name]",
+	Short: "Rename an account",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !exactArgs(cmd, args, 2) {
+			return
+		}
+		withUser(false, func(user *app.User) error {
+			return user.Rename(args[0], args[1])
+		})
+	},
+}
+
+var accountArchiveCmd = &cobra.Command{
+	Use:   "archive [name]",
+	Short: "Hide an account and stop spending from it",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !exactArgs(cmd, args, 1) {
+			return
+		}
+		withUser(false, func(user *app.User) error {
+			return user.Archive(args[0], true)
+		})
+	},
+}
+
+var accountUnarchiveCmd = &cobra.Command{
+	Use:   "unarchive [name]",
+	Short: "Bring back an archived account",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !exactArgs(cmd, args, 1) {
+			return
+		}
+		withUser(false, func(user *app.User) error {
+			return user.Archive(args[0], false)
+		})
+	},
+}
+
+// exactArgs returns true if there are n arguments, and prints the command's
+// usage otherwise.
+func exactArgs(cmd *cobra.Command, args []string, n int) bool {
+	if len(args) != n {
+		cmd.Usage()
+		return false
+	}
+	return true
+}
+
+// withUser loads the user in the current directory, calls f with it, saves it
+// again and lists its accounts, including archived ones if all is true. Exits
+// if anything fails.
+func withUser(all bool, f func(*app.User) error) {
+	user, err := app.LoadUser(app.UserFileName)
+	if err != nil {
+		fmt.Println("Failed to load user from", app.UserFileName+":", err)
+		os.Exit(1)
+	}
+	if err := f(user); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := user.Save(app.UserFileName); err != nil {
+		fmt.Println("Failed to save user to", app.UserFileName+":", err)
+		os.Exit(1)
+	}
+	listAccounts(user, all)
+}
+
+// listAccounts prints the name and address of each of the user's accounts,
+// marking the selected one.
+func listAccounts(user *app.User, archived bool) {
+	for _, account := range user.Accounts {
+		if account.Archived && !archived {
+			continue
+		}
+		marker := " "
+		if account == user.Current() {
+			marker = "*"
+		}
+		status := ""
+		if account.Archived {
+			status = " (archived)"
+		}
+		fmt.Printf("%s %s%s\t%s\n", marker, account.Name, status,
+			account.Wallet.Public().Repr())
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(accountCmd)
+	accountCmd.AddCommand(accountNewCmd, accountSelectCmd, accountRenameCmd,
+		accountArchiveCmd, accountUnarchiveCmd)
+	accountCmd.Flags().Bool("all", false, "Include archived accounts")
+}
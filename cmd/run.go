@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/spf13/cobra"
 	"github.com/ubclaunchpad/cumulus/app"
 	"github.com/ubclaunchpad/cumulus/conf"
+	"github.com/ubclaunchpad/cumulus/consensus"
 	"github.com/ubclaunchpad/cumulus/peer"
+	"github.com/ubclaunchpad/cumulus/pool"
 )
 
 // runCmd represents the run command
@@ -20,6 +24,18 @@ var runCmd = &cobra.Command{
 		verbose, _ := cmd.Flags().GetBool("verbose")
 		mine, _ := cmd.Flags().GetBool("mine")
 		console, _ := cmd.Flags().GetBool("console")
+		account, _ := cmd.Flags().GetString("account")
+		checkpoints, _ := cmd.Flags().GetStringSlice("checkpoint")
+		fastValidation, _ := cmd.Flags().GetBool("fast-validation")
+		pruneDepth, _ := cmd.Flags().GetUint32("prune")
+		maxFutureDrift, _ := cmd.Flags().GetDuration("max-future-drift")
+		selectionPolicy, _ := cmd.Flags().GetString("selection-policy")
+		maxPerSender, _ := cmd.Flags().GetInt("max-per-sender")
+		poolMaxTxns, _ := cmd.Flags().GetInt("pool-max-txns")
+		poolMaxBytes, _ := cmd.Flags().GetInt("pool-max-bytes")
+		poolExpiry, _ := cmd.Flags().GetDuration("pool-expiry")
+		poolMaxPerSender, _ := cmd.Flags().GetInt("pool-max-per-sender")
+		poolMaxPerPeer, _ := cmd.Flags().GetInt("pool-max-per-peer")
 		config := conf.Config{
 			Interface: iface,
 			Port:      uint16(port),
@@ -27,6 +43,21 @@ var runCmd = &cobra.Command{
 			Verbose:   verbose,
 			Mine:      mine,
 			Console:   console,
+			Account:   account,
+
+			Checkpoints:    checkpoints,
+			FastValidation: fastValidation,
+			PruneDepth:     pruneDepth,
+			MaxFutureDrift: maxFutureDrift,
+
+			SelectionPolicy:  selectionPolicy,
+			MaxTxnsPerSender: maxPerSender,
+
+			PoolMaxTxns:      poolMaxTxns,
+			PoolMaxBytes:     poolMaxBytes,
+			PoolExpiry:       poolExpiry,
+			PoolMaxPerSender: poolMaxPerSender,
+			PoolMaxPerPeer:   poolMaxPerPeer,
 		}
 
 		// Start the application
@@ -54,4 +85,24 @@ func init() {
 	runCmd.Flags().BoolP("verbose", "v", false, "Enable verbose logging")
 	runCmd.Flags().BoolP("console", "c", false, "Start Cumulus console")
 	runCmd.Flags().BoolP("mine", "m", false, "Enable mining on this node")
+	runCmd.Flags().StringP("account", "a", "", "Name of the account to pay and receive with")
+	runCmd.Flags().StringSlice("checkpoint", []string{}, "Block the chain must contain, as height:hash")
+	runCmd.Flags().Bool("fast-validation", false, "Skip signature checks below the last checkpoint")
+	runCmd.Flags().Uint32("prune", 0, "Only keep transactions of this many recent blocks")
+	runCmd.Flags().Duration("max-future-drift", time.Duration(consensus.MaxFutureDrift)*time.Second,
+		"How far ahead of our clock a block's timestamp may be")
+	runCmd.Flags().String("selection-policy", pool.OldestFirstPolicy,
+		"How to choose transactions for mined blocks: oldest-first, best-fit or fair")
+	runCmd.Flags().Int("max-per-sender", pool.DefaultMaxPerSender,
+		"Most transactions from one sender in a block with the fair selection policy")
+	runCmd.Flags().Int("pool-max-txns", pool.DefaultLimits.MaxCount,
+		"Most transactions to keep in the pool, 0 for no limit")
+	runCmd.Flags().Int("pool-max-bytes", pool.DefaultLimits.MaxBytes,
+		"Most bytes of transactions to keep in the pool, 0 for no limit")
+	runCmd.Flags().Duration("pool-expiry", pool.DefaultLimits.MaxAge,
+		"How long transactions can wait in the pool, 0 to never expire them")
+	runCmd.Flags().Int("pool-max-per-sender", pool.DefaultLimits.MaxPerSender,
+		"Most pending transactions one sender can have in the pool, 0 for no limit")
+	runCmd.Flags().Int("pool-max-per-peer", pool.DefaultLimits.MaxPerPeer,
+		"Most pending transactions to accept from one peer, 0 for no limit")
 }
@@ -1,5 +1,7 @@
 package conf
 
+import "time"
+
 // Config contains all configuration options for a node.
 type Config struct {
 	// The interface to listen on for new connections.
@@ -15,4 +17,37 @@ type Config struct {
 	Mine bool
 	// Whether or not to start the Cumulus console
 	Console bool
+	// The name of the user's account to pay and receive with, or empty to
+	// use the account that was selected last.
+	Account string
+	// Checkpoints the blockchain must match, each of the form "height:hash".
+	Checkpoints []string
+	// Whether or not to skip verifying transaction signatures in blocks below
+	// the last checkpoint.
+	FastValidation bool
+	// The number of recent blocks to keep transactions for, or 0 to keep every
+	// block in full.
+	PruneDepth uint32
+	// How far ahead of our clock a block's timestamp may be, or 0 to use the
+	// default.
+	MaxFutureDrift time.Duration
+	// The name of the policy used to choose the transactions in mined blocks.
+	SelectionPolicy string
+	// The most transactions from a single sender the fair selection policy
+	// will put in a block.
+	MaxTxnsPerSender int
+	// The most transactions the transaction pool will hold, or 0 for no limit.
+	PoolMaxTxns int
+	// The most bytes of transactions the transaction pool will hold, or 0 for
+	// no limit.
+	PoolMaxBytes int
+	// How long a transaction can wait in the pool before it expires, or 0 to
+	// keep transactions until they are mined.
+	PoolExpiry time.Duration
+	// The most pending transactions a single sender can have in the pool, or 0
+	// for no limit.
+	PoolMaxPerSender int
+	// The most pending transactions the pool will admit from a single peer, or
+	// 0 for no limit.
+	PoolMaxPerPeer int
 }
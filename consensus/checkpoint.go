@@ -0,0 +1,111 @@
+package consensus
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+// Checkpoint is the hash of the block we expect at a given height of the main
+// chain.
+type Checkpoint struct {
+	Height uint32
+	Hash   blockchain.Hash
+}
+
+var (
+	// Checkpoints are the blocks the main chain must contain. Blocks that
+	// don't match a checkpoint at their height, and side branches that fork
+	// from the main chain before a checkpoint it has already passed, are
+	// rejected.
+	Checkpoints = []Checkpoint{}
+	// FastValidation skips transaction signature verification for blocks
+	// that the block at the last checkpoint is known to build on. Hashes,
+	// proof of work and everything else about those blocks are still
+	// verified.
+	FastValidation = false
+)
+
+// ParseCheckpoint parses a checkpoint of the form "height:hash", where hash is
+// hex encoded.
+func ParseCheckpoint(s string) (Checkpoint, error) {
+	var cp Checkpoint
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return cp, errors.New("Checkpoint must be of the form height:hash")
+	}
+
+	height, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return cp, err
+	}
+
+	hashBytes, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return cp, err
+	}
+	if len(hashBytes) != blockchain.HashLen {
+		return cp, errors.New("Checkpoint hash has the wrong length")
+	}
+
+	cp.Height = uint32(height)
+	copy(cp.Hash[:], hashBytes)
+	return cp, nil
+}
+
+// LastCheckpoint returns the checkpoint with the greatest height, and false if
+// there are no checkpoints.
+func LastCheckpoint() (Checkpoint, bool) {
+	var last Checkpoint
+	found := false
+	for _, cp := range Checkpoints {
+		if !found || cp.Height > last.Height {
+			last = cp
+			found = true
+		}
+	}
+	return last, found
+}
+
+// verifyCheckpoint returns false if the block doesn't match the checkpoint at
+// its height, or if it is at or below the last checkpoint and would fork a main
+// chain that has already passed that checkpoint.
+func verifyCheckpoint(bc *blockchain.BlockChain, b *blockchain.Block) bool {
+	for _, cp := range Checkpoints {
+		if cp.Height == b.BlockNumber && cp.Hash != blockchain.HashSum(b) {
+			return false
+		}
+	}
+	last, ok := LastCheckpoint()
+	return !ok || b.BlockNumber > last.Height ||
+		uint32(len(bc.Blocks)) <= last.Height
+}
+
+// DeferBlock returns true if a block that extends the main chain should only be
+// added to the block tree for now, rather than connected. In fast validation
+// mode, blocks at or below the last checkpoint are held back until the
+// checkpoint block is known, so that they can be connected without checking
+// their signatures once they are known to lead to it.
+func DeferBlock(bc *blockchain.BlockChain, b *blockchain.Block) bool {
+	if !FastValidation {
+		return false
+	}
+	last, ok := LastCheckpoint()
+	return ok && b.BlockNumber <= last.Height && bc.GetKnownBlock(last.Hash) == nil
+}
+
+// skipSignatures returns true if signatures of transactions in a block don't
+// need to be verified. This is only the case in fast validation mode for
+// blocks in the block tree that the block at the last checkpoint builds on,
+// since the checkpoint hash then commits to their transactions.
+func skipSignatures(bc *blockchain.BlockChain, b *blockchain.Block) bool {
+	if !FastValidation {
+		return false
+	}
+	last, ok := LastCheckpoint()
+	return ok && b.BlockNumber <= last.Height &&
+		bc.IsAncestor(blockchain.HashSum(b), last.Hash)
+}
@@ -0,0 +1,133 @@
+package consensus
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	crand "crypto/rand"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+// setCheckpoints replaces the checkpoints and fast validation setting and
+// returns a function that restores the old ones.
+func setCheckpoints(cps []Checkpoint, fast bool) func() {
+	oldCheckpoints, oldFast := Checkpoints, FastValidation
+	Checkpoints, FastValidation = cps, fast
+	return func() {
+		Checkpoints, FastValidation = oldCheckpoints, oldFast
+	}
+}
+
+func TestParseCheckpoint(t *testing.T) {
+	hash := blockchain.NewTestHash()
+	cp, err := ParseCheckpoint(fmt.Sprintf("12:%s", hex.EncodeToString(hash[:])))
+	assert.Nil(t, err)
+	assert.Equal(t, Checkpoint{Height: 12, Hash: hash}, cp)
+
+	for _, s := range []string{"12", "x:00", "12:zz", "12:0000"} {
+		_, err = ParseCheckpoint(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestLastCheckpoint(t *testing.T) {
+	defer setCheckpoints([]Checkpoint{}, false)()
+	_, ok := LastCheckpoint()
+	assert.False(t, ok)
+
+	Checkpoints = []Checkpoint{{Height: 5}, {Height: 9}, {Height: 2}}
+	last, ok := LastCheckpoint()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(9), last.Height)
+}
+
+func TestVerifyBlockCheckpoint(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+
+	defer setCheckpoints([]Checkpoint{{b.BlockNumber, blockchain.HashSum(b)}}, false)()
+	valid, code := VerifyBlock(bc, b)
+	assert.True(t, valid)
+	assert.Equal(t, ValidBlock, code)
+
+	Checkpoints[0].Hash = blockchain.NewTestHash()
+	valid, code = VerifyBlock(bc, b)
+	assert.False(t, valid)
+	assert.Equal(t, BadCheckpoint, code)
+}
+
+func TestVerifyBlockHeaderForkBeforeCheckpoint(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+
+	// Build on the second last block instead of the tip.
+	b.LastBlock = blockchain.HashSum(bc.Blocks[len(bc.Blocks)-2])
+	b.BlockNumber = uint32(len(bc.Blocks)) - 1
+
+	tip := bc.LastBlock()
+	defer setCheckpoints([]Checkpoint{{tip.BlockNumber, blockchain.HashSum(tip)}}, false)()
+	valid, code := VerifyBlockHeader(bc, b)
+	assert.False(t, valid)
+	assert.Equal(t, BadCheckpoint, code)
+
+	// Forks after the last checkpoint are fine.
+	Checkpoints[0] = Checkpoint{0, blockchain.HashSum(bc.Blocks[0])}
+	valid, code = VerifyBlockHeader(bc, b)
+	assert.True(t, valid)
+	assert.Equal(t, ValidBlock, code)
+}
+
+func TestVerifyBlockFastValidation(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+
+	// Resign a transaction with the wrong wallet.
+	b.Transactions[1], _ = b.Transactions[1].TxBody.Sign(*blockchain.NewWallet(), crand.Reader)
+	b.UpdateMerkleRoot()
+
+	// Signatures are checked unless fast validation is on and the block is
+	// known to lead to the last checkpoint.
+	defer setCheckpoints([]Checkpoint{{b.BlockNumber, blockchain.HashSum(b)}}, false)()
+	valid, code := VerifyBlock(bc, b)
+	assert.False(t, valid)
+	assert.Equal(t, BadTransaction, code)
+
+	FastValidation = true
+	valid, code = VerifyBlock(bc, b)
+	assert.False(t, valid)
+	assert.Equal(t, BadTransaction, code)
+
+	bc.AddSideBlock(b)
+	valid, code = VerifyBlock(bc, b)
+	assert.True(t, valid)
+	assert.Equal(t, ValidBlock, code)
+
+	// A block below the last checkpoint that isn't known to lead to it is
+	// fully verified.
+	Checkpoints = []Checkpoint{{b.BlockNumber + 1, blockchain.NewTestHash()}}
+	valid, code = VerifyBlock(bc, b)
+	assert.False(t, valid)
+	assert.Equal(t, BadTransaction, code)
+
+	Checkpoints = []Checkpoint{{b.BlockNumber - 1, blockchain.HashSum(bc.LastBlock())}}
+	valid, code = VerifyBlock(bc, b)
+	assert.False(t, valid)
+	assert.Equal(t, BadTransaction, code)
+}
+
+func TestDeferBlock(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+
+	defer setCheckpoints([]Checkpoint{{b.BlockNumber, blockchain.HashSum(b)}}, false)()
+	assert.False(t, DeferBlock(bc, b))
+
+	// Blocks up to the checkpoint are held back until it is known.
+	FastValidation = true
+	assert.True(t, DeferBlock(bc, b))
+	bc.AddSideBlock(b)
+	assert.False(t, DeferBlock(bc, b))
+
+	Checkpoints[0].Height--
+	Checkpoints[0].Hash = blockchain.NewTestHash()
+	assert.False(t, DeferBlock(bc, b))
+}
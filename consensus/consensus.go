@@ -10,49 +10,159 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/ubclaunchpad/cumulus/blockchain"
 	c "github.com/ubclaunchpad/cumulus/common/constants"
+	"github.com/ubclaunchpad/cumulus/script"
 )
 
-// VerifyTransaction tests whether a transaction valid.
+// VerifyTransaction tests whether a transaction valid and can be included in
+// the next block.
 func VerifyTransaction(bc *blockchain.BlockChain,
 	t *blockchain.Transaction) (bool, TransactionCode) {
+	view := bc.NewUTXOView()
+	if valid, code := verifyTransaction(view, t, true); !valid {
+		return false, code
+	}
+	return VerifyLocks(view, t, uint32(len(bc.Blocks)),
+		MedianTimePast(bc, bc.LastBlock()))
+}
+
+// VerifyPendingTransaction tests whether a transaction is valid with respect
+// to the given view, so it may spend the outputs of unconfirmed transactions
+// that have been applied to the view. The transaction's locks are not checked,
+// so it may not be final yet.
+func VerifyPendingTransaction(v *blockchain.UTXOView,
+	t *blockchain.Transaction) (bool, TransactionCode) {
+	return verifyTransaction(v, t, true)
+}
+
+// verifyTransaction tests whether a transaction is valid with respect to the
+// given view, only verifying its signature if checkSig is true.
+func verifyTransaction(v *blockchain.UTXOView, t *blockchain.Transaction,
+	checkSig bool) (bool, TransactionCode) {
 
 	// Check if the transaction is equal to nil
 	if t == nil {
 		return false, NilTransaction
 	}
 
-	// Find the transaction input in the chain (by hash)
-	inputs, err := bc.GetAllInputs(t)
-	if err != nil || len(inputs) == 0 {
+	// A transaction must spend at least one input.
+	if len(t.Inputs) == 0 {
 		return false, NoInputTransactions
 	}
 
-	// Check that output to sender in input is equal to outputs in t
-	out := t.GetTotalOutput()
-	in, err := t.GetTotalInput(bc)
-	if out != in {
+	// A transaction with relative locks must have one for each input.
+	if len(t.RelativeLocks) > 0 && len(t.RelativeLocks) != len(t.Inputs) {
+		return false, BadRelativeLocks
+	}
+
+	// Look up the output each input spends in the set of unspent outputs. If
+	// an input isn't there, either it doesn't exist, it isn't to the sender or
+	// it has already been spent. Each output can only be spent once.
+	sender := t.From()
+	in := uint64(0)
+	spent := make(map[blockchain.TxHashPointer]bool, len(t.Inputs))
+	for _, input := range t.Inputs {
+		resolved := v.Resolve(input)
+		if spent[resolved] {
+			return false, Respend
+		}
+		spent[resolved] = true
+
+		amount, unspent := v.UnspentOutput(input, sender)
+		if !unspent {
+			output, ok := input.Spends(v.GetInputTransaction(&input))
+			if !ok || output.Recipient != sender {
+				return false, NoInputTransactions
+			}
+			return false, Respend
+		}
+		var ok bool
+		if in, ok = blockchain.AddAmount(in, amount); !ok {
+			return false, AmountOverflow
+		}
+	}
+
+	// Check that the outputs in t don't exceed the input to the sender. Any
+	// input that isn't spent is a fee for the miner.
+	out, err := t.GetTotalOutput()
+	if err != nil {
+		return false, AmountOverflow
+	}
+	if out > in {
 		return false, Overspend
 	}
 
+	// Outputs locked by a script must be sent to the script's address.
+	for _, output := range t.Outputs {
+		if len(output.Script) > script.MaxScriptSize ||
+			(len(output.Script) > 0 && output.Recipient != blockchain.ScriptRepr(output.Script)) {
+			return false, BadScript
+		}
+	}
+
 	// Verify signature of t.
+	if checkSig {
+		return verifySignatures(t)
+	}
+
+	return true, ValidTransaction
+}
+
+// verifySignatures tests whether t is signed by its sender, or by enough of
+// the keys of the multisig address it spends from, or meets the conditions of
+// the script address it spends from.
+func verifySignatures(t *blockchain.Transaction) (bool, TransactionCode) {
+	if len(t.Script) > 0 {
+		return verifyScripts(t)
+	}
+	if len(t.Unlocks) > 0 {
+		return false, BadScript
+	}
+
 	hash := blockchain.HashSum(t.TxBody)
-	if !ecdsa.Verify(t.Sender.Key(), hash.Marshal(), t.Sig.R, t.Sig.S) {
+	if t.Multisig == nil {
+		if len(t.Sigs) > 0 || !ecdsa.Verify(t.Sender.Key(), hash.Marshal(), t.Sig.R, t.Sig.S) {
+			return false, BadSig
+		}
+		return true, ValidTransaction
+	}
+
+	// Transactions from multisig addresses are only signed by its keys.
+	if !t.Multisig.Valid() ||
+		!reflect.DeepEqual(t.Sender, blockchain.NilAddr) ||
+		!reflect.DeepEqual(t.Sig, blockchain.NilSig) {
+		return false, BadMultisig
+	}
+	if !t.Multisig.Verify(hash, t.Sigs) {
 		return false, BadSig
 	}
+	return true, ValidTransaction
+}
 
-	// Test if inputs already spent elsewhere.
-	start, _ := bc.GetBlockRange(t)
-	if t.InputsSpentElsewhere(bc, start) {
-		return false, Respend
+// verifyScripts tests whether the unlocking script of each input of t, run
+// before the locking script of the address t spends from, succeeds.
+func verifyScripts(t *blockchain.Transaction) (bool, TransactionCode) {
+	// Transactions from script addresses are only unlocked by their scripts.
+	if t.Multisig != nil || len(t.Sigs) > 0 ||
+		len(t.Unlocks) != len(t.Inputs) ||
+		!reflect.DeepEqual(t.Sender, blockchain.NilAddr) ||
+		!reflect.DeepEqual(t.Sig, blockchain.NilSig) {
+		return false, BadScript
 	}
 
+	for i, unlock := range t.Unlocks {
+		ctx := script.NewContext(t.TxBody, i)
+		if err := script.Execute(unlock, t.Script, ctx); err != nil {
+			log.WithError(err).Debug("Script failed")
+			return false, ScriptFailed
+		}
+	}
 	return true, ValidTransaction
 }
 
 // VerifyCloudBase returns true if a transaction is a valid CloudBase transaction
-// and false otherwise
+// that claims the block reward plus the given fees, and false otherwise
 func VerifyCloudBase(bc *blockchain.BlockChain,
-	t *blockchain.Transaction) (bool, CloudBaseTransactionCode) {
+	t *blockchain.Transaction, fees uint64) (bool, CloudBaseTransactionCode) {
 
 	// Check if the CloudBase transaction is equal to nil.
 	if t == nil {
@@ -69,18 +179,17 @@ func VerifyCloudBase(bc *blockchain.BlockChain,
 	if len(input) != 1 ||
 		input[0].BlockNumber != 0 ||
 		input[0].Hash != blockchain.NilHash ||
-		input[0].Index != 0 {
+		input[0].Index != 0 ||
+		input[0].Output != 0 {
 		return false, BadCloudBaseInput
 	}
 
 	// Search for the block associated with the CloudBase transaction. If the
 	// transaction is not found, then it is to be added to the next block in the
 	// blockchain.
-	var i int
-	for i = 0; i < len(bc.Blocks); i++ {
-		if b, _ := bc.Blocks[i].ContainsTransaction(t); b {
-			break
-		}
+	i := len(bc.Blocks)
+	if ok, n, _ := bc.ContainsTransaction(t, 0, uint32(i)); ok {
+		i = int(n)
 	}
 
 	// Determine the reward associated with that specific block.
@@ -93,7 +202,8 @@ func VerifyCloudBase(bc *blockchain.BlockChain,
 	}
 
 	// Check that the reward is properly set.
-	if t.Outputs[0].Amount != reward {
+	total, ok := blockchain.AddAmount(reward, fees)
+	if !ok || t.Outputs[0].Amount != total {
 		return false, BadCloudBaseReward
 	}
 
@@ -131,15 +241,13 @@ func VerifyGenesisBlock(bc *blockchain.BlockChain,
 	}
 
 	// Check if the transaction is a valid cloud base transaction.
-	if valid, code := VerifyCloudBase(bc, gb.Transactions[0]); !valid {
+	if valid, code := VerifyCloudBase(bc, gb.Transactions[0], 0); !valid {
 		log.Errorf("Invalid CloudBase, CloudBaseTransactionCode: %d", code)
 		return false, BadGenesisCloudBaseTransaction
 	}
 
-	// Check that the target is within the min and max difficulty levels.
-	// TODO: CurrentTarget() is used because the difficulty is static for v1 of
-	// cumulus. Once the difficulty is dynamic, the target would need to be
-	// compared to the target calculated for that specific block.
+	// Check that the target is within the min and max difficulty levels and
+	// matches the starting difficulty.
 	target := blockchain.HashToBigInt(gb.Target)
 	if target.Cmp(c.MaxTarget) == 1 ||
 		target.Cmp(c.MinTarget) == -1 ||
@@ -186,36 +294,81 @@ func VerifyBlock(bc *blockchain.BlockChain,
 		return false, BadBlockNumber
 	}
 
-	// Check that the first transaction is a CloudBase transaction
-	if valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction()); !valid {
+	// Check for multiple transactions referencing same input transaction,
+	// where the sender is the same.
+	inputSets := map[string]*set.Set{}
+	for _, txn := range b.Transactions {
+
+		// We'll inspect these inputs next.
+		nextInputSet := txn.InputSet()
+
+		// If the sender already exists in the map, check for
+		// a non-empty intersection in inputs.
+		if inSet, ok := inputSets[txn.From()]; ok {
+			if !set.Intersection(inSet, nextInputSet).IsEmpty() {
+				return false, DoubleSpend
+			}
+
+			// No intersection, but more inputs to add to sender.
+			inputSets[txn.From()].Merge(nextInputSet)
+
+		} else {
+			// First time seeing sender, give them inputs.
+			inputSets[txn.From()] = nextInputSet
+		}
+	}
+
+	// Check that the first transaction is a CloudBase transaction that claims
+	// the fees of the other transactions
+	fees, err := b.GetTotalFees(bc)
+	if err != nil {
+		log.WithError(err).Error("Failed to compute block fees")
+		return false, BadTransaction
+	}
+	if valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction(), fees); !valid {
 		log.Errorf("Invalid CloudBase, CloudBaseTransactionCode: %d", code)
 		return false, BadCloudBaseTransaction
 	}
 
-	// Verify every Transaction in the block.
+	// Check that the block matches our checkpoints.
+	if !verifyCheckpoint(bc, b) {
+		return false, BadCheckpoint
+	}
+
+	// Verify every Transaction in the block. Transactions may spend the
+	// outputs of transactions before them in the block. Signatures of blocks
+	// leading to the last checkpoint are not checked in fast validation mode.
+	checkSigs := !skipSignatures(bc, b)
+	view := bc.NewUTXOView()
+	median := MedianTimePast(bc, lastBlock)
 	for _, t := range b.Transactions[1:] {
-		if valid, code := VerifyTransaction(bc, t); !valid {
+		valid, code := verifyTransaction(view, t, checkSigs)
+		if valid {
+			valid, code = VerifyLocks(view, t, b.BlockNumber, median)
+		}
+		if !valid {
 			log.Errorf("Invalid Transaction, TransactionCode: %d", code)
 			return false, BadTransaction
 		}
+		view.Apply(t)
 	}
 
-	// Check that the target is within the min and max difficulty levels and
-	// that the target is correct.
-	// TODO: CurrentTarget() is used because the difficulty is static for v1 of
-	// cumulus. Once the difficulty is dynamic, the target would need to be
-	// compared to the target calculated for that specific block.
-	target := blockchain.HashToBigInt(b.Target)
-	if target.Cmp(blockchain.HashToBigInt(CurrentTarget())) != 0 {
+	// Check that the target is the one expected at this point in the chain.
+	if b.Target != NextTarget(bc, lastBlock) {
 		return false, BadTarget
 	}
 
-	// Check that time is not greater than current time or equal to 0
-	// TODO: check if time is the current time
+	// Check that time is not equal to 0
 	if b.Time == 0 {
 		return false, BadTime
 	}
 
+	// Check that time is after the median time of the last few blocks and not
+	// too far in the future.
+	if valid, code := verifyBlockTime(bc, lastBlock, b); !valid {
+		return false, code
+	}
+
 	// Check that hash of last block is correct
 	if blockchain.HashSum(lastBlock) != b.LastBlock {
 		return false, BadHash
@@ -226,29 +379,93 @@ func VerifyBlock(bc *blockchain.BlockChain,
 		return false, BadNonce
 	}
 
-	// Check for multiple transactions referencing same input transaction,
-	// where the sender is the same.
-	inputSets := map[blockchain.Address]*set.Set{}
-	for _, txn := range b.Transactions {
+	// Check that the header commits to the block's transactions.
+	if blockchain.MerkleRoot(b.Transactions) != b.MerkleRoot {
+		return false, BadMerkleRoot
+	}
 
-		// We'll inspect these inputs next.
-		nextInputSet := txn.InputSet()
+	return true, ValidBlock
+}
 
-		// If the sender already exists in the map, check for
-		// a non-empty intersection in inputs.
-		if inSet, ok := inputSets[txn.Sender]; ok {
-			if !set.Intersection(inSet, nextInputSet).IsEmpty() {
-				return false, DoubleSpend
-			}
+// VerifyBlockHeader checks the parts of a block that can be verified without
+// connecting it to the main chain. This is used to decide whether a block that
+// builds on a side branch of the block tree is worth keeping. The block must
+// extend a known block, follow its block number, have a valid target and time,
+// and meet its proof of work. Its transactions are only verified when the
+// block is connected to the main chain with VerifyBlock.
+func VerifyBlockHeader(bc *blockchain.BlockChain,
+	b *blockchain.Block) (bool, BlockCode) {
+	// Check if the block is equal to nil.
+	if b == nil {
+		return false, NilBlock
+	}
 
-			// No intersection, but more inputs to add to sender.
-			inputSets[txn.Sender].Merge(nextInputSet)
+	// A genesis block can never build on another block.
+	if b.BlockNumber == 0 {
+		return false, BadGenesisBlock
+	}
 
-		} else {
-			// First time seeing sender, give them inputs.
-			inputSets[txn.Sender] = nextInputSet
-		}
+	// Check that the block builds on a block we know about.
+	parent := bc.GetKnownBlock(b.LastBlock)
+	if parent == nil {
+		return false, UnknownParent
 	}
 
+	// Check that block number is one greater than its parent's.
+	if b.BlockNumber != parent.BlockNumber+1 {
+		return false, BadBlockNumber
+	}
+
+	// Check that the block matches our checkpoints and doesn't fork the main
+	// chain before one.
+	if !verifyCheckpoint(bc, b) {
+		return false, BadCheckpoint
+	}
+
+	// Check that the target is the one expected at this point in the branch.
+	if b.Target != NextTarget(bc, parent) {
+		return false, BadTarget
+	}
+
+	// Check that time is not equal to 0.
+	if b.Time == 0 {
+		return false, BadTime
+	}
+
+	// Check that time is after the median time of the last few blocks on the
+	// branch and not too far in the future.
+	if valid, code := verifyBlockTime(bc, parent, b); !valid {
+		return false, code
+	}
+
+	// Verify proof of work
+	if !blockchain.HashSum(b).LessThan(b.Target) {
+		return false, BadNonce
+	}
+
+	// Every block must start with a CloudBase transaction.
+	if len(b.Transactions) == 0 {
+		return false, BadCloudBaseTransaction
+	}
+
+	// Check that the header commits to the block's transactions.
+	if blockchain.MerkleRoot(b.Transactions) != b.MerkleRoot {
+		return false, BadMerkleRoot
+	}
+
+	return true, ValidBlock
+}
+
+// verifyBlockTime checks that the time of a block building on parent is after
+// the median time past of parent and no more than MaxFutureDrift seconds ahead
+// of our clock.
+func verifyBlockTime(bc *blockchain.BlockChain, parent *blockchain.Block,
+	b *blockchain.Block) (bool, BlockCode) {
+	if b.Time <= MedianTimePast(bc, parent) {
+		return false, TimeBeforeMedian
+	}
+	if uint64(b.Time) > uint64(now())+uint64(MaxFutureDrift) {
+		return false, TimeTooFarAhead
+	}
 	return true, ValidBlock
 }
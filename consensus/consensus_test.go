@@ -1,6 +1,7 @@
 package consensus
 
 import (
+	"math"
 	"math/rand"
 	"testing"
 
@@ -35,8 +36,7 @@ func TestVerifyTransactionNoInputTransaction(t *testing.T) {
 
 func TestVerifyTransactionOverspend(t *testing.T) {
 	// 2 + 2 = 5 ?
-	bc, _ := blockchain.NewValidBlockChainFixture()
-	tr := bc.Blocks[1].Transactions[1]
+	bc, tr := blockchain.NewValidChainAndTxn()
 	tr.Outputs[0].Amount = 5
 
 	valid, code := VerifyTransaction(bc, tr)
@@ -45,6 +45,29 @@ func TestVerifyTransactionOverspend(t *testing.T) {
 	assert.Equal(t, code, Overspend)
 }
 
+func TestVerifyTransactionOutputOverflow(t *testing.T) {
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	alice := wallets["alice"]
+
+	// Outputs that wrap around to less than alice's 3 coins can't mint coins.
+	txn, _ := blockchain.TxBody{
+		Sender: alice.Public(),
+		Inputs: []blockchain.TxHashPointer{{
+			BlockNumber: 1,
+			Index:       1,
+			Hash:        blockchain.HashSum(bc.Blocks[1].Transactions[1]),
+		}},
+		Outputs: []blockchain.TxOutput{
+			{Amount: math.MaxUint64, Recipient: wallets["bob"].Public().Repr()},
+			{Amount: 1, Recipient: alice.Public().Repr()},
+		},
+	}.Sign(*alice, crand.Reader)
+
+	valid, code := VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, AmountOverflow, code)
+}
+
 func TestVerifyTransactionSignatureFail(t *testing.T) {
 	bc, txn := blockchain.NewValidChainAndTxn()
 
@@ -77,6 +100,83 @@ func TestTransactionRespend(t *testing.T) {
 	assert.Equal(t, code, Respend)
 }
 
+func TestVerifyTransactionDuplicateInput(t *testing.T) {
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	alice := wallets["alice"]
+
+	// Alice can't spend her 3 coins twice in one transaction.
+	input := blockchain.TxHashPointer{
+		BlockNumber: 1,
+		Index:       1,
+		Hash:        blockchain.HashSum(bc.Blocks[1].Transactions[1]),
+	}
+	txn, _ := blockchain.TxBody{
+		Sender:  alice.Public(),
+		Inputs:  []blockchain.TxHashPointer{input, input},
+		Outputs: []blockchain.TxOutput{{Amount: 6, Recipient: alice.Public().Repr()}},
+	}.Sign(*alice, crand.Reader)
+
+	valid, code := VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, Respend, code)
+}
+
+func TestVerifyTransactionSpendsOneOutput(t *testing.T) {
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	sender := wallets["sender"]
+
+	// Roll back to where the sender has two outputs of 2 coins from the same
+	// transaction.
+	bc.RollBack()
+	bc.RollBack()
+	tA := blockchain.HashSum(bc.Blocks[0].Transactions[1])
+	input := blockchain.TxHashPointer{BlockNumber: 0, Hash: tA, Index: 1, Output: 1}
+	body := blockchain.TxBody{
+		Sender:  sender.Public(),
+		Inputs:  []blockchain.TxHashPointer{input},
+		Outputs: []blockchain.TxOutput{{Amount: 2, Recipient: "badf00d"}},
+	}
+	txn, _ := body.Sign(*sender, crand.Reader)
+	valid, code := VerifyTransaction(bc, txn)
+	assert.True(t, valid)
+	assert.Equal(t, ValidTransaction, code)
+
+	// One input is worth only the output it spends.
+	body.Outputs[0].Amount = 3
+	txn, _ = body.Sign(*sender, crand.Reader)
+	valid, code = VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, Overspend, code)
+
+	// The same output can't be spent twice by one transaction.
+	body.Inputs = []blockchain.TxHashPointer{input, input}
+	txn, _ = body.Sign(*sender, crand.Reader)
+	valid, code = VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, Respend, code)
+}
+
+func TestVerifyTransactionOtherRecipientsOutput(t *testing.T) {
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	alice := wallets["alice"]
+
+	// The second output of the transaction in block 1 is the sender's change,
+	// which alice can't spend.
+	txn, _ := blockchain.TxBody{
+		Sender: alice.Public(),
+		Inputs: []blockchain.TxHashPointer{{
+			BlockNumber: 1,
+			Hash:        blockchain.HashSum(bc.Blocks[1].Transactions[1]),
+			Index:       1,
+			Output:      1,
+		}},
+		Outputs: []blockchain.TxOutput{{Amount: 1, Recipient: "badf00d"}},
+	}.Sign(*alice, crand.Reader)
+	valid, code := VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, NoInputTransactions, code)
+}
+
 // VerifyBlock Tests
 
 func TestVerifyBlockNilBlock(t *testing.T) {
@@ -90,10 +190,12 @@ func TestVerifyBlockNilBlock(t *testing.T) {
 
 func TestVerifyBlockBadNonce(t *testing.T) {
 	bc, b := blockchain.NewValidTestChainAndBlock()
-	b.Target = blockchain.BigIntToHash(c.Big1)
-	CurrentDifficulty = c.MaxTarget
+
+	// Make the expected target as hard as possible.
+	bc.Blocks[2].Target = blockchain.BigIntToHash(c.Big1)
+	b.LastBlock = blockchain.HashSum(bc.Blocks[2])
+	b.Target = bc.Blocks[2].Target
 	valid, code := VerifyBlock(bc, b)
-	CurrentDifficulty = c.MinTarget
 
 	assert.False(t, valid)
 	assert.Equal(t, code, BadNonce)
@@ -164,6 +266,84 @@ func TestVerifyBlockBadTime(t *testing.T) {
 	}
 }
 
+func TestVerifyBlockWithFees(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlockWithFee(1)
+
+	valid, txnCode := VerifyTransaction(bc, b.Transactions[1])
+	assert.True(t, valid)
+	assert.Equal(t, ValidTransaction, txnCode)
+
+	valid, code := VerifyBlock(bc, b)
+	assert.True(t, valid)
+	assert.Equal(t, ValidBlock, code)
+
+	// The CloudBase transaction must claim exactly the reward and the fees.
+	b.Transactions[0].Outputs[0].Amount--
+	b.UpdateMerkleRoot()
+	valid, code = VerifyBlock(bc, b)
+	assert.False(t, valid)
+	assert.Equal(t, BadCloudBaseTransaction, code)
+}
+
+func TestVerifyPendingTransaction(t *testing.T) {
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+
+	valid, code := VerifyTransaction(bc, child)
+	assert.False(t, valid)
+	assert.Equal(t, NoInputTransactions, code)
+
+	view := bc.NewUTXOView()
+	view.Apply(parent)
+	valid, code = VerifyPendingTransaction(view, child)
+	assert.True(t, valid)
+	assert.Equal(t, ValidTransaction, code)
+}
+
+func TestVerifyBlockChainedTransactions(t *testing.T) {
+	_, b := blockchain.NewValidTestChainAndBlock()
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+	b.LastBlock = blockchain.HashSum(bc.LastBlock())
+	b.Time = bc.LastBlock().Time + 60
+	b.Transactions = []*blockchain.Transaction{b.Transactions[0], parent, child}
+	b.UpdateMerkleRoot()
+
+	valid, code := VerifyBlock(bc, b)
+	assert.True(t, valid)
+	assert.Equal(t, ValidBlock, code)
+
+	// A transaction can't come before the one it spends from.
+	b.Transactions[1], b.Transactions[2] = child, parent
+	b.UpdateMerkleRoot()
+	valid, code = VerifyBlock(bc, b)
+	assert.False(t, valid)
+	assert.Equal(t, BadTransaction, code)
+}
+
+func TestVerifyBlockTimeBeforeMedian(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+	b.Time = MedianTimePast(bc, bc.LastBlock())
+
+	valid, code := VerifyBlock(bc, b)
+
+	assert.False(t, valid)
+	assert.Equal(t, TimeBeforeMedian, code)
+}
+
+func TestVerifyBlockTimeTooFarAhead(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+	b.Time = util.UnixNow() + MaxFutureDrift + 60
+
+	valid, code := VerifyBlock(bc, b)
+
+	assert.False(t, valid)
+	assert.Equal(t, TimeTooFarAhead, code)
+
+	valid, code = VerifyBlockHeader(bc, b)
+
+	assert.False(t, valid)
+	assert.Equal(t, TimeTooFarAhead, code)
+}
+
 func TestVerifyBlockBadTarget(t *testing.T) {
 	bc, b := blockchain.NewValidTestChainAndBlock()
 	b.Target = blockchain.BigIntToHash(util.BigAdd(c.MaxTarget, c.Big1))
@@ -212,6 +392,16 @@ func TestBlockDoubleSpend(t *testing.T) {
 	assert.Equal(t, code, DoubleSpend)
 }
 
+func TestVerifyBlockBadMerkleRoot(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+	b.Transactions[1], b.Transactions[2] = b.Transactions[2], b.Transactions[1]
+
+	valid, code := VerifyBlock(bc, b)
+
+	assert.False(t, valid)
+	assert.Equal(t, BadMerkleRoot, code)
+}
+
 func TestVerifyBlockBigNumber(t *testing.T) {
 	bc, b := blockchain.NewValidTestChainAndBlock()
 	b.BlockNumber = uint32(len(bc.Blocks)) + 1
@@ -240,11 +430,44 @@ func TestVerifyBlockBadCloudBaseTransaction(t *testing.T) {
 	}
 }
 
+func TestVerifyBlockHeader(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+
+	// Build on the second last block instead of the tip.
+	b.LastBlock = blockchain.HashSum(bc.Blocks[len(bc.Blocks)-2])
+	b.BlockNumber = uint32(len(bc.Blocks)) - 1
+
+	valid, code := VerifyBlockHeader(bc, b)
+
+	assert.True(t, valid)
+	assert.Equal(t, ValidBlock, code)
+}
+
+func TestVerifyBlockHeaderUnknownParent(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+	b.LastBlock = blockchain.NewTestHash()
+
+	valid, code := VerifyBlockHeader(bc, b)
+
+	assert.False(t, valid)
+	assert.Equal(t, UnknownParent, code)
+}
+
+func TestVerifyBlockHeaderBadBlockNumber(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+	b.BlockNumber++
+
+	valid, code := VerifyBlockHeader(bc, b)
+
+	assert.False(t, valid)
+	assert.Equal(t, BadBlockNumber, code)
+}
+
 // VerifyCloudBase Tests
 
 func TestVerifyCloudBaseNilCloudBase(t *testing.T) {
 	bc, _ := blockchain.NewValidTestChainAndBlock()
-	valid, code := VerifyCloudBase(bc, nil)
+	valid, code := VerifyCloudBase(bc, nil, 0)
 
 	if valid {
 		t.Fail()
@@ -259,7 +482,7 @@ func TestVerifyCloudBaseBadCloudBaseBlockReward(t *testing.T) {
 	b := bc.Blocks[0]
 	b.Transactions[0].Outputs[0].Amount = CurrentBlockReward(bc) + 1
 
-	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 
 	if valid {
 		t.Fail()
@@ -271,10 +494,21 @@ func TestVerifyCloudBaseBadCloudBaseBlockReward(t *testing.T) {
 
 }
 
+func TestVerifyCloudBaseFeeOverflow(t *testing.T) {
+	bc, _ := blockchain.NewValidBlockChainFixture()
+	b := bc.Blocks[0]
+
+	// The reward plus fees can't wrap around to a smaller reward.
+	b.Transactions[0].Outputs[0].Amount = CurrentBlockReward(bc) - 1
+	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction(), math.MaxUint64)
+	assert.False(t, valid)
+	assert.Equal(t, BadCloudBaseReward, code)
+}
+
 func TestVerifyCloudBaseTransaction(t *testing.T) {
 	bc, _ := blockchain.NewValidBlockChainFixture()
 	b := bc.Blocks[0]
-	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 
 	if !valid {
 		t.Fail()
@@ -289,7 +523,7 @@ func TestVerifyCloudBaseBadSender(t *testing.T) {
 	bc, _ := blockchain.NewValidBlockChainFixture()
 	b := bc.Blocks[0]
 	b.GetCloudBaseTransaction().Sender = w.Public()
-	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 
 	if valid {
 		t.Fail()
@@ -303,7 +537,7 @@ func TestVerifyCloudBaseBadBadInput(t *testing.T) {
 	bc, _ := blockchain.NewValidBlockChainFixture()
 	b := bc.Blocks[0]
 	b.GetCloudBaseTransaction().TxBody.Inputs[0].BlockNumber = 1
-	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 
 	if valid {
 		t.Fail()
@@ -315,7 +549,7 @@ func TestVerifyCloudBaseBadBadInput(t *testing.T) {
 	bc, _ = blockchain.NewValidBlockChainFixture()
 	b = bc.Blocks[0]
 	b.GetCloudBaseTransaction().TxBody.Inputs[0].Hash = blockchain.NewTestHash()
-	valid, code = VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code = VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 
 	if valid {
 		t.Fail()
@@ -327,7 +561,7 @@ func TestVerifyCloudBaseBadBadInput(t *testing.T) {
 	bc, _ = blockchain.NewValidBlockChainFixture()
 	b = bc.Blocks[0]
 	b.GetCloudBaseTransaction().TxBody.Inputs[0].Index = 1
-	valid, code = VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code = VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 
 	if valid {
 		t.Fail()
@@ -349,7 +583,7 @@ func TestVerifyCloudBaseBadOutput(t *testing.T) {
 				Recipient: w.Public().Repr(),
 			},
 		)
-	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 
 	if valid {
 		t.Fail()
@@ -362,7 +596,7 @@ func TestVerifyCloudBaseBadOutput(t *testing.T) {
 	b = bc.Blocks[0]
 	var emptyOutputs []blockchain.TxOutput
 	b.GetCloudBaseTransaction().Outputs = emptyOutputs
-	valid, code = VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code = VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 
 	if valid {
 		t.Fail()
@@ -374,7 +608,7 @@ func TestVerifyCloudBaseBadOutput(t *testing.T) {
 	bc, _ = blockchain.NewValidBlockChainFixture()
 	b = bc.Blocks[0]
 	b.GetCloudBaseTransaction().Outputs[0].Recipient = blockchain.NilAddr.Repr()
-	valid, code = VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code = VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 
 	if valid {
 		t.Fail()
@@ -390,7 +624,7 @@ func TestVerifyCloudBaseBadSig(t *testing.T) {
 	w := blockchain.NewWallet()
 	b.GetCloudBaseTransaction().Sig, _ =
 		w.Sign(blockchain.NewTestHash(), crand.Reader)
-	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 
 	if valid {
 		t.Fail()
@@ -412,7 +646,7 @@ func TestVerifiyCloudBaseMultiInputs(t *testing.T) {
 	})
 
 	// Should fail with multi-inputs.
-	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 	assert.False(t, valid)
 	assert.Equal(t, code, BadCloudBaseInput)
 }
@@ -424,7 +658,7 @@ func TestVerifiyCloudBaseNoInputs(t *testing.T) {
 	b.Transactions[0].Inputs = []blockchain.TxHashPointer{}
 
 	// Should fail with multi-inputs.
-	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction())
+	valid, code := VerifyCloudBase(bc, b.GetCloudBaseTransaction(), 0)
 	assert.False(t, valid)
 	assert.Equal(t, code, BadCloudBaseInput)
 }
@@ -622,3 +856,43 @@ func TestVerifyGenesisBlockBadGenesisTime(t *testing.T) {
 func RandomUint64() uint64 {
 	return uint64(rand.Uint32())<<32 + uint64(rand.Uint32())
 }
+
+func TestVerifyTransactionMultisig(t *testing.T) {
+	ms, cosigners := blockchain.NewTestMultisig()
+	bc, body, _ := blockchain.NewValidTestChainAndSpend(&blockchain.TxOutput{Recipient: ms.Repr()})
+	body.Multisig = ms
+	txn, _ := blockchain.NewMultisigTransaction(body)
+
+	// One signature is not enough to spend from a 2-of-3 address.
+	txn.AddSignature(cosigners[1], crand.Reader)
+	valid, code := VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, BadSig, code)
+
+	txn.AddSignature(cosigners[2], crand.Reader)
+	valid, code = VerifyTransaction(bc, txn)
+	assert.True(t, valid)
+	assert.Equal(t, ValidTransaction, code)
+
+	// A single key can't spend from the address by signing as the sender.
+	txn.Sig, _ = cosigners[0].Sign(blockchain.HashSum(txn.TxBody), crand.Reader)
+	valid, code = VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, BadMultisig, code)
+}
+
+func TestVerifyTransactionMultisigWrongAddress(t *testing.T) {
+	ms, cosigners := blockchain.NewTestMultisig()
+	bc, body, _ := blockchain.NewValidTestChainAndSpend(&blockchain.TxOutput{Recipient: ms.Repr()})
+	body.Multisig = ms
+	txn, _ := blockchain.NewMultisigTransaction(body)
+
+	// The outputs to one multisig address can't be spent by another.
+	ms, _ = blockchain.NewMultisig(1, cosigners[0].Public())
+	txn.Multisig = ms
+	txn.Sigs = []blockchain.Signature{blockchain.NilSig}
+	txn.AddSignature(cosigners[0], crand.Reader)
+	valid, code := VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, NoInputTransactions, code)
+}
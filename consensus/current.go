@@ -3,14 +3,34 @@ package consensus
 import (
 	"math"
 	"math/big"
+	"reflect"
+	"sort"
 
 	"github.com/ubclaunchpad/cumulus/blockchain"
 	c "github.com/ubclaunchpad/cumulus/common/constants"
+	"github.com/ubclaunchpad/cumulus/common/util"
 )
 
 var (
-	// CurrentDifficulty is the current hashing difficulty of the network
+	// CurrentDifficulty is the hashing difficulty of the genesis block. The
+	// difficulty of every later block is derived from it by NextTarget.
 	CurrentDifficulty = c.MinTarget
+	// RetargetInterval is the number of blocks between difficulty
+	// adjustments. It must be at least 2.
+	RetargetInterval uint32 = 100
+	// TargetBlockTime is the number of seconds we aim to have between blocks.
+	TargetBlockTime uint32 = 60
+	// MaxRetargetFactor is the largest factor by which the target can grow or
+	// shrink in a single adjustment.
+	MaxRetargetFactor int64 = 4
+	// MedianTimeSpan is the number of blocks whose median timestamp a new
+	// block's timestamp must be greater than.
+	MedianTimeSpan = 11
+	// MaxFutureDrift is the number of seconds a block's timestamp may be ahead
+	// of our clock.
+	MaxFutureDrift uint32 = 2 * 60 * 60
+	// now returns the current time according to our clock.
+	now = util.UnixNow
 )
 
 // CurrentBlockReward determines the current block reward using the
@@ -20,7 +40,8 @@ func CurrentBlockReward(bc *blockchain.BlockChain) uint64 {
 	return blockchain.StartingBlockReward / uint64(math.Pow(float64(2), timesHalved))
 }
 
-// CurrentTarget returns the current target based on the CurrentDifficulty
+// CurrentTarget returns the target of the genesis block based on the
+// CurrentDifficulty
 func CurrentTarget() blockchain.Hash {
 	return blockchain.BigIntToHash(
 		new(big.Int).Div(
@@ -29,3 +50,82 @@ func CurrentTarget() blockchain.Hash {
 		),
 	)
 }
+
+// NextTarget returns the target that a block building on parent must have.
+// The target only changes every RetargetInterval blocks, when it is scaled by
+// how long the previous RetargetInterval blocks took to mine compared to how
+// long they should have taken. The scaling factor is clamped to
+// MaxRetargetFactor either way, and the result to the min and max targets.
+func NextTarget(bc *blockchain.BlockChain, parent *blockchain.Block) blockchain.Hash {
+	if parent == nil {
+		return CurrentTarget()
+	}
+	height := parent.BlockNumber + 1
+	if height%RetargetInterval != 0 {
+		return parent.Target
+	}
+
+	first := ancestor(bc, parent, height-RetargetInterval)
+	if first == nil {
+		// We don't know enough about the branch to retarget.
+		return parent.Target
+	}
+
+	// Measure the time taken to mine the window and clamp it so the target
+	// can't move too far in one go.
+	expected := int64(RetargetInterval-1) * int64(TargetBlockTime)
+	actual := int64(parent.Time) - int64(first.Time)
+	if actual < expected/MaxRetargetFactor {
+		actual = expected / MaxRetargetFactor
+	} else if actual > expected*MaxRetargetFactor {
+		actual = expected * MaxRetargetFactor
+	}
+
+	target := blockchain.HashToBigInt(parent.Target)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(c.MaxTarget) > 0 {
+		target = c.MaxTarget
+	} else if target.Cmp(c.MinTarget) < 0 {
+		target = c.MinTarget
+	}
+	return blockchain.BigIntToHash(target)
+}
+
+// MedianTimePast returns the median timestamp of the last MedianTimeSpan
+// blocks on the branch of the block tree ending at parent, or fewer if the
+// branch is shorter. A block building on parent must have a later timestamp.
+// Returns 0 if parent is nil.
+func MedianTimePast(bc *blockchain.BlockChain, parent *blockchain.Block) uint32 {
+	times := make([]uint32, 0, MedianTimeSpan)
+	for b := parent; b != nil && len(times) < MedianTimeSpan; {
+		times = append(times, b.Time)
+		if b.BlockNumber == 0 {
+			break
+		}
+		b = bc.GetKnownBlock(b.LastBlock)
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// ancestor returns the block with the given block number on the branch of the
+// block tree ending at b, or nil if it is not known.
+func ancestor(bc *blockchain.BlockChain, b *blockchain.Block, number uint32) *blockchain.Block {
+	main, err := bc.GetBlockByNumber(b.BlockNumber)
+	if err == nil && reflect.DeepEqual(main.BlockHeader, b.BlockHeader) {
+		// The branch is the main chain.
+		main, _ = bc.GetBlockByNumber(number)
+		return main
+	}
+	for b != nil && b.BlockNumber > number {
+		b = bc.GetKnownBlock(b.LastBlock)
+	}
+	if b == nil || b.BlockNumber != number {
+		return nil
+	}
+	return b
+}
@@ -1,8 +1,10 @@
 package consensus
 
 import (
+	"math/big"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/ubclaunchpad/cumulus/blockchain"
 	c "github.com/ubclaunchpad/cumulus/common/constants"
 )
@@ -31,3 +33,70 @@ func TestCurrentBlockReward(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// newTestRetargetChain creates a chain of RetargetInterval blocks with the given
+// target, mined the given number of seconds apart.
+func newTestRetargetChain(target *big.Int, spacing uint32) *blockchain.BlockChain {
+	bc := blockchain.New()
+	b := blockchain.NewTestChildBlock(&blockchain.Block{}, blockchain.BigIntToHash(target))
+	b.BlockNumber = 0
+	b.Time = 1000
+	bc.AppendBlock(b)
+	for i := uint32(1); i < RetargetInterval; i++ {
+		b = blockchain.NewTestChildBlock(b, b.Target)
+		b.Time = 1000 + i*spacing
+		bc.AppendBlock(b)
+	}
+	return bc
+}
+
+func TestNextTarget(t *testing.T) {
+	oldInterval := RetargetInterval
+	RetargetInterval = 4
+	defer func() { RetargetInterval = oldInterval }()
+	start := new(big.Int).Div(c.MaxTarget, big.NewInt(1000))
+
+	// The target doesn't change between retargets.
+	bc := newTestRetargetChain(start, TargetBlockTime)
+	assert.Equal(t, bc.Blocks[1].Target, NextTarget(bc, bc.Blocks[1]))
+
+	// Blocks mined on schedule keep the target the same.
+	assert.Equal(t, bc.LastBlock().Target, NextTarget(bc, bc.LastBlock()))
+
+	// Blocks mined twice as fast halve the target.
+	bc = newTestRetargetChain(start, TargetBlockTime/2)
+	expected := new(big.Int).Div(start, big.NewInt(2))
+	assert.Equal(t, expected, blockchain.HashToBigInt(NextTarget(bc, bc.LastBlock())))
+
+	// Adjustments are clamped.
+	bc = newTestRetargetChain(start, TargetBlockTime*100)
+	expected = new(big.Int).Mul(start, big.NewInt(MaxRetargetFactor))
+	assert.Equal(t, expected, blockchain.HashToBigInt(NextTarget(bc, bc.LastBlock())))
+	bc = newTestRetargetChain(c.MaxTarget, TargetBlockTime*2)
+	assert.Equal(t, c.MaxTarget, blockchain.HashToBigInt(NextTarget(bc, bc.LastBlock())))
+
+	// Side branches are retargeted using their own timestamps.
+	bc = newTestRetargetChain(start, TargetBlockTime)
+	side := blockchain.NewTestChildBlock(bc.Blocks[2], bc.Blocks[2].Target)
+	side.Time = bc.Blocks[0].Time + (TargetBlockTime*3)/2
+	bc.AddSideBlock(side)
+	expected = new(big.Int).Div(start, big.NewInt(2))
+	assert.Equal(t, expected, blockchain.HashToBigInt(NextTarget(bc, side)))
+}
+
+func TestMedianTimePast(t *testing.T) {
+	bc := newTestRetargetChain(c.MaxTarget, TargetBlockTime)
+	assert.Equal(t, uint32(0), MedianTimePast(bc, nil))
+	assert.Equal(t, bc.Blocks[0].Time, MedianTimePast(bc, bc.Blocks[0]))
+
+	// Blocks out of order still give the median time.
+	bc.Blocks[1].Time = bc.Blocks[2].Time + 1
+	assert.Equal(t, bc.Blocks[2].Time, MedianTimePast(bc, bc.Blocks[2]))
+
+	// Only the last MedianTimeSpan blocks are considered.
+	oldSpan := MedianTimeSpan
+	MedianTimeSpan = 3
+	defer func() { MedianTimeSpan = oldSpan }()
+	last := bc.LastBlock()
+	assert.Equal(t, bc.Blocks[len(bc.Blocks)-2].Time, MedianTimePast(bc, last))
+}
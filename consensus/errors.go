@@ -25,6 +25,29 @@ const (
 	Respend
 	// NilTransaction is returned when the transaction pointer is nil.
 	NilTransaction
+	// LockedTransaction is returned when the transaction's lock time has not
+	// been reached.
+	LockedTransaction
+	// LockedInput is returned when an input has not been confirmed for as
+	// many blocks as the transaction's relative lock requires.
+	LockedInput
+	// BadMultisig is returned when a transaction spends from an invalid
+	// multisig address, or has a sender or signature of its own.
+	BadMultisig
+	// BadScript is returned when a transaction spending from a script address
+	// does not have an unlocking script for each input, or has a sender or
+	// signature of its own, or when an output locked by a script is not sent
+	// to the script's address.
+	BadScript
+	// ScriptFailed is returned when an unlocking script does not meet the
+	// conditions of the locking script.
+	ScriptFailed
+	// AmountOverflow is returned when the inputs or outputs of a transaction
+	// sum to more than can be represented.
+	AmountOverflow
+	// BadRelativeLocks is returned when a transaction has relative locks but
+	// not one for each input.
+	BadRelativeLocks
 )
 
 const (
@@ -103,4 +126,19 @@ const (
 	BadNonce
 	// NilBlock is returned when the block pointer is nil.
 	NilBlock
+	// UnknownParent is returned when the block the block builds on is not
+	// known.
+	UnknownParent
+	// BadMerkleRoot is returned when the block's Merkle root does not match
+	// its transactions.
+	BadMerkleRoot
+	// TimeBeforeMedian is returned when the block's time is not after the
+	// median time of the blocks before it.
+	TimeBeforeMedian
+	// TimeTooFarAhead is returned when the block's time is too far ahead of
+	// our clock.
+	TimeTooFarAhead
+	// BadCheckpoint is returned when the block does not match a checkpoint or
+	// forks the main chain before a checkpoint.
+	BadCheckpoint
 )
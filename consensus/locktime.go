@@ -0,0 +1,43 @@
+package consensus
+
+import "github.com/ubclaunchpad/cumulus/blockchain"
+
+// VerifyLocks tests whether t can be included in the block with the given
+// block number, given the median time past of its parent. A lock time below
+// blockchain.LockTimeThreshold is reached at that block number, and any other
+// lock time is reached once the median time past is at least that time. Each
+// input must have been confirmed at least as many blocks before the block as
+// its relative lock. Inputs that spend unconfirmed transactions in the view
+// have not been confirmed at all.
+func VerifyLocks(v *blockchain.UTXOView, t *blockchain.Transaction,
+	blockNumber, medianTime uint32) (bool, TransactionCode) {
+
+	if t == nil {
+		return false, NilTransaction
+	}
+
+	// Check the absolute lock.
+	if t.LockTime < blockchain.LockTimeThreshold {
+		if blockNumber < t.LockTime {
+			return false, LockedTransaction
+		}
+	} else if medianTime < t.LockTime {
+		return false, LockedTransaction
+	}
+
+	// Check the relative lock of each input against the block it was
+	// confirmed in.
+	for i, input := range t.Inputs {
+		lock := t.RelativeLock(i)
+		if lock == 0 {
+			continue
+		}
+		confirmed := v.Resolve(input)
+		if confirmed.Unconfirmed() || confirmed.BlockNumber > blockNumber ||
+			blockNumber-confirmed.BlockNumber < lock {
+			return false, LockedInput
+		}
+	}
+
+	return true, ValidTransaction
+}
@@ -0,0 +1,124 @@
+package consensus
+
+import (
+	crand "crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+// newTestLockedTxn returns a chain of 3 blocks and a transaction in which alice
+// spends her coins confirmed in block 1, subject to the given locks.
+func newTestLockedTxn(lockTime uint32, relativeLocks ...uint32) (*blockchain.BlockChain,
+	*blockchain.Transaction) {
+	bc, body, wallets := blockchain.NewValidTestChainAndSpend(nil)
+	body.LockTime = lockTime
+	body.RelativeLocks = relativeLocks
+	txn, _ := body.Sign(*wallets["alice"], crand.Reader)
+	return bc, txn
+}
+
+func TestVerifyTransactionLockTime(t *testing.T) {
+	// The next block is block 3.
+	bc, txn := newTestLockedTxn(3)
+	valid, code := VerifyTransaction(bc, txn)
+	assert.True(t, valid)
+	assert.Equal(t, ValidTransaction, code)
+
+	bc, txn = newTestLockedTxn(4)
+	valid, code = VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, LockedTransaction, code)
+}
+
+func TestVerifyTransactionLockTimeUnix(t *testing.T) {
+	bc, _ := blockchain.NewValidTestChainAndBlock()
+	median := MedianTimePast(bc, bc.LastBlock())
+	assert.True(t, median >= blockchain.LockTimeThreshold)
+
+	bc, txn := newTestLockedTxn(median)
+	median = MedianTimePast(bc, bc.LastBlock())
+	valid, code := VerifyLocks(bc.NewUTXOView(), txn, 3, median)
+	assert.True(t, valid)
+	assert.Equal(t, ValidTransaction, code)
+
+	valid, code = VerifyLocks(bc.NewUTXOView(), txn, 3, median-1)
+	assert.False(t, valid)
+	assert.Equal(t, LockedTransaction, code)
+}
+
+func TestVerifyTransactionRelativeLock(t *testing.T) {
+	// The input was confirmed in block 1, 2 blocks before the next block.
+	bc, txn := newTestLockedTxn(0, 2)
+	valid, code := VerifyTransaction(bc, txn)
+	assert.True(t, valid)
+	assert.Equal(t, ValidTransaction, code)
+
+	bc, txn = newTestLockedTxn(0, 3)
+	valid, code = VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, LockedInput, code)
+
+	// There must be a relative lock for each input.
+	bc, txn = newTestLockedTxn(0, 2, 2)
+	valid, code = VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, BadRelativeLocks, code)
+}
+
+func TestVerifyLocksUnconfirmedInput(t *testing.T) {
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+	view := bc.NewUTXOView()
+	view.Apply(parent)
+
+	valid, _ := VerifyLocks(view, child, 3, 0)
+	assert.True(t, valid)
+
+	// An input that isn't confirmed yet has no confirmations.
+	child.RelativeLocks = []uint32{1}
+	valid, code := VerifyLocks(view, child, 3, 0)
+	assert.False(t, valid)
+	assert.Equal(t, LockedInput, code)
+}
+
+func TestVerifyLocksMixedInputs(t *testing.T) {
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+	view := bc.NewUTXOView()
+	view.Apply(parent)
+
+	// Give the child an input confirmed in block 1 as well as its unconfirmed
+	// one. Only the locked input has to wait.
+	child.Inputs = append([]blockchain.TxHashPointer{parent.Inputs[0]}, child.Inputs...)
+	child.RelativeLocks = []uint32{2, 0}
+	valid, code := VerifyLocks(view, child, 3, 0)
+	assert.True(t, valid)
+	assert.Equal(t, ValidTransaction, code)
+
+	child.RelativeLocks = []uint32{3, 0}
+	valid, code = VerifyLocks(view, child, 3, 0)
+	assert.False(t, valid)
+	assert.Equal(t, LockedInput, code)
+
+	child.RelativeLocks = []uint32{0, 1}
+	valid, code = VerifyLocks(view, child, 3, 0)
+	assert.False(t, valid)
+	assert.Equal(t, LockedInput, code)
+}
+
+func TestVerifyBlockLockedTransaction(t *testing.T) {
+	for lockTime, expected := range map[uint32]BlockCode{
+		3: ValidBlock,
+		4: BadTransaction,
+	} {
+		_, b := blockchain.NewValidTestChainAndBlock()
+		bc, txn := newTestLockedTxn(lockTime)
+		b.LastBlock = blockchain.HashSum(bc.LastBlock())
+		b.Time = bc.LastBlock().Time + 60
+		b.Transactions = []*blockchain.Transaction{b.Transactions[0], txn}
+		b.UpdateMerkleRoot()
+
+		_, code := VerifyBlock(bc, b)
+		assert.Equal(t, expected, code)
+	}
+}
@@ -0,0 +1,94 @@
+package consensus
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+	"github.com/ubclaunchpad/cumulus/script"
+)
+
+// newTestHashTimeLock returns a chain in which alice has locked 3 coins in a
+// hash-time-locked contract that pays bob with the given preimage, or refunds
+// alice at block 10, along with a transaction body spending them and the
+// wallets involved.
+func newTestHashTimeLock(preimage []byte) (*blockchain.BlockChain,
+	blockchain.TxBody, map[string]*blockchain.Wallet) {
+	alice, bob := blockchain.NewWallet(), blockchain.NewWallet()
+	hash := sha256.Sum256(preimage)
+	lock, _ := script.HashTimeLock(hash[:], bob.Public(), alice.Public(), 10)
+	bc, body, wallets := blockchain.NewValidTestChainAndSpend(&blockchain.TxOutput{
+		Recipient: blockchain.ScriptRepr(lock),
+		Script:    lock,
+	})
+	body.Script = lock
+	wallets["alice"], wallets["bob"] = alice, bob
+	return bc, body, wallets
+}
+
+func TestVerifyTransactionScript(t *testing.T) {
+	preimage := []byte("secret")
+	bc, body, wallets := newTestHashTimeLock(preimage)
+	sig, _ := script.Sign(wallets["bob"], body, crand.Reader)
+	claim, _ := script.HashLockClaim(sig, preimage)
+	txn := &blockchain.Transaction{
+		TxBody:  body,
+		Sig:     blockchain.NilSig,
+		Unlocks: [][]byte{claim},
+	}
+
+	valid, code := VerifyTransaction(bc, txn)
+	assert.True(t, valid)
+	assert.Equal(t, ValidTransaction, code)
+
+	// The wrong preimage doesn't unlock the output.
+	txn.Unlocks[0], _ = script.HashLockClaim(sig, []byte("guess"))
+	valid, code = VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, ScriptFailed, code)
+
+	// Each input needs an unlocking script.
+	txn.Unlocks = nil
+	valid, code = VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, BadScript, code)
+}
+
+func TestVerifyTransactionScriptRefund(t *testing.T) {
+	bc, body, wallets := newTestHashTimeLock([]byte("secret"))
+
+	// Alice can take her coins back once the contract's lock time is reached,
+	// which the transaction's lock time must also reach.
+	body.LockTime = 10
+	sig, _ := script.Sign(wallets["alice"], body, crand.Reader)
+	refund, _ := script.HashLockRefund(sig)
+	txn := &blockchain.Transaction{
+		TxBody:  body,
+		Sig:     blockchain.NilSig,
+		Unlocks: [][]byte{refund},
+	}
+
+	valid, code := VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, LockedTransaction, code)
+
+	view := bc.NewUTXOView()
+	valid, code = VerifyPendingTransaction(view, txn)
+	assert.True(t, valid)
+	assert.Equal(t, ValidTransaction, code)
+	valid, _ = VerifyLocks(view, txn, 10, 0)
+	assert.True(t, valid)
+}
+
+func TestVerifyTransactionScriptedOutput(t *testing.T) {
+	bc, txn := blockchain.NewValidChainAndTxn()
+	lock, _ := script.PayToKey(blockchain.NewWallet().Public())
+
+	// An output locked by a script must be sent to the script's address.
+	txn.Outputs[0].Script = lock
+	valid, code := VerifyTransaction(bc, txn)
+	assert.False(t, valid)
+	assert.Equal(t, BadScript, code)
+}
@@ -0,0 +1,223 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Version is the version of the keystore format.
+	Version = 1
+	// KDF is the name of the key derivation function of the keystore format.
+	KDF = "scrypt"
+	// Cipher is the name of the cipher of the keystore format.
+	Cipher = "aes-256-ctr"
+
+	// saltLen is the length in bytes of KDF salts.
+	saltLen = 32
+	// keyLen is the length in bytes of both the cipher and the MAC keys.
+	keyLen = 32
+	// maxMemory bounds the memory, in bytes, that the KDF parameters of a
+	// keystore may ask for, so that a tampered keystore can't exhaust it.
+	maxMemory = 1 << 30
+)
+
+// FileMode is the permissions of keystore files, which must only be readable
+// by their owner.
+const FileMode os.FileMode = 0600
+
+var (
+	// StandardParams are the scrypt parameters used to protect keys. Deriving
+	// a key takes about a second and 256 MB of memory.
+	StandardParams = Params{N: 1 << 18, R: 8, P: 1}
+	// LightParams are much cheaper scrypt parameters, for tests.
+	LightParams = Params{N: 1 << 12, R: 8, P: 1}
+
+	// ErrVersion is returned when opening a keystore in a format this version
+	// doesn't know.
+	ErrVersion = errors.New("Unknown keystore version, KDF or cipher")
+	// ErrBadParams is returned for KDF parameters that scrypt doesn't accept
+	// or that ask for too much memory.
+	ErrBadParams = errors.New("Invalid keystore KDF parameters")
+	// ErrBadPassword is returned when a keystore's MAC doesn't match, which
+	// means the password is wrong or the keystore was tampered with.
+	ErrBadPassword = errors.New("Invalid keystore password")
+)
+
+// Params are the cost parameters of scrypt.
+type Params struct {
+	N, R, P int
+}
+
+// valid returns true if scrypt accepts the parameters and they need no more
+// than maxMemory.
+func (p Params) valid() bool {
+	return p.N > 1 && p.N&(p.N-1) == 0 && p.R > 0 && p.P > 0 &&
+		uint64(p.N)*uint64(p.R)*128 <= maxMemory &&
+		uint64(p.R)*uint64(p.P) < 1<<30
+}
+
+// Keystore is a secret encrypted under a password. The cipher and MAC keys are
+// derived from the password with scrypt, the secret is encrypted with AES in
+// counter mode, and the MAC is an HMAC-SHA256 of everything else, so any
+// change to the keystore is detected.
+type Keystore struct {
+	Version    int
+	KDF        string
+	KDFParams  Params
+	Salt       []byte
+	Cipher     string
+	IV         []byte
+	Ciphertext []byte
+	MAC        []byte
+}
+
+// Seal returns a keystore holding the secret encrypted under the password,
+// using the given scrypt parameters.
+func Seal(secret []byte, password string, params Params) (*Keystore, error) {
+	if !params.valid() {
+		return nil, ErrBadParams
+	}
+	ks := &Keystore{
+		Version:   Version,
+		KDF:       KDF,
+		KDFParams: params,
+		Salt:      make([]byte, saltLen),
+		Cipher:    Cipher,
+		IV:        make([]byte, aes.BlockSize),
+	}
+	if _, err := io.ReadFull(rand.Reader, ks.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, ks.IV); err != nil {
+		return nil, err
+	}
+
+	cipherKey, macKey, err := ks.deriveKeys(password)
+	if err != nil {
+		return nil, err
+	}
+	ks.Ciphertext, err = ks.xor(cipherKey, secret)
+	if err != nil {
+		return nil, err
+	}
+	ks.MAC = ks.mac(macKey)
+	return ks, nil
+}
+
+// Open returns the secret in the keystore, or ErrBadPassword if the password
+// is wrong.
+func (ks *Keystore) Open(password string) ([]byte, error) {
+	if ks.Version != Version || ks.KDF != KDF || ks.Cipher != Cipher {
+		return nil, ErrVersion
+	} else if !ks.KDFParams.valid() || len(ks.IV) != aes.BlockSize {
+		return nil, ErrBadParams
+	}
+	cipherKey, macKey, err := ks.deriveKeys(password)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(ks.mac(macKey), ks.MAC) {
+		return nil, ErrBadPassword
+	}
+	return ks.xor(cipherKey, ks.Ciphertext)
+}
+
+// deriveKeys returns the cipher and MAC keys derived from the password.
+func (ks *Keystore) deriveKeys(password string) ([]byte, []byte, error) {
+	p := ks.KDFParams
+	key, err := scrypt.Key([]byte(password), ks.Salt, p.N, p.R, p.P, 2*keyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key[:keyLen], key[keyLen:], nil
+}
+
+// xor encrypts or decrypts the given text with the cipher key.
+func (ks *Keystore) xor(cipherKey, text []byte) ([]byte, error) {
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(text))
+	cipher.NewCTR(block, ks.IV).XORKeyStream(out, text)
+	return out, nil
+}
+
+// mac returns the MAC of every field of the keystore but the MAC itself.
+func (ks *Keystore) mac(macKey []byte) []byte {
+	h := hmac.New(sha256.New, macKey)
+	writeField := func(field []byte) {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(field)))
+		h.Write(length)
+		h.Write(field)
+	}
+	params := make([]byte, 16)
+	binary.BigEndian.PutUint32(params, uint32(ks.Version))
+	binary.BigEndian.PutUint32(params[4:], uint32(ks.KDFParams.N))
+	binary.BigEndian.PutUint32(params[8:], uint32(ks.KDFParams.R))
+	binary.BigEndian.PutUint32(params[12:], uint32(ks.KDFParams.P))
+	writeField(params)
+	writeField([]byte(ks.KDF))
+	writeField(ks.Salt)
+	writeField([]byte(ks.Cipher))
+	writeField(ks.IV)
+	writeField(ks.Ciphertext)
+	return h.Sum(nil)
+}
+
+// Save writes the keystore to the file with the given name in JSON format,
+// readable only by its owner. The keystore is written to a temporary file
+// which then replaces the file, so that the file is never left partially
+// written.
+func (ks *Keystore) Save(fileName string) error {
+	ksBytes, err := json.Marshal(ks)
+	if err != nil {
+		return err
+	}
+
+	tmpName := fileName + ".tmp"
+	os.Remove(tmpName)
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, FileMode)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(ksBytes)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// Load reads a keystore written by Save from the file with the given name.
+func Load(fileName string) (*Keystore, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ks Keystore
+	if err := json.NewDecoder(file).Decode(&ks); err != nil {
+		return nil, err
+	}
+	return &ks, nil
+}
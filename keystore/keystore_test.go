@@ -0,0 +1,78 @@
+package keystore
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealAndOpen(t *testing.T) {
+	secret := []byte{0, 0, 1, 2, 3}
+	ks, err := Seal(secret, "password", LightParams)
+	assert.Nil(t, err)
+	assert.Equal(t, Version, ks.Version)
+	assert.Equal(t, LightParams, ks.KDFParams)
+	assert.NotEqual(t, secret, ks.Ciphertext)
+
+	// Leading zeros are kept.
+	opened, err := ks.Open("password")
+	assert.Nil(t, err)
+	assert.Equal(t, secret, opened)
+
+	_, err = ks.Open("wrong password")
+	assert.Equal(t, ErrBadPassword, err)
+
+	// Sealing the same secret again gives a different keystore.
+	other, err := Seal(secret, "password", LightParams)
+	assert.Nil(t, err)
+	assert.NotEqual(t, ks.Salt, other.Salt)
+	assert.NotEqual(t, ks.Ciphertext, other.Ciphertext)
+}
+
+func TestOpenTamperedKeystore(t *testing.T) {
+	seal := func() *Keystore {
+		ks, err := Seal([]byte("secret"), "password", LightParams)
+		assert.Nil(t, err)
+		return ks
+	}
+	tests := map[string]struct {
+		tamper   func(*Keystore)
+		expected error
+	}{
+		"ciphertext": {func(ks *Keystore) { ks.Ciphertext[0] ^= 1 }, ErrBadPassword},
+		"iv":         {func(ks *Keystore) { ks.IV[0] ^= 1 }, ErrBadPassword},
+		"salt":       {func(ks *Keystore) { ks.Salt[0] ^= 1 }, ErrBadPassword},
+		"mac":        {func(ks *Keystore) { ks.MAC[0] ^= 1 }, ErrBadPassword},
+		"params":     {func(ks *Keystore) { ks.KDFParams.P = 2 }, ErrBadPassword},
+		"version":    {func(ks *Keystore) { ks.Version = 2 }, ErrVersion},
+		"kdf":        {func(ks *Keystore) { ks.KDF = "pbkdf2" }, ErrVersion},
+		"cost":       {func(ks *Keystore) { ks.KDFParams.N = 1 << 30 }, ErrBadParams},
+		"n":          {func(ks *Keystore) { ks.KDFParams.N = 1000 }, ErrBadParams},
+	}
+	for name, test := range tests {
+		ks := seal()
+		test.tamper(ks)
+		_, err := ks.Open("password")
+		assert.Equal(t, test.expected, err, name)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	fileName := "keystoreTestFile.json"
+	defer os.Remove(fileName)
+
+	ks, err := Seal([]byte("secret"), "password", LightParams)
+	assert.Nil(t, err)
+	assert.Nil(t, ks.Save(fileName))
+	info, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, FileMode, info.Mode().Perm())
+
+	loaded, err := Load(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, ks, loaded)
+	opened, err := loaded.Open("password")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret"), opened)
+}
@@ -102,8 +102,11 @@ func (m *Miner) Mine(b *blockchain.Block) *MiningResult {
 			b.Nonce = 0
 		}
 
-		// Timestamp and increase the nonce.
-		b.Time = util.UnixNow()
+		// Timestamp and increase the nonce. The time is never moved backwards
+		// so that it stays valid with respect to the chain.
+		if now := util.UnixNow(); now > b.Time {
+			b.Time = now
+		}
 		b.Nonce++
 	}
 
@@ -157,19 +160,20 @@ func (m *Miner) VerifyProofOfWork(b *blockchain.Block) bool {
 }
 
 // CloudBase prepends the cloudbase transaction to the front of a list of
-// transactions in a block that is to be added to the blockchain
+// transactions in a block that is to be added to the blockchain. Returns an
+// error, leaving the block unchanged, if the fees of the block's transactions
+// can't be computed, as the block could not be valid.
 func CloudBase(
 	b *blockchain.Block,
 	bc *blockchain.BlockChain,
-	cb blockchain.Address) *blockchain.Block {
+	cb blockchain.Address) (*blockchain.Block, error) {
 	// Create a cloudbase transaction by setting all inputs to 0
 	cbInput := blockchain.TxHashPointer{
 		BlockNumber: 0,
 		Hash:        blockchain.NilHash,
 		Index:       0,
 	}
-	// Set the transaction amount to the BlockReward
-	// TODO: Add transaction fees
+	// Set the transaction amount to the BlockReward, fees are added below
 	cbReward := blockchain.TxOutput{
 		Amount:    consensus.CurrentBlockReward(bc),
 		Recipient: cb.Repr(),
@@ -186,5 +190,14 @@ func CloudBase(
 
 	b.Transactions = append([]*blockchain.Transaction{&cbTx}, b.Transactions...)
 
-	return b
+	// Claim the fees of the rest of the transactions in the block
+	fees, err := b.GetTotalFees(bc)
+	if err != nil {
+		b.Transactions = b.Transactions[1:]
+		return nil, err
+	}
+	cbTx.Outputs[0].Amount += fees
+	b.UpdateMerkleRoot()
+
+	return b, nil
 }
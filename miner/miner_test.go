@@ -84,6 +84,30 @@ func TestCloudBase(t *testing.T) {
 	}
 }
 
+func TestCloudBaseClaimsFees(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlockWithFee(1)
+	b.Transactions = b.Transactions[1:]
+
+	_, err := CloudBase(b, bc, blockchain.NewWallet().Public())
+	assert.Nil(t, err)
+
+	assert.Equal(t, consensus.CurrentBlockReward(bc)+1, b.Transactions[0].Outputs[0].Amount)
+	valid, code := consensus.VerifyCloudBase(bc, b.Transactions[0], 1)
+	assert.True(t, valid, code)
+}
+
+func TestCloudBaseBadFees(t *testing.T) {
+	bc, b := blockchain.NewValidTestChainAndBlock()
+	txns := b.Transactions[1:]
+	txns[0].Outputs[0].Amount++
+	b.Transactions = txns
+
+	// A block whose fees can't be computed is left as it was.
+	_, err := CloudBase(b, bc, blockchain.NewWallet().Public())
+	assert.NotNil(t, err)
+	assert.Equal(t, txns, b.Transactions)
+}
+
 func TestVerifyProofOfWork(t *testing.T) {
 	_, b := blockchain.NewValidTestChainAndBlock()
 	b.Target = blockchain.BigIntToHash(
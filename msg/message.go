@@ -30,6 +30,11 @@ const (
 	ResourceBlock
 	// ResourceTransaction resources contain a transaction to add to the blockchain.
 	ResourceTransaction
+	// ResourceChainInfo resources describe which blocks a node can serve.
+	ResourceChainInfo
+	// ResourceReplacement resources contain a pending transaction's hash and
+	// the transaction its sender wants to replace it with.
+	ResourceReplacement
 )
 
 const (
@@ -43,6 +48,9 @@ const (
 	// RequestTimeout occurs when a peer does not respond to a request within
 	// some predefined period of time (see peer.DefaultRequestTimeout)
 	RequestTimeout = 408
+	// Pruned occurs when a block is requested from a node that has discarded
+	// its transactions.
+	Pruned = 410
 	// UpToDate occurs when a block request is received for a block that has
 	// not yet been mined (i.e. the lastBlockHash param in the request is the
 	// hash of the latest block in the blockchain).
@@ -104,10 +112,12 @@ type Response struct {
 }
 
 // Push is a container for a push payload, containing a resource proactively sent
-// to us by another peer.
+// to us by another peer. From is the listen address of the peer the push was
+// received from; it is set on receipt and never sent over the wire.
 type Push struct {
 	ResourceType ResourceType
 	Resource     interface{}
+	From         string `json:"-"`
 }
 
 // Write encodes and writes the Message into the given Writer.
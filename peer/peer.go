@@ -153,6 +153,7 @@ func (p *Peer) Dispatch() {
 				log.Error("Dispatcher could not find push handler for push message on peer",
 					p.ListenAddr)
 			} else {
+				push.From = p.ListenAddr
 				p.pushHandler(push)
 			}
 		}
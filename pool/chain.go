@@ -0,0 +1,151 @@
+package pool
+
+import (
+	"errors"
+
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+// ErrParentEvicted is returned when a pending transaction that a new
+// transaction spends from has expired or would have to be evicted to make room
+// for it.
+var ErrParentEvicted = errors.New("Pending transaction spent from was evicted")
+
+// parents returns the pending transactions whose outputs t spends.
+func (p *Pool) parents(t *blockchain.Transaction) []*blockchain.Transaction {
+	parents := make([]*blockchain.Transaction, 0)
+	seen := map[blockchain.Hash]bool{}
+	for _, input := range t.Inputs {
+		if !input.Unconfirmed() || seen[input.Hash] {
+			continue
+		}
+		if parent := p.get(input.Hash); parent != nil {
+			parents = append(parents, parent)
+			seen[input.Hash] = true
+		}
+	}
+	return parents
+}
+
+// view returns a view of the unspent outputs on bc with the pending
+// transactions that t spends from applied to it.
+func (p *Pool) view(bc *blockchain.BlockChain, t *blockchain.Transaction) *blockchain.UTXOView {
+	view := bc.NewUTXOView()
+	for _, parent := range p.parents(t) {
+		view.Apply(parent)
+	}
+	return view
+}
+
+// resolveInputs returns the inputs of t resolved in the given view.
+func resolveInputs(view *blockchain.UTXOView, t *blockchain.Transaction) []blockchain.TxHashPointer {
+	inputs := make([]blockchain.TxHashPointer, len(t.Inputs))
+	for i, input := range t.Inputs {
+		inputs[i] = view.Resolve(input)
+	}
+	return inputs
+}
+
+// linkParents records the pooled transaction with the given hash as a child of
+// the pending transactions it spends from if linked is true, and forgets it
+// otherwise.
+func (p *Pool) linkParents(hash blockchain.Hash, vt *PooledTransaction, linked bool) {
+	if p.children == nil {
+		p.children = map[blockchain.Hash]map[blockchain.Hash]bool{}
+	}
+	if !linked {
+		// The children of a transaction that leaves the pool no longer have
+		// a pending parent.
+		delete(p.children, hash)
+	}
+	for _, input := range vt.inputs {
+		if !input.Unconfirmed() {
+			continue
+		}
+		if linked && p.get(input.Hash) != nil {
+			if p.children[input.Hash] == nil {
+				p.children[input.Hash] = map[blockchain.Hash]bool{}
+			}
+			p.children[input.Hash][hash] = true
+		} else if !linked {
+			delete(p.children[input.Hash], hash)
+		}
+	}
+}
+
+// evict removes t from the pool along with every pending transaction that
+// spends its outputs, directly or indirectly.
+func (p *Pool) evict(t *blockchain.Transaction) {
+	hash := blockchain.HashSum(t)
+	for child := range p.children[hash] {
+		if c := p.get(child); c != nil {
+			p.evict(c)
+		}
+	}
+	p.drop(t)
+}
+
+// parentsFirst orders the given transactions so that each one comes after the
+// pending transactions it spends from. Transactions that spend from a pending
+// transaction that isn't among them can't be mined yet, so they are dropped.
+func (p *Pool) parentsFirst(txns []*blockchain.Transaction) []*blockchain.Transaction {
+	selected := make(map[blockchain.Hash]*blockchain.Transaction, len(txns))
+	for _, t := range txns {
+		selected[blockchain.HashSum(t)] = t
+	}
+
+	ordered := make([]*blockchain.Transaction, 0, len(txns))
+	placed := map[blockchain.Hash]bool{}
+	var place func(hash blockchain.Hash) bool
+	place = func(hash blockchain.Hash) bool {
+		if ok, seen := placed[hash]; seen {
+			return ok
+		}
+		t, ok := selected[hash]
+		if !ok {
+			return false
+		}
+		for _, parent := range p.parents(t) {
+			if !place(blockchain.HashSum(parent)) {
+				placed[hash] = false
+				return false
+			}
+		}
+		ordered = append(ordered, t)
+		placed[hash] = true
+		return true
+	}
+	for _, t := range txns {
+		place(blockchain.HashSum(t))
+	}
+	return ordered
+}
+
+// UnspentOutputsFor returns the outputs of pending transactions to the given
+// recipient that are not spent by other pending transactions, keyed by
+// unconfirmed pointers to the outputs.
+func (p *Pool) UnspentOutputsFor(recipient string) map[blockchain.TxHashPointer]uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	unspent := map[blockchain.TxHashPointer]uint64{}
+	for hash, vt := range p.ValidTransactions {
+		for i, out := range vt.Transaction.Outputs {
+			if out.Recipient != recipient {
+				continue
+			}
+			input := blockchain.UnconfirmedInput(hash, uint32(i))
+			if _, spent := p.spends[spendKey{input, recipient}]; !spent {
+				unspent[input] = out.Amount
+			}
+		}
+	}
+	return unspent
+}
+
+// Fee returns the fee paid by t, which may spend the outputs of pending
+// transactions in the pool. Returns an error if the fee can't be determined.
+func (p *Pool) Fee(t *blockchain.Transaction, bc *blockchain.BlockChain) (uint64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.view(bc, t).Fee(t)
+}
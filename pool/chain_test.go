@@ -0,0 +1,117 @@
+package pool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+	"github.com/ubclaunchpad/cumulus/consensus"
+)
+
+func TestPushChainedTransactions(t *testing.T) {
+	p := New()
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+
+	// The child can't be added before its parent.
+	code, _ := p.PushFrom(child, bc, "")
+	assert.Equal(t, consensus.NoInputTransactions, code)
+
+	assert.Equal(t, consensus.ValidTransaction, p.Push(parent, bc))
+	code, err := p.PushFrom(child, bc, "")
+	assert.Equal(t, consensus.ValidTransaction, code)
+	assert.Nil(t, err)
+	assert.Equal(t, child, p.SpentBy(child.Inputs[0], child.Sender.Repr()))
+
+	fee, err := p.Fee(child, bc)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), fee)
+
+	// The parent's change is spent, so it is not offered to the sender.
+	assert.Empty(t, p.UnspentOutputsFor(parent.Sender.Repr()))
+	p.Delete(child)
+	assert.Equal(t, map[blockchain.TxHashPointer]uint64{
+		blockchain.UnconfirmedInput(blockchain.HashSum(parent), 1): 1,
+	}, p.UnspentOutputsFor(parent.Sender.Repr()))
+}
+
+func TestEvictRemovesDescendants(t *testing.T) {
+	p := New()
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+	p.Push(parent, bc)
+	p.Push(child, bc)
+	other := blockchain.NewTestTransaction()
+	p.PushUnsafe(other)
+
+	// Making room for one more transaction evicts the parent and its child.
+	p.SetLimits(Limits{MaxCount: 3})
+	p.makeRoom(other.Len())
+	assert.Equal(t, 1, p.Size())
+	assert.Equal(t, other, p.Peek())
+}
+
+func TestPushDoesNotEvictParents(t *testing.T) {
+	p := New()
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+	p.SetLimits(Limits{MaxCount: 1})
+	assert.Equal(t, consensus.ValidTransaction, p.Push(parent, bc))
+
+	// Making room for the child would evict its parent, so it is rejected
+	// without evicting anything.
+	_, err := p.PushFrom(child, bc, "")
+	assert.Equal(t, ErrParentEvicted, err)
+	assert.Equal(t, 1, p.Size())
+	assert.Equal(t, parent, p.Peek())
+}
+
+func TestUpdateKeepsChildrenOfConfirmedParents(t *testing.T) {
+	p := New()
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+	p.Push(parent, bc)
+	p.Push(child, bc)
+
+	_, b := blockchain.NewValidTestChainAndBlock()
+	b.LastBlock = blockchain.HashSum(bc.LastBlock())
+	b.Time = bc.LastBlock().Time + 60
+	b.Transactions = []*blockchain.Transaction{b.Transactions[0], parent}
+	b.UpdateMerkleRoot()
+
+	assert.True(t, p.Update(b, bc))
+	assert.Equal(t, []*PooledTransaction{p.ValidTransactions[blockchain.HashSum(child)]}, p.Order)
+	bc.AppendBlock(b)
+	valid, _ := consensus.VerifyTransaction(bc, child)
+	assert.True(t, valid)
+}
+
+func TestUpdateEvictsConflicts(t *testing.T) {
+	p := New()
+	bc, t1, t2 := newTestSpends()
+	p.Push(t2, bc)
+
+	// A block confirms another transaction spending the same input.
+	_, b := blockchain.NewValidTestChainAndBlock()
+	b.LastBlock = blockchain.HashSum(bc.LastBlock())
+	b.Time = bc.LastBlock().Time + 60
+	b.Transactions = []*blockchain.Transaction{b.Transactions[0], t1}
+	b.UpdateMerkleRoot()
+
+	assert.True(t, p.Update(b, bc))
+	assert.True(t, p.Empty())
+}
+
+func TestNextBlockPutsParentsFirst(t *testing.T) {
+	p := New()
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+	p.Push(parent, bc)
+	p.Push(child, bc)
+
+	b := p.NextBlock(bc, blockchain.NewWallet().Public(), 1<<18)
+	assert.Equal(t, []*blockchain.Transaction{parent, child}, b.Transactions[1:])
+	valid, code := consensus.VerifyBlock(bc, b)
+	assert.True(t, valid, "code %d", code)
+
+	// A child is left out of a block without its parent.
+	assert.Equal(t, []*blockchain.Transaction{},
+		p.parentsFirst([]*blockchain.Transaction{child}))
+	assert.Equal(t, []*blockchain.Transaction{parent, child},
+		p.parentsFirst([]*blockchain.Transaction{child, parent}))
+}
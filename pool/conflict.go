@@ -0,0 +1,116 @@
+package pool
+
+import (
+	"errors"
+
+	"github.com/ubclaunchpad/cumulus/blockchain"
+	"github.com/ubclaunchpad/cumulus/consensus"
+)
+
+var (
+	// ErrConflict is returned when a transaction spends an input that is
+	// already spent by another transaction in the pool.
+	ErrConflict = errors.New("Transaction conflicts with a pending transaction")
+	// ErrNotPending is returned when the transaction to be replaced is not in
+	// the pool.
+	ErrNotPending = errors.New("Transaction to replace is not pending")
+	// ErrNotSender is returned when a replacement is not sent by the sender of
+	// the transaction it replaces.
+	ErrNotSender = errors.New("Replacement is not from the original sender")
+	// ErrInputsDiffer is returned when a replacement does not spend the same
+	// inputs as the transaction it replaces.
+	ErrInputsDiffer = errors.New("Replacement does not spend the same inputs")
+)
+
+// spendKey identifies an output spent by a pending transaction: the resolved
+// pointer to the transaction containing it and the recipient spending it.
+type spendKey struct {
+	input  blockchain.TxHashPointer
+	sender string
+}
+
+// Replacement supersedes the pending transaction with hash Old with New, a
+// transaction signed by the same sender that spends the same inputs.
+type Replacement struct {
+	Old blockchain.Hash
+	New *blockchain.Transaction
+}
+
+// SpentBy returns the pending transaction that spends the output of the
+// transaction referenced by input to the given sender, or nil if no
+// transaction in the pool spends it.
+func (p *Pool) SpentBy(input blockchain.TxHashPointer, sender string) *blockchain.Transaction {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if hash, ok := p.spends[spendKey{input, sender}]; ok {
+		return p.get(hash)
+	}
+	return nil
+}
+
+// conflicts returns ErrConflict if any of the given resolved inputs of t are
+// spent by another transaction in the pool.
+func (p *Pool) conflicts(t *blockchain.Transaction, inputs []blockchain.TxHashPointer) error {
+	hash := blockchain.HashSum(t)
+	for _, input := range inputs {
+		spender, ok := p.spends[spendKey{input, t.From()}]
+		if ok && spender != hash {
+			return ErrConflict
+		}
+	}
+	return nil
+}
+
+// trackInputs records that the pooled transaction with the given hash spends
+// its inputs if spent is true, and forgets it otherwise.
+func (p *Pool) trackInputs(hash blockchain.Hash, vt *PooledTransaction, spent bool) {
+	if p.spends == nil {
+		p.spends = map[spendKey]blockchain.Hash{}
+	}
+	sender := vt.Transaction.From()
+	for _, input := range vt.inputs {
+		key := spendKey{input, sender}
+		if spent {
+			p.spends[key] = hash
+		} else if p.spends[key] == hash {
+			delete(p.spends, key)
+		}
+	}
+}
+
+// Replace evicts the pending transaction r.Old from the pool and inserts
+// r.New, received from the peer with the given listen address, in its place.
+// The replacement must be valid, signed by the sender of the original and
+// spend exactly the same inputs. Pending transactions that spend the outputs
+// of the original are evicted too. Returns the result of validating the
+// replacement, and an error if it could not replace the original.
+func (p *Pool) Replace(r *Replacement, bc *blockchain.BlockChain,
+	peer string) (consensus.TransactionCode, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	old, ok := p.ValidTransactions[r.Old]
+	if !ok {
+		return consensus.ValidTransaction, ErrNotPending
+	}
+	if old.Transaction.From() != r.New.From() {
+		return consensus.ValidTransaction, ErrNotSender
+	}
+	oldInputs, newInputs := old.Transaction.InputSet(), r.New.InputSet()
+	if oldInputs.Size() != newInputs.Size() || !oldInputs.Has(newInputs.List()...) {
+		return consensus.ValidTransaction, ErrInputsDiffer
+	}
+	view := p.view(bc, r.New)
+	ok, code := consensus.VerifyPendingTransaction(view, r.New)
+	if !ok {
+		return code, nil
+	}
+
+	p.evict(old.Transaction)
+	if err := p.admit(r.New, peer, p.parents(r.New)); err != nil {
+		// Put the original back, it stays pending.
+		p.insert(old.Transaction, old.Peer, old.inputs)
+		return code, err
+	}
+	p.insert(r.New, peer, resolveInputs(view, r.New))
+	return code, nil
+}
@@ -0,0 +1,102 @@
+package pool
+
+import (
+	crand "crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+	"github.com/ubclaunchpad/cumulus/consensus"
+)
+
+// newTestSpends returns a chain and two valid transactions signed by the same
+// sender that spend the same input and pay different amounts.
+func newTestSpends() (*blockchain.BlockChain, *blockchain.Transaction,
+	*blockchain.Transaction) {
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	alice := wallets["alice"]
+	input := blockchain.TxHashPointer{
+		BlockNumber: 1,
+		Index:       1,
+		Hash:        blockchain.HashSum(bc.Blocks[1].Transactions[1]),
+	}
+	spend := func(amount uint64) *blockchain.Transaction {
+		t, _ := blockchain.TxBody{
+			Sender: alice.Public(),
+			Inputs: []blockchain.TxHashPointer{input},
+			Outputs: []blockchain.TxOutput{{
+				Amount:    amount,
+				Recipient: wallets["bob"].Public().Repr(),
+			}},
+		}.Sign(*alice, crand.Reader)
+		return t
+	}
+	return bc, spend(3), spend(2)
+}
+
+func TestPushRejectsConflict(t *testing.T) {
+	p := New()
+	bc, t1, t2 := newTestSpends()
+
+	code, err := p.PushFrom(t1, bc, "")
+	assert.Equal(t, consensus.ValidTransaction, code)
+	assert.Nil(t, err)
+	assert.Equal(t, t1, p.SpentBy(t1.Inputs[0], t1.Sender.Repr()))
+
+	_, err = p.PushFrom(t2, bc, "")
+	assert.Equal(t, ErrConflict, err)
+	assert.Nil(t, p.Get(blockchain.HashSum(t2)))
+
+	// Pushing the same transaction again is not a conflict.
+	_, err = p.PushFrom(t1, bc, "")
+	assert.Nil(t, err)
+
+	// The input is free once the pending transaction leaves the pool.
+	p.Delete(t1)
+	assert.Nil(t, p.SpentBy(t1.Inputs[0], t1.Sender.Repr()))
+	_, err = p.PushFrom(t2, bc, "")
+	assert.Nil(t, err)
+}
+
+func TestReplace(t *testing.T) {
+	p := New()
+	bc, t1, t2 := newTestSpends()
+	p.Push(t1, bc)
+
+	code, err := p.Replace(&Replacement{Old: blockchain.HashSum(t1), New: t2}, bc, "peer")
+	assert.Equal(t, consensus.ValidTransaction, code)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Get(blockchain.HashSum(t1)))
+	assert.Equal(t, t2, p.SpentBy(t2.Inputs[0], t2.Sender.Repr()))
+	assert.Equal(t, 1, p.Size())
+
+	// The original is no longer pending so can't be replaced again.
+	_, err = p.Replace(&Replacement{Old: blockchain.HashSum(t1), New: t2}, bc, "")
+	assert.Equal(t, ErrNotPending, err)
+}
+
+func TestReplaceRejected(t *testing.T) {
+	p := New()
+	bc, b := blockchain.NewValidTestChainAndBlock()
+	aliceToBob, bobToSender := b.Transactions[1], b.Transactions[2]
+	p.Push(aliceToBob, bc)
+	old := blockchain.HashSum(aliceToBob)
+
+	// Only the original sender can replace a transaction.
+	_, err := p.Replace(&Replacement{Old: old, New: bobToSender}, bc, "")
+	assert.Equal(t, ErrNotSender, err)
+
+	// The replacement must spend the same inputs.
+	other := *aliceToBob
+	other.Inputs = []blockchain.TxHashPointer{blockchain.NewTestTxHashPointer()}
+	_, err = p.Replace(&Replacement{Old: old, New: &other}, bc, "")
+	assert.Equal(t, ErrInputsDiffer, err)
+
+	// The replacement must be valid.
+	other = *aliceToBob
+	other.Outputs = other.Outputs[:1]
+	code, err := p.Replace(&Replacement{Old: old, New: &other}, bc, "")
+	assert.Equal(t, consensus.BadSig, code)
+	assert.Nil(t, err)
+	assert.Equal(t, aliceToBob, p.Get(old))
+}
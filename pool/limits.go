@@ -0,0 +1,188 @@
+package pool
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+// Limits bounds the resources used by a Pool. A zero value for any field means
+// there is no limit.
+type Limits struct {
+	// MaxCount is the most transactions the pool will hold.
+	MaxCount int
+	// MaxBytes is the most bytes of transactions the pool will hold.
+	MaxBytes int
+	// MaxAge is how long a transaction can sit in the pool before it expires.
+	MaxAge time.Duration
+	// MaxPerSender is the most pending transactions a single sender can have
+	// in the pool.
+	MaxPerSender int
+	// MaxPerPeer is the most pending transactions the pool will admit from a
+	// single peer.
+	MaxPerPeer int
+}
+
+// DefaultLimits are the limits used by new pools.
+var DefaultLimits = Limits{
+	MaxCount:     50000,
+	MaxBytes:     64 << 20,
+	MaxAge:       72 * time.Hour,
+	MaxPerSender: 100,
+	MaxPerPeer:   5000,
+}
+
+var (
+	// ErrTooLarge is returned when a transaction is larger than the pool.
+	ErrTooLarge = errors.New("Transaction is larger than the pool")
+	// ErrSenderQuota is returned when the sender of a transaction already has
+	// as many pending transactions in the pool as it is allowed.
+	ErrSenderQuota = errors.New("Sender has too many transactions in the pool")
+	// ErrPeerQuota is returned when the peer a transaction came from has
+	// already had as many of its transactions admitted as it is allowed.
+	ErrPeerQuota = errors.New("Peer has too many transactions in the pool")
+)
+
+// SetLimits sets the limits of the pool, evicting transactions if the pool no
+// longer fits within them.
+func (p *Pool) SetLimits(l Limits) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.limits = l
+	p.expire()
+	p.makeRoom(0)
+}
+
+// Limits returns the limits of the pool.
+func (p *Pool) Limits() Limits {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.limits
+}
+
+// Bytes returns the total size of the transactions in the pool.
+func (p *Pool) Bytes() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.bytes
+}
+
+// Expire removes transactions that have been in the pool longer than its
+// maximum age, along with the transactions that spend their outputs,
+// returning the number of transactions removed.
+func (p *Pool) Expire() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.expire()
+}
+
+// expire is Expire without locking the pool.
+func (p *Pool) expire() int {
+	size := p.size()
+	for p.size() > 0 && p.expired(p.Order[0]) {
+		p.evict(p.Order[0].Transaction)
+	}
+	return size - p.size()
+}
+
+// expired returns true if pt has been in the pool longer than its maximum age.
+func (p *Pool) expired(pt *PooledTransaction) bool {
+	return p.limits.MaxAge > 0 && pt.Time.Before(time.Now().Add(-p.limits.MaxAge))
+}
+
+// admit checks that t from the given peer fits within the pool's quotas, then
+// makes room for it. If one of the given pending transactions that t spends
+// from has expired or would have to be evicted to make room, ErrParentEvicted
+// is returned and nothing is evicted to make room.
+func (p *Pool) admit(t *blockchain.Transaction, peer string,
+	parents []*blockchain.Transaction) error {
+	p.expire()
+	if p.limits.MaxBytes > 0 && t.Len() > p.limits.MaxBytes {
+		return ErrTooLarge
+	}
+	if p.limits.MaxPerSender > 0 && p.senders[t.From()] >= p.limits.MaxPerSender {
+		return ErrSenderQuota
+	}
+	if len(peer) > 0 && p.limits.MaxPerPeer > 0 && p.peers[peer] >= p.limits.MaxPerPeer {
+		return ErrPeerQuota
+	}
+	doomed := p.doomed(t.Len())
+	for _, parent := range parents {
+		hash := blockchain.HashSum(parent)
+		if p.get(hash) == nil || doomed[hash] {
+			return ErrParentEvicted
+		}
+	}
+	p.dropAll(doomed)
+	return nil
+}
+
+// makeRoom evicts the oldest transactions in the pool, and the transactions
+// that spend their outputs, until a transaction of the given size fits within
+// its limits.
+func (p *Pool) makeRoom(size int) {
+	p.dropAll(p.doomed(size))
+}
+
+// doomed returns the hashes of the transactions makeRoom would evict to fit a
+// transaction of the given size, without evicting them.
+func (p *Pool) doomed(size int) map[blockchain.Hash]bool {
+	doomed := map[blockchain.Hash]bool{}
+	count, bytes := p.size(), p.bytes
+	if size > 0 {
+		count++
+	}
+	var doom func(hash blockchain.Hash)
+	doom = func(hash blockchain.Hash) {
+		vt, ok := p.ValidTransactions[hash]
+		if !ok || doomed[hash] {
+			return
+		}
+		doomed[hash] = true
+		count--
+		bytes -= vt.Transaction.Len()
+		for child := range p.children[hash] {
+			doom(child)
+		}
+	}
+	for _, vt := range p.Order {
+		overCount := p.limits.MaxCount > 0 && count > p.limits.MaxCount
+		overBytes := p.limits.MaxBytes > 0 && bytes+size > p.limits.MaxBytes
+		if !overCount && !overBytes {
+			break
+		}
+		doom(blockchain.HashSum(vt.Transaction))
+	}
+	return doomed
+}
+
+// dropAll removes the transactions with the given hashes from the pool.
+func (p *Pool) dropAll(hashes map[blockchain.Hash]bool) {
+	for hash := range hashes {
+		if t := p.get(hash); t != nil {
+			p.drop(t)
+		}
+	}
+}
+
+// account updates the sender and peer counts and the total size of the pool
+// by delta for the given transaction.
+func (p *Pool) account(vt *PooledTransaction, delta int) {
+	if p.senders == nil {
+		p.senders = map[string]int{}
+	}
+	if p.peers == nil {
+		p.peers = map[string]int{}
+	}
+	p.bytes += delta * vt.Transaction.Len()
+	sender := vt.Transaction.From()
+	if p.senders[sender] += delta; p.senders[sender] <= 0 {
+		delete(p.senders, sender)
+	}
+	if len(vt.Peer) > 0 {
+		if p.peers[vt.Peer] += delta; p.peers[vt.Peer] <= 0 {
+			delete(p.peers, vt.Peer)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package pool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+	"github.com/ubclaunchpad/cumulus/consensus"
+)
+
+func TestMaxCountEvictsOldest(t *testing.T) {
+	p := New()
+	p.SetLimits(Limits{MaxCount: 2})
+	t1 := blockchain.NewTestTransaction()
+	t2 := blockchain.NewTestTransaction()
+	t3 := blockchain.NewTestTransaction()
+	p.PushUnsafe(t1)
+	p.PushUnsafe(t2)
+	p.PushUnsafe(t3)
+	assert.Equal(t, 2, p.Size())
+	assert.Nil(t, p.Get(blockchain.HashSum(t1)))
+	assert.Equal(t, t2, p.Peek())
+}
+
+func TestMaxBytesEvictsOldest(t *testing.T) {
+	p := New()
+	t1 := blockchain.NewTestTransaction()
+	t2 := blockchain.NewTestTransaction()
+	p.SetLimits(Limits{MaxBytes: t1.Len() + t2.Len()})
+	p.PushUnsafe(t1)
+	p.PushUnsafe(t2)
+	assert.Equal(t, t1.Len()+t2.Len(), p.Bytes())
+
+	t3 := blockchain.NewTestTransaction()
+	p.PushUnsafe(t3)
+	assert.Nil(t, p.Get(blockchain.HashSum(t1)))
+	assert.True(t, p.Bytes() <= p.Limits().MaxBytes)
+
+	p.Delete(t2)
+	p.Delete(t3)
+	assert.Equal(t, 0, p.Bytes())
+}
+
+func TestExpire(t *testing.T) {
+	p := New()
+	p.SetLimits(Limits{MaxAge: time.Hour})
+	t1 := blockchain.NewTestTransaction()
+	t2 := blockchain.NewTestTransaction()
+	p.PushUnsafe(t1)
+	p.PushUnsafe(t2)
+	p.Order[0].Time = time.Now().Add(-2 * time.Hour)
+
+	assert.Equal(t, 1, p.Expire())
+	assert.Nil(t, p.Get(blockchain.HashSum(t1)))
+	assert.Equal(t, t2, p.Peek())
+	assert.Equal(t, 0, p.Expire())
+}
+
+func TestPushFromPeerQuota(t *testing.T) {
+	p := New()
+	p.SetLimits(Limits{MaxPerPeer: 1})
+	bc, b := blockchain.NewValidTestChainAndBlock()
+
+	code, err := p.PushFrom(b.Transactions[1], bc, "peer")
+	assert.Equal(t, consensus.ValidTransaction, code)
+	assert.Nil(t, err)
+	_, err = p.PushFrom(b.Transactions[2], bc, "peer")
+	assert.Equal(t, ErrPeerQuota, err)
+	assert.Equal(t, 1, p.Size())
+
+	// Other peers have their own quota.
+	_, err = p.PushFrom(b.Transactions[2], bc, "other")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, p.Size())
+
+	// The quota is freed once the transaction leaves the pool.
+	p.Delete(b.Transactions[1])
+	_, err = p.PushFrom(b.Transactions[1], bc, "peer")
+	assert.Nil(t, err)
+}
+
+func TestPushFromSenderQuota(t *testing.T) {
+	p := New()
+	p.SetLimits(Limits{MaxPerSender: 1})
+	bc, b := blockchain.NewValidTestChainAndBlock()
+
+	// Another pending transaction from the same sender.
+	other := *b.Transactions[1]
+	other.Inputs = []blockchain.TxHashPointer{blockchain.NewTestTxHashPointer()}
+	p.PushUnsafe(&other)
+
+	_, err := p.PushFrom(b.Transactions[1], bc, "")
+	assert.Equal(t, ErrSenderQuota, err)
+	_, err = p.PushFrom(b.Transactions[2], bc, "")
+	assert.Nil(t, err)
+}
+
+func TestPushAllInsertsAllOrNone(t *testing.T) {
+	p := New()
+	p.SetLimits(Limits{MaxPerSender: 1})
+	bc, b := blockchain.NewValidTestChainAndBlock()
+
+	// An invalid transaction keeps the valid one out too.
+	invalid := blockchain.NewTestTransaction()
+	code, _ := p.PushAll([]*blockchain.Transaction{b.Transactions[1], invalid}, bc, "")
+	assert.NotEqual(t, consensus.ValidTransaction, code)
+	assert.Equal(t, 0, p.Size())
+
+	// A transaction over its sender's quota takes the ones before it back out.
+	other := *b.Transactions[2]
+	other.Inputs = []blockchain.TxHashPointer{blockchain.NewTestTxHashPointer()}
+	p.PushUnsafe(&other)
+	_, err := p.PushAll(b.Transactions[1:3], bc, "")
+	assert.Equal(t, ErrSenderQuota, err)
+	assert.Equal(t, 1, p.Size())
+
+	p.Delete(&other)
+	code, err = p.PushAll(b.Transactions[1:3], bc, "")
+	assert.Equal(t, consensus.ValidTransaction, code)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, p.Size())
+}
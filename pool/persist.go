@@ -0,0 +1,78 @@
+package pool
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/ubclaunchpad/cumulus/blockchain"
+	"github.com/ubclaunchpad/cumulus/consensus"
+)
+
+// Save writes the transactions in the pool to the file with the given name in
+// JSON format, in the order they arrived. The file is written to a temporary
+// file and synced to disk first and then renamed, so a crash while saving
+// leaves the previous copy intact.
+func (p *Pool) Save(fileName string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	tmpFileName := fileName + ".tmp"
+	file, err := os.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(p.Order); err != nil {
+		file.Close()
+		os.Remove(tmpFileName)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpFileName)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// Load reads the transactions written by Save from the file with the given
+// name and pushes them back into the pool, re-validating each one against bc.
+// Transactions that are no longer valid or have expired are dropped. Restored
+// transactions keep the time they first arrived as long as that doesn't put
+// them ahead of transactions already in the pool. Returns the transactions
+// that were restored, in order.
+func (p *Pool) Load(fileName string, bc *blockchain.BlockChain) ([]*blockchain.Transaction, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var saved []*PooledTransaction
+	dec := json.NewDecoder(file)
+	dec.UseNumber()
+	if err := dec.Decode(&saved); err != nil {
+		return nil, err
+	}
+
+	restored := make([]*blockchain.Transaction, 0, len(saved))
+	for _, pt := range saved {
+		if pt.Transaction == nil || p.expired(pt) {
+			continue
+		}
+		code, err := p.pushFrom(pt.Transaction, bc, pt.Peer)
+		if code != consensus.ValidTransaction || err != nil {
+			continue
+		}
+		vt := p.ValidTransactions[blockchain.HashSum(pt.Transaction)]
+		if n := len(p.Order); n < 2 || p.Order[n-2].Time.Before(pt.Time) {
+			vt.Time = pt.Time
+		}
+		restored = append(restored, pt.Transaction)
+	}
+	return restored, nil
+}
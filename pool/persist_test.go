@@ -0,0 +1,56 @@
+package pool
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+const testPoolFileName = "poolTestFile.json"
+
+func TestSaveAndLoad(t *testing.T) {
+	p := New()
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+	p.Push(parent, bc)
+	p.PushFrom(child, bc, "127.0.0.1:8001")
+	p.Order[0].Time = time.Now().Add(-time.Hour)
+	p.PushUnsafe(blockchain.NewTestTransaction())
+
+	assert.Nil(t, p.Save(testPoolFileName))
+	defer os.Remove(testPoolFileName)
+
+	// Only the valid transactions are restored, in order.
+	loaded := New()
+	restored, err := loaded.Load(testPoolFileName, bc)
+	assert.Nil(t, err)
+	assert.Equal(t, []*blockchain.Transaction{parent, child}, restored)
+	assert.Equal(t, 2, loaded.Size())
+	assert.Equal(t, parent, loaded.Peek())
+	assert.True(t, p.Order[0].Time.Equal(loaded.Order[0].Time))
+	assert.Equal(t, "127.0.0.1:8001", loaded.Order[1].Peer)
+	assert.Equal(t, child, loaded.SpentBy(child.Inputs[0], child.Sender.Repr()))
+}
+
+func TestLoadDropsExpired(t *testing.T) {
+	p := New()
+	bc, txn := blockchain.NewValidChainAndTxn()
+	p.Push(txn, bc)
+	p.Order[0].Time = time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, p.Save(testPoolFileName))
+	defer os.Remove(testPoolFileName)
+
+	loaded := New()
+	loaded.SetLimits(Limits{MaxAge: time.Hour})
+	restored, err := loaded.Load(testPoolFileName, bc)
+	assert.Nil(t, err)
+	assert.Empty(t, restored)
+	assert.True(t, loaded.Empty())
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	_, err := New().Load("doesNotExist.json", blockchain.New())
+	assert.True(t, os.IsNotExist(err))
+}
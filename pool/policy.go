@@ -0,0 +1,99 @@
+package pool
+
+import (
+	"fmt"
+
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+const (
+	// OldestFirstPolicy is the name of the OldestFirst selection policy.
+	OldestFirstPolicy = "oldest-first"
+	// BestFitPolicy is the name of the BestFit selection policy.
+	BestFitPolicy = "best-fit"
+	// FairPolicy is the name of the selection policy that caps the number of
+	// transactions from each sender and packs them with BestFit.
+	FairPolicy = "fair"
+	// DefaultMaxPerSender is the default number of transactions from a single
+	// sender that the fair selection policy will put in a block.
+	DefaultMaxPerSender = 10
+)
+
+// SelectionPolicy decides which pooled transactions go in the next block.
+type SelectionPolicy interface {
+	// Select returns the transactions to put in a block, in order, given the
+	// pooled transactions in the order they arrived and the number of bytes
+	// available for them in the block. Transactions that aren't selected stay
+	// in the pool.
+	Select(txns []*PooledTransaction, space int) []*blockchain.Transaction
+}
+
+// OldestFirst selects transactions in the order they arrived, stopping at the
+// first one that doesn't fit.
+type OldestFirst struct{}
+
+// Select implements SelectionPolicy.
+func (OldestFirst) Select(txns []*PooledTransaction, space int) []*blockchain.Transaction {
+	selected := make([]*blockchain.Transaction, 0)
+	for _, pt := range txns {
+		size := pt.Transaction.Len()
+		if size > space {
+			break
+		}
+		selected = append(selected, pt.Transaction)
+		space -= size
+	}
+	return selected
+}
+
+// BestFit selects transactions in the order they arrived, skipping those that
+// don't fit and continuing to fill the block with smaller ones.
+type BestFit struct{}
+
+// Select implements SelectionPolicy.
+func (BestFit) Select(txns []*PooledTransaction, space int) []*blockchain.Transaction {
+	selected := make([]*blockchain.Transaction, 0)
+	for _, pt := range txns {
+		if size := pt.Transaction.Len(); size <= space {
+			selected = append(selected, pt.Transaction)
+			space -= size
+		}
+	}
+	return selected
+}
+
+// SenderCap limits the number of transactions from each sender that Policy
+// may select, so that one sender can't fill every block.
+type SenderCap struct {
+	Max    int
+	Policy SelectionPolicy
+}
+
+// Select implements SelectionPolicy.
+func (s SenderCap) Select(txns []*PooledTransaction, space int) []*blockchain.Transaction {
+	counts := map[string]int{}
+	capped := make([]*PooledTransaction, 0, len(txns))
+	for _, pt := range txns {
+		sender := pt.Transaction.From()
+		if counts[sender] < s.Max {
+			capped = append(capped, pt)
+			counts[sender]++
+		}
+	}
+	return s.Policy.Select(capped, space)
+}
+
+// PolicyByName returns the built-in selection policy with the given name. The
+// fair policy allows at most maxPerSender transactions from each sender.
+// Returns an error if there is no such policy.
+func PolicyByName(name string, maxPerSender int) (SelectionPolicy, error) {
+	switch name {
+	case OldestFirstPolicy:
+		return OldestFirst{}, nil
+	case BestFitPolicy:
+		return BestFit{}, nil
+	case FairPolicy:
+		return SenderCap{Max: maxPerSender, Policy: BestFit{}}, nil
+	}
+	return nil, fmt.Errorf("Unknown selection policy %q", name)
+}
@@ -0,0 +1,84 @@
+package pool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+// newTestPolicyPool creates a pool holding a large transaction between two
+// small ones, and returns the pool and its transactions in arrival order.
+func newTestPolicyPool() (*Pool, []*blockchain.Transaction) {
+	p := New()
+	small := blockchain.NewTestTransaction()
+	small.Inputs = small.Inputs[:1]
+	small.Outputs = small.Outputs[:1]
+	large := blockchain.NewTestTransaction()
+	for len(large.Inputs) < 10 {
+		large.Inputs = append(large.Inputs, blockchain.NewTestTxHashPointer())
+	}
+	other := blockchain.NewTestTransaction()
+	other.Inputs = other.Inputs[:1]
+	other.Outputs = other.Outputs[:1]
+
+	txns := []*blockchain.Transaction{small, large, other}
+	for _, txn := range txns {
+		p.PushUnsafe(txn)
+	}
+	return p, txns
+}
+
+func TestOldestFirst(t *testing.T) {
+	p, txns := newTestPolicyPool()
+	space := txns[0].Len() + txns[2].Len()
+
+	// The large transaction doesn't fit, so the block ends there.
+	selected := OldestFirst{}.Select(p.Order, space)
+	assert.Equal(t, txns[:1], selected)
+	assert.Equal(t, txns, OldestFirst{}.Select(p.Order, 1<<18))
+}
+
+func TestBestFit(t *testing.T) {
+	p, txns := newTestPolicyPool()
+	space := txns[0].Len() + txns[2].Len()
+
+	// The large transaction is skipped and the block is filled after it.
+	selected := BestFit{}.Select(p.Order, space)
+	assert.Equal(t, []*blockchain.Transaction{txns[0], txns[2]}, selected)
+	assert.Equal(t, 3, p.Size())
+}
+
+func TestSenderCap(t *testing.T) {
+	p := New()
+	sender := blockchain.NewWallet().Public()
+	flood := make([]*blockchain.Transaction, 5)
+	for i := range flood {
+		flood[i] = blockchain.NewTestTransaction()
+		flood[i].Sender = sender
+		p.PushUnsafe(flood[i])
+	}
+	other := blockchain.NewTestTransaction()
+	p.PushUnsafe(other)
+
+	policy := SenderCap{Max: 2, Policy: BestFit{}}
+	selected := policy.Select(p.Order, 1<<18)
+	assert.Equal(t, []*blockchain.Transaction{flood[0], flood[1], other}, selected)
+}
+
+func TestPolicyByName(t *testing.T) {
+	policy, err := PolicyByName(OldestFirstPolicy, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, OldestFirst{}, policy)
+
+	policy, err = PolicyByName(BestFitPolicy, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, BestFit{}, policy)
+
+	policy, err = PolicyByName(FairPolicy, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, SenderCap{Max: 3, Policy: BestFit{}}, policy)
+
+	_, err = PolicyByName("newest-first", 0)
+	assert.NotNil(t, err)
+}
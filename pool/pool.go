@@ -1,24 +1,39 @@
 package pool
 
 import (
+	"sync"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/ubclaunchpad/cumulus/blockchain"
 	"github.com/ubclaunchpad/cumulus/common/util"
 	"github.com/ubclaunchpad/cumulus/consensus"
 	"github.com/ubclaunchpad/cumulus/miner"
 )
 
-// PooledTransaction is a Transaction with a timestamp.
+// PooledTransaction is a Transaction with a timestamp and the listen address
+// of the peer it came from, which is empty if it was created locally.
 type PooledTransaction struct {
 	Transaction *blockchain.Transaction
 	Time        time.Time
+	Peer        string
+	// inputs are the transaction's inputs with unconfirmed pointers to
+	// transactions on the blockchain resolved.
+	inputs []blockchain.TxHashPointer
 }
 
-// Pool is a set of valid Transactions.
+// Pool is a set of valid Transactions. It is safe for concurrent use.
 type Pool struct {
 	Order             []*PooledTransaction
 	ValidTransactions map[blockchain.Hash]*PooledTransaction
+	policy            SelectionPolicy
+	limits            Limits
+	bytes             int
+	senders           map[string]int
+	peers             map[string]int
+	spends            map[spendKey]blockchain.Hash
+	children          map[blockchain.Hash]map[blockchain.Hash]bool
+	lock              sync.Mutex
 }
 
 // New initializes a new pool.
@@ -26,11 +41,32 @@ func New() *Pool {
 	return &Pool{
 		Order:             []*PooledTransaction{},
 		ValidTransactions: map[blockchain.Hash]*PooledTransaction{},
+		policy:            OldestFirst{},
+		limits:            DefaultLimits,
+		senders:           map[string]int{},
+		peers:             map[string]int{},
+		spends:            map[spendKey]blockchain.Hash{},
+		children:          map[blockchain.Hash]map[blockchain.Hash]bool{},
 	}
 }
 
+// SetPolicy sets the policy used to choose the transactions in the blocks
+// returned by NextBlock.
+func (p *Pool) SetPolicy(policy SelectionPolicy) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.policy = policy
+}
+
 // Size returns the number of transactions in the Pool.
 func (p *Pool) Size() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.size()
+}
+
+// size returns the number of transactions in the Pool without locking it.
+func (p *Pool) size() int {
 	return len(p.ValidTransactions)
 }
 
@@ -42,6 +78,13 @@ func (p *Pool) Empty() bool {
 // Get returns the tranasction with transaction Hash h. Returns nil if
 // there is not transaction in the pool with the given hashsum.
 func (p *Pool) Get(h blockchain.Hash) *blockchain.Transaction {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.get(h)
+}
+
+// get is Get without locking the pool.
+func (p *Pool) get(h blockchain.Hash) *blockchain.Transaction {
 	if pooledTxn := p.ValidTransactions[h]; pooledTxn != nil {
 		return pooledTxn.Transaction
 	}
@@ -50,14 +93,23 @@ func (p *Pool) Get(h blockchain.Hash) *blockchain.Transaction {
 
 // GetN returns the Nth transaction in the pool.
 func (p *Pool) GetN(N int) *blockchain.Transaction {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	return p.Order[N].Transaction
 }
 
 // GetIndex returns the index of the transaction in the ordering.
 func (p *Pool) GetIndex(t *blockchain.Transaction) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.index(t)
+}
+
+// index is GetIndex without locking the pool.
+func (p *Pool) index(t *blockchain.Transaction) int {
 	hash := blockchain.HashSum(t)
 	target := p.ValidTransactions[hash].Time
-	return getIndex(p.Order, target, 0, p.Size()-1)
+	return getIndex(p.Order, target, 0, p.size()-1)
 }
 
 // getIndex does a binary search for a PooledTransaction by timestamp.
@@ -72,66 +124,255 @@ func getIndex(a []*PooledTransaction, target time.Time, low, high int) int {
 	}
 }
 
-// Push inserts a transaction into the pool, returning
-// true if the Transaction was inserted (was valid).
-// TODO: This should return an error if could not add.
+// Push inserts a locally created transaction into the pool, returning the
+// result of validating it. The transaction is only inserted if it is valid and
+// fits within the pool's quotas.
 func (p *Pool) Push(t *blockchain.Transaction, bc *blockchain.BlockChain) consensus.TransactionCode {
-	ok, code := consensus.VerifyTransaction(bc, t)
-	if ok {
-		p.set(t)
-	}
+	code, _ := p.PushFrom(t, bc, "")
 	return code
 }
 
-// PushUnsafe adds a transaction to the pool without validation.
+// PushFrom inserts a transaction received from the peer with the given listen
+// address into the pool, returning the result of validating it. The
+// transaction may spend the outputs of pending transactions in the pool. An
+// error is returned if the transaction is valid but spends an input already
+// spent by a pending transaction, or exceeds the pool's quotas. The oldest
+// transactions in the pool are evicted to make room for it if the pool is
+// full. Pushing a transaction that is already pending has no effect.
+// Transactions whose locks have not been reached are held in the pool until
+// they can be mined.
+func (p *Pool) PushFrom(t *blockchain.Transaction, bc *blockchain.BlockChain,
+	peer string) (consensus.TransactionCode, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.pushFrom(t, bc, peer)
+}
+
+// pushFrom is PushFrom without locking the pool.
+func (p *Pool) pushFrom(t *blockchain.Transaction, bc *blockchain.BlockChain,
+	peer string) (consensus.TransactionCode, error) {
+	view := p.view(bc, t)
+	ok, code := consensus.VerifyPendingTransaction(view, t)
+	if !ok || p.get(blockchain.HashSum(t)) != nil {
+		return code, nil
+	}
+	inputs := resolveInputs(view, t)
+	if err := p.conflicts(t, inputs); err != nil {
+		return code, err
+	}
+	if err := p.admit(t, peer, p.parents(t)); err != nil {
+		return code, err
+	}
+	p.insert(t, peer, inputs)
+	return code, nil
+}
+
+// PushAll inserts transactions from the peer with the given listen address
+// into the pool as PushFrom does, but only if all of them can be inserted.
+// Every transaction is validated before any is inserted, and if one of them
+// exceeds the pool's quotas the ones already inserted are removed again. The
+// code returned is that of the first transaction that failed, or that of the
+// last one if all were inserted.
+func (p *Pool) PushAll(txns []*blockchain.Transaction, bc *blockchain.BlockChain,
+	peer string) (consensus.TransactionCode, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	code := consensus.ValidTransaction
+	inputs := make([][]blockchain.TxHashPointer, len(txns))
+	for i, t := range txns {
+		view := p.view(bc, t)
+		var ok bool
+		if ok, code = consensus.VerifyPendingTransaction(view, t); !ok {
+			return code, nil
+		}
+		inputs[i] = resolveInputs(view, t)
+		if err := p.conflicts(t, inputs[i]); err != nil {
+			return code, err
+		}
+	}
+	for i, t := range txns {
+		if p.get(blockchain.HashSum(t)) != nil {
+			continue
+		}
+		if err := p.admit(t, peer, p.parents(t)); err != nil {
+			for _, done := range txns[:i] {
+				p.drop(done)
+			}
+			return code, err
+		}
+		p.insert(t, peer, inputs[i])
+	}
+	return code, nil
+}
+
+// PushUnsafe adds a transaction to the pool without validation or quota
+// checks. The oldest transactions are still evicted if the pool is full.
 func (p *Pool) PushUnsafe(t *blockchain.Transaction) {
-	p.set(t)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.makeRoom(t.Len())
+	p.set(t, "")
 }
 
 // Silently adds a transaction to the pool.
 // Deletes a transaction if it exists from the input hash.
-func (p *Pool) set(t *blockchain.Transaction) {
+func (p *Pool) set(t *blockchain.Transaction, peer string) {
+	p.insert(t, peer, t.Inputs)
+}
+
+// insert adds a transaction from the given peer, which spends the given
+// resolved inputs, to the pool. Deletes the transaction first if it is already
+// in the pool.
+func (p *Pool) insert(t *blockchain.Transaction, peer string,
+	inputs []blockchain.TxHashPointer) {
 	hash := blockchain.HashSum(t)
 	if txn, ok := p.ValidTransactions[hash]; ok {
-		p.Delete(txn.Transaction)
+		p.drop(txn.Transaction)
 	}
 	vt := &PooledTransaction{
 		Transaction: t,
 		Time:        time.Now(),
+		Peer:        peer,
+		inputs:      inputs,
 	}
 	p.Order = append(p.Order, vt)
 	p.ValidTransactions[hash] = vt
+	p.account(vt, 1)
+	p.trackInputs(hash, vt, true)
+	p.linkParents(hash, vt, true)
 }
 
-// Delete removes a transaction from the Pool.
+// Delete removes a transaction from the Pool. Pending transactions that spend
+// its outputs stay in the pool; use evict to remove them too.
 func (p *Pool) Delete(t *blockchain.Transaction) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.drop(t)
+}
+
+// drop is Delete without locking the pool.
+func (p *Pool) drop(t *blockchain.Transaction) {
 	hash := blockchain.HashSum(t)
 	vt, ok := p.ValidTransactions[hash]
 	if ok {
-		i := p.GetIndex(vt.Transaction)
+		i := p.index(vt.Transaction)
 		p.Order = append(p.Order[0:i], p.Order[i+1:]...)
 		delete(p.ValidTransactions, hash)
+		p.account(vt, -1)
+		p.trackInputs(hash, vt, false)
+		p.linkParents(hash, vt, false)
 	}
 }
 
 // Update updates the Pool by removing the Transactions found in the
-// Block. If the Block is found invalid wrt bc, then false is returned and no
-// Transactions are removed from the Pool.
+// Block. Pending transactions that spend the same inputs as a transaction in
+// the block can never be mined, so they are evicted along with the pending
+// transactions that spend their outputs. If the Block is found invalid wrt
+// bc, then false is returned and no Transactions are removed from the Pool.
 func (p *Pool) Update(b *blockchain.Block, bc *blockchain.BlockChain) bool {
 	if ok, _ := consensus.VerifyBlock(bc, b); !ok {
 		return false
 	}
+	p.Purge(b, bc)
+	return true
+}
+
+// Purge removes the Transactions found in the Block from the Pool, along with
+// the pending transactions that conflict with them, like Update does. The
+// Block is not verified; it must be valid wrt bc and not yet added to it.
+func (p *Pool) Purge(b *blockchain.Block, bc *blockchain.BlockChain) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, t := range b.Transactions {
+		p.drop(t)
+	}
+	p.evictConflicts(b, bc.NewUTXOView())
+}
+
+// evictConflicts evicts the pending transactions that spend the same inputs as
+// the transactions in the given block, resolved in the given view, along with
+// the pending transactions that spend their outputs.
+func (p *Pool) evictConflicts(b *blockchain.Block, view *blockchain.UTXOView) {
 	for _, t := range b.Transactions {
-		p.Delete(t)
+		for _, input := range resolveInputs(view, t) {
+			key := spendKey{input, t.From()}
+			if hash, ok := p.spends[key]; ok {
+				p.evict(p.get(hash))
+			}
+		}
+		view.Apply(t)
+	}
+}
+
+// Reorganize updates the Pool after the main chain of bc has been switched to
+// another branch as described by r. Transactions in the newly connected blocks
+// are removed from the Pool along with the pending transactions that conflict
+// with them, and transactions in the disconnected blocks that did not make it
+// into the new branch are returned to the Pool if they are still valid with
+// respect to bc. Pending transactions that are no longer valid, such as those
+// spending outputs created only in the disconnected blocks, are evicted.
+// Returns the number of transactions that were returned to the Pool.
+func (p *Pool) Reorganize(r *blockchain.Reorg, bc *blockchain.BlockChain) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	confirmed := map[blockchain.Hash]bool{}
+	view := bc.NewUTXOView()
+	for _, b := range r.Connected {
+		for _, t := range b.Transactions {
+			confirmed[blockchain.HashSum(t)] = true
+			p.drop(t)
+		}
+		p.evictConflicts(b, view)
+	}
+
+	returned := 0
+	for i := len(r.Disconnected) - 1; i >= 0; i-- {
+		// Skip the CloudBase transaction, its reward went with the block.
+		for _, t := range r.Disconnected[i].Transactions[1:] {
+			if confirmed[blockchain.HashSum(t)] {
+				continue
+			}
+			if code, _ := p.pushFrom(t, bc, ""); code == consensus.ValidTransaction {
+				returned++
+			}
+		}
+	}
+	p.revalidate(bc)
+	return returned
+}
+
+// revalidate evicts the pending transactions that are no longer valid with
+// respect to bc, along with the pending transactions that spend their outputs.
+// The inputs of the others are resolved again, as the transactions they spend
+// may have moved on the main chain or left it.
+func (p *Pool) revalidate(bc *blockchain.BlockChain) {
+	for _, vt := range append([]*PooledTransaction{}, p.Order...) {
+		t := vt.Transaction
+		hash := blockchain.HashSum(t)
+		if p.ValidTransactions[hash] != vt {
+			// Already evicted along with a transaction it spends from.
+			continue
+		}
+		view := p.view(bc, t)
+		if ok, _ := consensus.VerifyPendingTransaction(view, t); !ok {
+			p.evict(t)
+			continue
+		}
+		p.trackInputs(hash, vt, false)
+		vt.inputs = resolveInputs(view, t)
+		p.trackInputs(hash, vt, true)
+		p.linkParents(hash, vt, true)
 	}
-	return true
 }
 
 // Pop returns the next transaction and removes it from the pool.
 func (p *Pool) Pop() *blockchain.Transaction {
-	if p.Size() > 0 {
-		next := p.GetN(0)
-		p.Delete(next)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.size() > 0 {
+		next := p.Order[0].Transaction
+		p.drop(next)
 		return next
 	}
 	return nil
@@ -139,44 +380,89 @@ func (p *Pool) Pop() *blockchain.Transaction {
 
 // Peek returns the next transaction and does not remove it from the pool.
 func (p *Pool) Peek() *blockchain.Transaction {
-	if p.Size() > 0 {
-		return p.GetN(0)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.size() > 0 {
+		return p.Order[0].Transaction
 	}
 	return nil
 }
 
-// NextBlock produces a new block from the pool for mining. The block returned
-// may not contain transactions if there are none left in the transaction pool.
+// NextBlock produces a new block from the pool for mining, smaller than the
+// given size. The pool's selection policy chooses the transactions in the
+// block from those whose locks have been reached; they stay in the pool until
+// the block is added to the blockchain. The block returned may not contain
+// transactions if there are none in the transaction pool.
 func (p *Pool) NextBlock(chain *blockchain.BlockChain,
 	address blockchain.Address, size uint32) *blockchain.Block {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	var txns []*blockchain.Transaction
 
+	// Don't mine transactions that have expired.
+	p.expire()
+
 	// Hash the last block in the chain.
 	lastHash := blockchain.HashSum(chain.LastBlock())
 
+	// The block's time must be after the median time of the last few blocks.
+	now := util.UnixNow()
+	median := consensus.MedianTimePast(chain, chain.LastBlock())
+	if now <= median {
+		now = median + 1
+	}
+
 	// Build a new block for mining.
 	b := &blockchain.Block{
 		BlockHeader: blockchain.BlockHeader{
 			BlockNumber: uint32(len(chain.Blocks)),
 			LastBlock:   lastHash,
-			Time:        util.UnixNow(),
+			Target:      consensus.NextTarget(chain, chain.LastBlock()),
+			Time:        now,
 			Nonce:       0,
 		}, Transactions: txns,
 	}
 
-	// Prepend the cloudbase transaction for this miner.
+	// Work out how much space is left once the cloudbase transaction is
+	// added.
 	miner.CloudBase(b, chain, address)
+	space := int(size) - b.Len() - 1
 
-	// Try to grab as many transactions as the block will allow.
-	// Test each transaction to see if we break size before adding.
-	for p.Size() > 0 {
-		nextSize := p.Peek().Len()
-		if b.Len()+nextSize < int(size) {
-			b.Transactions = append(b.Transactions, p.Pop())
-		} else {
-			break
-		}
+	// Let the selection policy fill the block, putting transactions after the
+	// pending transactions they spend from, then prepend the cloudbase
+	// transaction for this miner, which claims the fees of the transactions
+	// selected.
+	policy := p.policy
+	if policy == nil {
+		policy = OldestFirst{}
+	}
+	final := p.final(chain, b.BlockNumber, median)
+	b.Transactions = p.parentsFirst(policy.Select(final, space))
+	if _, err := miner.CloudBase(b, chain, address); err != nil {
+		// Mine an empty block rather than one that will be rejected.
+		log.WithError(err).Error("Leaving transactions out of block")
+		b.Transactions = nil
+		miner.CloudBase(b, chain, address)
 	}
-
 	return b
 }
+
+// final returns the pooled transactions, in order, that are still valid wrt
+// chain and whose locks allow them to be included in the block with the given
+// block number and median time past. Transactions are verified again in case
+// blocks that conflict with them were added to chain without updating the
+// pool.
+func (p *Pool) final(chain *blockchain.BlockChain, blockNumber,
+	medianTime uint32) []*PooledTransaction {
+	final := make([]*PooledTransaction, 0, len(p.Order))
+	for _, pt := range p.Order {
+		view := p.view(chain, pt.Transaction)
+		if ok, _ := consensus.VerifyPendingTransaction(view, pt.Transaction); !ok {
+			continue
+		}
+		if ok, _ := consensus.VerifyLocks(view, pt.Transaction, blockNumber, medianTime); ok {
+			final = append(final, pt)
+		}
+	}
+	return final
+}
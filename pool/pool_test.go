@@ -1,7 +1,10 @@
 package pool
 
 import (
+	crand "crypto/rand"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/ubclaunchpad/cumulus/blockchain"
@@ -76,26 +79,53 @@ func TestGetIndex(t *testing.T) {
 
 func TestNextBlock(t *testing.T) {
 	p := New()
-	chain, _ := blockchain.NewValidTestChainAndBlock()
+	chain, valid := blockchain.NewValidTestChainAndBlock()
 	nBlks := len(chain.Blocks)
 	lastBlk := chain.Blocks[nBlks-1]
 	numTxns := 1000
 	for i := 0; i < numTxns; i++ {
 		p.PushUnsafe(blockchain.NewTestTransaction())
 	}
+	for _, txn := range valid.Transactions[1:] {
+		p.PushUnsafe(txn)
+	}
 	b := p.NextBlock(chain, blockchain.NewWallet().Public(), 1<<18)
 
 	assert.NotNil(t, b)
 	assert.True(t, b.Len() < 1<<18)
 	assert.True(t, b.Len() > 0)
 
-	// The difference is off by one thanks to cloud transaction.
-	assert.Equal(t, len(b.Transactions), numTxns-p.Size()+1)
+	// Only the transactions that are valid wrt the chain are picked, and they
+	// stay in the pool until the block is mined.
+	assert.Equal(t, valid.Transactions[1:], b.Transactions[1:])
+	assert.Equal(t, numTxns+2, p.Size())
 	assert.Equal(t, blockchain.HashSum(lastBlk), b.LastBlock)
 	assert.Equal(t, uint64(0), b.Nonce)
 	assert.Equal(t, uint32(nBlks), b.BlockNumber)
 }
 
+func TestConcurrentAccess(t *testing.T) {
+	p := New()
+	p.SetLimits(Limits{MaxAge: time.Millisecond})
+	chain, _ := blockchain.NewValidTestChainAndBlock()
+	defer os.Remove("concurrentPoolTestFile.json")
+
+	// The miner builds blocks, expiring old transactions, while transactions
+	// arrive and the pool is saved.
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			p.NextBlock(chain, blockchain.NewWallet().Public(), 1<<18)
+		}
+		done <- true
+	}()
+	for i := 0; i < 100; i++ {
+		p.PushUnsafe(blockchain.NewTestTransaction())
+		assert.Nil(t, p.Save("concurrentPoolTestFile.json"))
+	}
+	<-done
+}
+
 func TestPeek(t *testing.T) {
 	p := New()
 	assert.Nil(t, p.Peek())
@@ -114,3 +144,154 @@ func TestSetDedupes(t *testing.T) {
 	assert.Equal(t, p.Size(), 1)
 	assert.Equal(t, p.Peek(), t1)
 }
+
+func TestReorganize(t *testing.T) {
+	p := New()
+	bc, b := blockchain.NewValidTestChainAndBlock()
+
+	// Pretend the new block was disconnected and the last block in the chain
+	// was connected in its place.
+	confirmed := bc.Blocks[2].Transactions[1]
+	p.PushUnsafe(confirmed)
+	r := &blockchain.Reorg{
+		Disconnected: []*blockchain.Block{b},
+		Connected:    []*blockchain.Block{bc.Blocks[2]},
+	}
+
+	assert.Equal(t, 2, p.Reorganize(r, bc))
+	assert.Equal(t, 2, p.Size())
+	assert.Nil(t, p.Get(blockchain.HashSum(confirmed)))
+	assert.NotNil(t, p.Get(blockchain.HashSum(b.Transactions[1])))
+	assert.NotNil(t, p.Get(blockchain.HashSum(b.Transactions[2])))
+}
+
+// newTestBlockOn returns a block containing the given transactions after a
+// CloudBase transaction that builds on the last block of bc.
+func newTestBlockOn(bc *blockchain.BlockChain, txns ...*blockchain.Transaction) *blockchain.Block {
+	_, b := blockchain.NewValidTestChainAndBlock()
+	b.BlockNumber = uint32(len(bc.Blocks))
+	b.LastBlock = blockchain.HashSum(bc.LastBlock())
+	b.Time = bc.LastBlock().Time + 60
+	b.Transactions = append([]*blockchain.Transaction{b.Transactions[0]}, txns...)
+	b.UpdateMerkleRoot()
+	return b
+}
+
+func TestReorganizeKeepsChildrenOfReturnedTransactions(t *testing.T) {
+	p := New()
+	bc, parent, child := blockchain.NewValidTestChainAndChainedTxns()
+	b := newTestBlockOn(bc, parent)
+	bc.AppendBlock(b)
+	assert.Equal(t, consensus.ValidTransaction, p.Push(child, bc))
+
+	// The parent goes back to the pool and the child now spends from it.
+	bc.RollBack()
+	r := &blockchain.Reorg{Disconnected: []*blockchain.Block{b}}
+	assert.Equal(t, 1, p.Reorganize(r, bc))
+	assert.Equal(t, 2, p.Size())
+	assert.Equal(t, child, p.SpentBy(child.Inputs[0], child.Sender.Repr()))
+	next := p.NextBlock(bc, blockchain.NewWallet().Public(), 1<<18)
+	assert.Equal(t, []*blockchain.Transaction{parent, child}, next.Transactions[1:])
+	valid, code := consensus.VerifyBlock(bc, next)
+	assert.True(t, valid, "code %d", code)
+}
+
+func TestReorganizeEvictsConflicts(t *testing.T) {
+	p := New()
+	bc, t1, t2 := newTestSpends()
+	p.Push(t2, bc)
+
+	// The new branch confirms another transaction spending the same input.
+	b := newTestBlockOn(bc, t1)
+	bc.AppendBlock(b)
+	assert.Equal(t, 0, p.Reorganize(&blockchain.Reorg{Connected: []*blockchain.Block{b}}, bc))
+	assert.True(t, p.Empty())
+}
+
+func TestReorganizeEvictsSpendsOfDisconnectedOutputs(t *testing.T) {
+	p := New()
+	bc, wallets := blockchain.NewValidBlockChainFixture()
+	alice, bob := wallets["alice"], wallets["bob"]
+	pay := func(from *blockchain.Wallet, input blockchain.TxHashPointer,
+		to *blockchain.Wallet) *blockchain.Transaction {
+		t, _ := blockchain.TxBody{
+			Sender: from.Public(),
+			Inputs: []blockchain.TxHashPointer{input},
+			Outputs: []blockchain.TxOutput{{
+				Amount:    3,
+				Recipient: to.Public().Repr(),
+			}},
+		}.Sign(*from, crand.Reader)
+		return t
+	}
+	input := blockchain.TxHashPointer{
+		BlockNumber: 1,
+		Index:       1,
+		Hash:        blockchain.HashSum(bc.Blocks[1].Transactions[1]),
+	}
+
+	// Alice pays bob in one block, and bob's spend of the payment is pending.
+	payment := pay(alice, input, bob)
+	b1 := newTestBlockOn(bc, payment)
+	bc.AppendBlock(b1)
+	spend := pay(bob, blockchain.UnconfirmedInput(blockchain.HashSum(payment), 0), alice)
+	assert.Equal(t, consensus.ValidTransaction, p.Push(spend, bc))
+
+	// Another branch has alice pay herself instead, so the payment isn't
+	// returned to the pool and bob's spend of it can never be mined.
+	bc.RollBack()
+	b2 := newTestBlockOn(bc, pay(alice, input, alice))
+	bc.AppendBlock(b2)
+	r := &blockchain.Reorg{
+		Disconnected: []*blockchain.Block{b1},
+		Connected:    []*blockchain.Block{b2},
+	}
+	assert.Equal(t, 0, p.Reorganize(r, bc))
+	assert.True(t, p.Empty())
+}
+
+func TestNextBlockClaimsFees(t *testing.T) {
+	p := New()
+	bc, b := blockchain.NewValidTestChainAndBlockWithFee(1)
+	for _, txn := range b.Transactions[1:] {
+		assert.Equal(t, consensus.ValidTransaction, p.Push(txn, bc))
+	}
+
+	next := p.NextBlock(bc, blockchain.NewWallet().Public(), 1<<18)
+	assert.Equal(t, b.Transactions[1:], next.Transactions[1:])
+	assert.Equal(t, consensus.CurrentBlockReward(bc)+1, next.Transactions[0].Outputs[0].Amount)
+}
+
+func TestNextBlockSkipsConfirmedTransactions(t *testing.T) {
+	p := New()
+	bc, b := blockchain.NewValidTestChainAndBlock()
+	for _, txn := range b.Transactions[1:] {
+		assert.Equal(t, consensus.ValidTransaction, p.Push(txn, bc))
+	}
+
+	// The pool wasn't updated when the block was added, but its transactions
+	// can't be mined again.
+	bc.AppendBlock(b)
+	next := p.NextBlock(bc, blockchain.NewWallet().Public(), 1<<18)
+	assert.Len(t, next.Transactions, 1)
+	valid, code := consensus.VerifyBlock(bc, next)
+	assert.True(t, valid, code)
+}
+
+func TestNextBlockHoldsLockedTransactions(t *testing.T) {
+	p := New()
+	bc, body, wallets := blockchain.NewValidTestChainAndSpend(nil)
+	body.LockTime = 4
+	txn, _ := body.Sign(*wallets["alice"], crand.Reader)
+	assert.Equal(t, consensus.ValidTransaction, p.Push(txn, bc))
+
+	// The transaction can't be mined until block 4.
+	next := p.NextBlock(bc, blockchain.NewWallet().Public(), 1<<18)
+	assert.Equal(t, uint32(3), next.BlockNumber)
+	assert.Len(t, next.Transactions, 1)
+	assert.Equal(t, 1, p.Size())
+
+	bc.AppendBlock(next)
+	next = p.NextBlock(bc, blockchain.NewWallet().Public(), 1<<18)
+	assert.Equal(t, []*blockchain.Transaction{txn}, next.Transactions[1:])
+}
@@ -0,0 +1,196 @@
+package script
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+// Builder builds scripts one opcode at a time. The first error is kept and
+// returned by Script.
+type Builder struct {
+	script []byte
+	err    error
+}
+
+// NewBuilder returns a Builder for an empty script.
+func NewBuilder() *Builder {
+	return &Builder{script: []byte{}}
+}
+
+// AddOp appends an opcode to the script.
+func (b *Builder) AddOp(op Opcode) *Builder {
+	if !op.valid() {
+		b.fail(ErrBadOpcode)
+	}
+	b.script = append(b.script, byte(op))
+	return b
+}
+
+// AddData appends the shortest opcode that pushes data onto the stack.
+func (b *Builder) AddData(data []byte) *Builder {
+	switch n := len(data); {
+	case n == 0:
+		b.script = append(b.script, byte(Op0))
+	case n <= int(OpData75):
+		b.script = append(b.script, byte(n))
+	case n <= MaxElementSize:
+		b.script = append(b.script, byte(OpPushData1), byte(n))
+	default:
+		b.fail(ErrElementTooLarge)
+		return b
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+// AddNumber appends the shortest opcode that pushes n onto the stack.
+func (b *Builder) AddNumber(n uint32) *Builder {
+	if n >= 1 && n <= 16 {
+		return b.AddOp(Op1 + Opcode(n-1))
+	}
+	return b.AddData(encodeNumber(n))
+}
+
+// Script returns the script built, or the first error that occurred while
+// building it.
+func (b *Builder) Script() ([]byte, error) {
+	if b.err == nil && len(b.script) > MaxScriptSize {
+		b.err = ErrScriptTooLarge
+	}
+	return b.script, b.err
+}
+
+// fail records the first error that occurs while building.
+func (b *Builder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// EncodeKey returns the encoding of an address in scripts: its coordinates
+// in big endian, each padded to blockchain.CoordLen bytes.
+func EncodeKey(a blockchain.Address) []byte {
+	return append(pad(a.X), pad(a.Y)...)
+}
+
+// DecodeKey returns the address encoded by data, and false if data is not
+// the encoding of a point on the curve.
+func DecodeKey(data []byte) (blockchain.Address, bool) {
+	if len(data) != blockchain.AddrLen {
+		return blockchain.Address{}, false
+	}
+	a := blockchain.Address{
+		X: new(big.Int).SetBytes(data[:blockchain.CoordLen]),
+		Y: new(big.Int).SetBytes(data[blockchain.CoordLen:]),
+	}
+	if !a.Key().Curve.IsOnCurve(a.X, a.Y) {
+		return blockchain.Address{}, false
+	}
+	return a, true
+}
+
+// EncodeSignature returns the encoding of a signature in scripts: R and S in
+// big endian, each padded to blockchain.CoordLen bytes.
+func EncodeSignature(s blockchain.Signature) []byte {
+	return append(pad(s.R), pad(s.S)...)
+}
+
+// DecodeSignature returns the signature encoded by data, and false if data is
+// not the length of a signature.
+func DecodeSignature(data []byte) (blockchain.Signature, bool) {
+	if len(data) != blockchain.SigLen {
+		return blockchain.Signature{}, false
+	}
+	return blockchain.Signature{
+		R: new(big.Int).SetBytes(data[:blockchain.CoordLen]),
+		S: new(big.Int).SetBytes(data[blockchain.CoordLen:]),
+	}, true
+}
+
+// pad returns n in big endian, padded with leading zeros to
+// blockchain.CoordLen bytes.
+func pad(n *big.Int) []byte {
+	buf := make([]byte, blockchain.CoordLen)
+	b := n.Bytes()
+	copy(buf[len(buf)-len(b):], b)
+	return buf
+}
+
+// Sign returns the encoding of the given account's signature of a
+// transaction body, for use in unlocking scripts.
+func Sign(a blockchain.Account, tb blockchain.TxBody, r io.Reader) ([]byte, error) {
+	sig, err := a.Sign(blockchain.HashSum(tb), r)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeSignature(sig), nil
+}
+
+// PayToKey returns a locking script that is unlocked by a signature of the
+// given address, pushed by the unlocking script.
+func PayToKey(a blockchain.Address) ([]byte, error) {
+	return NewBuilder().
+		AddData(EncodeKey(a)).
+		AddOp(OpCheckSig).
+		Script()
+}
+
+// Multisig returns a locking script that is unlocked by signatures of m of
+// the given addresses, pushed by the unlocking script in the same order as
+// the addresses.
+func Multisig(m uint32, keys ...blockchain.Address) ([]byte, error) {
+	if m == 0 || int(m) > len(keys) || len(keys) > blockchain.MaxMultisigKeys {
+		return nil, ErrBadMultisig
+	}
+	b := NewBuilder().AddNumber(m)
+	for _, key := range keys {
+		b.AddData(EncodeKey(key))
+	}
+	return b.AddNumber(uint32(len(keys))).
+		AddOp(OpCheckMultisig).
+		Script()
+}
+
+// HashTimeLock returns the locking script of a hash-time-locked contract.
+// The recipient can spend the output by revealing the preimage of the given
+// SHA-256 hash with HashLockClaim. Once lockTime is reached, the refund
+// address can take the output back with HashLockRefund instead.
+func HashTimeLock(hash []byte, recipient, refund blockchain.Address,
+	lockTime uint32) ([]byte, error) {
+	return NewBuilder().
+		AddOp(OpIf).
+		AddOp(OpSHA256).
+		AddData(hash).
+		AddOp(OpEqualVerify).
+		AddData(EncodeKey(recipient)).
+		AddOp(OpElse).
+		AddNumber(lockTime).
+		AddOp(OpCheckLockTimeVerify).
+		AddData(EncodeKey(refund)).
+		AddOp(OpEndIf).
+		AddOp(OpCheckSig).
+		Script()
+}
+
+// HashLockClaim returns the unlocking script with which the recipient of a
+// hash-time-locked contract claims it, given their signature and the
+// preimage of the contract's hash.
+func HashLockClaim(sig, preimage []byte) ([]byte, error) {
+	return NewBuilder().
+		AddData(sig).
+		AddData(preimage).
+		AddNumber(1).
+		Script()
+}
+
+// HashLockRefund returns the unlocking script with which the refund address
+// of a hash-time-locked contract takes it back, given its signature. The
+// transaction's lock time must be at least the contract's.
+func HashLockRefund(sig []byte) ([]byte, error) {
+	return NewBuilder().
+		AddData(sig).
+		AddNumber(0).
+		Script()
+}
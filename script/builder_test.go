@@ -0,0 +1,87 @@
+package script
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+func TestBuilderAddData(t *testing.T) {
+	s, err := NewBuilder().AddData(nil).AddData([]byte{7}).Script()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{byte(Op0), byte(OpData1), 7}, s)
+
+	data := make([]byte, int(OpData75)+1)
+	s, err = NewBuilder().AddData(data).Script()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{byte(OpPushData1), byte(len(data))}, s[:2])
+	assert.Len(t, s, len(data)+2)
+
+	_, err = NewBuilder().AddData(make([]byte, MaxElementSize+1)).Script()
+	assert.Equal(t, ErrElementTooLarge, err)
+}
+
+func TestBuilderAddNumber(t *testing.T) {
+	s, _ := NewBuilder().AddNumber(0).AddNumber(1).AddNumber(16).Script()
+	assert.Equal(t, []byte{byte(Op0), byte(Op1), byte(Op16)}, s)
+
+	s, _ = NewBuilder().AddNumber(17).AddNumber(0x1234).Script()
+	assert.Equal(t, []byte{1, 17, 2, 0x34, 0x12}, s)
+
+	for _, n := range []uint32{0, 1, 16, 17, 255, 256, 1 << 31} {
+		data := encodeNumber(n)
+		decoded, err := decodeNumber(data)
+		assert.Nil(t, err)
+		assert.Equal(t, n, decoded)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	_, err := NewBuilder().AddOp(Opcode(0xff)).Script()
+	assert.Equal(t, ErrBadOpcode, err)
+
+	b := NewBuilder()
+	for i := 0; i <= MaxScriptSize; i++ {
+		b.AddOp(OpDup)
+	}
+	_, err = b.Script()
+	assert.Equal(t, ErrScriptTooLarge, err)
+}
+
+func TestEncodeKey(t *testing.T) {
+	a := blockchain.NewWallet().Public()
+	data := EncodeKey(a)
+	assert.Len(t, data, blockchain.AddrLen)
+	decoded, ok := DecodeKey(data)
+	assert.True(t, ok)
+	assert.Equal(t, a.Repr(), decoded.Repr())
+
+	_, ok = DecodeKey(data[1:])
+	assert.False(t, ok)
+	data[0]++
+	_, ok = DecodeKey(data)
+	assert.False(t, ok)
+}
+
+func TestEncodeSignature(t *testing.T) {
+	sig := blockchain.NewTestTransaction().Sig
+	data := EncodeSignature(sig)
+	assert.Len(t, data, blockchain.SigLen)
+	decoded, ok := DecodeSignature(data)
+	assert.True(t, ok)
+	assert.Equal(t, 0, sig.R.Cmp(decoded.R))
+	assert.Equal(t, 0, sig.S.Cmp(decoded.S))
+
+	_, ok = DecodeSignature(data[1:])
+	assert.False(t, ok)
+}
+
+func TestOpcodeString(t *testing.T) {
+	assert.Equal(t, "0", Op0.String())
+	assert.Equal(t, "DATA20", Opcode(20).String())
+	assert.Equal(t, "PUSHDATA1", OpPushData1.String())
+	assert.Equal(t, "16", Op16.String())
+	assert.Equal(t, "CHECKSIG", OpCheckSig.String())
+	assert.Equal(t, "UNKNOWN(0xff)", Opcode(0xff).String())
+}
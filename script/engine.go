@@ -0,0 +1,474 @@
+package script
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+const (
+	// MaxScriptSize is the largest script in bytes.
+	MaxScriptSize = 1024
+	// MaxElementSize is the largest element in bytes that can be pushed onto
+	// the stack.
+	MaxElementSize = 128
+	// MaxStackSize is the most elements the stack can hold.
+	MaxStackSize = 64
+	// MaxOps is the most opcodes that don't push data a script can execute.
+	MaxOps = 128
+	// MaxNumberSize is the largest number in bytes. Numbers are unsigned and
+	// little endian, so they fit in a uint32.
+	MaxNumberSize = 4
+)
+
+var (
+	// ErrScriptTooLarge is returned when a script is longer than MaxScriptSize.
+	ErrScriptTooLarge = errors.New("Script is too large")
+	// ErrMalformed is returned when a push runs past the end of a script.
+	ErrMalformed = errors.New("Script is malformed")
+	// ErrBadOpcode is returned when a script contains an unknown opcode.
+	ErrBadOpcode = errors.New("Script contains an unknown opcode")
+	// ErrElementTooLarge is returned when an element larger than
+	// MaxElementSize is pushed.
+	ErrElementTooLarge = errors.New("Element is too large")
+	// ErrStackOverflow is returned when the stack holds more than
+	// MaxStackSize elements.
+	ErrStackOverflow = errors.New("Stack overflow")
+	// ErrStackUnderflow is returned when an opcode needs more elements than
+	// the stack holds.
+	ErrStackUnderflow = errors.New("Stack underflow")
+	// ErrTooManyOps is returned when a script executes more than MaxOps
+	// opcodes that don't push data.
+	ErrTooManyOps = errors.New("Script executes too many opcodes")
+	// ErrUnbalancedConditional is returned when an ELSE or ENDIF has no IF,
+	// or an IF has no ENDIF.
+	ErrUnbalancedConditional = errors.New("Unbalanced conditional")
+	// ErrBadNumber is returned when an element used as a number is larger
+	// than MaxNumberSize.
+	ErrBadNumber = errors.New("Element is not a number")
+	// ErrVerify is returned when a VERIFY opcode finds false on the stack.
+	ErrVerify = errors.New("Verify failed")
+	// ErrReturn is returned when a RETURN opcode is executed.
+	ErrReturn = errors.New("Script returned early")
+	// ErrLockTime is returned when the transaction's locks do not meet the
+	// script's time locks.
+	ErrLockTime = errors.New("Transaction locks do not meet time lock")
+	// ErrBadMultisig is returned when a CHECKMULTISIG has a bad number of keys
+	// or signatures.
+	ErrBadMultisig = errors.New("Bad number of keys or signatures")
+	// ErrPushOnly is returned when an unlocking script does more than push
+	// data.
+	ErrPushOnly = errors.New("Unlocking script is not push only")
+	// ErrFalse is returned when a script finishes without true on the stack.
+	ErrFalse = errors.New("Script evaluated to false")
+)
+
+// Context is the part of the spending transaction scripts can inspect.
+type Context struct {
+	// Digest is the hash of the body of the transaction, which signatures
+	// sign.
+	Digest blockchain.Hash
+	// LockTime is the lock time of the transaction.
+	LockTime uint32
+	// RelativeLock is the relative lock of the input being unlocked.
+	RelativeLock uint32
+}
+
+// NewContext returns the context for scripts that unlock the input at
+// position i of the given transaction body.
+func NewContext(tb blockchain.TxBody, i int) Context {
+	return Context{
+		Digest:       blockchain.HashSum(tb),
+		LockTime:     tb.LockTime,
+		RelativeLock: tb.RelativeLock(i),
+	}
+}
+
+// Execute runs the unlocking script and then the locking script on the same
+// stack. Returns nil if both run without error and leave true on top of the
+// stack. The unlocking script may only push data. Scripts can't loop, so
+// execution is bounded by their size.
+func Execute(unlock, lock []byte, ctx Context) error {
+	if len(unlock) > MaxScriptSize || len(lock) > MaxScriptSize {
+		return ErrScriptTooLarge
+	}
+	if !IsPushOnly(unlock) {
+		return ErrPushOnly
+	}
+
+	e := &engine{ctx: ctx}
+	if err := e.run(unlock); err != nil {
+		return err
+	}
+	if err := e.run(lock); err != nil {
+		return err
+	}
+	top, err := e.pop()
+	if err != nil || !asBool(top) {
+		return ErrFalse
+	}
+	return nil
+}
+
+// IsPushOnly returns true if the script is well formed and only pushes data.
+func IsPushOnly(script []byte) bool {
+	for pc := 0; pc < len(script); {
+		op, _, next, err := parse(script, pc)
+		if err != nil || !op.isPush() {
+			return false
+		}
+		pc = next
+	}
+	return true
+}
+
+// parse returns the opcode at position pc in the script, the data it pushes
+// if any, and the position of the next opcode.
+func parse(script []byte, pc int) (Opcode, []byte, int, error) {
+	op := Opcode(script[pc])
+	pc++
+	n := 0
+	switch {
+	case op >= OpData1 && op <= OpData75:
+		n = int(op)
+	case op == OpPushData1:
+		if pc >= len(script) {
+			return op, nil, pc, ErrMalformed
+		}
+		n = int(script[pc])
+		pc++
+	case !op.valid():
+		return op, nil, pc, ErrBadOpcode
+	}
+	if pc+n > len(script) {
+		return op, nil, pc, ErrMalformed
+	}
+	return op, script[pc : pc+n], pc + n, nil
+}
+
+// engine is the state of a script being executed.
+type engine struct {
+	ctx   Context
+	stack [][]byte
+	// conds holds whether the branch taken by each enclosing IF is executed.
+	conds []bool
+	ops   int
+}
+
+// executing returns true if the opcodes in the current branch are executed.
+func (e *engine) executing() bool {
+	for _, cond := range e.conds {
+		if !cond {
+			return false
+		}
+	}
+	return true
+}
+
+// run executes a script on the engine's stack.
+func (e *engine) run(script []byte) error {
+	e.conds = nil
+	for pc := 0; pc < len(script); {
+		op, data, next, err := parse(script, pc)
+		if err != nil {
+			return err
+		}
+		pc = next
+		if len(data) > MaxElementSize {
+			return ErrElementTooLarge
+		}
+		if !op.isPush() {
+			if e.ops++; e.ops > MaxOps {
+				return ErrTooManyOps
+			}
+		}
+
+		// Conditionals are tracked in branches that aren't executed so their
+		// ELSE and ENDIF can be matched.
+		switch op {
+		case OpIf, OpNotIf:
+			cond := false
+			if e.executing() {
+				top, err := e.pop()
+				if err != nil {
+					return err
+				}
+				cond = asBool(top) == (op == OpIf)
+			}
+			e.conds = append(e.conds, cond)
+			continue
+		case OpElse:
+			if len(e.conds) == 0 {
+				return ErrUnbalancedConditional
+			}
+			e.conds[len(e.conds)-1] = !e.conds[len(e.conds)-1]
+			continue
+		case OpEndIf:
+			if len(e.conds) == 0 {
+				return ErrUnbalancedConditional
+			}
+			e.conds = e.conds[:len(e.conds)-1]
+			continue
+		}
+		if !e.executing() {
+			continue
+		}
+		if err := e.step(op, data); err != nil {
+			return err
+		}
+	}
+	if len(e.conds) > 0 {
+		return ErrUnbalancedConditional
+	}
+	return nil
+}
+
+// step executes a single opcode other than a conditional.
+func (e *engine) step(op Opcode, data []byte) error {
+	switch {
+	case op == Op0 || (op >= OpData1 && op <= OpPushData1):
+		return e.push(data)
+	case op >= Op1 && op <= Op16:
+		return e.push(encodeNumber(uint32(op - Op1 + 1)))
+	}
+
+	switch op {
+	case OpVerify:
+		return e.verify(ErrVerify)
+	case OpReturn:
+		return ErrReturn
+	case OpDrop:
+		_, err := e.pop()
+		return err
+	case OpDup:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(top)
+		return e.push(top)
+	case OpSwap:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(a)
+		return e.push(b)
+	case OpSize:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(top)
+		return e.push(encodeNumber(uint32(len(top))))
+	case OpEqual, OpEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(encodeBool(bytes.Equal(a, b)))
+		if op == OpEqualVerify {
+			return e.verify(ErrVerify)
+		}
+	case OpSHA256:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(top)
+		return e.push(hash[:])
+	case OpCheckSig, OpCheckSigVerify:
+		key, err := e.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(encodeBool(e.checkSig(sig, key)))
+		if op == OpCheckSigVerify {
+			return e.verify(ErrVerify)
+		}
+	case OpCheckMultisig, OpCheckMultisigVerify:
+		valid, err := e.checkMultisig()
+		if err != nil {
+			return err
+		}
+		e.push(encodeBool(valid))
+		if op == OpCheckMultisigVerify {
+			return e.verify(ErrVerify)
+		}
+	case OpCheckLockTimeVerify:
+		lockTime, err := e.popNumber()
+		if err != nil {
+			return err
+		}
+		// The lock times must both be block numbers or both be times.
+		if (lockTime < blockchain.LockTimeThreshold) !=
+			(e.ctx.LockTime < blockchain.LockTimeThreshold) ||
+			e.ctx.LockTime < lockTime {
+			return ErrLockTime
+		}
+	case OpCheckRelativeLockVerify:
+		relativeLock, err := e.popNumber()
+		if err != nil {
+			return err
+		}
+		if e.ctx.RelativeLock < relativeLock {
+			return ErrLockTime
+		}
+	}
+	return nil
+}
+
+// checkSig returns true if sig is a valid signature of the context's digest
+// by key.
+func (e *engine) checkSig(sig, key []byte) bool {
+	a, ok := DecodeKey(key)
+	if !ok {
+		return false
+	}
+	s, ok := DecodeSignature(sig)
+	if !ok {
+		return false
+	}
+	return ecdsa.Verify(a.Key(), e.ctx.Digest.Marshal(), s.R, s.S)
+}
+
+// checkMultisig pops the number of keys, the keys, the number of signatures
+// and the signatures, and returns true if each signature is valid for one of
+// the keys. The signatures must be in the same order as their keys.
+func (e *engine) checkMultisig() (bool, error) {
+	n, err := e.popNumber()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 || n > blockchain.MaxMultisigKeys {
+		return false, ErrBadMultisig
+	}
+	keys := make([][]byte, n)
+	for i := range keys {
+		if keys[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+	m, err := e.popNumber()
+	if err != nil {
+		return false, err
+	}
+	if m == 0 || m > n {
+		return false, ErrBadMultisig
+	}
+	sigs := make([][]byte, m)
+	for i := range sigs {
+		if sigs[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	// Keys and signatures were popped in reverse order, so match them from
+	// the last key and signature back.
+	k := 0
+	for _, sig := range sigs {
+		for k < len(keys) && !e.checkSig(sig, keys[k]) {
+			k++
+		}
+		if k == len(keys) {
+			return false, nil
+		}
+		k++
+	}
+	return true, nil
+}
+
+// verify pops the top of the stack and returns err if it is false.
+func (e *engine) verify(err error) error {
+	top, popErr := e.pop()
+	if popErr != nil {
+		return popErr
+	}
+	if !asBool(top) {
+		return err
+	}
+	return nil
+}
+
+// push pushes an element onto the stack.
+func (e *engine) push(data []byte) error {
+	if len(e.stack) >= MaxStackSize {
+		return ErrStackOverflow
+	}
+	e.stack = append(e.stack, data)
+	return nil
+}
+
+// pop removes the element on top of the stack and returns it.
+func (e *engine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	top := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return top, nil
+}
+
+// popNumber pops the element on top of the stack as a number.
+func (e *engine) popNumber() (uint32, error) {
+	top, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	return decodeNumber(top)
+}
+
+// asBool returns false if the element is empty or all zeros, and true
+// otherwise.
+func asBool(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeBool returns 1 for true and an empty element for false.
+func encodeBool(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
+// encodeNumber returns the shortest little endian encoding of n.
+func encodeNumber(n uint32) []byte {
+	var buf []byte
+	for ; n > 0; n >>= 8 {
+		buf = append(buf, byte(n))
+	}
+	return buf
+}
+
+// decodeNumber returns the number encoded in little endian by data.
+func decodeNumber(data []byte) (uint32, error) {
+	if len(data) > MaxNumberSize {
+		return 0, ErrBadNumber
+	}
+	n := uint32(0)
+	for i := len(data) - 1; i >= 0; i-- {
+		n = n<<8 | uint32(data[i])
+	}
+	return n, nil
+}
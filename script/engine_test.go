@@ -0,0 +1,278 @@
+package script
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ubclaunchpad/cumulus/blockchain"
+)
+
+// build returns the script built by adding the given opcodes, numbers and
+// data in order.
+func build(items ...interface{}) []byte {
+	b := NewBuilder()
+	for _, item := range items {
+		switch v := item.(type) {
+		case Opcode:
+			b.AddOp(v)
+		case int:
+			b.AddNumber(uint32(v))
+		case []byte:
+			b.AddData(v)
+		}
+	}
+	s, _ := b.Script()
+	return s
+}
+
+func TestExecuteStackOps(t *testing.T) {
+	tests := []struct {
+		name   string
+		unlock []byte
+		lock   []byte
+		err    error
+	}{
+		{"true", build(1), nil, nil},
+		{"false", build(0), nil, ErrFalse},
+		{"empty", nil, nil, ErrFalse},
+		{"zeros are false", build([]byte{0, 0}), nil, ErrFalse},
+		{"equal", build(2, 2), build(OpEqual), nil},
+		{"not equal", build(2, 3), build(OpEqual), ErrFalse},
+		{"equalverify", build(2, 2), build(OpEqualVerify, 1), nil},
+		{"equalverify fails", build(2, 3), build(OpEqualVerify, 1), ErrVerify},
+		{"verify", build(1), build(OpVerify, 1), nil},
+		{"verify fails", build(0), build(OpVerify, 1), ErrVerify},
+		{"drop", build(1, 0), build(OpDrop), nil},
+		{"dup", build(5), build(OpDup, OpEqual), nil},
+		{"swap", build(0, 1), build(OpSwap, OpDrop), nil},
+		{"size", build([]byte("abc")), build(OpSize, 3, OpEqualVerify, OpDrop, 1), nil},
+		{"sha256", build([]byte("abc")), build(OpSHA256, sha256Of("abc"), OpEqual), nil},
+		{"return", build(1), build(OpReturn), ErrReturn},
+		{"underflow", nil, build(OpDrop), ErrStackUnderflow},
+		{"swap underflow", build(1), build(OpSwap), ErrStackUnderflow},
+		{"large number", build(1000, 1000), build(OpEqual), nil},
+		{"16", build(16), build([]byte{16}, OpEqual), nil},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.err, Execute(test.unlock, test.lock, Context{}), test.name)
+	}
+}
+
+func TestExecuteConditionals(t *testing.T) {
+	tests := []struct {
+		name   string
+		unlock []byte
+		lock   []byte
+		err    error
+	}{
+		{"if", build(1), build(OpIf, 1, OpElse, 0, OpEndIf), nil},
+		{"else", build(0), build(OpIf, 0, OpElse, 1, OpEndIf), nil},
+		{"notif", build(0), build(OpNotIf, 1, OpElse, 0, OpEndIf), nil},
+		{"if without else", build(1, 0), build(OpIf, OpReturn, OpEndIf), nil},
+		{"nested", build(0, 1),
+			build(OpIf, OpIf, 0, OpElse, 1, OpEndIf, OpElse, 0, OpEndIf), nil},
+		{"skipped branch", build(0),
+			build(OpIf, OpIf, OpReturn, OpEndIf, OpReturn, OpElse, 1, OpEndIf), nil},
+		{"no endif", build(1), build(OpIf, 1), ErrUnbalancedConditional},
+		{"no if", build(1), build(OpEndIf), ErrUnbalancedConditional},
+		{"else without if", build(1), build(OpElse), ErrUnbalancedConditional},
+		{"if underflow", nil, build(OpIf, OpEndIf), ErrStackUnderflow},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.err, Execute(test.unlock, test.lock, Context{}), test.name)
+	}
+}
+
+func TestExecuteConditionalsDontSpanScripts(t *testing.T) {
+	assert.Equal(t, ErrPushOnly, Execute(build(1, OpIf), build(OpEndIf), Context{}))
+	assert.Equal(t, ErrUnbalancedConditional,
+		Execute(build(1), build(OpIf, 1), Context{}))
+}
+
+func TestExecuteLimits(t *testing.T) {
+	big := make([]byte, MaxScriptSize+1)
+	assert.Equal(t, ErrScriptTooLarge, Execute(nil, big, Context{}))
+	assert.Equal(t, ErrScriptTooLarge, Execute(big, nil, Context{}))
+
+	// Elements can't be larger than MaxElementSize.
+	element := append([]byte{byte(OpPushData1), MaxElementSize + 1},
+		make([]byte, MaxElementSize+1)...)
+	assert.Equal(t, ErrElementTooLarge, Execute(element, nil, Context{}))
+
+	// The stack can't hold more than MaxStackSize elements.
+	pushes := make([]interface{}, MaxStackSize+1)
+	for i := range pushes {
+		pushes[i] = 1
+	}
+	assert.Equal(t, ErrStackOverflow, Execute(build(pushes...), nil, Context{}))
+	assert.Nil(t, Execute(build(pushes[1:]...), nil, Context{}))
+
+	// Scripts can't execute more than MaxOps opcodes.
+	ops := make([]interface{}, MaxOps+1)
+	for i := range ops {
+		ops[i] = OpDup
+		if i%2 == 1 {
+			ops[i] = OpDrop
+		}
+	}
+	assert.Equal(t, ErrTooManyOps, Execute(build(1), build(ops...), Context{}))
+
+	// Numbers fit in a uint32.
+	assert.Equal(t, ErrBadNumber, Execute(build([]byte{1, 0, 0, 0, 0}),
+		build(OpCheckRelativeLockVerify, 1), Context{}))
+}
+
+func TestExecuteMalformed(t *testing.T) {
+	assert.Equal(t, ErrMalformed, Execute(nil, []byte{byte(OpData1) + 1, 0}, Context{}))
+	assert.Equal(t, ErrMalformed, Execute(nil, []byte{byte(OpPushData1)}, Context{}))
+	assert.Equal(t, ErrBadOpcode, Execute(build(1), []byte{0xff}, Context{}))
+
+	// Unknown opcodes aren't allowed in branches that aren't executed.
+	lock := append(build(OpIf), 0xff, byte(OpEndIf), byte(Op1))
+	assert.Equal(t, ErrBadOpcode, Execute(build(0), lock, Context{}))
+}
+
+func TestExecutePushOnly(t *testing.T) {
+	assert.True(t, IsPushOnly(build(1, []byte("data"), 0)))
+	assert.False(t, IsPushOnly(build(1, OpDup)))
+	assert.False(t, IsPushOnly([]byte{byte(OpData1)}))
+	assert.Equal(t, ErrPushOnly, Execute(build(1, OpVerify, 1), nil, Context{}))
+}
+
+func TestExecuteCheckSig(t *testing.T) {
+	w := blockchain.NewWallet()
+	body := blockchain.NewTestTxBody()
+	ctx := NewContext(body, 0)
+	sig, err := Sign(w, body, crand.Reader)
+	assert.Nil(t, err)
+	lock, err := PayToKey(w.Public())
+	assert.Nil(t, err)
+
+	assert.Nil(t, Execute(build(sig), lock, ctx))
+
+	// Signatures of other bodies or by other keys are not valid.
+	other, _ := Sign(w, blockchain.NewTestTxBody(), crand.Reader)
+	assert.Equal(t, ErrFalse, Execute(build(other), lock, ctx))
+	other, _ = Sign(blockchain.NewWallet(), body, crand.Reader)
+	assert.Equal(t, ErrFalse, Execute(build(other), lock, ctx))
+
+	// Malformed signatures and keys are not valid.
+	assert.Equal(t, ErrFalse, Execute(build(sig[1:]), lock, ctx))
+	badKey := build(make([]byte, blockchain.AddrLen), OpCheckSig)
+	assert.Equal(t, ErrFalse, Execute(build(sig), badKey, ctx))
+
+	// CHECKSIGVERIFY fails instead of pushing false.
+	verify := build(EncodeKey(w.Public()), OpCheckSigVerify, 1)
+	assert.Nil(t, Execute(build(sig), verify, ctx))
+	assert.Equal(t, ErrVerify, Execute(build(other), verify, ctx))
+}
+
+func TestExecuteCheckMultisig(t *testing.T) {
+	wallets := []*blockchain.Wallet{
+		blockchain.NewWallet(), blockchain.NewWallet(), blockchain.NewWallet(),
+	}
+	body := blockchain.NewTestTxBody()
+	ctx := NewContext(body, 0)
+	sigs := make([][]byte, len(wallets))
+	for i, w := range wallets {
+		sigs[i], _ = Sign(w, body, crand.Reader)
+	}
+	lock, err := Multisig(2, wallets[0].Public(), wallets[1].Public(),
+		wallets[2].Public())
+	assert.Nil(t, err)
+
+	assert.Nil(t, Execute(build(sigs[0], sigs[1]), lock, ctx))
+	assert.Nil(t, Execute(build(sigs[0], sigs[2]), lock, ctx))
+	assert.Nil(t, Execute(build(sigs[1], sigs[2]), lock, ctx))
+
+	// Signatures must be in the same order as their keys, and distinct.
+	assert.Equal(t, ErrFalse, Execute(build(sigs[2], sigs[0]), lock, ctx))
+	assert.Equal(t, ErrFalse, Execute(build(sigs[0], sigs[0]), lock, ctx))
+
+	// Too few signatures.
+	assert.Equal(t, ErrStackUnderflow, Execute(build(sigs[0]), lock, ctx))
+
+	// Bad numbers of keys and signatures.
+	_, err = Multisig(3, wallets[0].Public(), wallets[1].Public())
+	assert.Equal(t, ErrBadMultisig, err)
+	bad := build(0, EncodeKey(wallets[0].Public()), 1, OpCheckMultisig)
+	assert.Equal(t, ErrBadMultisig, Execute(nil, bad, ctx))
+	bad = build(sigs[0], 1, 0, OpCheckMultisig)
+	assert.Equal(t, ErrBadMultisig, Execute(nil, bad, ctx))
+
+	// CHECKMULTISIGVERIFY fails instead of pushing false.
+	verify := append(lock[:len(lock)-1], byte(OpCheckMultisigVerify), byte(Op1))
+	assert.Nil(t, Execute(build(sigs[0], sigs[1]), verify, ctx))
+	assert.Equal(t, ErrVerify, Execute(build(sigs[1], sigs[0]), verify, ctx))
+}
+
+func TestExecuteCheckLockTimeVerify(t *testing.T) {
+	lock := build(100, OpCheckLockTimeVerify, 1)
+	assert.Nil(t, Execute(nil, lock, Context{LockTime: 100}))
+	assert.Nil(t, Execute(nil, lock, Context{LockTime: 101}))
+	assert.Equal(t, ErrLockTime, Execute(nil, lock, Context{LockTime: 99}))
+
+	// Block numbers and times can't be compared.
+	assert.Equal(t, ErrLockTime, Execute(nil, lock,
+		Context{LockTime: blockchain.LockTimeThreshold}))
+	lock = build(blockchain.LockTimeThreshold, OpCheckLockTimeVerify, 1)
+	assert.Nil(t, Execute(nil, lock, Context{LockTime: blockchain.LockTimeThreshold}))
+	assert.Equal(t, ErrLockTime, Execute(nil, lock, Context{LockTime: 100}))
+}
+
+func TestNewContextRelativeLock(t *testing.T) {
+	body := blockchain.NewTestTxBody()
+	body.Inputs = body.Inputs[:1]
+	body.Inputs = append(body.Inputs, blockchain.NewTestTxHashPointer())
+	body.RelativeLocks = []uint32{5, 0}
+
+	// Each input is unlocked with its own relative lock.
+	assert.Equal(t, uint32(5), NewContext(body, 0).RelativeLock)
+	assert.Equal(t, uint32(0), NewContext(body, 1).RelativeLock)
+}
+
+func TestExecuteCheckRelativeLockVerify(t *testing.T) {
+	lock := build(10, OpCheckRelativeLockVerify, 1)
+	assert.Nil(t, Execute(nil, lock, Context{RelativeLock: 10}))
+	assert.Equal(t, ErrLockTime, Execute(nil, lock, Context{RelativeLock: 9}))
+	assert.Equal(t, ErrStackUnderflow,
+		Execute(nil, build(OpCheckRelativeLockVerify), Context{}))
+}
+
+func TestExecuteHashTimeLock(t *testing.T) {
+	recipient, refund := blockchain.NewWallet(), blockchain.NewWallet()
+	preimage := []byte("secret")
+	hash := sha256.Sum256(preimage)
+	lock, err := HashTimeLock(hash[:], recipient.Public(), refund.Public(), 100)
+	assert.Nil(t, err)
+
+	// The recipient can claim the output with the preimage at any time.
+	body := blockchain.NewTestTxBody()
+	sig, _ := Sign(recipient, body, crand.Reader)
+	claim, _ := HashLockClaim(sig, preimage)
+	assert.Nil(t, Execute(claim, lock, NewContext(body, 0)))
+	claim, _ = HashLockClaim(sig, []byte("guess"))
+	assert.Equal(t, ErrVerify, Execute(claim, lock, NewContext(body, 0)))
+
+	// The refund address can only take it back once the lock time is reached.
+	sig, _ = Sign(refund, body, crand.Reader)
+	refundScript, _ := HashLockRefund(sig)
+	assert.Equal(t, ErrLockTime, Execute(refundScript, lock, NewContext(body, 0)))
+	body.LockTime = 100
+	sig, _ = Sign(refund, body, crand.Reader)
+	refundScript, _ = HashLockRefund(sig)
+	assert.Nil(t, Execute(refundScript, lock, NewContext(body, 0)))
+
+	// The recipient can't use the refund branch.
+	sig, _ = Sign(recipient, body, crand.Reader)
+	refundScript, _ = HashLockRefund(sig)
+	assert.Equal(t, ErrFalse, Execute(refundScript, lock, NewContext(body, 0)))
+}
+
+// sha256Of returns the SHA-256 hash of s.
+func sha256Of(s string) []byte {
+	hash := sha256.Sum256([]byte(s))
+	return hash[:]
+}
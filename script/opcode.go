@@ -0,0 +1,89 @@
+package script
+
+import "fmt"
+
+// Opcode is a single instruction in a script.
+type Opcode byte
+
+// The opcodes of the script language. Opcodes between OpData1 and OpData75
+// push the given number of bytes that follow them onto the stack.
+const (
+	Op0                       Opcode = 0x00
+	OpData1                   Opcode = 0x01
+	OpData75                  Opcode = 0x4b
+	OpPushData1               Opcode = 0x4c
+	Op1                       Opcode = 0x51
+	Op16                      Opcode = 0x60
+	OpIf                      Opcode = 0x63
+	OpNotIf                   Opcode = 0x64
+	OpElse                    Opcode = 0x67
+	OpEndIf                   Opcode = 0x68
+	OpVerify                  Opcode = 0x69
+	OpReturn                  Opcode = 0x6a
+	OpDrop                    Opcode = 0x75
+	OpDup                     Opcode = 0x76
+	OpSwap                    Opcode = 0x7c
+	OpSize                    Opcode = 0x82
+	OpEqual                   Opcode = 0x87
+	OpEqualVerify             Opcode = 0x88
+	OpSHA256                  Opcode = 0xa8
+	OpCheckSig                Opcode = 0xac
+	OpCheckSigVerify          Opcode = 0xad
+	OpCheckMultisig           Opcode = 0xae
+	OpCheckMultisigVerify     Opcode = 0xaf
+	OpCheckLockTimeVerify     Opcode = 0xb1
+	OpCheckRelativeLockVerify Opcode = 0xb2
+)
+
+// names are the names of the opcodes that don't push data.
+var names = map[Opcode]string{
+	OpIf:                      "IF",
+	OpNotIf:                   "NOTIF",
+	OpElse:                    "ELSE",
+	OpEndIf:                   "ENDIF",
+	OpVerify:                  "VERIFY",
+	OpReturn:                  "RETURN",
+	OpDrop:                    "DROP",
+	OpDup:                     "DUP",
+	OpSwap:                    "SWAP",
+	OpSize:                    "SIZE",
+	OpEqual:                   "EQUAL",
+	OpEqualVerify:             "EQUALVERIFY",
+	OpSHA256:                  "SHA256",
+	OpCheckSig:                "CHECKSIG",
+	OpCheckSigVerify:          "CHECKSIGVERIFY",
+	OpCheckMultisig:           "CHECKMULTISIG",
+	OpCheckMultisigVerify:     "CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify:     "CHECKLOCKTIMEVERIFY",
+	OpCheckRelativeLockVerify: "CHECKRELATIVELOCKVERIFY",
+}
+
+// isPush returns true if the opcode pushes data or a small number onto the
+// stack.
+func (op Opcode) isPush() bool {
+	return op <= OpPushData1 || (op >= Op1 && op <= Op16)
+}
+
+// valid returns true if the opcode is part of the script language.
+func (op Opcode) valid() bool {
+	_, ok := names[op]
+	return ok || op.isPush()
+}
+
+// String returns the name of the opcode.
+func (op Opcode) String() string {
+	switch {
+	case op == Op0:
+		return "0"
+	case op >= OpData1 && op <= OpData75:
+		return fmt.Sprintf("DATA%d", op)
+	case op == OpPushData1:
+		return "PUSHDATA1"
+	case op >= Op1 && op <= Op16:
+		return fmt.Sprintf("%d", op-Op1+1)
+	}
+	if name, ok := names[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(0x%02x)", byte(op))
+}